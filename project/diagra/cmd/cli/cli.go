@@ -2,17 +2,31 @@ package cli
 
 import (
 	"diagra/cmd/utils"
+	"diagra/interpreter"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
 // Run is the entry point for the CLI application.
 func RunCLI(args []string) {
+	if err := utils.LoadThemes(); err != nil {
+		fmt.Println(err)
+	}
+	args, ok := themeFlag(args)
+	if !ok || len(args) == 0 {
+		return
+	}
+
 	switch args[0] {
 	case "render":
+		args, ok := formatFlag(args)
+		if !ok {
+			return
+		}
 		if len(args) < 2 {
 			fmt.Println("Specify a .diag file to render")
 			return
@@ -29,9 +43,24 @@ func RunCLI(args []string) {
 		renderCmd(fullPath)
 		return
 	case "render-all":
+		if _, ok := formatFlag(args); !ok {
+			return
+		}
 		renderAllCmd()
 		utils.ResetCombinedTime()
 		return
+	case "fmt":
+		fmtCmd(args[1:])
+		return
+	case "export":
+		exportCmd(args[1:])
+		return
+	case "import":
+		importCmd(args[1:])
+		return
+	case "convert":
+		convertCmd(args[1:])
+		return
 	case "-h", "--help", "help":
 		helpCmd()
 		return
@@ -42,6 +71,80 @@ func RunCLI(args []string) {
 
 }
 
+// themeFlag takes --theme NAME or --theme=NAME out of the arguments and
+// sets utils.Theme. NAME is a theme or the path of a .theme file.
+// It returns false when the theme can not be used.
+func themeFlag(args []string) ([]string, bool) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, found := strings.CutPrefix(args[i], "--theme=")
+		if !found && args[i] != "--theme" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !found {
+			if i+1 >= len(args) {
+				fmt.Println("Specify a theme after --theme")
+				return nil, false
+			}
+			i++
+			name = args[i]
+		}
+
+		if strings.HasSuffix(name, ".theme") {
+			loaded, err := utils.LoadTheme(name)
+			if err != nil {
+				fmt.Println(err)
+				return nil, false
+			}
+			name = loaded
+		}
+		if _, ok := interpreter.LookupTheme(name); !ok {
+			fmt.Printf("Unknown theme %s, available: %s\n", name, strings.Join(interpreter.ThemeNames(), ", "))
+			return nil, false
+		}
+		utils.Theme = name
+	}
+	return rest, true
+}
+
+// formatFlag takes --format NAME or --format=NAME out of the arguments
+// of render and sets utils.Format. It returns false for an unknown format.
+func formatFlag(args []string) ([]string, bool) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, found := strings.CutPrefix(args[i], "--format=")
+		if !found && args[i] != "--format" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !found {
+			if i+1 >= len(args) {
+				fmt.Println("Specify a format after --format")
+				return nil, false
+			}
+			i++
+			name = args[i]
+		}
+		if _, ok := utils.OutputFormats[name]; !ok {
+			fmt.Printf("Unknown output format %s, available: %s\n", name, strings.Join(outputFormats(), ", "))
+			return nil, false
+		}
+		utils.Format = name
+	}
+	return rest, true
+}
+
+// outputFormats returns the names of utils.OutputFormats, sorted
+func outputFormats() []string {
+	var names []string
+	for name := range utils.OutputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // renderAllCmd renders all diagrams in the example directory.
 // It reads all .diag files, processes them, and saves the output as SVG files.
 func renderAllCmd() {
@@ -60,26 +163,270 @@ func renderAllCmd() {
 		}
 		files = append(files, file.Name())
 	}
-	utils.RenderAllDiagrams(files)
-	fmt.Println("All diagrams rendered to SVG in", utils.OutputDir)
+	for _, result := range utils.RenderAllDiagrams(files) {
+		printResult(result)
+	}
+	fmt.Println("All diagrams rendered to", strings.ToUpper(utils.Format), "in", utils.OutputDir)
 	fmt.Printf("Total time: %d ms\n", utils.CombinedTime)
 }
 
 func renderCmd(filename string) {
 	utils.ResetRenderStart()
-	utils.RenderDiagToSVG(filename)
+	outPath, diags, err := utils.RenderDiag(filename)
+	printResult(utils.RenderResult{Path: filename, OutPath: outPath, Diags: diags, Err: err})
 	fmt.Println("Rendering finished for", filename)
 	timeTaken := time.Since(utils.RenderStart).Milliseconds()
 	fmt.Printf("Total time: %d ms\n", timeTaken)
 }
 
+// printResult prints the errors and warnings of a rendered file on stderr,
+// with the offending source lines
+func printResult(r utils.RenderResult) {
+	if len(r.Diags) > 0 {
+		errs, warnings := r.Diags.Count()
+		fmt.Fprintf(os.Stderr, "%s: %d error(s), %d warning(s)\n", r.Path, errs, warnings)
+		fmt.Fprintln(os.Stderr, r.Diags.Report())
+		if errs > 0 && r.OutPath != "" {
+			fmt.Fprintln(os.Stderr, "Rendered the parts of the diagram that could be parsed")
+		}
+	}
+	if r.Err != nil {
+		fmt.Fprintln(os.Stderr, r.Err)
+	}
+}
+
+// fmtCmd formats .diag files. It prints the formatted files, writes them
+// back with -w, or with --check only lists the files that are not
+// formatted. It exits with status 1 when a file is not formatted (--check)
+// or could not be formatted.
+func fmtCmd(args []string) {
+	write, check := false, false
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "-w":
+			write = true
+		case arg == "--check":
+			check = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown flag for fmt:", arg)
+			return
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("Specify .diag files to format")
+		return
+	}
+
+	failed := false
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".diag") {
+			fmt.Println("File must have .diag extension:", file)
+			failed = true
+			continue
+		}
+		formatted, changed, diags, err := utils.FormatDiag(file)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		if diags.HasErrors() {
+			fmt.Printf("%s: can not format a file with errors\n", file)
+			fmt.Println(diags.Report())
+			failed = true
+			continue
+		}
+
+		switch {
+		case check:
+			if changed {
+				fmt.Println(file)
+				failed = true
+			}
+		case write:
+			if !changed {
+				continue
+			}
+			if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+				fmt.Println("could not write file:", err)
+				failed = true
+				continue
+			}
+			fmt.Println("Formatted", file)
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// exportFormats returns the names of utils.ExportFormats, sorted
+func exportFormats() []string {
+	var names []string
+	for name := range utils.ExportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportCmd writes the parsed diagram of a .diag file in another format,
+// to stdout or to the file given with -o. Warnings about what could not
+// be exported go to stderr. It exits with status 1 when the file could
+// not be exported.
+func exportCmd(args []string) {
+	format, out := "json", ""
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--format" || arg == "-o":
+			if i+1 >= len(args) {
+				fmt.Println("Specify a value after", arg)
+				return
+			}
+			i++
+			if arg == "-o" {
+				out = args[i]
+			} else {
+				format = args[i]
+			}
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println("Unknown flag for export:", arg)
+			return
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 1 {
+		fmt.Println("Specify one .diag file to export")
+		return
+	}
+	if _, ok := utils.ExportFormats[format]; !ok {
+		fmt.Printf("Unknown export format %s, available: %s\n", format, strings.Join(exportFormats(), ", "))
+		return
+	}
+	if !strings.HasSuffix(files[0], ".diag") {
+		fmt.Println("File must have .diag extension")
+		return
+	}
+
+	data, diags, err := utils.ExportDiag(files[0], format)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if diags.HasErrors() {
+		fmt.Printf("%s: can not export a file with errors\n", files[0])
+		fmt.Println(diags.Report())
+		os.Exit(1)
+	}
+	if len(diags) > 0 {
+		fmt.Fprintln(os.Stderr, diags.Report())
+	}
+	if out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		fmt.Println("could not write file:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Exported", files[0], "to", out)
+}
+
+// importCmd renders a diagram from a JSON file written by export
+func importCmd(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Specify a .json file to import")
+		return
+	}
+	if !strings.HasSuffix(args[0], ".json") {
+		fmt.Println("File must have .json extension")
+		return
+	}
+	outPath, diags, err := utils.ImportDiag(args[0])
+	if len(diags) > 0 {
+		fmt.Println(diags.Report())
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Created:", outPath)
+}
+
+// convertCmd converts a diagram in another format, like a Graphviz .dot or
+// a Mermaid .mmd file, to formatted .diag source. The warnings tell what
+// could not be converted. It exits with status 1 when the file could not
+// be converted.
+func convertCmd(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Specify the file to convert and the .diag file to write")
+		return
+	}
+	in, out := args[0], args[1]
+	if !strings.HasSuffix(out, ".diag") {
+		fmt.Println("Output file must have .diag extension")
+		return
+	}
+	if _, ok := utils.Importers[filepath.Ext(in)]; !ok {
+		var exts []string
+		for ext := range utils.Importers {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		fmt.Printf("Can not convert %s, available: %s\n", in, strings.Join(exts, ", "))
+		return
+	}
+
+	source, diags, err := utils.ConvertFile(in)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(diags) > 0 {
+		errs, warnings := diags.Count()
+		fmt.Printf("%s: %d error(s), %d warning(s)\n", in, errs, warnings)
+		fmt.Println(diags.Report())
+	}
+	if diags.HasErrors() {
+		fmt.Printf("%s: can not convert a file with errors\n", in)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(out, []byte(source), 0644); err != nil {
+		fmt.Println("could not write file:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Converted", in, "to", out)
+}
+
 // helpCmd prints the help message for the CLI application.
 // It shows the available commands and their usage.
 func helpCmd() {
 	fmt.Println("Usage: diagra [command]")
 	fmt.Println("Commands:")
-	fmt.Println("  render <file>		Render a diagram from a .diag file")
-	fmt.Println("  render-all		Render all diagrams in the example directory")
+	fmt.Println("  render <file> [--format <format>]")
+	fmt.Println("			Render a diagram from a .diag file to " + strings.Join(outputFormats(), " or "))
+	fmt.Println("  render-all [--format <format>]")
+	fmt.Println("			Render all diagrams in the example directory")
+	fmt.Println("  fmt [-w] [--check] <files>")
+	fmt.Println("			Format .diag files, print them, write them back (-w)")
+	fmt.Println("			or list the files that are not formatted (--check)")
+	fmt.Println("  export [--format " + strings.Join(exportFormats(), "|") + "] [-o <out>] <file>")
+	fmt.Println("			Write the parsed diagram of a .diag file as JSON or a Mermaid flowchart")
+	fmt.Println("  import <file>		Render a diagram from a .json file written by export")
+	fmt.Println("  convert <in> <out.diag>")
+	fmt.Println("			Convert a Graphviz (.dot, .gv) or Mermaid (.mmd, .mermaid) file to .diag source")
 	fmt.Println("  -h, --help, help     	Show this help message")
+	fmt.Println("\nOptions:")
+	fmt.Println("  --theme <name|file>	Draw with a theme: " + strings.Join(interpreter.ThemeNames(), ", "))
+	fmt.Println("			or a .theme file, themes in " + utils.ThemeDir + "/ can be used by name")
 	fmt.Println("\n\nRun the program without arguments to start the TUI.")
 }
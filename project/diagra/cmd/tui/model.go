@@ -122,8 +122,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case renderFinishedMsg:
 		m.loading = false
 		duration := time.Since(m.renderStart).Milliseconds()
-		m.output = fmt.Sprintf("✅ Rendering finished in %dms", duration)
-		return m, clearOutputAfter(2 * time.Second)
+		if len(msg.problems) == 0 {
+			m.output = fmt.Sprintf("✅ Rendering finished in %dms", duration)
+			return m, clearOutputAfter(2 * time.Second)
+		}
+		// Keep the problems on screen, the partial diagram is still rendered
+		m.output = fmt.Sprintf("⚠️  Rendered in %dms with problems:\n%s", duration, strings.Join(msg.problems, "\n"))
+		return m, nil
 
 	case spinner.TickMsg:
 		var cmd tea.Cmd
@@ -168,15 +173,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 // Custom message for async rendering
-type renderFinishedMsg string
+// problems holds one line per error or warning found in the diagram
+type renderFinishedMsg struct {
+	problems []string
+}
+
+// maxProblems is how many errors and warnings fit in the TUI box
+const maxProblems = 4
 
 // renderFinishedMsg is a custom message type to indicate that rendering is finished
 func renderDiagCmd(filename string) tea.Cmd {
 
 	return func() tea.Msg {
 		path := path.Join("example", filename)
-		utils.RenderDiagToSVG(path)
-		return renderFinishedMsg("Rendering finished")
+		_, diags, err := utils.RenderDiag(path)
+
+		var problems []string
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for i, d := range diags {
+			if i == maxProblems {
+				problems = append(problems, fmt.Sprintf("... and %d more", len(diags)-maxProblems))
+				break
+			}
+			problems = append(problems, d.Error())
+		}
+		return renderFinishedMsg{problems: problems}
 	}
 }
 
@@ -196,8 +219,19 @@ func renderAllCmd() tea.Cmd {
 		files = append(files, file.Name())
 	}
 	return func() tea.Msg {
-		utils.RenderAllDiagrams(files)
-		return renderFinishedMsg("Rendering finished")
+		var problems []string
+		for _, r := range utils.RenderAllDiagrams(files) {
+			if r.Err != nil {
+				problems = append(problems, r.Path+": "+r.Err.Error())
+			}
+			for _, d := range r.Diags {
+				problems = append(problems, r.Path+": "+d.Error())
+			}
+		}
+		if len(problems) > maxProblems {
+			problems = append(problems[:maxProblems], fmt.Sprintf("... and %d more", len(problems)-maxProblems))
+		}
+		return renderFinishedMsg{problems: problems}
 	}
 }
 
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"diagra/cmd/utils"
 	"fmt"
 	"io/fs"
 	"os"
@@ -12,6 +13,10 @@ import (
 // RunTUI starts the TUI application
 // It loads the .diag files from the example directory and initializes the model
 func RunTUI() {
+	if err := utils.LoadThemes(); err != nil {
+		fmt.Println(err)
+	}
+
 	diagFiles, err := loadDiagFiles("./example")
 	if err != nil {
 		fmt.Println("Error loading .diag files:", err)
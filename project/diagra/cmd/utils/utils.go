@@ -15,11 +15,25 @@ var (
 	RenderStart  time.Time
 	CombinedTime int64
 	mu           sync.Mutex
+
+	// Theme is the theme from --theme, it wins over the theme in the
+	// diagram. "" uses the theme of each diagram.
+	Theme string
+
+	// Format is the output format from --format, one of OutputFormats
+	Format = "svg"
 )
 
+// OutputFormats are the formats a diagram can be rendered to
+var OutputFormats = map[string]func(interpreter.Diagram) string{
+	"svg": renderer.RenderSVG,
+	"dot": renderer.RenderDOT,
+}
+
 const (
 	ExampleDir = "example"
 	OutputDir  = "output"
+	ThemeDir   = "themes" // the .theme files here can be used by name
 )
 
 // CheckError checks if an error occurred and prints it to the console.
@@ -46,23 +60,24 @@ func ResetCombinedTime() {
 	CombinedTime = 0
 }
 
-// RenderDiagToSVG reads a .diag file, parses it, and renders it to an SVG file.
-// It creates an output directory if it doesn't exist and saves the SVG file there
-func RenderDiagToSVG(path string) string {
-	outputDir := "output"
-	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		err := os.Mkdir(outputDir, 0755)
-		if err != nil {
-			fmt.Println("Could not create output directory:", err)
-			return ""
-		}
-	}
+// RenderResult is what rendering one .diag file gave
+type RenderResult struct {
+	Path    string                  // the .diag file
+	OutPath string                  // the file that was written, "" when none was
+	Diags   interpreter.Diagnostics // the errors and warnings, with source excerpts
+	Err     error                   // set when the file could not be read or written
+}
 
+// RenderDiag reads a .diag file, parses it, and renders it to an SVG file
+// without printing anything, so it can be used from the TUI.
+// A diagram with errors is still rendered from what could be parsed,
+// the returned diagnostics (with source excerpts) tell what went wrong.
+// The error is only set when the file could not be read or written.
+func RenderDiag(path string) (string, interpreter.Diagnostics, error) {
 	// fmt.Print("Reading:", path)
 	src, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Println("Could not read file:", err)
-		return ""
+		return "", nil, fmt.Errorf("could not read file: %w", err)
 	}
 	// fmt.Printf("Input length: %d bytes\n", len(src))
 
@@ -71,39 +86,196 @@ func RenderDiagToSVG(path string) string {
 	// 	fmt.Printf("%d: %s (%s)\n", i, tok.Value, tok.Type)
 	// }
 
-	diagram, err := interpreter.Parse(tokens)
+	diagram, diags := interpreter.ParseFile(tokens, path, Theme)
 	// fmt.Printf("Nodes: %d, Edges: %d\n", len(diagram.Nodes), len(diagram.Edges))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	interpreter.AttachSource(diags, string(src))
+
+	outPath, err := writeOutput(path, diagram)
 	if err != nil {
-		fmt.Println("Parsing error:", err)
-		return ""
+		return "", diags, err
 	}
+	// fmt.Println("Created:", outPath)
+	return outPath, diags, nil
+}
 
-	svg := renderer.RenderSVG(diagram)
-	base := strings.TrimSuffix(filepath.Base(path), ".diag")
-	outPath := filepath.Join(outputDir, base+".svg")
+// writeOutput renders the diagram in Format and saves it in OutputDir,
+// named after the file it was read from
+func writeOutput(path string, diagram interpreter.Diagram) (string, error) {
+	outputDir := OutputDir
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		err := os.Mkdir(outputDir, 0755)
+		if err != nil {
+			return "", fmt.Errorf("could not create output directory: %w", err)
+		}
+	}
 
-	err = os.WriteFile(outPath, []byte(svg), 0644)
+	render, ok := OutputFormats[Format]
+	if !ok {
+		return "", fmt.Errorf("unknown output format %s", Format)
+	}
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	outPath := filepath.Join(outputDir, base+"."+Format)
+
+	err := os.WriteFile(outPath, []byte(render(diagram)), 0644)
 	if err != nil {
-		fmt.Println("Could not save SVG:", err)
-		return ""
+		return "", fmt.Errorf("could not save %s: %w", strings.ToUpper(Format), err)
+	}
+	return outPath, nil
+}
+
+// ExportFormats write a parsed diagram in another format. The diagnostics
+// are warnings about what the format can not represent.
+var ExportFormats = map[string]func(interpreter.Diagram) ([]byte, interpreter.Diagnostics, error){
+	"json": func(d interpreter.Diagram) ([]byte, interpreter.Diagnostics, error) {
+		data, err := interpreter.ExportJSON(d)
+		return append(data, '\n'), nil, err
+	},
+	"mermaid": func(d interpreter.Diagram) ([]byte, interpreter.Diagnostics, error) {
+		src, diags, err := interpreter.ExportMermaid(d)
+		return []byte(src), diags, err
+	},
+}
+
+// ExportDiag reads a .diag file and returns the parsed diagram in one of
+// ExportFormats, like JSON (see interpreter.ExportJSON). A file with parse
+// errors is not exported, the returned diagnostics (with source excerpts)
+// tell what went wrong or what could not be exported.
+func ExportDiag(path, format string) ([]byte, interpreter.Diagnostics, error) {
+	export, ok := ExportFormats[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown export format %s", format)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read file: %w", err)
 	}
-	// fmt.Println("Created:", outPath)
-	return outPath
 
+	tokens := interpreter.Lex(string(src))
+	diagram, diags := interpreter.ParseFile(tokens, path, Theme)
+	if diags.HasErrors() {
+		interpreter.AttachSource(diags, string(src))
+		return nil, diags, nil
+	}
+	data, diags, err := export(diagram)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not export diagram: %w", err)
+	}
+	interpreter.AttachSource(diags, string(src))
+	return data, diags, nil
+}
+
+// ImportDiag reads a diagram from a JSON file and renders it to an SVG
+// file (or Format) like RenderDiag. The diagnostics are the warnings of Validate,
+// the error is set when the JSON can not be used.
+func ImportDiag(path string) (string, interpreter.Diagnostics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read file: %w", err)
+	}
+	diagram, err := interpreter.ImportJSON(data, Theme)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	diags := interpreter.Validate(diagram)
+
+	outPath, err := writeOutput(path, diagram)
+	if err != nil {
+		return "", diags, err
+	}
+	return outPath, diags, nil
+}
+
+// FormatDiag reads a .diag file and returns it in the canonical form of
+// interpreter.Format, and if that is different from the file.
+// A file with parse errors is not formatted, the returned diagnostics
+// (with source excerpts) tell what went wrong.
+func FormatDiag(path string) (string, bool, interpreter.Diagnostics, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, nil, fmt.Errorf("could not read file: %w", err)
+	}
+
+	tokens := interpreter.Lex(string(src))
+	diagram, diags := interpreter.ParseFile(tokens, path, "")
+	if diags.HasErrors() {
+		interpreter.AttachSource(diags, string(src))
+		return "", false, diags, nil
+	}
+	formatted := interpreter.Format(diagram, tokens)
+	return formatted, formatted != string(src), nil, nil
+}
+
+// Importers read diagrams in other formats, by file extension
+var Importers = map[string]func(src string) (interpreter.Diagram, interpreter.Diagnostics){
+	".dot":     interpreter.ParseDOT,
+	".gv":      interpreter.ParseDOT,
+	".mmd":     interpreter.ParseMermaid,
+	".mermaid": interpreter.ParseMermaid,
+}
+
+// ConvertFile reads a diagram in one of the formats of Importers and
+// returns it as formatted .diag source. A file with errors is not
+// converted, the returned diagnostics (with source excerpts) tell what
+// went wrong and what could not be converted.
+func ConvertFile(path string) (string, interpreter.Diagnostics, error) {
+	parse, ok := Importers[filepath.Ext(path)]
+	if !ok {
+		return "", nil, fmt.Errorf("can not convert %s files", filepath.Ext(path))
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read file: %w", err)
+	}
+
+	diagram, diags := parse(string(src))
+	interpreter.AttachSource(diags, string(src))
+	if diags.HasErrors() {
+		return "", diags, nil
+	}
+	return interpreter.Format(diagram, nil), diags, nil
+}
+
+// LoadThemes registers the .theme files in ThemeDir so diagrams and
+// --theme can use them by name. A missing directory is not an error,
+// the returned error tells which files could not be loaded.
+func LoadThemes() error {
+	files, err := filepath.Glob(filepath.Join(ThemeDir, "*.theme"))
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, file := range files {
+		if _, err := LoadTheme(file); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not load themes:\n%s", strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// LoadTheme reads and registers a theme file and returns its name
+func LoadTheme(path string) (string, error) {
+	t, err := interpreter.LoadTheme(path)
+	if err != nil {
+		return "", err
+	}
+	interpreter.RegisterTheme(t)
+	return t.Name, nil
 }
 
 // RenderAllDiagrams renders all diagrams in the given list of diagram files.
 // It reads each file, processes it, and saves the output as SVG files.
-func RenderAllDiagrams(diagramFiles []string) string {
-	var output string
+// Nothing is printed, the results tell the caller what went wrong.
+func RenderAllDiagrams(diagramFiles []string) []RenderResult {
+	var results []RenderResult
 	for _, file := range diagramFiles {
 		path := filepath.Join("example", file)
-		svgPath := RenderDiagToSVG(path)
+		outPath, diags, err := RenderDiag(path)
 		CombinedTime += time.Since(RenderStart).Milliseconds()
-		if svgPath != "" {
-			output += fmt.Sprintf("Created: %s\n", svgPath)
-
-		}
+		results = append(results, RenderResult{Path: path, OutPath: outPath, Diags: diags, Err: err})
 	}
-	return output
+	return results
 }
@@ -0,0 +1,364 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains the parts of the parser and validator that are only
+// used for class diagrams:
+//
+//	diagram class {
+//		interface Pet {
+//			+ play(): void
+//		}
+//		class Animal "Djur" {
+//			+ name: string
+//			protected age: int
+//			+ static count: int
+//			+ abstract speak(loud: bool): string
+//		}
+//		class Dog
+//		Dog -> Animal (relation=inheritance)
+//		Dog -> Pet (relation=implementation)
+//		Zoo -> Animal "houses" (relation=aggregation, from="1", to="*")
+//	}
+//
+// Classes and interfaces are nodes with Shape "class" or "interface" and
+// their fields and methods in Node.Members. The visibility is + - ~ or one
+// of the words public, private, protected and package ('#' starts a comment).
+// An edge is a relationship, the relation attribute gives its kind.
+
+// classKeywords are the keywords that only make sense in class diagrams
+var classKeywords = map[string]bool{
+	"class":     true,
+	"interface": true,
+}
+
+// visibilities maps the visibility words and symbols to the UML symbol
+var visibilities = map[string]string{
+	"+":         "+",
+	"-":         "-",
+	"~":         "~",
+	"public":    "+",
+	"private":   "-",
+	"protected": "#",
+	"package":   "~",
+}
+
+// Member is a field or method of a class. Params is nil for fields.
+type Member struct {
+	Visibility string // "+", "-", "#", "~" or "" when not given
+	Name       string
+	Type       string // "" when not given
+	Method     bool
+	Params     []Param
+	Static     bool
+	Abstract   bool
+	Pos        Position
+}
+
+// Param is a parameter of a method
+type Param struct {
+	Name string
+	Type string
+}
+
+// String returns the member the way UML writes it, like "+ speak(loud: bool): string"
+func (m Member) String() string {
+	var sb strings.Builder
+	if m.Visibility != "" {
+		sb.WriteString(m.Visibility + " ")
+	}
+	sb.WriteString(m.Name)
+	if m.Method {
+		params := make([]string, len(m.Params))
+		for i, p := range m.Params {
+			params[i] = p.Name
+			if p.Type != "" {
+				params[i] += ": " + p.Type
+			}
+		}
+		sb.WriteString("(" + strings.Join(params, ", ") + ")")
+	}
+	if m.Type != "" {
+		sb.WriteString(": " + m.Type)
+	}
+	return sb.String()
+}
+
+// Relation is the kind of a relationship between two classes
+type Relation string
+
+const (
+	RelationAssociation    Relation = "association"
+	RelationDependency     Relation = "dependency"
+	RelationInheritance    Relation = "inheritance"
+	RelationImplementation Relation = "implementation"
+	RelationComposition    Relation = "composition" // From is the whole, To the part
+	RelationAggregation    Relation = "aggregation" // From is the whole, To the part
+)
+
+var relations = map[Relation]bool{
+	RelationAssociation:    true,
+	RelationDependency:     true,
+	RelationInheritance:    true,
+	RelationImplementation: true,
+	RelationComposition:    true,
+	RelationAggregation:    true,
+}
+
+// classEdgeAttributes are the attributes of a relationship,
+// from and to are the multiplicities written at the ends
+var classEdgeAttributes = map[string]bool{"color": true, "width": true, "relation": true, "from": true, "to": true, "class": true}
+
+// parseClassStatement parses the statements that start with a keyword
+// in a class diagram. Relationships are parsed by parseEdge.
+func (p *parser) parseClassStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "class", "interface":
+		return p.parseClass(d)
+	case "group":
+		return p.parseGroup(d)
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'class' instead of 'node' in class diagrams"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in class diagrams"})
+	return false
+}
+
+// parseClass parses: class ID "Label" (attributes) { members }
+// and the same for interface. The label, attributes and members are optional.
+func (p *parser) parseClass(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	keyword := p.currentToken().Value
+	p.advance() // class or interface
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected(keyword+" id", "expected "+keyword+" id after '"+keyword+"'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes(keyword)
+	}
+
+	n := p.newNode(id, label, keyword, attrs, pos)
+	if p.match(TOKEN_LBRACE) {
+		for p.currentToken().Type != TOKEN_RBRACE && p.currentToken().Type != TOKEN_EOF &&
+			!p.isKeyword(p.currentToken()) {
+			member, ok := p.parseMember()
+			if ok {
+				n.Members = append(n.Members, member)
+				continue
+			}
+			// Skip to the next member or the end of the class
+			p.advance()
+			for !p.atMemberStart() {
+				p.advance()
+			}
+		}
+		if !p.match(TOKEN_RBRACE) {
+			p.fail(p.expected("'}'", "expected '}' at end of "+keyword+" "+id))
+		}
+	}
+
+	p.addNode(d, n)
+	return true
+}
+
+// atMemberStart reports whether the current token starts a member with a
+// visibility or ends the class
+func (p *parser) atMemberStart() bool {
+	tok := p.currentToken()
+	if p.isKeyword(tok) {
+		return true
+	}
+	switch tok.Type {
+	case TOKEN_RBRACE, TOKEN_EOF:
+		return true
+	case TOKEN_SYMBOL, TOKEN_IDENTIFIER:
+		_, ok := visibilities[tok.Value]
+		return ok
+	}
+	return false
+}
+
+// parseMember parses a field or a method:
+//
+//	visibility static abstract name(param: type, ...): type
+//
+// where everything but the name is optional
+func (p *parser) parseMember() (Member, bool) {
+	var m Member
+	m.Pos = p.currentToken().Pos
+
+	if v, ok := visibilities[p.currentToken().Value]; ok && p.peek().Type == TOKEN_IDENTIFIER {
+		m.Visibility = v
+		p.advance()
+	}
+	for {
+		tok := p.currentToken()
+		if tok.Type != TOKEN_IDENTIFIER || p.peek().Type != TOKEN_IDENTIFIER {
+			break
+		}
+		switch tok.Value {
+		case "static":
+			m.Static = true
+		case "abstract":
+			m.Abstract = true
+		default:
+			p.fail(&Diagnostic{Pos: tok.Pos, Message: "unknown member modifier '" + tok.Value + "'", Expected: "static or abstract"})
+			return m, false
+		}
+		p.advance()
+	}
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("member name", "expected field or method name"))
+		return m, false
+	}
+	m.Name = p.currentToken().Value
+	p.advance()
+
+	if p.isSymbol("(") {
+		m.Method = true
+		m.Params = []Param{}
+		p.advance()
+		for !p.isSymbol(")") {
+			if p.currentToken().Type != TOKEN_IDENTIFIER {
+				p.fail(p.expected("parameter name", "expected parameter name in method "+m.Name))
+				return m, false
+			}
+			param := Param{Name: p.currentToken().Value}
+			p.advance()
+			if p.isSymbol(":") {
+				p.advance()
+				typ, ok := p.parseMemberType()
+				if !ok {
+					return m, false
+				}
+				param.Type = typ
+			}
+			m.Params = append(m.Params, param)
+			if !p.isSymbol(",") {
+				break
+			}
+			p.advance()
+		}
+		if !p.isSymbol(")") {
+			p.fail(p.expected("')'", "expected ')' after the parameters of "+m.Name))
+			return m, false
+		}
+		p.advance()
+	}
+
+	if p.isSymbol(":") {
+		p.advance()
+		typ, ok := p.parseMemberType()
+		if !ok {
+			return m, false
+		}
+		m.Type = typ
+	}
+	return m, true
+}
+
+// parseMemberType parses a type, an identifier or a string for
+// types like "List<Item>"
+func (p *parser) parseMemberType() (string, bool) {
+	tok := p.currentToken()
+	if tok.Type != TOKEN_IDENTIFIER && tok.Type != TOKEN_STRING {
+		p.fail(p.expected("type", "expected a type after ':'"))
+		return "", false
+	}
+	p.advance()
+	return tok.Value, true
+}
+
+// relation parses the value of attr as a relationship kind into dst.
+// An invalid kind is reported and dst keeps its default.
+func (p *parser) relation(attr Attribute, dst *Relation) {
+	r := Relation(attr.Value)
+	if !relations[r] {
+		p.fail(&Diagnostic{
+			Pos:      attr.Pos,
+			Message:  fmt.Sprintf("%s: unknown relation '%s'", attr.Key, attr.Value),
+			Expected: "association, dependency, inheritance, implementation, composition or aggregation",
+		})
+		return
+	}
+	*dst = r
+}
+
+// validateClass checks a class diagram: fields must be unique in their
+// class, inheritance must not form a cycle and implementation should
+// point to an interface
+func validateClass(d Diagram, nodes map[string]Node) Diagnostics {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, n := range d.Nodes {
+		fields := map[string]Position{}
+		for _, m := range n.Members {
+			if m.Method {
+				continue
+			}
+			if first, ok := fields[m.Name]; ok {
+				errorf(m.Pos, "duplicate field '%s' in %s '%s', first declared at %s", m.Name, n.Shape, n.ID, first)
+			}
+			fields[m.Name] = m.Pos
+		}
+	}
+
+	parents := map[string][]string{}
+	for _, e := range d.Edges {
+		from, okFrom := nodes[e.From]
+		to, okTo := nodes[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		switch e.Relation {
+		case RelationInheritance:
+			if from.Shape != to.Shape {
+				warnf(e.Pos, "%s '%s' inherits from %s '%s', use relation=implementation", from.Shape, e.From, to.Shape, e.To)
+			}
+			parents[e.From] = append(parents[e.From], e.To)
+		case RelationImplementation:
+			if to.Shape != "interface" {
+				warnf(e.Pos, "'%s' implements '%s' which is not an interface", e.From, e.To)
+			}
+			parents[e.From] = append(parents[e.From], e.To)
+		}
+	}
+
+	// Only the inheritance edges, so an association does not hide a cycle
+	var inheritance []Edge
+	for _, e := range d.Edges {
+		if e.Relation == RelationInheritance || e.Relation == RelationImplementation {
+			inheritance = append(inheritance, e)
+		}
+	}
+	for _, e := range cycleEdges(Diagram{Nodes: d.Nodes, Edges: inheritance}, parents) {
+		errorf(e.Pos, "%s %s -> %s creates a cycle", e.Relation, e.From, e.To)
+	}
+	return diags
+}
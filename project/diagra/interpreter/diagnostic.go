@@ -0,0 +1,213 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Position is a location in the source text.
+// Line and Col are 1-based, Col counts runes, Offset is the byte offset.
+// File is the path of an included file, "" for the file being parsed.
+type Position struct {
+	Line   int
+	Col    int
+	Offset int
+	File   string
+}
+
+// String returns the position as "line:col", or "file:line:col"
+// in an included file
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Severity tells if a diagnostic stops the diagram from being correct
+// (SeverityError) or is just something the user probably wants to know.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+// String returns "error" or "warning"
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic is a structured error produced while interpreting a diagram.
+// Expected and Found are set when the parser wanted a specific token,
+// Excerpt is filled in by AttachSource with the offending line and a caret.
+type Diagnostic struct {
+	Pos      Position
+	Severity Severity
+	Message  string
+	Expected string
+	Found    string
+	Excerpt  string
+}
+
+// Error implements the error interface
+func (d *Diagnostic) Error() string {
+	msg := fmt.Sprintf("%s: %s", d.Pos, d.Message)
+	if d.Severity == SeverityWarning {
+		msg = fmt.Sprintf("%s: warning: %s", d.Pos, d.Message)
+	}
+	if d.Found != "" {
+		msg += ", found " + d.Found
+	}
+	return msg
+}
+
+// Report returns the error message followed by the source excerpt, if any.
+// This is what the CLI prints.
+func (d *Diagnostic) Report() string {
+	if d.Excerpt == "" {
+		return d.Error()
+	}
+	return d.Error() + "\n" + d.Excerpt
+}
+
+// Diagnostics is a list of diagnostics collected in one pass.
+// It is returned as the error from Parse when there is at least one error.
+type Diagnostics []*Diagnostic
+
+// Error implements the error interface, one diagnostic per line
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap lets errors.As find the individual diagnostics
+func (ds Diagnostics) Unwrap() []error {
+	errs := make([]error, len(ds))
+	for i, d := range ds {
+		errs[i] = d
+	}
+	return errs
+}
+
+// Report returns the report of every diagnostic, separated by blank lines
+func (ds Diagnostics) Report() string {
+	reports := make([]string, len(ds))
+	for i, d := range ds {
+		reports[i] = d.Report()
+	}
+	return strings.Join(reports, "\n\n")
+}
+
+// HasErrors reports whether any of the diagnostics is an error
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of errors and warnings
+func (ds Diagnostics) Count() (errs, warnings int) {
+	for _, d := range ds {
+		if d.Severity == SeverityWarning {
+			warnings++
+		} else {
+			errs++
+		}
+	}
+	return errs, warnings
+}
+
+// AttachSource fills in the source excerpt of every diagnostic in err.
+// Errors that are not diagnostics are left untouched. src is the source
+// of the file that was parsed, included files are read again for theirs.
+func AttachSource(err error, src string) {
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		var d *Diagnostic
+		if !errors.As(err, &d) {
+			return
+		}
+		ds = Diagnostics{d}
+	}
+	sources := map[string]string{"": src}
+	for _, d := range ds {
+		source, ok := sources[d.Pos.File]
+		if !ok {
+			data, err := os.ReadFile(d.Pos.File)
+			if err != nil {
+				continue
+			}
+			source = string(data)
+			sources[d.Pos.File] = source
+		}
+		d.Excerpt = excerpt(source, d.Pos)
+	}
+}
+
+// sortDiagnostics sorts diagnostics in the order of the source,
+// the file being parsed first and then the included files
+func sortDiagnostics(ds Diagnostics) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		if ds[i].Pos.File != ds[j].Pos.File {
+			return ds[i].Pos.File < ds[j].Pos.File
+		}
+		return ds[i].Pos.Offset < ds[j].Pos.Offset
+	})
+}
+
+// excerpt returns the line at pos with a caret under the column.
+// Tabs are kept in the caret line so the caret lines up in the terminal.
+func excerpt(src string, pos Position) string {
+	if pos.Line < 1 {
+		return ""
+	}
+	lines := strings.Split(src, "\n")
+	if pos.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[pos.Line-1], "\r")
+
+	var caret strings.Builder
+	for i, r := range []rune(line) {
+		if i >= pos.Col-1 {
+			break
+		}
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return "    " + line + "\n    " + caret.String()
+}
+
+// describe returns a short human readable description of a token,
+// used as the "found" part of a diagnostic.
+func describe(tok Token) string {
+	switch tok.Type {
+	case TOKEN_EOF:
+		return "end of file"
+	case TOKEN_STRING:
+		return fmt.Sprintf("string %q", tok.Value)
+	case TOKEN_IDENTIFIER:
+		return fmt.Sprintf("identifier '%s'", tok.Value)
+	case TOKEN_KEYWORD:
+		return fmt.Sprintf("keyword '%s'", tok.Value)
+	default:
+		return fmt.Sprintf("'%s'", tok.Value)
+	}
+}
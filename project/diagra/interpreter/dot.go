@@ -0,0 +1,940 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file contains ParseDOT, which reads a graph in the Graphviz DOT
+// language as a flowchart:
+//
+//	digraph G {
+//		rankdir=LR
+//		node [shape=box]
+//		start [label="Start", shape=ellipse, fillcolor=lightgreen, style=filled]
+//		subgraph cluster_db { label="Databas"; db [shape=cylinder] }
+//		start -> db [label="sparar", color=red, penwidth=3]
+//	}
+//
+// Clusters become groups, other subgraphs only scope default attributes
+// and group the ends of edges like A -> {B C}. The DOT attributes that
+// have a diagra equivalent are turned into diagra attributes, so Format
+// can write the diagram as .diag source. The ones that have not are left
+// out with a warning, once for each attribute.
+
+// tokenHTML is the token of an HTML-like DOT string, <...>
+const tokenHTML TokenType = "HTML"
+
+// dotKeywords are the keywords of DOT, they are not case sensitive
+var dotKeywords = map[string]bool{
+	"graph": true, "digraph": true, "subgraph": true, "node": true, "edge": true, "strict": true,
+}
+
+// lexDOT returns the tokens of DOT source. Quoted strings joined with +
+// are one token, comments and # lines are skipped.
+func lexDOT(input string) []Token {
+	var tokens []Token
+	runes := []rune(input)
+	length := len(runes)
+	pos := positions(runes)
+
+	emit := func(typ TokenType, value string, start int) {
+		tokens = append(tokens, Token{Type: typ, Value: value, Pos: pos[start]})
+	}
+	isIDStart := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || r >= 0x80
+	}
+	skipSpace := func(i int) int {
+		for i < length && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		return i
+	}
+
+	i := 0
+	for i < length {
+		c := runes[i]
+		next := rune(0)
+		if i+1 < length {
+			next = runes[i+1]
+		}
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		// Comments, and lines starting with # from the C preprocessor
+		case c == '/' && next == '/', c == '#' && (i == 0 || runes[i-1] == '\n'):
+			for i < length && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && next == '*':
+			start := i
+			i += 2
+			for i < length && !(runes[i] == '*' && i+1 < length && runes[i+1] == '/') {
+				i++
+			}
+			if i >= length {
+				emit(TOKEN_ILLEGAL, "unterminated block comment", start)
+				continue
+			}
+			i += 2
+
+		case c == '"':
+			start := i
+			var sb strings.Builder
+			closed := true
+			for closed && i < length && runes[i] == '"' {
+				i++
+				closed = false
+				for i < length {
+					if runes[i] == '\\' && i+1 < length && (runes[i+1] == '"' || runes[i+1] == '\n') {
+						if runes[i+1] == '"' {
+							sb.WriteRune('"')
+						}
+						i += 2
+						continue
+					}
+					if runes[i] == '"' {
+						closed = true
+						i++
+						break
+					}
+					sb.WriteRune(runes[i])
+					i++
+				}
+				// "a" + "b" is "ab"
+				if j := skipSpace(i); closed && j < length && runes[j] == '+' {
+					i = skipSpace(j + 1)
+				}
+			}
+			if !closed {
+				emit(TOKEN_ILLEGAL, "unterminated string", start)
+				continue
+			}
+			emit(TOKEN_STRING, sb.String(), start)
+
+		case c == '<':
+			start := i
+			depth := 0
+			for i < length {
+				if runes[i] == '<' {
+					depth++
+				} else if runes[i] == '>' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+				i++
+			}
+			if i >= length {
+				emit(TOKEN_ILLEGAL, "unterminated HTML string", start)
+				continue
+			}
+			i++
+			emit(tokenHTML, string(runes[start+1:i-1]), start)
+
+		case c == '-' && (next == '>' || next == '-'):
+			emit(TOKEN_ARROW, string(runes[i:i+2]), i)
+			i += 2
+
+		case isIDStart(c):
+			start := i
+			for i < length && (isIDStart(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			value := string(runes[start:i])
+			if dotKeywords[strings.ToLower(value)] {
+				emit(TOKEN_KEYWORD, strings.ToLower(value), start)
+			} else {
+				emit(TOKEN_IDENTIFIER, value, start)
+			}
+
+		// Numerals: 1, -2.5, .5
+		case unicode.IsDigit(c), c == '.' && unicode.IsDigit(next), c == '-' && (unicode.IsDigit(next) || next == '.'):
+			start := i
+			i++
+			for i < length && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			emit(TOKEN_IDENTIFIER, string(runes[start:i]), start)
+
+		case c == '{':
+			emit(TOKEN_LBRACE, "{", i)
+			i++
+		case c == '}':
+			emit(TOKEN_RBRACE, "}", i)
+			i++
+		case strings.ContainsRune("[]=;,:", c):
+			emit(TOKEN_SYMBOL, string(c), i)
+			i++
+		default:
+			emit(TOKEN_ILLEGAL, fmt.Sprintf("unexpected character '%c'", c), i)
+			i++
+		}
+	}
+	emit(TOKEN_EOF, "", length)
+	return tokens
+}
+
+// ParseDOT reads a DOT graph and returns it as a flowchart. Like Parse it
+// does not stop at the first problem, the diagnostics have every error
+// and a warning for each feature that could not be represented.
+func ParseDOT(src string) (Diagram, Diagnostics) {
+	p := &dotParser{
+		foreignParser: newForeignParser(lexDOT(src)),
+		byID:          map[string]*dotNode{},
+		root:          &dotCluster{},
+	}
+	p.parseGraph()
+	d := p.diagram()
+	sortDiagnostics(p.diags)
+	return d, p.diags
+}
+
+// dotParser reads the statements of a DOT graph with the token helpers of
+// parser. Nodes can get attributes in later statements, so the nodes,
+// edges and clusters are collected first and made into a diagram at the end.
+type dotParser struct {
+	*foreignParser
+	directed bool
+	name     string // the name of the graph, for \G in labels
+	attrs    []dotAttr
+	nodes    []*dotNode
+	byID     map[string]*dotNode
+	edges    []dotEdge
+	root     *dotCluster // the graph itself, its clusters are the top level groups
+}
+
+// dotAttr is one key=value of an attribute list, html is set for <...>
+type dotAttr struct {
+	key, value string
+	html       bool
+	pos        Position
+}
+
+type dotNode struct {
+	id      string
+	attrs   []dotAttr // in the order given, the last one of a key wins
+	cluster *dotCluster
+	pos     Position
+}
+
+type dotEdge struct {
+	from, to string
+	attrs    []dotAttr
+	pos      Position
+}
+
+type dotCluster struct {
+	id       string
+	attrs    []dotAttr
+	nodes    []*dotNode
+	clusters []*dotCluster
+	pos      Position
+}
+
+// dotScope is a graph or subgraph being read: the default attributes
+// and the nodes named in it, which are the ends of an edge to a subgraph
+type dotScope struct {
+	node, edge []dotAttr
+	cluster    *dotCluster
+	root       bool
+	mentioned  []string
+}
+
+// unsupported warns about an attribute that has no equivalent
+func (p *dotParser) unsupported(a dotAttr) {
+	p.warnOnce(a.pos, "attribute "+a.key, fmt.Sprintf("the DOT attribute '%s' has no equivalent and is left out", a.key))
+}
+
+// keyword moves past the keyword kw, and reports whether it was there
+func (p *dotParser) keyword(kw string) bool {
+	tok := p.currentToken()
+	if tok.Type == TOKEN_KEYWORD && tok.Value == kw {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+// id moves past an ID: a name, a numeral, a quoted or an HTML string
+func (p *dotParser) id() (Token, bool) {
+	tok := p.currentToken()
+	switch tok.Type {
+	case TOKEN_IDENTIFIER, TOKEN_STRING, tokenHTML:
+		p.advance()
+		return tok, true
+	}
+	return tok, false
+}
+
+// skip moves past the rest of a statement after an error.
+// It stops at a '}', that ends the scope and not the statement.
+func (p *dotParser) skip(line int) {
+	if t := p.currentToken().Type; t != TOKEN_EOF && t != TOKEN_RBRACE {
+		p.advance()
+	}
+	for {
+		tok := p.currentToken()
+		if tok.Type == TOKEN_EOF || tok.Type == TOKEN_RBRACE || tok.Pos.Line > line || p.isSymbol(";") {
+			return
+		}
+		p.advance()
+	}
+}
+
+// parseGraph parses: [strict] (graph | digraph) [ID] { statements }
+func (p *dotParser) parseGraph() {
+	if tok := p.currentToken(); p.keyword("strict") {
+		p.warn(tok.Pos, "strict is not supported, duplicate edges are kept")
+	}
+	switch {
+	case p.keyword("digraph"):
+		p.directed = true
+	case p.keyword("graph"):
+	default:
+		p.fail(p.expected("'graph' or 'digraph'", "expected 'graph' or 'digraph'"))
+		return
+	}
+	if tok, ok := p.id(); ok {
+		p.name = tok.Value
+	}
+	if !p.match(TOKEN_LBRACE) {
+		p.fail(p.expected("'{'", "expected '{' after the graph name"))
+		return
+	}
+	p.statements(&dotScope{root: true, cluster: p.root})
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of graph"))
+	}
+	if tok := p.currentToken(); tok.Type != TOKEN_EOF {
+		p.warn(tok.Pos, "content after the closing '}' is ignored")
+	}
+}
+
+// statements parses statements up to the '}' of the scope
+func (p *dotParser) statements(scope *dotScope) {
+	for {
+		tok := p.currentToken()
+		switch {
+		case tok.Type == TOKEN_EOF || tok.Type == TOKEN_RBRACE:
+			return
+		case p.isSymbol(";"):
+			p.advance()
+		case !p.statement(scope):
+			p.skip(tok.Pos.Line)
+		}
+	}
+}
+
+// statement parses a node, edge, attribute or subgraph statement
+func (p *dotParser) statement(scope *dotScope) bool {
+	tok := p.currentToken()
+	switch {
+	case tok.Type == TOKEN_KEYWORD && (tok.Value == "graph" || tok.Value == "node" || tok.Value == "edge"):
+		p.advance()
+		if !p.isSymbol("[") {
+			p.fail(p.expected("'['", "expected attributes after '"+tok.Value+"'"))
+			return false
+		}
+		attrs, ok := p.attrLists()
+		switch tok.Value {
+		case "graph":
+			p.graphAttrs(scope, attrs)
+		case "node":
+			scope.node = append(scope.node, attrs...)
+		case "edge":
+			scope.edge = append(scope.edge, attrs...)
+		}
+		return ok
+	case tok.Type == TOKEN_KEYWORD && tok.Value == "subgraph", tok.Type == TOKEN_LBRACE:
+		ids, ok := p.subgraph(scope)
+		return ok && p.edgeRest(scope, ids, tok.Pos)
+	}
+
+	id, ok := p.id()
+	if !ok {
+		p.fail(p.expected("statement", "expected a node, edge, attribute or subgraph"))
+		return false
+	}
+	if p.isSymbol("=") {
+		p.advance()
+		value, ok := p.id()
+		if !ok {
+			p.fail(p.expected("value", "expected a value after '"+id.Value+" ='"))
+			return false
+		}
+		p.graphAttrs(scope, []dotAttr{{key: id.Value, value: value.Value, html: value.Type == tokenHTML, pos: id.Pos}})
+		return true
+	}
+	p.port()
+	n := p.node(scope, id.Value, id.Pos)
+	if p.currentToken().Type == TOKEN_ARROW {
+		return p.edgeRest(scope, []string{id.Value}, id.Pos)
+	}
+	attrs, ok := p.attrLists()
+	n.attrs = append(n.attrs, attrs...)
+	return ok
+}
+
+// node returns the node with the DOT id, created with the default
+// attributes of the scope the first time it is named. A node belongs to
+// the first cluster it is named in.
+func (p *dotParser) node(scope *dotScope, id string, pos Position) *dotNode {
+	scope.mentioned = append(scope.mentioned, id)
+	n, ok := p.byID[id]
+	if !ok {
+		n = &dotNode{id: id, attrs: append([]dotAttr{}, scope.node...), pos: pos}
+		p.byID[id] = n
+		p.nodes = append(p.nodes, n)
+	}
+	if n.cluster == nil && scope.cluster != p.root {
+		n.cluster = scope.cluster
+		scope.cluster.nodes = append(scope.cluster.nodes, n)
+	}
+	return n
+}
+
+// port skips the port of a node, A:p or A:p:ne, which has no equivalent
+func (p *dotParser) port() {
+	tok := p.currentToken()
+	for p.isSymbol(":") {
+		p.advance()
+		p.id()
+		p.warnOnce(tok.Pos, "port", "ports are not supported, the edge goes to the node")
+	}
+}
+
+// edgeRest parses the arrows and ends of an edge statement after its
+// first end, from are the nodes of that end
+func (p *dotParser) edgeRest(scope *dotScope, from []string, pos Position) bool {
+	if p.currentToken().Type != TOKEN_ARROW {
+		return true // a subgraph on its own
+	}
+	ends := [][]string{from}
+	for p.currentToken().Type == TOKEN_ARROW {
+		arrow := p.currentToken()
+		p.advance()
+		if want := map[bool]string{true: "->", false: "--"}[p.directed]; arrow.Value != want {
+			p.fail(&Diagnostic{Pos: arrow.Pos, Message: fmt.Sprintf("'%s' can not be used in this graph, use '%s'", arrow.Value, want)})
+		}
+
+		tok := p.currentToken()
+		if tok.Type == TOKEN_LBRACE || (tok.Type == TOKEN_KEYWORD && tok.Value == "subgraph") {
+			ids, ok := p.subgraph(scope)
+			if !ok {
+				return false
+			}
+			ends = append(ends, ids)
+			continue
+		}
+		id, ok := p.id()
+		if !ok {
+			p.fail(p.expected("node id", "expected a node after '"+arrow.Value+"'"))
+			return false
+		}
+		p.port()
+		p.node(scope, id.Value, id.Pos)
+		ends = append(ends, []string{id.Value})
+	}
+
+	attrs, ok := p.attrLists()
+	attrs = append(append([]dotAttr{}, scope.edge...), attrs...)
+	for i := 1; i < len(ends); i++ {
+		for _, from := range ends[i-1] {
+			for _, to := range ends[i] {
+				p.edges = append(p.edges, dotEdge{from: from, to: to, attrs: attrs, pos: pos})
+			}
+		}
+	}
+	return ok
+}
+
+// subgraph parses: [subgraph [ID]] { statements } and returns the nodes
+// named in it. A subgraph whose name starts with "cluster" is a group.
+func (p *dotParser) subgraph(scope *dotScope) ([]string, bool) {
+	pos := p.currentToken().Pos
+	name := ""
+	if p.keyword("subgraph") {
+		if tok, ok := p.id(); ok {
+			name = tok.Value
+		}
+	}
+	if !p.match(TOKEN_LBRACE) {
+		p.fail(p.expected("'{'", "expected '{' after subgraph"))
+		return nil, false
+	}
+
+	inner := &dotScope{
+		node:    append([]dotAttr{}, scope.node...),
+		edge:    append([]dotAttr{}, scope.edge...),
+		cluster: scope.cluster,
+	}
+	if strings.HasPrefix(name, "cluster") {
+		c := &dotCluster{id: name, pos: pos}
+		scope.cluster.clusters = append(scope.cluster.clusters, c)
+		inner.cluster = c
+	}
+	p.statements(inner)
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of subgraph"))
+		return nil, false
+	}
+	scope.mentioned = append(scope.mentioned, inner.mentioned...)
+	return inner.mentioned, true
+}
+
+// attrLists parses [a=b, c=d][e=f], the lists are optional
+func (p *dotParser) attrLists() ([]dotAttr, bool) {
+	var attrs []dotAttr
+	for p.isSymbol("[") {
+		p.advance()
+		for !p.isSymbol("]") {
+			key, ok := p.id()
+			if !ok {
+				p.fail(p.expected("attribute", "expected an attribute or ']'"))
+				return attrs, false
+			}
+			if !p.isSymbol("=") {
+				p.fail(p.expected("'='", "expected '=' after "+key.Value))
+				return attrs, false
+			}
+			p.advance()
+			value, ok := p.id()
+			if !ok {
+				p.fail(p.expected("value", "expected a value for "+key.Value))
+				return attrs, false
+			}
+			attrs = append(attrs, dotAttr{key: key.Value, value: value.Value, html: value.Type == tokenHTML, pos: key.Pos})
+			if p.isSymbol(",") || p.isSymbol(";") {
+				p.advance()
+			}
+		}
+		p.advance() // ]
+	}
+	return attrs, true
+}
+
+// graphAttrs handles the attributes of the graph or a subgraph
+func (p *dotParser) graphAttrs(scope *dotScope, attrs []dotAttr) {
+	switch {
+	case scope.root:
+		p.attrs = append(p.attrs, attrs...)
+	case scope.cluster != p.root && scope.cluster != nil:
+		scope.cluster.attrs = append(scope.cluster.attrs, attrs...)
+	default:
+		for _, a := range attrs {
+			if a.key != "rank" { // only changes the layout of dot
+				p.unsupported(a)
+			}
+		}
+	}
+}
+
+// diagram turns what was read into a flowchart
+func (p *dotParser) diagram() Diagram {
+	d := Diagram{Name: "flowchart", Layout: "vertical", Theme: p.theme}
+	for _, a := range p.attrs {
+		switch a.key {
+		case "rankdir":
+			d.Layout = "vertical"
+			if v := strings.ToUpper(a.value); v == "LR" || v == "RL" {
+				d.Layout = ""
+			}
+		default:
+			p.unsupported(a)
+		}
+	}
+	if d.Layout != "" {
+		d.Attrs = []Attribute{{Key: "layout", Value: d.Layout}}
+	}
+
+	for _, n := range p.nodes {
+		d.Nodes = append(d.Nodes, p.convertNode(n))
+	}
+	for _, c := range p.root.clusters {
+		d.Groups = append(d.Groups, p.convertCluster(c))
+	}
+	for _, e := range p.edges {
+		d.Edges = append(d.Edges, p.convertEdge(e))
+	}
+	return d
+}
+
+// dotShapes maps the DOT shapes to the shapes of diagra
+var dotShapes = map[string]string{
+	"box": "rect", "rect": "rect", "rectangle": "rect", "square": "rect",
+	"ellipse": "ellipse", "oval": "ellipse",
+	"circle": "circle", "doublecircle": "circle", "point": "circle",
+	"diamond":       "diamond",
+	"parallelogram": "parallelogram",
+	"hexagon":       "hexagon",
+	"cylinder":      "cylinder",
+	"note":          "note",
+}
+
+// convertNode turns a DOT node into a diagra node
+func (p *dotParser) convertNode(n *dotNode) Node {
+	id := p.ident(n.id)
+	label := n.id
+	shape := "rect"
+	var fill, border, width, height *dotAttr
+	filled, fixed, record := false, false, false
+
+	var attrs []Attribute
+	set := func(key, value string, pos Position) {
+		for i := range attrs {
+			if attrs[i].Key == key {
+				attrs[i] = Attribute{Key: key, Value: value, Pos: pos}
+				return
+			}
+		}
+		attrs = append(attrs, Attribute{Key: key, Value: value, Pos: pos})
+	}
+
+	for i := range n.attrs {
+		a := &n.attrs[i]
+		switch a.key {
+		case "label":
+			label = p.label(*a, n.id)
+		case "shape":
+			s, ok := dotShapes[strings.ToLower(a.value)]
+			record = strings.EqualFold(a.value, "record") || strings.EqualFold(a.value, "Mrecord")
+			switch {
+			case record:
+				p.warnOnce(a.pos, "shape record", "record shapes are drawn as rectangles with a line for each field")
+				s = "rect"
+			case !ok:
+				p.warnOnce(a.pos, "shape "+a.value, fmt.Sprintf("the DOT shape '%s' has no equivalent, it is drawn as a rectangle", a.value))
+				s = "rect"
+			}
+			shape = s
+		case "fillcolor":
+			fill = a
+		case "color":
+			border = a
+		case "fontcolor":
+			if c, ok := p.dotColor(*a); ok {
+				set("text", c, a.pos)
+			}
+		case "style":
+			for _, style := range strings.Split(a.value, ",") {
+				switch style = strings.TrimSpace(style); style {
+				case "filled":
+					filled = true
+				case "solid", "":
+				default:
+					p.warnOnce(a.pos, "style "+style, fmt.Sprintf("the DOT style '%s' has no equivalent and is left out", style))
+				}
+			}
+		case "width":
+			width = a
+		case "height":
+			height = a
+		case "fixedsize":
+			fixed = a.value == "true" || a.value == "shape"
+		default:
+			p.unsupported(*a)
+		}
+	}
+
+	if record {
+		label = recordLabel(label)
+	}
+	if shape != "rect" {
+		set("shape", shape, Position{})
+	}
+	// Without style=filled dot does not fill the node, it is filled with
+	// the colour when there is no fillcolor
+	if filled && fill == nil {
+		fill = border
+	}
+	if filled && fill != nil {
+		if c, ok := p.dotColor(*fill); ok {
+			set("color", c, fill.pos)
+		}
+	}
+	if border != nil {
+		if c, ok := p.dotColor(*border); ok {
+			set("border", c, border.pos)
+		}
+	}
+	// width and height are the smallest size in dot, unless the size is fixed
+	for key, a := range map[string]*dotAttr{"width": width, "height": height} {
+		if a == nil || !fixed {
+			continue
+		}
+		inches, err := strconv.ParseFloat(a.value, 64)
+		if err != nil || inches <= 0 {
+			p.fail(&Diagnostic{Pos: a.pos, Message: fmt.Sprintf("%s: invalid number '%s'", key, a.value)})
+			continue
+		}
+		set(key, strconv.Itoa(int(math.Round(inches*72))), a.pos)
+	}
+	sortAttributes(attrs)
+	return p.newNode(id, label, shape, attrs, n.pos)
+}
+
+// sortAttributes puts attributes in the order Format writes them, so the
+// converted diagram does not depend on the order of a map
+func sortAttributes(attrs []Attribute) {
+	for i := 1; i < len(attrs); i++ {
+		for j := i; j > 0 && attributeRank(attrs[j].Key) < attributeRank(attrs[j-1].Key); j-- {
+			attrs[j], attrs[j-1] = attrs[j-1], attrs[j]
+		}
+	}
+}
+
+// convertCluster turns a cluster into a group
+func (p *dotParser) convertCluster(c *dotCluster) Group {
+	name := strings.TrimPrefix(strings.TrimPrefix(c.id, "cluster"), "_")
+	if name == "" {
+		name = c.id
+	}
+	id := p.unique(name)
+	label := ""
+
+	// Like nodes, a cluster is only filled with fillcolor or color when it
+	// is filled, bgcolor always fills it
+	byKey := map[string]dotAttr{}
+	filled := false
+	for _, a := range c.attrs {
+		switch a.key {
+		case "label":
+			label = p.label(a, "")
+		case "fillcolor", "bgcolor", "color", "pencolor", "fontcolor":
+			byKey[a.key] = a
+		case "style":
+			filled = strings.Contains(a.value, "filled")
+		default:
+			p.unsupported(a)
+		}
+	}
+	var attrs []Attribute
+	add := func(key string, candidates ...string) {
+		for _, c := range candidates {
+			a, ok := byKey[c]
+			if !ok {
+				continue
+			}
+			if v, ok := p.dotColor(a); ok {
+				attrs = append(attrs, Attribute{Key: key, Value: v, Pos: a.pos})
+			}
+			return
+		}
+	}
+	if filled {
+		add("color", "fillcolor", "color", "bgcolor")
+	} else {
+		add("color", "bgcolor")
+	}
+	add("border", "pencolor", "color")
+	add("text", "fontcolor")
+	if label == "" {
+		label = id
+	}
+	sortAttributes(attrs)
+
+	g := p.newGroup(id, label, attrs, c.pos)
+	for _, n := range c.nodes {
+		g.Nodes = append(g.Nodes, p.ident(n.id))
+	}
+	for _, inner := range c.clusters {
+		g.Groups = append(g.Groups, p.convertCluster(inner))
+	}
+	return g
+}
+
+// convertEdge turns a DOT edge into a diagra edge
+func (p *dotParser) convertEdge(de dotEdge) Edge {
+	e := Edge{
+		From:  p.ident(de.from),
+		To:    p.ident(de.to),
+		Kind:  EdgeDirected,
+		Color: p.theme.EdgeColor,
+		Width: Length{Value: 2},
+		Pos:   de.pos,
+	}
+	dir := "forward"
+	if !p.directed {
+		dir = "none"
+	}
+	style := ""
+	var attrs []Attribute
+	for _, a := range de.attrs {
+		switch a.key {
+		case "label":
+			e.Label = p.label(a, "")
+		case "color":
+			if c, ok := p.dotColor(a); ok {
+				attrs = append(attrs, Attribute{Key: "color", Value: c, Pos: a.pos})
+			}
+		case "penwidth":
+			attrs = append(attrs, Attribute{Key: "width", Value: a.value, Pos: a.pos})
+		case "dir":
+			switch a.value {
+			case "forward", "back", "both", "none":
+				dir = a.value
+			default:
+				p.fail(&Diagnostic{Pos: a.pos, Message: fmt.Sprintf("dir: unknown direction '%s'", a.value)})
+			}
+		case "style":
+			switch a.value {
+			case "dashed", "dotted", "bold", "solid":
+				style = a.value
+			default:
+				p.warnOnce(a.pos, "style "+a.value, fmt.Sprintf("the DOT style '%s' has no equivalent and is left out", a.value))
+			}
+		default:
+			p.unsupported(a)
+		}
+	}
+
+	switch dir {
+	case "back":
+		e.From, e.To = e.To, e.From
+	case "none":
+		e.Kind = EdgeUndirected
+	case "both":
+		e.Kind = EdgeBidirectional
+	}
+	if style != "" && style != "solid" {
+		if e.Kind != EdgeDirected {
+			p.warnOnce(de.pos, "style of "+string(e.Kind), fmt.Sprintf("%s edges can not be %s, they are drawn solid", e.Kind, style))
+		} else {
+			e.Kind = map[string]EdgeKind{"dashed": EdgeDashed, "dotted": EdgeDotted, "bold": EdgeThick}[style]
+		}
+	}
+	if e.Kind == EdgeThick {
+		e.Width = Length{Value: 4}
+	}
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "color":
+			p.color(attr, &e.Color)
+		case "width":
+			p.length(attr, &e.Width)
+		}
+	}
+	sortAttributes(attrs)
+	e.Attrs = attrs
+	return e
+}
+
+// dotColor returns a DOT colour as a diagra colour. DOT reads names from the
+// X11 scheme, most of them are CSS colours too, and hue, saturation and
+// value as three numbers from 0 to 1.
+func (p *dotParser) dotColor(a dotAttr) (string, bool) {
+	value := strings.ToLower(strings.TrimSpace(a.value))
+	if first, _, found := strings.Cut(value, ":"); found {
+		p.warnOnce(a.pos, "colour list", "colour lists are not supported, the first colour is used")
+		value, _, _ = strings.Cut(first, ";")
+	}
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "/x11/"), "/svg/")
+
+	if parts := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }); len(parts) == 3 {
+		var hsv [3]float64
+		ok := true
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(part, 64)
+			ok = ok && err == nil && v >= 0 && v <= 1
+			hsv[i] = v
+		}
+		if ok {
+			return hsvToHex(hsv[0], hsv[1], hsv[2]), true
+		}
+	}
+	if _, err := ParseColor(value); err != nil || strings.HasPrefix(value, "rgb") {
+		p.warnOnce(a.pos, "colour "+value, fmt.Sprintf("%s: the colour '%s' is not a CSS colour and is left out", a.key, a.value))
+		return "", false
+	}
+	return value, true
+}
+
+// hsvToHex returns a colour given as hue, saturation and value as #rrggbb
+func hsvToHex(h, s, v float64) string {
+	h = math.Mod(h*6, 6)
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := v - c
+	channel := func(f float64) int { return int(math.Round((f + m) * 255)) }
+	return fmt.Sprintf("#%02x%02x%02x", channel(r), channel(g), channel(b))
+}
+
+// recordLabel returns the fields of a record label like "{<a> A|B}" as
+// lines, without the ports and braces
+func recordLabel(label string) string {
+	label = regexp.MustCompile(`<[^>]*>`).ReplaceAllString(label, "")
+	var lines []string
+	for _, field := range strings.FieldsFunc(label, func(r rune) bool { return strings.ContainsRune("{}|", r) }) {
+		if field = strings.TrimSpace(field); field != "" {
+			lines = append(lines, field)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	dotHTMLBreak = regexp.MustCompile(`(?i)<br\s*/?>|</tr>`)
+	dotHTMLTag   = regexp.MustCompile(`<[^>]*>`)
+	dotEntities  = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ", "&amp;", "&")
+)
+
+// label returns a DOT label as text. The escapes \n, \l and \r are line
+// breaks and \N is the name of the node. HTML-like labels keep only their
+// text.
+func (p *dotParser) label(a dotAttr, node string) string {
+	if a.html {
+		p.warnOnce(a.pos, "html", "HTML-like labels are not supported, only the text is kept")
+		text := dotHTMLTag.ReplaceAllString(dotHTMLBreak.ReplaceAllString(a.value, "\n"), "")
+		var lines []string
+		for _, line := range strings.Split(dotEntities.Replace(text), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	var sb strings.Builder
+	runes := []rune(a.value)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '\\' || i+1 == len(runes) {
+			sb.WriteRune(runes[i])
+			continue
+		}
+		i++
+		switch runes[i] {
+		case 'n', 'l', 'r':
+			sb.WriteRune('\n')
+		case 'N':
+			sb.WriteString(node)
+		case 'G':
+			sb.WriteString(p.name)
+		case 'E', 'T', 'H':
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
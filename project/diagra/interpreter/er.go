@@ -0,0 +1,289 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains the parts of the parser and validator that are only
+// used for entity-relationship diagrams:
+//
+//	diagram er {
+//		entity User "Användare" {
+//			id: int PK
+//			email: varchar(255) UK
+//		}
+//		entity Order {
+//			id: int PK
+//			user_id: int FK
+//		}
+//		Order.user_id -> User.id "places" (from=many, to=one)
+//	}
+//
+// Entities are nodes with Shape "entity" and their columns in Node.Columns.
+// A relationship is an edge, Entity.column attaches it to a column row.
+// The cardinality of each end is one, zero_or_one, many or zero_or_many,
+// a relationship without them is many to one (a foreign key to a key).
+
+// erKeywords are the keywords that only make sense in ER diagrams
+var erKeywords = map[string]bool{
+	"entity": true,
+}
+
+// Column is a column of an entity: name: type PK FK UK
+type Column struct {
+	Name   string
+	Type   string
+	PK     bool // primary key
+	FK     bool // foreign key
+	Unique bool
+	Pos    Position
+}
+
+// Keys returns the key markers of the column, like "PK, FK"
+func (c Column) Keys() string {
+	var keys []string
+	if c.PK {
+		keys = append(keys, "PK")
+	}
+	if c.FK {
+		keys = append(keys, "FK")
+	}
+	if c.Unique {
+		keys = append(keys, "UK")
+	}
+	return strings.Join(keys, ", ")
+}
+
+// Cardinality is how many rows one end of a relationship can have
+type Cardinality string
+
+const (
+	CardinalityOne        Cardinality = "one"
+	CardinalityZeroOrOne  Cardinality = "zero_or_one"
+	CardinalityMany       Cardinality = "many" // one or many
+	CardinalityZeroOrMany Cardinality = "zero_or_many"
+)
+
+var cardinalities = map[Cardinality]bool{
+	CardinalityOne:        true,
+	CardinalityZeroOrOne:  true,
+	CardinalityMany:       true,
+	CardinalityZeroOrMany: true,
+}
+
+// erEdgeAttributes are the attributes of a relationship
+var erEdgeAttributes = map[string]bool{"color": true, "width": true, "from": true, "to": true, "class": true}
+
+// parseERStatement parses the statements that start with a keyword
+// in an ER diagram. Relationships are parsed by parseEdge.
+func (p *parser) parseERStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "entity":
+		return p.parseEntity(d)
+	case "group":
+		return p.parseGroup(d)
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'entity' instead of 'node' in ER diagrams"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in ER diagrams"})
+	return false
+}
+
+// parseEntity parses: entity ID "Label" (attributes) { columns }
+// The label, attributes and columns are optional.
+func (p *parser) parseEntity(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	p.advance() // entity
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("entity id", "expected entity id after 'entity'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("entity")
+	}
+
+	n := p.newNode(id, label, "entity", attrs, pos)
+	if p.match(TOKEN_LBRACE) {
+		for p.currentToken().Type != TOKEN_RBRACE && p.currentToken().Type != TOKEN_EOF &&
+			(p.atColumnName() || !p.isKeyword(p.currentToken())) {
+			column, ok := p.parseColumn()
+			if ok {
+				n.Columns = append(n.Columns, column)
+				continue
+			}
+			// Skip to the next column or the end of the entity
+			p.advance()
+			for !p.atColumnStart() {
+				p.advance()
+			}
+		}
+		if !p.match(TOKEN_RBRACE) {
+			p.fail(p.expected("'}'", "expected '}' at end of entity "+id))
+		}
+	}
+
+	p.addNode(d, n)
+	return true
+}
+
+// atColumnStart reports whether the current token starts a column
+// or ends the entity
+func (p *parser) atColumnStart() bool {
+	tok := p.currentToken()
+	return tok.Type == TOKEN_RBRACE || tok.Type == TOKEN_EOF || p.isKeyword(tok) || p.atColumnName()
+}
+
+// atColumnName reports whether the current token is a column name, an
+// identifier or a keyword followed by ':'. A keyword without the ':' ends
+// the entity.
+func (p *parser) atColumnName() bool {
+	tok, next := p.currentToken(), p.peek()
+	return (tok.Type == TOKEN_IDENTIFIER || p.isKeyword(tok)) && next.Type == TOKEN_SYMBOL && next.Value == ":"
+}
+
+// parseColumn parses: name: type PK FK UK
+// The type can have parameters, varchar(255) or decimal(10, 2).
+func (p *parser) parseColumn() (Column, bool) {
+	tok := p.currentToken()
+	if tok.Type != TOKEN_IDENTIFIER && !p.atColumnName() {
+		p.fail(p.expected("column name", "expected column name"))
+		return Column{}, false
+	}
+	c := Column{Name: tok.Value, Pos: tok.Pos}
+	p.advance()
+
+	if !p.isSymbol(":") {
+		p.fail(p.expected("':'", "expected ':' after column "+c.Name))
+		return c, false
+	}
+	p.advance()
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("column type", "expected type for column "+c.Name))
+		return c, false
+	}
+	c.Type = p.currentToken().Value
+	p.advance()
+
+	// Type parameters
+	if p.isSymbol("(") {
+		var params []string
+		p.advance()
+		for p.currentToken().Type == TOKEN_NUMBER {
+			params = append(params, p.currentToken().Value)
+			p.advance()
+			if !p.isSymbol(",") {
+				break
+			}
+			p.advance()
+		}
+		if !p.isSymbol(")") {
+			p.fail(p.expected("')'", "expected ')' after the parameters of type "+c.Type))
+			return c, false
+		}
+		p.advance()
+		c.Type += "(" + strings.Join(params, ",") + ")"
+	}
+
+	// Key markers, up to the next column
+	for p.currentToken().Type == TOKEN_IDENTIFIER && !p.atColumnStart() {
+		marker := p.currentToken()
+		switch strings.ToUpper(marker.Value) {
+		case "PK":
+			c.PK = true
+		case "FK":
+			c.FK = true
+		case "UK", "UNIQUE":
+			c.Unique = true
+		default:
+			p.fail(&Diagnostic{
+				Pos:      marker.Pos,
+				Message:  "unknown column marker '" + marker.Value + "'",
+				Expected: "PK, FK or UK",
+				Found:    describe(marker),
+			})
+		}
+		p.advance()
+	}
+	return c, true
+}
+
+// cardinality parses the value of attr as a cardinality into dst.
+// An invalid cardinality is reported and dst keeps its default.
+func (p *parser) cardinality(attr Attribute, dst *Cardinality) {
+	c := Cardinality(attr.Value)
+	if !cardinalities[c] {
+		p.fail(&Diagnostic{
+			Pos:      attr.Pos,
+			Message:  fmt.Sprintf("%s: unknown cardinality '%s'", attr.Key, attr.Value),
+			Expected: "one, zero_or_one, many or zero_or_many",
+		})
+		return
+	}
+	*dst = c
+}
+
+// validateER checks an ER diagram: column names must be unique in their
+// entity, relationships must attach to existing columns and every entity
+// should have a primary key
+func validateER(d Diagram, nodes map[string]Node) Diagnostics {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, n := range d.Nodes {
+		if n.Shape != "entity" {
+			continue
+		}
+		seen := map[string]Position{}
+		hasPK := false
+		for _, c := range n.Columns {
+			if first, ok := seen[c.Name]; ok {
+				errorf(c.Pos, "duplicate column '%s' in entity '%s', first declared at %s", c.Name, n.ID, first)
+			}
+			seen[c.Name] = c.Pos
+			hasPK = hasPK || c.PK
+		}
+		if len(n.Columns) > 0 && !hasPK {
+			warnf(n.Pos, "entity '%s' has no primary key", n.ID)
+		}
+	}
+
+	hasColumn := func(entity, column string) bool {
+		for _, c := range nodes[entity].Columns {
+			if c.Name == column {
+				return true
+			}
+		}
+		return false
+	}
+	for _, e := range d.Edges {
+		if _, ok := nodes[e.From]; ok && e.FromPort != "" && !hasColumn(e.From, e.FromPort) {
+			errorf(e.Pos, "entity '%s' has no column '%s'", e.From, e.FromPort)
+		}
+		if _, ok := nodes[e.To]; ok && e.ToPort != "" && !hasColumn(e.To, e.ToPort) {
+			errorf(e.Pos, "entity '%s' has no column '%s'", e.To, e.ToPort)
+		}
+	}
+	return diags
+}
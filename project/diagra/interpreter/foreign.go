@@ -0,0 +1,90 @@
+package interpreter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file contains foreignParser, the part the parsers of other diagram
+// languages (dot.go, mermaid.go) have in common: the ids of the other
+// language are made into diagra ids, and a feature that can not be
+// represented is only warned about once. The writers of other languages
+// use the same two helpers, foreignIDs and onceWarner.
+
+// foreignParser has the parser helpers (newNode, newGroup, color, warn, ...)
+// and keeps track of the ids and warnings of an import
+type foreignParser struct {
+	*parser
+	foreignIDs
+	onceWarner
+}
+
+// newForeignParser returns a foreignParser reading tokens, which can be nil for
+// languages that are not read with the token helpers
+func newForeignParser(tokens []Token) *foreignParser {
+	p := &foreignParser{parser: newParser(tokens, ""), foreignIDs: newForeignIDs(keywords)}
+	p.onceWarner = newOnceWarner(&p.diags)
+	return p
+}
+
+// onceWarner warns about each feature the first time it is used
+type onceWarner struct {
+	out    *Diagnostics
+	warned map[string]bool
+}
+
+// newOnceWarner returns a onceWarner adding its warnings to out
+func newOnceWarner(out *Diagnostics) onceWarner {
+	return onceWarner{out: out, warned: map[string]bool{}}
+}
+
+// warnOnce warns about a feature the first time it is used
+func (w *onceWarner) warnOnce(pos Position, feature, message string) {
+	if w.warned[feature] {
+		return
+	}
+	w.warned[feature] = true
+	*w.out = append(*w.out, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: message})
+}
+
+var invalidID = regexp.MustCompile(`[^\p{L}\p{N}_]+`)
+
+// foreignIDs turns the ids of one language into the ids of another
+type foreignIDs struct {
+	ids      map[string]string // ids to the ids of the other language
+	used     map[string]bool   // the ids of the other language that are taken
+	reserved map[string]bool   // the keywords of the other language
+}
+
+// newForeignIDs returns a foreignIDs that never gives one of reserved
+func newForeignIDs(reserved map[string]bool) foreignIDs {
+	return foreignIDs{ids: map[string]string{}, used: map[string]bool{}, reserved: reserved}
+}
+
+// ident returns the id of a foreign id, the same id every time
+func (f *foreignIDs) ident(foreign string) string {
+	if id, ok := f.ids[foreign]; ok {
+		return id
+	}
+	id := f.unique(foreign)
+	f.ids[foreign] = id
+	return id
+}
+
+// unique returns an id for name that is not taken. Characters an
+// identifier can not have become _, and an id that is taken or a keyword
+// gets a number.
+func (f *foreignIDs) unique(name string) string {
+	base := strings.Trim(invalidID.ReplaceAllString(name, "_"), "_")
+	if base == "" || !unicode.IsLetter([]rune(base)[0]) {
+		base = "n" + base
+	}
+	id := base
+	for i := 2; f.used[id] || f.reserved[id]; i++ {
+		id = base + strconv.Itoa(i)
+	}
+	f.used[id] = true
+	return id
+}
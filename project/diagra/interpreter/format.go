@@ -0,0 +1,622 @@
+package interpreter
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// This file contains Format, which writes a diagram back as .diag source
+// in the one canonical form that `diagra fmt` uses:
+//
+//	diagram flowchart (layout=vertical) {
+//		node A "Start" (shape=ellipse, color=lightgreen)
+//		group G "Grupp" {
+//			node B "B"
+//		}
+//		A -> B "vidare" (color=red, width=3)
+//	}
+//
+// Every statement is on a line of its own, indented with one tab for each
+// block it is in. Statements keep the order they were written in, the
+// attributes of a statement are sorted by attributeOrder and values are
+// only quoted when they have to be. Labels are always quoted, and left out
+// when they are the id and the keyword does not need one.
+//
+// The comments and the blank lines between statements come from the
+// tokens the diagram was parsed from. What an include brings in is not
+// written, only the include itself, and neither are the nodes that were
+// created because an edge used them.
+
+// attributeOrder is the order attributes are written in,
+// attributes that are not listed come last in alphabetical order
+var attributeOrder = []string{
+	"class", "layout", "theme", "today",
+	"shape", "relation", "from", "to", "start", "duration",
+	"color", "text", "border", "width", "height",
+}
+
+// Format returns the canonical source of a diagram. tokens are the tokens
+// it was parsed from, for the comments and blank lines, or nil for a
+// diagram that was not read from source. A diagram with parse errors is
+// written as far as it could be parsed, so it should not be formatted.
+func Format(d Diagram, tokens []Token) string {
+	f := newFormatter(d, tokens)
+	f.diagram()
+	return strings.Join(f.lines, "\n") + "\n"
+}
+
+// formatter holds the state of Format
+type formatter struct {
+	d        Diagram
+	tokens   []Token
+	comments []Comment         // the comments that are not written yet, in source order
+	blank    map[int]bool      // offsets of the tokens and comments with a blank line before them
+	parent   map[string]*Group // the group every node is declared in, nil at the top level
+	lines    []string
+	depth    int
+}
+
+// newFormatter collects the comments and blank lines from tokens
+func newFormatter(d Diagram, tokens []Token) *formatter {
+	f := &formatter{d: d, tokens: tokens, blank: map[int]bool{}, parent: map[string]*Group{}}
+
+	last := 0 // the line the token or comment before ends on
+	for _, tok := range tokens {
+		for _, c := range tok.Comments {
+			f.comments = append(f.comments, c)
+			if last > 0 && c.Pos.Line > last+1 {
+				f.blank[c.Pos.Offset] = true
+			}
+			last = c.Pos.Line + strings.Count(c.Text, "\n")
+		}
+		if last > 0 && tok.Pos.Line > last+1 {
+			f.blank[tok.Pos.Offset] = true
+		}
+		last = tok.Pos.Line
+	}
+
+	var walk func(gs []Group)
+	walk = func(gs []Group) {
+		for i := range gs {
+			for _, id := range gs[i].Nodes {
+				if _, ok := f.parent[id]; !ok {
+					f.parent[id] = &gs[i]
+				}
+			}
+			walk(gs[i].Groups)
+		}
+	}
+	walk(f.d.Groups)
+	return f
+}
+
+// line writes text on a line of its own for the statement at pos,
+// after the comments that come before it
+func (f *formatter) line(pos Position, text string) {
+	f.flush(pos.Offset)
+	if f.blank[pos.Offset] && len(f.lines) > 0 {
+		if last := f.lines[len(f.lines)-1]; last != "" && !strings.HasSuffix(last, "{") {
+			f.lines = append(f.lines, "")
+		}
+	}
+	f.lines = append(f.lines, strings.Repeat("\t", f.depth)+text)
+}
+
+// flush writes the comments before offset. A comment that was on the
+// same line as the token before it stays at the end of that line.
+func (f *formatter) flush(offset int) {
+	for len(f.comments) > 0 && f.comments[0].Pos.Offset < offset {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		if c.Trailing && len(f.lines) > 0 && f.lines[len(f.lines)-1] != "" {
+			f.lines[len(f.lines)-1] += " " + c.Text
+			continue
+		}
+		f.line(c.Pos, c.Text)
+	}
+}
+
+// close writes the '}' of a block, end is the offset of the '}' in the
+// source so the comments inside the block stay inside it
+func (f *formatter) close(end int) {
+	f.flush(end)
+	f.depth--
+	f.lines = append(f.lines, strings.Repeat("\t", f.depth)+"}")
+}
+
+// tokenAt returns the index of the token at pos, or -1
+func (f *formatter) tokenAt(pos Position) int {
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].Pos.Offset >= pos.Offset })
+	if i == len(f.tokens) || f.tokens[i].Pos.Offset != pos.Offset {
+		return -1
+	}
+	return i
+}
+
+// blockEnd returns the offset of the '}' that closes the block of the
+// statement at pos, or -1 when it is not in the tokens
+func (f *formatter) blockEnd(pos Position) int {
+	i := f.tokenAt(pos)
+	if i < 0 || pos.File != "" {
+		return -1
+	}
+	depth := 0
+	for ; i < len(f.tokens); i++ {
+		switch f.tokens[i].Type {
+		case TOKEN_LBRACE:
+			depth++
+		case TOKEN_RBRACE:
+			depth--
+			if depth <= 0 {
+				return f.tokens[i].Pos.Offset
+			}
+		}
+	}
+	return -1
+}
+
+// inside reports whether pos is in the block of the group g
+func (f *formatter) inside(pos Position, g Group) bool {
+	return pos.Offset > g.Pos.Offset && pos.Offset < f.blockEnd(g.Pos)
+}
+
+// item is a statement of a block and the function that writes it
+type item struct {
+	pos   Position
+	write func()
+}
+
+// block writes the statements of a block in the order they were written
+func (f *formatter) block(items []item) {
+	sort.SliceStable(items, func(i, j int) bool { return items[i].pos.Offset < items[j].pos.Offset })
+	for _, it := range items {
+		it.write()
+	}
+}
+
+// diagram writes the whole diagram
+func (f *formatter) diagram() {
+	var pos Position
+	if len(f.tokens) > 0 {
+		pos = f.tokens[0].Pos
+	}
+	f.line(pos, "diagram "+f.d.Name+formatAttributes(f.d.Attrs)+" {")
+	f.depth++
+	f.block(f.items(nil))
+	f.close(f.blockEnd(pos))
+	f.flush(math.MaxInt)
+}
+
+// items returns the statements of the block of g,
+// or of the diagram itself when g is nil
+func (f *formatter) items(g *Group) []item {
+	d := f.d
+	groups := d.Groups
+	if g != nil {
+		groups = g.Groups
+	}
+	// here reports whether the statement at pos is in this block,
+	// with the tokens to tell edges and styles in a group apart
+	here := func(pos Position) bool {
+		if pos.File != "" || (g != nil && !f.inside(pos, *g)) {
+			return false
+		}
+		for _, inner := range groups {
+			if f.inside(pos, inner) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var items []item
+	for _, inc := range d.Includes {
+		if here(inc.Pos) {
+			text := "include " + quote(inc.Path)
+			if inc.As != "" {
+				text += " as " + inc.As
+			}
+			items = append(items, item{inc.Pos, func() { f.line(inc.Pos, text) }})
+		}
+	}
+	for _, s := range d.Styles {
+		if here(s.Pos) {
+			items = append(items, item{s.Pos, func() { f.line(s.Pos, "style "+s.Name+formatAttributes(s.Attrs)) }})
+		}
+	}
+
+	if d.Name == "mindmap" {
+		if g == nil {
+			items = append(items, f.topics()...)
+		}
+		return items
+	}
+
+	for _, n := range d.Nodes {
+		if n.Pos.File == "" && !n.Implicit && f.parent[n.ID] == g {
+			items = append(items, item{n.Pos, func() { f.node(n) }})
+		}
+	}
+	for i := range groups {
+		inner := &groups[i]
+		if inner.Pos.File == "" {
+			items = append(items, item{inner.Pos, func() { f.group(inner) }})
+		}
+	}
+
+	var edges []Edge
+	for _, e := range d.Edges {
+		if here(e.Pos) {
+			edges = append(edges, e)
+		}
+	}
+	for i := 0; i < len(edges); i++ {
+		chain := []Edge{edges[i]}
+		arrows := []string{edgeArrow(edges[i].Kind)}
+		for i+1 < len(edges) && f.continues(edges[i], edges[i+1]) {
+			i++
+			chain = append(chain, edges[i])
+			arrows = append(arrows, edgeArrow(edges[i].Kind))
+		}
+		items = append(items, item{chain[0].Pos, func() { f.line(chain[0].Pos, formatEdges(chain, arrows)) }})
+	}
+
+	if g == nil {
+		items = append(items, f.steps(d.Steps)...)
+	}
+	return items
+}
+
+// continues reports whether e is the next link of a chain of edges after
+// prev, like B -> C after A -> B in A -> B -> C. Only the tokens can tell.
+func (f *formatter) continues(prev, e Edge) bool {
+	if prev.To != e.From || prev.ToPort != e.FromPort || e.Pos.File != "" {
+		return false
+	}
+	i := f.tokenAt(e.Pos)
+	return i > 0 && f.tokens[i-1].Type == TOKEN_ARROW
+}
+
+// node writes a node with its columns or members
+func (f *formatter) node(n Node) {
+	keyword := f.nodeKeyword(n)
+	text := keyword + " " + n.ID
+	switch {
+	case keyword == "initial" || keyword == "final":
+	case keyword == "node" || n.Label != n.ID:
+		text += " " + quote(n.Label)
+	}
+	if n.Task != nil && len(n.Task.After) > 0 {
+		text += " after " + strings.Join(n.Task.After, ", ")
+	}
+	text += formatAttributes(n.Attrs)
+
+	if len(n.Columns) == 0 && len(n.Members) == 0 {
+		f.line(n.Pos, text)
+		return
+	}
+	f.line(n.Pos, text+" {")
+	f.depth++
+	for _, c := range n.Columns {
+		f.line(c.Pos, formatColumn(c))
+	}
+	for _, m := range n.Members {
+		f.line(m.Pos, formatMember(m))
+	}
+	f.close(f.blockEnd(n.Pos))
+}
+
+// nodeKeyword returns the keyword that declares n in this type of diagram
+func (f *formatter) nodeKeyword(n Node) string {
+	switch f.d.Name {
+	case "sequence":
+		if n.Shape == "actor" {
+			return "actor"
+		}
+		return "participant"
+	case "state":
+		if n.Shape == "initial" || n.Shape == "final" {
+			return n.Shape
+		}
+		return "state"
+	case "er":
+		return "entity"
+	case "class":
+		if n.Shape == "interface" {
+			return "interface"
+		}
+		return "class"
+	case "gantt":
+		if n.Shape == "milestone" {
+			return "milestone"
+		}
+		return "task"
+	}
+	return "node"
+}
+
+// group writes a group and everything in it. Composite states are
+// written with state and the groups of gantt diagrams as sections.
+func (f *formatter) group(g *Group) {
+	keyword := "group"
+	switch f.d.Name {
+	case "state":
+		keyword = "state"
+	case "gantt":
+		keyword = "section"
+	}
+	text := keyword + " " + g.ID
+	if g.Label != g.ID {
+		text += " " + quote(g.Label)
+	}
+	f.line(g.Pos, text+formatAttributes(g.Attrs)+" {")
+	f.depth++
+	f.block(f.items(g))
+	f.close(f.blockEnd(g.Pos))
+}
+
+// topics returns the central topics of a mind map, the other topics are
+// written inside their parent
+func (f *formatter) topics() []item {
+	children := map[string][]string{}
+	hasParent := map[string]bool{}
+	for _, e := range f.d.Edges {
+		children[e.From] = append(children[e.From], e.To)
+		hasParent[e.To] = true
+	}
+	nodes := map[string]Node{}
+	for _, n := range f.d.Nodes {
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+		}
+	}
+
+	written := map[string]bool{}
+	var topic func(n Node)
+	topic = func(n Node) {
+		written[n.ID] = true
+		text := "topic " + n.ID
+		if n.Label != n.ID {
+			text += " " + quote(n.Label)
+		}
+		text += formatAttributes(n.Attrs)
+		if len(children[n.ID]) == 0 {
+			f.line(n.Pos, text)
+			return
+		}
+		f.line(n.Pos, text+" {")
+		f.depth++
+		for _, id := range children[n.ID] {
+			if child, ok := nodes[id]; ok && !written[id] {
+				topic(child)
+			}
+		}
+		f.close(f.blockEnd(n.Pos))
+	}
+
+	var items []item
+	for _, n := range f.d.Nodes {
+		if n.Pos.File == "" && !hasParent[n.ID] {
+			items = append(items, item{n.Pos, func() {
+				if !written[n.ID] {
+					topic(n)
+				}
+			}})
+		}
+	}
+	return items
+}
+
+// steps returns the steps of a sequence diagram, or of a section of a
+// fragment
+func (f *formatter) steps(steps []Step) []item {
+	var items []item
+	for i := 0; i < len(steps); i++ {
+		s := steps[i]
+		if s.Pos.File != "" {
+			continue
+		}
+		switch s.Kind {
+		case StepMessage:
+			chain := []Edge{s.Edge}
+			arrows := []string{messageArrow(s.Message)}
+			for i+1 < len(steps) && steps[i+1].Kind == StepMessage && f.continues(steps[i].Edge, steps[i+1].Edge) {
+				i++
+				chain = append(chain, steps[i].Edge)
+				arrows = append(arrows, messageArrow(steps[i].Message))
+			}
+			items = append(items, item{s.Pos, func() { f.line(s.Pos, formatEdges(chain, arrows)) }})
+		case StepActivate, StepDeactivate:
+			items = append(items, item{s.Pos, func() { f.line(s.Pos, string(s.Kind)+" "+s.Participants[0]) }})
+		case StepNote:
+			text := "note over " + strings.Join(s.Participants, ", ")
+			if s.Placement != "over" {
+				text = "note " + s.Placement + " of " + s.Participants[0]
+			}
+			items = append(items, item{s.Pos, func() { f.line(s.Pos, text+" "+quote(s.Text)) }})
+		case StepFragment:
+			items = append(items, item{s.Pos, func() { f.fragment(s) }})
+		}
+	}
+	return items
+}
+
+// fragment writes a loop, alt or opt with its sections
+func (f *formatter) fragment(s Step) {
+	for i, section := range s.Fragment.Sections {
+		header := "{"
+		if section.Label != "" {
+			header = quote(section.Label) + " {"
+		}
+		if i == 0 {
+			f.line(s.Pos, s.Fragment.Kind+" "+header)
+		} else {
+			// else goes after the '}' of the section before it
+			// unless a comment is in the way
+			f.flush(section.Pos.Offset)
+			closing := strings.Repeat("\t", f.depth) + "}"
+			if last := len(f.lines) - 1; f.lines[last] == closing {
+				f.lines[last] += " else " + header
+			} else {
+				f.lines = append(f.lines, strings.Repeat("\t", f.depth)+"else "+header)
+			}
+		}
+		f.depth++
+		f.block(f.steps(section.Steps))
+		f.close(f.blockEnd(section.Pos))
+	}
+}
+
+// formatEdges returns an edge, or a chain of edges that share their label
+// and attributes, with the arrows into each of the ends
+func formatEdges(chain []Edge, arrows []string) string {
+	var sb strings.Builder
+	sb.WriteString(formatEnd(chain[0].From, chain[0].FromPort))
+	for i, e := range chain {
+		sb.WriteString(" " + arrows[i] + " " + formatEnd(e.To, e.ToPort))
+	}
+	last := chain[len(chain)-1]
+	if last.Label != "" {
+		sb.WriteString(" " + quote(last.Label))
+	}
+	sb.WriteString(formatAttributes(last.Attrs))
+	return sb.String()
+}
+
+// formatEnd returns the end of an edge, with the column in ER diagrams
+func formatEnd(id, port string) string {
+	if port != "" {
+		return id + "." + port
+	}
+	return id
+}
+
+// edgeArrow returns the arrow of an edge kind
+func edgeArrow(kind EdgeKind) string {
+	for arrow, k := range edgeKinds {
+		if k == kind {
+			return arrow
+		}
+	}
+	return "->"
+}
+
+// messageArrow returns the arrow of a message kind
+func messageArrow(kind MessageKind) string {
+	for arrow, k := range messageKinds {
+		if k == kind {
+			return arrow
+		}
+	}
+	return "->"
+}
+
+// formatColumn returns a column of an entity: name: type PK FK UK
+func formatColumn(c Column) string {
+	text := c.Name + ": " + strings.ReplaceAll(c.Type, ",", ", ")
+	if c.PK {
+		text += " PK"
+	}
+	if c.FK {
+		text += " FK"
+	}
+	if c.Unique {
+		text += " UK"
+	}
+	return text
+}
+
+// formatMember returns a field or method of a class. Protected is written
+// as a word because '#' starts a comment.
+func formatMember(m Member) string {
+	var parts []string
+	switch m.Visibility {
+	case "":
+	case "#":
+		parts = append(parts, "protected")
+	default:
+		parts = append(parts, m.Visibility)
+	}
+	if m.Static {
+		parts = append(parts, "static")
+	}
+	if m.Abstract {
+		parts = append(parts, "abstract")
+	}
+
+	name := m.Name
+	if m.Method {
+		params := make([]string, len(m.Params))
+		for i, p := range m.Params {
+			params[i] = p.Name
+			if p.Type != "" {
+				params[i] += ": " + formatType(p.Type)
+			}
+		}
+		name += "(" + strings.Join(params, ", ") + ")"
+	}
+	if m.Type != "" {
+		name += ": " + formatType(m.Type)
+	}
+	return strings.Join(append(parts, name), " ")
+}
+
+// formatType returns the type of a member, quoted when it is not an id
+func formatType(t string) string {
+	tokens := Lex(t)
+	if len(tokens) == 2 && tokens[0].Type == TOKEN_IDENTIFIER && tokens[0].Value == t {
+		return t
+	}
+	return quote(t)
+}
+
+// formatAttributes returns an attribute list in the canonical order,
+// with a space in front, or "" when there are no attributes
+func formatAttributes(attrs []Attribute) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	sorted := append([]Attribute{}, attrs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := attributeRank(sorted[i].Key), attributeRank(sorted[j].Key)
+		if a != b {
+			return a < b
+		}
+		return a == len(attributeOrder) && sorted[i].Key < sorted[j].Key
+	})
+	parts := make([]string, len(sorted))
+	for i, attr := range sorted {
+		parts[i] = attr.Key + "=" + formatValue(attr.Value)
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// attributeRank returns the place of an attribute in attributeOrder
+func attributeRank(key string) int {
+	for i, k := range attributeOrder {
+		if k == key {
+			return i
+		}
+	}
+	return len(attributeOrder)
+}
+
+// formatValue returns an attribute value, in quotes unless it is read
+// back as the same value without them
+func formatValue(value string) string {
+	tokens := Lex("=" + value)
+	if len(tokens) == 3 && tokens[1].Value == value && len(tokens[1].Comments) == 0 {
+		switch tokens[1].Type {
+		case TOKEN_IDENTIFIER, TOKEN_NUMBER, TOKEN_COLOR, TOKEN_DATE, TOKEN_KEYWORD:
+			return value
+		}
+	}
+	return quote(value)
+}
+
+// quote returns s as a string with the escapes the lexer reads
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
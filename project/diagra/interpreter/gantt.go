@@ -0,0 +1,310 @@
+package interpreter
+
+import (
+	"fmt"
+	"time"
+)
+
+// This file contains the parts of the parser and validator that are only
+// used for gantt diagrams:
+//
+//	diagram gantt (today=2024-03-20) {
+//		section Design "Design" {
+//			task Spec "Specification" (start=2024-03-01, duration=5d)
+//			task Mock "Mockups" after Spec (duration=3d)
+//		}
+//		section Build "Build" {
+//			task API after Mock (duration=2w)
+//			task UI after Mock, API (duration=4d, color=#ffe0b2)
+//			milestone Release "Release" after UI
+//		}
+//	}
+//
+// Tasks and milestones are nodes with Shape "task" or "milestone" and the
+// dates in Node.Task, sections are groups. A task starts at its start date,
+// when all tasks it comes after have ended, or if it has neither, when
+// the task declared before it ends. Schedule works out the dates.
+
+// ganttKeywords are the keywords that only make sense in gantt diagrams
+var ganttKeywords = map[string]bool{
+	"task":      true,
+	"milestone": true,
+	"section":   true,
+}
+
+// Task is the schedule of a task as written. Start is zero when not given,
+// Days is 0 for a milestone.
+type Task struct {
+	Start time.Time
+	Days  int
+	After []string
+}
+
+// TaskSpan is when a task starts and ends after scheduling.
+// End is the day after the last day of the task, End == Start for a milestone.
+type TaskSpan struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Attributes of tasks and of the gantt diagram itself
+var (
+	ganttDiagramAttributes = map[string]bool{"today": true, "theme": true}
+	ganttTaskAttributes    = map[string]bool{"color": true, "text": true, "border": true, "start": true, "duration": true, "class": true}
+)
+
+// parseGanttStatement parses the statements that start with a keyword
+// in a gantt diagram
+func (p *parser) parseGanttStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "task", "milestone":
+		return p.parseTask(d)
+	case "section", "group":
+		return p.parseGroup(d)
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'task' instead of 'node' in gantt diagrams"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in gantt diagrams"})
+	return false
+}
+
+// parseTask parses: task ID "Label" after A, B (attributes)
+// and the same for milestone. The label, after and attributes are optional.
+func (p *parser) parseTask(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	keyword := p.currentToken().Value
+	p.advance() // task or milestone
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected(keyword+" id", "expected "+keyword+" id after '"+keyword+"'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	task := &Task{}
+	if tok := p.currentToken(); tok.Type == TOKEN_IDENTIFIER && tok.Value == "after" {
+		p.advance()
+		for {
+			if p.currentToken().Type != TOKEN_IDENTIFIER {
+				p.fail(p.expected("task id", "expected task id after 'after'"))
+				return false
+			}
+			task.After = append(task.After, p.reference())
+			if !p.isSymbol(",") {
+				break
+			}
+			p.advance()
+		}
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes(keyword)
+	}
+	n := p.newNode(id, label, keyword, attrs, pos)
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "start":
+			p.date(attr, &task.Start)
+		case "duration":
+			if keyword == "milestone" {
+				p.warn(attr.Pos, "a milestone has no duration, duration is ignored")
+				continue
+			}
+			p.days(attr, &task.Days)
+		}
+	}
+	if keyword == "task" && task.Days == 0 && !hasAttribute(attrs, "duration") {
+		p.fail(&Diagnostic{Pos: pos, Message: "task " + id + " has no duration"})
+	}
+	n.Task = task
+
+	p.addNode(d, n)
+	return true
+}
+
+// hasAttribute reports whether the attribute key is in attrs
+func hasAttribute(attrs []Attribute, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// date parses the value of attr as a date into dst.
+// An invalid date is reported and dst keeps its default.
+func (p *parser) date(attr Attribute, dst *time.Time) {
+	t, err := ParseDate(attr.Value)
+	if err != nil {
+		p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("%s: %v", attr.Key, err)})
+		return
+	}
+	*dst = t
+}
+
+// days parses the value of attr as a number of days into dst.
+// An invalid duration is reported and dst keeps its default.
+func (p *parser) days(attr Attribute, dst *int) {
+	n, err := ParseDays(attr.Value)
+	if err != nil {
+		p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("%s: %v", attr.Key, err)})
+		return
+	}
+	*dst = n
+}
+
+// Today returns the date of the today attribute of a gantt diagram
+func (d Diagram) Today() (time.Time, bool) {
+	for _, attr := range d.Attrs {
+		if attr.Key == "today" {
+			t, err := ParseDate(attr.Value)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// Schedule works out when every task of a gantt diagram starts and ends.
+// Tasks that can not be scheduled, because they have no start or depend
+// on themselves, are left out and reported in the diagnostics. So are the
+// tasks that come after them, each at its own position.
+func Schedule(d Diagram) (map[string]TaskSpan, Diagnostics) {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var order []Node
+	tasks := map[string]Node{}
+	previous := map[string]string{}
+	for _, n := range d.Nodes {
+		if n.Task == nil {
+			continue
+		}
+		if _, ok := tasks[n.ID]; ok {
+			continue // duplicate, reported by Validate
+		}
+		if len(order) > 0 {
+			previous[n.ID] = order[len(order)-1].ID
+		}
+		tasks[n.ID] = n
+		order = append(order, n)
+	}
+
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := map[string]int{}
+	spans := map[string]TaskSpan{}
+	cycle := map[string]bool{} // the tasks of a reported cycle
+
+	var resolve func(id string) (TaskSpan, bool)
+	resolve = func(id string) (TaskSpan, bool) {
+		switch state[id] {
+		case active:
+			return TaskSpan{}, false
+		case done:
+			span, ok := spans[id]
+			return span, ok
+		}
+		state[id] = active
+		defer func() { state[id] = done }()
+
+		n := tasks[id]
+		start := n.Task.Start
+		ok := true
+		for _, dep := range n.Task.After {
+			if _, exists := tasks[dep]; !exists {
+				errorf(n.Pos, "task '%s' comes after undefined task '%s'", id, dep)
+				ok = false
+				continue
+			}
+			if state[dep] == active {
+				errorf(n.Pos, "task '%s' comes after '%s' which depends on '%s', a cycle", id, dep, id)
+				cycle[id], cycle[dep] = true, true
+				ok = false
+				continue
+			}
+			span, resolved := resolve(dep)
+			if !resolved {
+				if !cycle[id] {
+					errorf(n.Pos, "task '%s' can not be scheduled because '%s' can not be scheduled", id, dep)
+				}
+				ok = false
+				continue
+			}
+			if !n.Task.Start.IsZero() && span.End.After(n.Task.Start) {
+				diags = append(diags, &Diagnostic{
+					Pos:      n.Pos,
+					Severity: SeverityWarning,
+					Message:  fmt.Sprintf("task '%s' starts %s, before '%s' ends %s", id, n.Task.Start.Format(DateLayout), dep, span.End.Format(DateLayout)),
+				})
+			}
+			if span.End.After(start) {
+				start = span.End
+			}
+		}
+		if !ok {
+			return TaskSpan{}, false
+		}
+
+		if start.IsZero() {
+			prev, hasPrev := previous[id]
+			if !hasPrev {
+				errorf(n.Pos, "task '%s' has no start date and nothing to come after", id)
+				return TaskSpan{}, false
+			}
+			if state[prev] == active {
+				errorf(n.Pos, "task '%s' has no start date and follows '%s' which comes after it, a cycle", id, prev)
+				cycle[id], cycle[prev] = true, true
+				return TaskSpan{}, false
+			}
+			span, resolved := resolve(prev)
+			if !resolved {
+				if !cycle[id] {
+					errorf(n.Pos, "task '%s' has no start date and can not be scheduled because '%s' can not be scheduled", id, prev)
+				}
+				return TaskSpan{}, false
+			}
+			start = span.End
+		}
+
+		span := TaskSpan{Start: start, End: start.AddDate(0, 0, n.Task.Days)}
+		spans[id] = span
+		return span, true
+	}
+
+	for _, n := range order {
+		resolve(n.ID)
+	}
+	return spans, diags
+}
+
+// validateGantt checks a gantt diagram by scheduling it
+func validateGantt(d Diagram) Diagnostics {
+	var diags Diagnostics
+	for _, attr := range d.Attrs {
+		if attr.Key == "today" {
+			if _, err := ParseDate(attr.Value); err != nil {
+				diags = append(diags, &Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("today: %v", err)})
+			}
+		}
+	}
+	_, problems := Schedule(d)
+	return append(diags, problems...)
+}
@@ -0,0 +1,259 @@
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// This file contains include, which pulls the nodes, edges, groups and
+// styles of another .diag file into the diagram:
+//
+//	diagram flowchart {
+//		include "shared/services.diag"
+//		include "shared/db.diag" as store
+//
+//		node Web "Webb"
+//		Web -> services.API
+//		services.API -> store.Users
+//	}
+//
+// The path is relative to the file with the include. The ids of the
+// included file get its namespace in front, the file name without the
+// extension or the name after 'as', so two files can both have a node
+// called API. Styles are shared without a namespace, the diagram's own
+// style with the same name wins over an included one.
+//
+// Positions in the included file have its path in File, so errors point
+// to the right file. An include cycle is reported at the include that
+// closes it.
+
+// Include is an include statement as written, As is "" when the
+// namespace is not given with 'as'
+type Include struct {
+	Path string
+	As   string
+	Pos  Position
+}
+
+// ParseFile is ParseWithTheme for the tokens of the file at path.
+// Includes are resolved relative to the directory of the file.
+func ParseFile(tokens []Token, path, theme string) (Diagram, Diagnostics) {
+	p := newParser(tokens, path)
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			p.includes = []string{abs}
+		}
+	}
+	d := p.parseDiagram(theme)
+	sortDiagnostics(p.diags)
+	return d, p.diags
+}
+
+// lexFile lexes the source of an included file,
+// every position gets the path of the file
+func lexFile(src, path string) []Token {
+	tokens := Lex(src)
+	for i := range tokens {
+		tokens[i].Pos.File = path
+		for j := range tokens[i].Comments {
+			tokens[i].Comments[j].Pos.File = path
+		}
+	}
+	return tokens
+}
+
+// parseInclude parses: include "path" [as NAME]
+func (p *parser) parseInclude(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	p.advance() // include
+
+	if p.currentToken().Type != TOKEN_STRING {
+		p.fail(p.expected("file name", "expected file name after 'include'"))
+		return false
+	}
+	name := p.currentToken().Value
+	p.advance()
+
+	namespace := namespaceOf(name)
+	include := Include{Path: name, Pos: pos}
+	if tok := p.currentToken(); tok.Type == TOKEN_IDENTIFIER && tok.Value == "as" {
+		p.advance()
+		if p.currentToken().Type != TOKEN_IDENTIFIER {
+			p.fail(p.expected("namespace", "expected namespace after 'as'"))
+			return false
+		}
+		namespace = p.currentToken().Value
+		include.As = namespace
+		p.advance()
+	}
+	d.Includes = append(d.Includes, include)
+	if namespace == "" {
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("'%s' is not a valid namespace, use 'as' to name it", filepath.Base(name))})
+		return true
+	}
+	if first, ok := p.namespaces[namespace]; ok {
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("namespace '%s' is already used by the include at %s, use 'as' to pick another", namespace, first)})
+		return true
+	}
+	// Also when the include fails, so its ids are still read as references
+	p.namespaces[namespace] = pos
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.file), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for i, file := range p.includes {
+		if file != abs {
+			continue
+		}
+		var chain []string
+		for _, f := range p.includes[i:] {
+			chain = append(chain, filepath.Base(f))
+		}
+		chain = append(chain, filepath.Base(abs))
+		p.fail(&Diagnostic{Pos: pos, Message: "include cycle: " + strings.Join(chain, " -> ")})
+		return true
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("could not read include: %v", err)})
+		return true
+	}
+
+	// The included file gets the theme of this one
+	sub := newParser(lexFile(string(src), path), path)
+	sub.includes = append(append([]string{}, p.includes...), abs)
+	sub.undefined = p.undefined // the merged elements are styled again here
+	included := sub.parseDiagram(p.theme.Name)
+	p.diags = append(p.diags, sub.diags...)
+
+	if included.Name != d.Name && (len(included.Nodes) > 0 || len(included.Steps) > 0) {
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("can not include a %s diagram in a %s diagram", included.Name, d.Name)})
+		return true
+	}
+
+	for inner := range sub.namespaces {
+		p.namespaces[namespace+"."+inner] = pos
+	}
+	p.merge(d, included, namespace)
+	return true
+}
+
+// merge adds the contents of an included diagram to d,
+// with the namespace in front of every id
+func (p *parser) merge(d *Diagram, included Diagram, namespace string) {
+	prefix := func(id string) string {
+		return namespace + "." + id
+	}
+	prefixAll := func(ids []string) []string {
+		var out []string
+		for _, id := range ids {
+			out = append(out, prefix(id))
+		}
+		return out
+	}
+
+	for _, n := range included.Nodes {
+		n.ID = prefix(n.ID)
+		if n.Task != nil {
+			task := *n.Task
+			task.After = prefixAll(task.After)
+			n.Task = &task
+		}
+		p.addNode(d, n)
+	}
+	for _, e := range included.Edges {
+		e.From, e.To = prefix(e.From), prefix(e.To)
+		d.Edges = append(d.Edges, e)
+	}
+
+	var prefixSteps func(steps []Step) []Step
+	prefixSteps = func(steps []Step) []Step {
+		var out []Step
+		for _, s := range steps {
+			s.Participants = prefixAll(s.Participants)
+			if s.Kind == StepMessage {
+				s.Edge.From, s.Edge.To = prefix(s.Edge.From), prefix(s.Edge.To)
+			}
+			if s.Fragment != nil {
+				f := *s.Fragment
+				f.Sections = nil
+				for _, section := range s.Fragment.Sections {
+					section.Steps = prefixSteps(section.Steps)
+					f.Sections = append(f.Sections, section)
+				}
+				s.Fragment = &f
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+	for _, s := range prefixSteps(included.Steps) {
+		p.addStep(d, s)
+	}
+
+	var prefixGroup func(g Group) Group
+	prefixGroup = func(g Group) Group {
+		g.ID = prefix(g.ID)
+		g.Nodes = prefixAll(g.Nodes)
+		var groups []Group
+		for _, inner := range g.Groups {
+			groups = append(groups, prefixGroup(inner))
+		}
+		g.Groups = groups
+		return g
+	}
+	for _, g := range included.Groups {
+		if p.group != nil {
+			p.group.Groups = append(p.group.Groups, prefixGroup(g))
+		} else {
+			d.Groups = append(d.Groups, prefixGroup(g))
+		}
+	}
+
+	p.included = append(p.included, included.Styles...)
+}
+
+// reference parses a node id that is used, not declared. After the
+// namespace of an include it takes the rest of the id: services.API.
+func (p *parser) reference() string {
+	id := p.currentToken().Value
+	p.advance()
+	for p.isNamespace(id) && p.isSymbol(".") && isNodeID(p.peek()) {
+		p.advance() // .
+		id += "." + p.currentToken().Value
+		p.advance()
+	}
+	return id
+}
+
+// isNamespace reports whether id is the namespace of an include
+func (p *parser) isNamespace(id string) bool {
+	_, ok := p.namespaces[id]
+	return ok
+}
+
+// namespaceOf returns the default namespace of an included file, its
+// name without the extension with '_' for everything that can not be in
+// an id. It is "" when the name can not be an id at all, like 2024.diag.
+func namespaceOf(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	id := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, base)
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) || keywords[id] {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,595 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This file contains the JSON form of a Diagram, for the tools that want
+// the parsed model instead of the SVG:
+//
+//	{
+//	  "version": 1,
+//	  "type": "flowchart",
+//	  "layout": "vertical",
+//	  "theme": "light",
+//	  "nodes": [
+//	    {"id": "A", "label": "Start", "shape": "ellipse", "color": "lightgreen", ...}
+//	  ],
+//	  "edges": [
+//	    {"from": "A", "to": "B", "kind": "directed", "label": "vidare", ...}
+//	  ]
+//	}
+//
+// The json types below are the schema. JSONVersion is raised when a field
+// is removed or changes meaning, a new field does not change the version
+// and readers should ignore fields they do not know. Colours, shapes and
+// sizes are the values after the theme and the styles are applied, the
+// attributes are the ones written in the source. Positions are only there
+// for diagrams that were parsed from source.
+
+// JSONVersion is the version of the JSON schema that ExportJSON writes
+// and the newest one ImportJSON reads
+const JSONVersion = 1
+
+type jsonDiagram struct {
+	Version    int             `json:"version"`
+	Type       string          `json:"type"`
+	Layout     string          `json:"layout,omitempty"`
+	Theme      string          `json:"theme,omitempty"`
+	Attributes []jsonAttribute `json:"attributes,omitempty"`
+	Nodes      []jsonNode      `json:"nodes"`
+	Edges      []jsonEdge      `json:"edges"`
+	Groups     []jsonGroup     `json:"groups,omitempty"`
+	Steps      []jsonStep      `json:"steps,omitempty"` // sequence diagrams
+	Styles     []jsonStyle     `json:"styles,omitempty"`
+}
+
+type jsonPosition struct {
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	Offset int    `json:"offset"`
+	File   string `json:"file,omitempty"` // an included file
+}
+
+type jsonAttribute struct {
+	Key   string        `json:"key"`
+	Value string        `json:"value"`
+	Pos   *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonNode struct {
+	ID         string          `json:"id"`
+	Label      string          `json:"label"`
+	Shape      string          `json:"shape,omitempty"`
+	Color      Color           `json:"color,omitempty"`
+	Text       Color           `json:"text,omitempty"`
+	Border     Color           `json:"border,omitempty"`
+	Width      string          `json:"width,omitempty"` // a length like "120" or "3cm"
+	Height     string          `json:"height,omitempty"`
+	Columns    []jsonColumn    `json:"columns,omitempty"` // ER diagrams
+	Members    []jsonMember    `json:"members,omitempty"` // class diagrams
+	Task       *jsonTask       `json:"task,omitempty"`    // gantt diagrams
+	Implicit   bool            `json:"implicit,omitempty"`
+	Attributes []jsonAttribute `json:"attributes,omitempty"`
+	Pos        *jsonPosition   `json:"pos,omitempty"`
+}
+
+type jsonColumn struct {
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	PK     bool          `json:"pk,omitempty"`
+	FK     bool          `json:"fk,omitempty"`
+	Unique bool          `json:"unique,omitempty"`
+	Pos    *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonMember struct {
+	Visibility string        `json:"visibility,omitempty"` // "+", "-", "#" or "~"
+	Name       string        `json:"name"`
+	Type       string        `json:"type,omitempty"`
+	Method     bool          `json:"method,omitempty"`
+	Params     []jsonParam   `json:"params,omitempty"`
+	Static     bool          `json:"static,omitempty"`
+	Abstract   bool          `json:"abstract,omitempty"`
+	Pos        *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonParam struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+type jsonTask struct {
+	Start string   `json:"start,omitempty"` // 2024-03-15
+	Days  int      `json:"days,omitempty"`
+	After []string `json:"after,omitempty"`
+}
+
+type jsonEdge struct {
+	From             string          `json:"from"`
+	FromPort         string          `json:"fromPort,omitempty"` // ER diagrams: the column
+	FromCardinality  Cardinality     `json:"fromCardinality,omitempty"`
+	To               string          `json:"to"`
+	ToPort           string          `json:"toPort,omitempty"`
+	ToCardinality    Cardinality     `json:"toCardinality,omitempty"`
+	Kind             EdgeKind        `json:"kind,omitempty"`
+	Relation         Relation        `json:"relation,omitempty"` // class diagrams
+	FromMultiplicity string          `json:"fromMultiplicity,omitempty"`
+	ToMultiplicity   string          `json:"toMultiplicity,omitempty"`
+	Label            string          `json:"label,omitempty"`
+	Color            Color           `json:"color,omitempty"`
+	Width            string          `json:"width,omitempty"`
+	Transition       *jsonTransition `json:"transition,omitempty"` // state diagrams, read from the label on import
+	Attributes       []jsonAttribute `json:"attributes,omitempty"`
+	Pos              *jsonPosition   `json:"pos,omitempty"`
+}
+
+type jsonTransition struct {
+	Event  string `json:"event,omitempty"`
+	Guard  string `json:"guard,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+type jsonGroup struct {
+	ID         string          `json:"id"`
+	Label      string          `json:"label"`
+	Color      Color           `json:"color,omitempty"`
+	Border     Color           `json:"border,omitempty"`
+	Text       Color           `json:"text,omitempty"`
+	Nodes      []string        `json:"nodes,omitempty"`
+	Groups     []jsonGroup     `json:"groups,omitempty"`
+	Attributes []jsonAttribute `json:"attributes,omitempty"`
+	Pos        *jsonPosition   `json:"pos,omitempty"`
+}
+
+type jsonStep struct {
+	Kind         StepKind      `json:"kind"`
+	Message      MessageKind   `json:"message,omitempty"`
+	Edge         *jsonEdge     `json:"edge,omitempty"`
+	Participants []string      `json:"participants,omitempty"`
+	Placement    string        `json:"placement,omitempty"`
+	Text         string        `json:"text,omitempty"`
+	Fragment     *jsonFragment `json:"fragment,omitempty"`
+	Pos          *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonFragment struct {
+	Kind     string        `json:"kind"`
+	Sections []jsonSection `json:"sections"`
+}
+
+type jsonSection struct {
+	Label string        `json:"label,omitempty"`
+	Steps []jsonStep    `json:"steps,omitempty"`
+	Pos   *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonStyle struct {
+	Name       string          `json:"name"`
+	Attributes []jsonAttribute `json:"attributes,omitempty"`
+	Pos        *jsonPosition   `json:"pos,omitempty"`
+}
+
+// ExportJSON returns the diagram as indented JSON in schema JSONVersion
+func ExportJSON(d Diagram) ([]byte, error) {
+	jd := jsonDiagram{
+		Version:    JSONVersion,
+		Type:       d.Name,
+		Layout:     d.Layout,
+		Theme:      d.Theme.Name,
+		Attributes: exportAttributes(d.Attrs),
+		Nodes:      []jsonNode{},
+		Edges:      []jsonEdge{},
+	}
+	for _, n := range d.Nodes {
+		jd.Nodes = append(jd.Nodes, exportNode(n))
+	}
+	for _, e := range d.Edges {
+		jd.Edges = append(jd.Edges, exportEdge(e))
+	}
+	for _, g := range d.Groups {
+		jd.Groups = append(jd.Groups, exportGroup(g))
+	}
+	jd.Steps = exportSteps(d.Steps)
+	for _, s := range d.Styles {
+		jd.Styles = append(jd.Styles, jsonStyle{Name: s.Name, Attributes: exportAttributes(s.Attrs), Pos: exportPosition(s.Pos)})
+	}
+	return json.MarshalIndent(jd, "", "  ")
+}
+
+// exportPosition returns nil for a position that is not in any source
+func exportPosition(pos Position) *jsonPosition {
+	if pos.Line == 0 {
+		return nil
+	}
+	return &jsonPosition{Line: pos.Line, Col: pos.Col, Offset: pos.Offset, File: pos.File}
+}
+
+func exportAttributes(attrs []Attribute) []jsonAttribute {
+	var out []jsonAttribute
+	for _, attr := range attrs {
+		out = append(out, jsonAttribute{Key: attr.Key, Value: attr.Value, Pos: exportPosition(attr.Pos)})
+	}
+	return out
+}
+
+// exportLength returns a length as written, "" when it is not set
+func exportLength(l Length) string {
+	if l == (Length{}) {
+		return ""
+	}
+	return l.String()
+}
+
+func exportNode(n Node) jsonNode {
+	jn := jsonNode{
+		ID: n.ID, Label: n.Label, Shape: n.Shape,
+		Color: n.Color, Text: n.Text, Border: n.Border,
+		Width: exportLength(n.Width), Height: exportLength(n.Height),
+		Implicit:   n.Implicit,
+		Attributes: exportAttributes(n.Attrs),
+		Pos:        exportPosition(n.Pos),
+	}
+	for _, c := range n.Columns {
+		jn.Columns = append(jn.Columns, jsonColumn{Name: c.Name, Type: c.Type, PK: c.PK, FK: c.FK, Unique: c.Unique, Pos: exportPosition(c.Pos)})
+	}
+	for _, m := range n.Members {
+		jm := jsonMember{Visibility: m.Visibility, Name: m.Name, Type: m.Type, Method: m.Method, Static: m.Static, Abstract: m.Abstract, Pos: exportPosition(m.Pos)}
+		for _, p := range m.Params {
+			jm.Params = append(jm.Params, jsonParam{Name: p.Name, Type: p.Type})
+		}
+		jn.Members = append(jn.Members, jm)
+	}
+	if n.Task != nil {
+		jn.Task = &jsonTask{Days: n.Task.Days, After: n.Task.After}
+		if !n.Task.Start.IsZero() {
+			jn.Task.Start = n.Task.Start.Format(DateLayout)
+		}
+	}
+	return jn
+}
+
+func exportEdge(e Edge) jsonEdge {
+	je := jsonEdge{
+		From: e.From, FromPort: e.FromPort, FromCardinality: e.FromCard,
+		To: e.To, ToPort: e.ToPort, ToCardinality: e.ToCard,
+		Kind: e.Kind, Relation: e.Relation,
+		FromMultiplicity: e.FromMultiplicity, ToMultiplicity: e.ToMultiplicity,
+		Label: e.Label, Color: e.Color, Width: exportLength(e.Width),
+		Attributes: exportAttributes(e.Attrs),
+		Pos:        exportPosition(e.Pos),
+	}
+	if t := e.Transition; t != (Transition{}) {
+		je.Transition = &jsonTransition{Event: t.Event, Guard: t.Guard, Action: t.Action}
+	}
+	return je
+}
+
+func exportGroup(g Group) jsonGroup {
+	jg := jsonGroup{
+		ID: g.ID, Label: g.Label, Color: g.Color, Border: g.Border, Text: g.Text,
+		Nodes:      g.Nodes,
+		Attributes: exportAttributes(g.Attrs),
+		Pos:        exportPosition(g.Pos),
+	}
+	for _, inner := range g.Groups {
+		jg.Groups = append(jg.Groups, exportGroup(inner))
+	}
+	return jg
+}
+
+func exportSteps(steps []Step) []jsonStep {
+	var out []jsonStep
+	for _, s := range steps {
+		js := jsonStep{Kind: s.Kind, Message: s.Message, Participants: s.Participants, Placement: s.Placement, Text: s.Text, Pos: exportPosition(s.Pos)}
+		if s.Kind == StepMessage {
+			e := exportEdge(s.Edge)
+			js.Edge = &e
+		}
+		if s.Fragment != nil {
+			js.Fragment = &jsonFragment{Kind: s.Fragment.Kind, Sections: []jsonSection{}}
+			for _, section := range s.Fragment.Sections {
+				js.Fragment.Sections = append(js.Fragment.Sections, jsonSection{Label: section.Label, Steps: exportSteps(section.Steps), Pos: exportPosition(section.Pos)})
+			}
+		}
+		out = append(out, js)
+	}
+	return out
+}
+
+// ImportJSON builds a diagram from JSON written by ExportJSON or by
+// another tool. Colours and shapes that are left out get the defaults
+// of the theme and the diagram type, like in a parsed diagram. A theme
+// chosen from outside wins over the theme in the JSON, like in
+// ParseWithTheme. The error tells every value that could not be used.
+func ImportJSON(data []byte, theme string) (Diagram, error) {
+	var jd jsonDiagram
+	if err := json.Unmarshal(data, &jd); err != nil {
+		return Diagram{}, fmt.Errorf("invalid diagram JSON: %w", err)
+	}
+	switch {
+	case jd.Version == 0:
+		return Diagram{}, errors.New("invalid diagram JSON: missing version")
+	case jd.Version > JSONVersion:
+		return Diagram{}, fmt.Errorf("diagram JSON version %d is newer than %d, the newest version that can be read", jd.Version, JSONVersion)
+	case !allowedTypes[jd.Type]:
+		return Diagram{}, fmt.Errorf("unknown diagram type '%s'", jd.Type)
+	}
+
+	im := &importer{}
+	if theme == "" {
+		theme = jd.Theme
+	}
+	if theme == "" {
+		theme = DefaultTheme
+	}
+	t, ok := LookupTheme(theme)
+	if !ok {
+		im.errorf("unknown theme '%s', expected one of: %s", theme, strings.Join(ThemeNames(), ", "))
+		t, _ = LookupTheme(DefaultTheme)
+	}
+	im.theme = t
+
+	d := Diagram{Name: jd.Type, Layout: jd.Layout, Attrs: importAttributes(jd.Attributes), Theme: t}
+	for _, jn := range jd.Nodes {
+		d.Nodes = append(d.Nodes, im.node(d.Name, jn))
+	}
+	for _, je := range jd.Edges {
+		d.Edges = append(d.Edges, im.edge(d.Name, je))
+	}
+	for _, jg := range jd.Groups {
+		d.Groups = append(d.Groups, im.group(jg))
+	}
+	d.Steps = im.steps(jd.Steps)
+	for _, js := range jd.Styles {
+		d.Styles = append(d.Styles, Style{Name: js.Name, Attrs: importAttributes(js.Attributes), Pos: importPosition(js.Pos)})
+	}
+	return d, errors.Join(im.errs...)
+}
+
+// importer collects the problems found by ImportJSON
+type importer struct {
+	theme Theme
+	errs  []error
+}
+
+func (im *importer) errorf(format string, args ...any) {
+	im.errs = append(im.errs, fmt.Errorf(format, args...))
+}
+
+// color parses a colour into dst, "" keeps the default in dst
+func (im *importer) color(what string, c Color, dst *Color) {
+	if c == "" {
+		return
+	}
+	parsed, err := ParseColor(string(c))
+	if err != nil {
+		im.errorf("%s: %v", what, err)
+		return
+	}
+	*dst = parsed
+}
+
+// length parses a length into dst, "" keeps the default in dst
+func (im *importer) length(what, s string, dst *Length) {
+	if s == "" {
+		return
+	}
+	l, err := ParseLength(s)
+	if err != nil {
+		im.errorf("%s: %v", what, err)
+		return
+	}
+	*dst = l
+}
+
+// size is length for the width and height of a node, which can not be
+// a percentage
+func (im *importer) size(what, s string, dst *Length) {
+	var l Length
+	im.length(what, s, &l)
+	if l.Unit == "%" {
+		im.errorf("%s: a node can not be a percentage wide or high", what)
+		return
+	}
+	if l != (Length{}) {
+		*dst = l
+	}
+}
+
+func importPosition(jp *jsonPosition) Position {
+	if jp == nil {
+		return Position{}
+	}
+	return Position{Line: jp.Line, Col: jp.Col, Offset: jp.Offset, File: jp.File}
+}
+
+func importAttributes(attrs []jsonAttribute) []Attribute {
+	var out []Attribute
+	for _, attr := range attrs {
+		out = append(out, Attribute{Key: attr.Key, Value: attr.Value, Pos: importPosition(attr.Pos)})
+	}
+	return out
+}
+
+// defaultShapes are the shapes of the nodes of each diagram type
+// when the JSON does not give one
+var defaultShapes = map[string]string{
+	"sequence": "participant",
+	"er":       "entity",
+	"class":    "class",
+	"gantt":    "task",
+	"mindmap":  "topic",
+}
+
+func (im *importer) node(diagram string, jn jsonNode) Node {
+	n := Node{
+		ID: jn.ID, Label: jn.Label, Shape: jn.Shape,
+		Color: im.theme.NodeColor, Text: im.theme.NodeText, Border: im.theme.NodeBorder,
+		Implicit: jn.Implicit,
+		Attrs:    importAttributes(jn.Attributes),
+		Pos:      importPosition(jn.Pos),
+	}
+	what := "node " + jn.ID
+	if n.Shape == "" {
+		n.Shape = defaultShapes[diagram]
+		if n.Shape == "" {
+			n.Shape = "rect"
+		}
+	}
+	im.color(what+": color", jn.Color, &n.Color)
+	im.color(what+": text", jn.Text, &n.Text)
+	im.color(what+": border", jn.Border, &n.Border)
+	im.size(what+": width", jn.Width, &n.Width)
+	im.size(what+": height", jn.Height, &n.Height)
+
+	for _, jc := range jn.Columns {
+		n.Columns = append(n.Columns, Column{Name: jc.Name, Type: jc.Type, PK: jc.PK, FK: jc.FK, Unique: jc.Unique, Pos: importPosition(jc.Pos)})
+	}
+	for _, jm := range jn.Members {
+		m := Member{Visibility: jm.Visibility, Name: jm.Name, Type: jm.Type, Method: jm.Method, Static: jm.Static, Abstract: jm.Abstract, Pos: importPosition(jm.Pos)}
+		if m.Method {
+			m.Params = []Param{}
+		}
+		for _, p := range jm.Params {
+			m.Params = append(m.Params, Param{Name: p.Name, Type: p.Type})
+		}
+		n.Members = append(n.Members, m)
+	}
+
+	if jn.Task != nil || n.Shape == "task" || n.Shape == "milestone" {
+		n.Task = &Task{}
+	}
+	if jn.Task != nil {
+		n.Task.Days, n.Task.After = jn.Task.Days, jn.Task.After
+		if jn.Task.Start != "" {
+			start, err := ParseDate(jn.Task.Start)
+			if err != nil {
+				im.errorf("%s: start: %v", what, err)
+			}
+			n.Task.Start = start
+		}
+	}
+	return n
+}
+
+func (im *importer) edge(diagram string, je jsonEdge) Edge {
+	e := Edge{
+		From: je.From, FromPort: je.FromPort, FromCard: je.FromCardinality,
+		To: je.To, ToPort: je.ToPort, ToCard: je.ToCardinality,
+		Kind: je.Kind, Relation: je.Relation,
+		FromMultiplicity: je.FromMultiplicity, ToMultiplicity: je.ToMultiplicity,
+		Label: je.Label,
+		Color: im.theme.EdgeColor, Width: Length{Value: 2},
+		Attrs: importAttributes(je.Attributes),
+		Pos:   importPosition(je.Pos),
+	}
+	what := fmt.Sprintf("edge %s -> %s", je.From, je.To)
+	if e.Kind == EdgeThick {
+		e.Width = Length{Value: 4}
+	}
+	im.color(what+": color", je.Color, &e.Color)
+	im.length(what+": width", je.Width, &e.Width)
+
+	if kind := e.Kind; kind != "" {
+		known := false
+		for _, k := range edgeKinds {
+			known = known || k == kind
+		}
+		if !known {
+			im.errorf("%s: unknown kind '%s'", what, kind)
+		}
+	}
+	switch diagram {
+	case "er":
+		if e.FromCard == "" {
+			e.FromCard = CardinalityMany
+		}
+		if e.ToCard == "" {
+			e.ToCard = CardinalityOne
+		}
+		for _, c := range []Cardinality{e.FromCard, e.ToCard} {
+			if !cardinalities[c] {
+				im.errorf("%s: unknown cardinality '%s'", what, c)
+			}
+		}
+	case "class":
+		if e.Relation == "" {
+			e.Relation = RelationAssociation
+		}
+		if !relations[e.Relation] {
+			im.errorf("%s: unknown relation '%s'", what, e.Relation)
+		}
+	case "state":
+		if t, err := ParseTransition(e.Label); err == nil {
+			e.Transition = t
+		}
+	}
+	return e
+}
+
+func (im *importer) group(jg jsonGroup) Group {
+	g := Group{
+		ID: jg.ID, Label: jg.Label,
+		Color: im.theme.GroupColor, Border: im.theme.GroupBorder, Text: im.theme.GroupText,
+		Nodes: jg.Nodes,
+		Attrs: importAttributes(jg.Attributes),
+		Pos:   importPosition(jg.Pos),
+	}
+	what := "group " + jg.ID
+	im.color(what+": color", jg.Color, &g.Color)
+	im.color(what+": border", jg.Border, &g.Border)
+	im.color(what+": text", jg.Text, &g.Text)
+	for _, inner := range jg.Groups {
+		g.Groups = append(g.Groups, im.group(inner))
+	}
+	return g
+}
+
+func (im *importer) steps(steps []jsonStep) []Step {
+	var out []Step
+	for _, js := range steps {
+		s := Step{Kind: js.Kind, Message: js.Message, Participants: js.Participants, Placement: js.Placement, Text: js.Text, Pos: importPosition(js.Pos)}
+		switch s.Kind {
+		case StepMessage:
+			if js.Edge == nil {
+				im.errorf("message without edge")
+				continue
+			}
+			s.Edge = im.edge("sequence", *js.Edge)
+			if s.Message == "" {
+				s.Message = MessageSync
+			}
+			known := false
+			for _, k := range messageKinds {
+				known = known || k == s.Message
+			}
+			if !known {
+				im.errorf("unknown message kind '%s'", s.Message)
+			}
+		case StepActivate, StepDeactivate, StepNote:
+			if len(s.Participants) == 0 {
+				im.errorf("%s without participant", s.Kind)
+				continue
+			}
+		case StepFragment:
+			if js.Fragment == nil {
+				im.errorf("fragment without sections")
+				continue
+			}
+			f := &Fragment{Kind: js.Fragment.Kind}
+			for _, section := range js.Fragment.Sections {
+				f.Sections = append(f.Sections, Section{Label: section.Label, Steps: im.steps(section.Steps), Pos: importPosition(section.Pos)})
+			}
+			s.Fragment = f
+		default:
+			im.errorf("unknown step kind '%s'", s.Kind)
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
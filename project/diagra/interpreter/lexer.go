@@ -1,22 +1,55 @@
 package interpreter
 
 import (
+	"fmt"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
+// keywords are the words that are keywords in every diagram type.
+// The words of one diagram type, like participant or note, are lexed as
+// identifiers and only the parser treats them as keywords, in that type.
 var keywords = map[string]bool{
 	"diagram": true,
 	"node":    true,
+	"group":   true,
+	"style":   true,
 }
 
 // Lex takes a string input and returns a slice of tokens.
 // It identifies keywords, identifiers, numbers, strings, and symbols.
 // It also handles whitespace and comments.
+// Every token records the line, column and byte offset where it starts.
+//
+// Comments are "# ...", "// ..." (to end of line) and "/* ... */".
+// They are not tokens of their own, instead they are attached as trivia
+// to the token that follows them (the EOF token gets any trailing comments).
 func Lex(input string) []Token {
 	// fmt.Println("Lexing started")
 	var tokens []Token
+	var comments []Comment
 	runes := []rune(input)
 	length := len(runes)
+	pos := positions(runes)
+
+	// emit adds a token starting at rune index start
+	// and hands it the comments collected since the last token
+	emit := func(typ TokenType, value string, start int) {
+		tokens = append(tokens, Token{Type: typ, Value: value, Pos: pos[start], Comments: comments})
+		comments = nil
+	}
+
+	// comment records the comment between rune index start and end
+	comment := func(start, end int, block bool) {
+		trailing := len(tokens) > 0 && tokens[len(tokens)-1].Pos.Line == pos[start].Line
+		comments = append(comments, Comment{
+			Text:     string(runes[start:end]),
+			Pos:      pos[start],
+			Block:    block,
+			Trailing: trailing,
+		})
+	}
 
 	i := 0
 	for i < length {
@@ -27,75 +60,377 @@ func Lex(input string) []Token {
 			continue
 		}
 
-		// Identifier and keywords
-		if unicode.IsLetter(c) {
+		// Hex colours: #ff0000, only directly as an attribute value (after '=')
+		// so that "# note" and "#todo" elsewhere are still comments
+		if c == '#' && i+1 < length && isHexDigit(runes[i+1]) && afterEquals(tokens) {
 			start := i
+			i++
 			for i < length && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
 				i++
 			}
+			emit(TOKEN_COLOR, string(runes[start:i]), start)
+			continue
+		}
+
+		// Line comments: # ... and // ...
+		if c == '#' || (c == '/' && i+1 < length && runes[i+1] == '/') {
+			start := i
+			for i < length && runes[i] != '\n' {
+				i++
+			}
+			comment(start, i, false)
+			continue
+		}
+
+		// Block comments: /* ... */
+		if c == '/' && i+1 < length && runes[i+1] == '*' {
+			start := i
+			i += 2
+			for i < length && !(runes[i] == '*' && i+1 < length && runes[i+1] == '/') {
+				i++
+			}
+			// An unterminated comment is reported before it is recorded,
+			// so the comment still goes to the token after it
+			if i >= length {
+				problem := lexProblem{start, "unterminated block comment, missing closing '*/'"}
+				emit(TOKEN_ILLEGAL, problem.message, problem.at)
+				comment(start, length, true)
+				continue
+			}
+			i += 2 // hoppa över */
+			comment(start, i, true)
+			continue
+		}
+
+		// Identifier and keywords, user_id is one identifier
+		if unicode.IsLetter(c) {
+			start := i
+			for i < length && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
 			value := string(runes[start:i])
+
+			// Colour functions: rgb(1, 2, 3) and rgba(1, 2, 3, 0.5)
+			if (value == "rgb" || value == "rgba") && i < length && runes[i] == '(' {
+				for i < length && runes[i] != ')' && runes[i] != '\n' {
+					i++
+				}
+				if i < length && runes[i] == ')' {
+					i++
+				}
+				emit(TOKEN_COLOR, string(runes[start:i]), start)
+				continue
+			}
+
 			if keywords[value] {
-				tokens = append(tokens, Token{Type: TOKEN_KEYWORD, Value: value})
+				emit(TOKEN_KEYWORD, value, start)
 			} else {
-				tokens = append(tokens, Token{Type: TOKEN_IDENTIFIER, Value: value})
+				emit(TOKEN_IDENTIFIER, value, start)
 			}
 			continue
 		}
 
-		// Numbers example: width = 3
+		// Dates: 2024-03-15
+		if isDate(runes[i:]) {
+			emit(TOKEN_DATE, string(runes[i:i+10]), i)
+			i += 10
+			continue
+		}
+
+		// Numbers with optional decimals and unit, example: width = 2.5px
 		if unicode.IsDigit(c) {
 			start := i
 			for i < length && unicode.IsDigit(runes[i]) {
 				i++
 			}
-			tokens = append(tokens, Token{Type: TOKEN_IDENTIFIER, Value: string(runes[start:i])})
+			if i+1 < length && runes[i] == '.' && unicode.IsDigit(runes[i+1]) {
+				i++
+				for i < length && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			// Unit: px, pt, em, %, ...
+			for i < length && (unicode.IsLetter(runes[i]) || runes[i] == '%') {
+				i++
+			}
+			emit(TOKEN_NUMBER, string(runes[start:i]), start)
 			continue
 		}
 
-		// Strings "..."
+		// Multi-line strings """...""" and strings "..."
+		// Problems in a string are emitted as TOKEN_ILLEGAL right before it,
+		// the string itself is still emitted so the parser can go on.
 		if c == '"' {
-			i++
-			start := i
-			for i < length && runes[i] != '"' {
-				i++
+			quote := i
+			var value string
+			var problems []lexProblem
+			if i+2 < length && runes[i+1] == '"' && runes[i+2] == '"' {
+				value, i, problems = lexMultilineString(runes, i)
+			} else {
+				value, i, problems = lexString(runes, i)
 			}
-			value := string(runes[start:i])
-			tokens = append(tokens, Token{Type: TOKEN_STRING, Value: value})
-			i++ // hoppa över slut-quote
+			for _, problem := range problems {
+				emit(TOKEN_ILLEGAL, problem.message, problem.at)
+			}
+			emit(TOKEN_STRING, value, quote)
 			continue
 		}
 
-		// Arrows ->
-		if c == '-' && i+1 < length && runes[i+1] == '>' {
-			tokens = append(tokens, Token{Type: TOKEN_ARROW, Value: "->"})
-			i += 2
+		// Arrows -> and -->, for edges also <-> ..> ==> and --,
+		// for sequence diagrams ->>
+		if arrow := lexArrow(runes[i:]); arrow != "" {
+			emit(TOKEN_ARROW, arrow, i)
+			i += len(arrow)
 			continue
 		}
-
 		// Braces
 		if c == '{' {
-			tokens = append(tokens, Token{Type: TOKEN_LBRACE, Value: "{"})
+			emit(TOKEN_LBRACE, "{", i)
 			i++
 			continue
 		}
 		if c == '}' {
-			tokens = append(tokens, Token{Type: TOKEN_RBRACE, Value: "}"})
+			emit(TOKEN_RBRACE, "}", i)
 			i++
 			continue
 		}
 
-		// Check if it is '=', '(', ')', eller ','.
+		// Check if it is '=', '(', ')', ',', ':', '.' eller a visibility '+', '-', '~'.
 		// If it is, create TOKEN_SYMBOL and add to token list.
-		if c == '=' || c == '(' || c == ')' || c == ',' {
-			tokens = append(tokens, Token{Type: TOKEN_SYMBOL, Value: string(c)})
+		if strings.ContainsRune("=(),:.+-~", c) {
+			emit(TOKEN_SYMBOL, string(c), i)
 			i++
 			continue
 		}
 
-		// Unknown, skip
+		// Unknown, reported by the parser and skipped
+		emit(TOKEN_ILLEGAL, fmt.Sprintf("unexpected character %q", c), i)
 		i++
 	}
 
-	tokens = append(tokens, Token{Type: TOKEN_EOF, Value: ""})
+	emit(TOKEN_EOF, "", length)
 	return tokens
 }
+
+// arrows are the arrows, longest first so --> is not read as -- and >
+var arrows = []string{"-->", "->>", "<->", "..>", "==>", "->", "--"}
+
+// lexArrow returns the arrow that runes start with, or ""
+func lexArrow(runes []rune) string {
+	for _, arrow := range arrows {
+		if strings.HasPrefix(string(runes[:min(len(runes), 3)]), arrow) {
+			return arrow
+		}
+	}
+	return ""
+}
+
+// lexProblem is an error found inside a string or comment, at is a rune index
+type lexProblem struct {
+	at      int
+	message string
+}
+
+// lexString reads a "..." string starting at the quote at index i.
+// It handles the escapes \" \\ \n \t \uXXXX and \u{X...}. A string must end
+// on the line it starts on, an unterminated string ends at the line break.
+// It returns the string value and the index after the closing quote.
+func lexString(runes []rune, i int) (string, int, []lexProblem) {
+	var sb strings.Builder
+	var problems []lexProblem
+	quote := i
+	length := len(runes)
+	i++
+
+	for {
+		if i >= length || runes[i] == '\n' {
+			problems = append(problems, lexProblem{quote, "unterminated string, missing closing '\"'"})
+			return sb.String(), i, problems
+		}
+		c := runes[i]
+		if c == '"' {
+			return sb.String(), i + 1, problems
+		}
+		if c != '\\' {
+			sb.WriteRune(c)
+			i++
+			continue
+		}
+
+		// Escape sequence
+		escape := i
+		i++
+		if i >= length || runes[i] == '\n' {
+			continue // reported as unterminated above
+		}
+		switch runes[i] {
+		case '"', '\\':
+			sb.WriteRune(runes[i])
+			i++
+		case 'n':
+			sb.WriteRune('\n')
+			i++
+		case 't':
+			sb.WriteRune('\t')
+			i++
+		case 'u':
+			r, next, ok := lexUnicodeEscape(runes, i+1)
+			if !ok {
+				problems = append(problems, lexProblem{escape, "invalid unicode escape, expected \\uXXXX or \\u{X...}"})
+				sb.WriteString(string(runes[escape:next]))
+			} else {
+				sb.WriteRune(r)
+			}
+			i = next
+		default:
+			problems = append(problems, lexProblem{escape, fmt.Sprintf("unknown escape sequence \\%c", runes[i])})
+			sb.WriteRune(runes[i])
+			i++
+		}
+	}
+}
+
+// lexUnicodeEscape reads the part after \u: four hex digits or {hex digits}.
+// It returns the rune, the index after the escape and if it was valid.
+func lexUnicodeEscape(runes []rune, i int) (rune, int, bool) {
+	length := len(runes)
+	start, end := i, i+4
+	if i < length && runes[i] == '{' {
+		start = i + 1
+		end = start
+		for end < length && runes[end] != '}' && end-start <= 6 {
+			end++
+		}
+		if end >= length || runes[end] != '}' {
+			return 0, end, false
+		}
+		i = end + 1
+	} else {
+		if end > length {
+			return 0, length, false
+		}
+		i = end
+	}
+
+	if start == end {
+		return 0, i, false
+	}
+	value := 0
+	for _, r := range runes[start:end] {
+		if !isHexDigit(r) {
+			return 0, i, false
+		}
+		value = value*16 + hexValue(r)
+	}
+	if value > unicode.MaxRune || (value >= 0xD800 && value <= 0xDFFF) {
+		return 0, i, false
+	}
+	return rune(value), i, true
+}
+
+func hexValue(r rune) int {
+	switch {
+	case r >= 'a':
+		return int(r-'a') + 10
+	case r >= 'A':
+		return int(r-'A') + 10
+	}
+	return int(r - '0')
+}
+
+// lexMultilineString reads a """...""" string starting at index i.
+// The content is taken as written, without escapes. A line break right
+// after the opening quotes and the indentation shared by all lines are
+// removed, so the label can be indented together with the diagram.
+func lexMultilineString(runes []rune, i int) (string, int, []lexProblem) {
+	quote := i
+	length := len(runes)
+	i += 3
+	start := i
+	for i+2 < length && !(runes[i] == '"' && runes[i+1] == '"' && runes[i+2] == '"') {
+		i++
+	}
+	if i+2 >= length {
+		problem := lexProblem{quote, "unterminated multi-line string, missing closing '\"\"\"'"}
+		return dedent(string(runes[start:])), length, []lexProblem{problem}
+	}
+	return dedent(string(runes[start:i])), i + 3, nil
+}
+
+// dedent removes a leading line break, a last line with only whitespace
+// and the indentation that every non-blank line has in common.
+func dedent(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "\r"), "\n")
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		} else {
+			lines[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isDate reports whether runes start with a date, four digits, '-',
+// two digits, '-' and two digits, not followed by more digits or letters
+func isDate(runes []rune) bool {
+	if len(runes) < 10 {
+		return false
+	}
+	for i, r := range runes[:10] {
+		switch i {
+		case 4, 7:
+			if r != '-' {
+				return false
+			}
+		default:
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return len(runes) == 10 || !(unicode.IsLetter(runes[10]) || unicode.IsDigit(runes[10]))
+}
+
+// afterEquals reports whether the last token is the '=' symbol
+func afterEquals(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	return last.Type == TOKEN_SYMBOL && last.Value == "="
+}
+
+// positions precomputes the position of every rune in the input.
+// The extra last entry is the position just after the input, used for EOF.
+func positions(runes []rune) []Position {
+	pos := make([]Position, len(runes)+1)
+	line, col, offset := 1, 1, 0
+	for i, r := range runes {
+		pos[i] = Position{Line: line, Col: col, Offset: offset}
+		offset += utf8.RuneLen(r)
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	pos[len(runes)] = Position{Line: line, Col: col, Offset: offset}
+	return pos
+}
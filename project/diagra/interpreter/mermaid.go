@@ -0,0 +1,1227 @@
+package interpreter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file converts between Mermaid flowcharts and diagra flowcharts.
+// ParseMermaid reads a flowchart:
+//
+//	flowchart LR
+//		A[Start] --> B{Giltig?}
+//		B -->|ja| C(Plocka)
+//		B -. nej .-> A
+//		subgraph lager [Lager]
+//			C ==> D[(Databas)]
+//		end
+//		style A fill:#e3f2fd,stroke:#1565c0
+//
+// and ExportMermaid writes a flowchart or a tree the same way. Subgraphs
+// are groups, classDef is a style class and style, class and linkStyle
+// become attributes. Both directions use the closest equivalent for the
+// shapes, links and styles that have none and warn about them, once for
+// each feature.
+
+// mermaidShapes are the brackets of the Mermaid node shapes, the longest
+// first so (( is found before (. name is set for the shapes that are
+// drawn as something else.
+var mermaidShapes = []struct {
+	open, close, shape, name string
+}{
+	{"(((", ")))", "circle", "double circle"},
+	{"((", "))", "circle", ""},
+	{"([", "])", "ellipse", ""},
+	{"[(", ")]", "cylinder", ""},
+	{"[[", "]]", "rect", "subroutine"},
+	{"[/", "/]", "parallelogram", ""},
+	{"[/", "\\]", "parallelogram", "trapezoid"},
+	{"[\\", "\\]", "parallelogram", ""},
+	{"[\\", "/]", "parallelogram", "trapezoid"},
+	{"{{", "}}", "hexagon", ""},
+	{"[", "]", "rect", ""},
+	{"(", ")", "ellipse", ""},
+	{"{", "}", "diamond", ""},
+	{">", "]", "rect", "asymmetric"},
+}
+
+// mermaidDiagrams are the other kinds of Mermaid diagrams, to tell what
+// a file is when it is not a flowchart
+var mermaidDiagrams = map[string]bool{
+	"sequenceDiagram": true, "classDiagram": true, "stateDiagram": true, "stateDiagram-v2": true,
+	"erDiagram": true, "gantt": true, "pie": true, "mindmap": true, "journey": true, "gitGraph": true,
+	"timeline": true, "quadrantChart": true, "requirementDiagram": true,
+}
+
+// ParseMermaid reads a Mermaid flowchart (flowchart or graph) and returns
+// it as a diagra flowchart. Like ParseDOT it does not stop at the first
+// problem, the diagnostics have every error and a warning for each
+// feature that could not be represented.
+func ParseMermaid(src string) (Diagram, Diagnostics) {
+	p := &mermaidParser{
+		foreignParser: newForeignParser(nil),
+		byID:          map[string]*mermaidNode{},
+		groups:        map[string]*mermaidGroup{},
+		root:          &mermaidGroup{},
+	}
+	p.parse(src)
+	d := p.diagram()
+	sortDiagnostics(p.diags)
+	return d, p.diags
+}
+
+// mermaidParser reads a flowchart statement by statement. Like dotParser
+// it collects the nodes, links and subgraphs first, since style, class and
+// linkStyle can come after what they style.
+type mermaidParser struct {
+	*foreignParser
+	header     bool // the flowchart line has been read
+	stopped    bool // the file is not a flowchart, the rest is not read
+	layout     string
+	nodes      []*mermaidNode
+	byID       map[string]*mermaidNode
+	edges      []*mermaidEdge
+	root       *mermaidGroup   // the flowchart itself, its subgraphs are the top level groups
+	open       []*mermaidGroup // the subgraphs being read, the innermost last
+	groups     map[string]*mermaidGroup
+	classes    []mermaidClass
+	linkStyles []mermaidLinkStyle
+}
+
+// mermaidProp is one key:value of a style
+type mermaidProp struct {
+	key, value string
+	pos        Position
+}
+
+type mermaidNode struct {
+	id, label, shape string
+	props            []mermaidProp // from style statements
+	classes          []mermaidProp // class and :::, the key is the name of the class
+	group            *mermaidGroup
+	pos              Position
+}
+
+// mermaidEdge is a link between two nodes. Invisible links and links to
+// subgraphs are kept as skipped, linkStyle counts them.
+type mermaidEdge struct {
+	from, to string
+	kind     EdgeKind
+	thick    bool // a thick link without arrow head, === or <==>
+	label    string
+	props    []mermaidProp
+	skip     bool
+	pos      Position
+}
+
+type mermaidGroup struct {
+	id, label string
+	nodes     []*mermaidNode
+	groups    []*mermaidGroup
+	props     []mermaidProp
+	classes   []mermaidProp
+	pos       Position
+}
+
+// mermaidClass is classDef NAME props
+type mermaidClass struct {
+	name  string
+	props []mermaidProp
+	pos   Position
+}
+
+// mermaidLinkStyle is linkStyle 0,1 props, without indexes for default
+type mermaidLinkStyle struct {
+	indexes []int
+	props   []mermaidProp
+	pos     Position
+}
+
+// mermaidLink is the link between the nodes of a statement, like -->|text|
+type mermaidLink struct {
+	line  rune // '-', '=' for thick, '.' for dotted and '~' for invisible
+	head  bool // an arrow head at the end
+	both  bool // an arrow head at the start too, <-->
+	label string
+}
+
+// mermaidScanner reads one statement, runes[i:end] of the source
+type mermaidScanner struct {
+	runes  []rune
+	pos    []Position
+	i, end int
+}
+
+func (s *mermaidScanner) done() bool { return s.i >= s.end }
+
+func (s *mermaidScanner) at() Position { return s.pos[s.i] }
+
+func (s *mermaidScanner) peek() rune {
+	if s.done() {
+		return 0
+	}
+	return s.runes[s.i]
+}
+
+func (s *mermaidScanner) hasPrefix(prefix string) bool {
+	r := []rune(prefix)
+	return s.i+len(r) <= s.end && string(s.runes[s.i:s.i+len(r)]) == prefix
+}
+
+// index returns where sub starts counted from s.i, or -1
+func (s *mermaidScanner) index(sub string) int {
+	i := strings.Index(string(s.runes[s.i:s.end]), sub)
+	if i < 0 {
+		return -1
+	}
+	return len([]rune(string(s.runes[s.i:s.end])[:i]))
+}
+
+func (s *mermaidScanner) skipSpace() {
+	for !s.done() && unicode.IsSpace(s.runes[s.i]) {
+		s.i++
+	}
+}
+
+// word moves past a name: letters, digits and _
+func (s *mermaidScanner) word() string {
+	start := s.i
+	for !s.done() && (unicode.IsLetter(s.runes[s.i]) || unicode.IsDigit(s.runes[s.i]) || s.runes[s.i] == '_') {
+		s.i++
+	}
+	return string(s.runes[start:s.i])
+}
+
+// name moves past the name of a class, which can have - too
+func (s *mermaidScanner) name() string {
+	start := s.i
+	for !s.done() && (unicode.IsLetter(s.runes[s.i]) || unicode.IsDigit(s.runes[s.i]) || s.runes[s.i] == '_' || s.runes[s.i] == '-') {
+		s.i++
+	}
+	return string(s.runes[start:s.i])
+}
+
+// field moves past everything up to the next space
+func (s *mermaidScanner) field() string {
+	start := s.i
+	for !s.done() && !unicode.IsSpace(s.runes[s.i]) {
+		s.i++
+	}
+	return string(s.runes[start:s.i])
+}
+
+// run moves past the characters that are in chars
+func (s *mermaidScanner) run(chars string) string {
+	start := s.i
+	for !s.done() && strings.ContainsRune(chars, s.runes[s.i]) {
+		s.i++
+	}
+	return string(s.runes[start:s.i])
+}
+
+// rest moves to the end of the statement and returns what was left
+func (s *mermaidScanner) rest() string {
+	text := string(s.runes[s.i:s.end])
+	s.i = s.end
+	return strings.TrimSpace(text)
+}
+
+// parse reads the source line by line. Lines starting with %% are
+// comments, the statements of a line are separated by ;
+func (p *mermaidParser) parse(src string) {
+	runes := []rune(src)
+	pos := positions(runes)
+	frontMatter := false
+	for start := 0; start < len(runes) && !p.stopped; {
+		end := start
+		for end < len(runes) && runes[end] != '\n' {
+			end++
+		}
+		line := strings.TrimSpace(string(runes[start:end]))
+		lineStart := start
+		start = end + 1
+
+		switch {
+		case line == "---" && (frontMatter || !p.header):
+			if !frontMatter {
+				p.warnOnce(pos[lineStart], "front matter", "the front matter (title and config) is left out")
+			}
+			frontMatter = !frontMatter
+			continue
+		case frontMatter, line == "":
+			continue
+		case strings.HasPrefix(line, "%%{"):
+			p.warnOnce(pos[lineStart], "directive", "Mermaid directives are not supported and are left out")
+			continue
+		case strings.HasPrefix(line, "%%"):
+			continue
+		}
+
+		// ; inside quotes and brackets is part of a text
+		quoted, depth, from := false, 0, lineStart
+		for i := lineStart; i <= end && !p.stopped; i++ {
+			if i < end {
+				switch r := runes[i]; {
+				case r == '"':
+					quoted = !quoted
+					continue
+				case quoted:
+					continue
+				case strings.ContainsRune("[({", r):
+					depth++
+					continue
+				case strings.ContainsRune("])}", r) && depth > 0:
+					depth--
+					continue
+				case r != ';' || depth > 0:
+					continue
+				}
+			}
+			s := &mermaidScanner{runes: runes, pos: pos, i: from, end: i}
+			s.skipSpace()
+			for s.end > s.i && unicode.IsSpace(runes[s.end-1]) {
+				s.end--
+			}
+			if !s.done() {
+				p.statement(s)
+			}
+			from = i + 1
+		}
+	}
+	for _, g := range p.open {
+		p.fail(&Diagnostic{Pos: g.pos, Message: fmt.Sprintf("subgraph %s is not closed with 'end'", g.id)})
+	}
+	if !p.header && !p.stopped {
+		p.fail(&Diagnostic{Pos: pos[len(runes)], Message: "expected 'flowchart' or 'graph'"})
+	}
+}
+
+// statement parses one statement, the first one is the flowchart line
+func (p *mermaidParser) statement(s *mermaidScanner) {
+	pos := s.at()
+	if !p.header {
+		p.flowchart(s)
+		return
+	}
+
+	start := s.i
+	keyword := s.word()
+	if !s.done() && !unicode.IsSpace(s.peek()) && keyword != "accTitle" && keyword != "accDescr" {
+		keyword = "" // a node like style1 or end-->A
+	}
+	s.skipSpace()
+	switch keyword {
+	case "subgraph":
+		p.subgraph(s, pos)
+	case "end":
+		if len(p.open) == 0 {
+			p.fail(&Diagnostic{Pos: pos, Message: "'end' without 'subgraph'"})
+			return
+		}
+		p.open = p.open[:len(p.open)-1]
+	case "direction":
+		p.warnOnce(pos, "direction", "direction in a subgraph is not supported, the direction of the flowchart is used")
+	case "style":
+		p.style(s, pos)
+	case "classDef":
+		names := strings.Split(s.field(), ",")
+		props := p.props(s)
+		for _, name := range names {
+			p.classes = append(p.classes, mermaidClass{name: name, props: props, pos: pos})
+		}
+	case "class":
+		p.class(s, pos)
+	case "linkStyle":
+		p.linkStyle(s, pos)
+	case "click", "callback", "call", "href":
+		p.warnOnce(pos, "click", "click events and links are not supported and are left out")
+	case "accTitle", "accDescr", "title":
+		p.warnOnce(pos, "title", "titles and descriptions are not supported and are left out")
+	default:
+		s.i = start
+		p.chain(s, pos)
+	}
+}
+
+// flowchart parses: (flowchart | graph) [TB | TD | BT | LR | RL]
+func (p *mermaidParser) flowchart(s *mermaidScanner) {
+	pos := s.at()
+	name := s.field()
+	switch {
+	case name == "flowchart" || name == "graph" || name == "flowchart-elk":
+	case mermaidDiagrams[name]:
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("only Mermaid flowcharts can be converted, this is a %s", name)})
+		p.stopped = true
+		return
+	default:
+		p.fail(&Diagnostic{Pos: pos, Message: "expected 'flowchart' or 'graph'"})
+		p.stopped = true
+		return
+	}
+	p.header = true
+	p.layout = "vertical"
+	s.skipSpace()
+	dirPos := s.at()
+	switch dir := s.rest(); dir {
+	case "", "TB", "TD":
+	case "BT":
+		p.warn(dirPos, "the direction BT has no equivalent, the flowchart is drawn top to bottom")
+	case "LR":
+		p.layout = ""
+	case "RL":
+		p.layout = ""
+		p.warn(dirPos, "the direction RL has no equivalent, the flowchart is drawn left to right")
+	default:
+		p.fail(&Diagnostic{Pos: dirPos, Message: fmt.Sprintf("unknown direction '%s', expected TB, TD, BT, LR or RL", dir)})
+	}
+}
+
+// subgraph parses: subgraph ID [Title] | subgraph "Title" | subgraph Title words
+func (p *mermaidParser) subgraph(s *mermaidScanner, pos Position) {
+	if s.done() {
+		p.fail(&Diagnostic{Pos: s.at(), Message: "expected a name after 'subgraph'"})
+		return
+	}
+	var id, label string
+	if s.peek() == '"' {
+		label = p.text(s.rest(), pos)
+		id = label
+	} else {
+		start := s.i
+		id = s.word()
+		s.skipSpace()
+		switch {
+		case s.peek() == '[':
+			textPos := s.at()
+			text := s.rest()
+			if !strings.HasSuffix(text, "]") {
+				p.fail(&Diagnostic{Pos: textPos, Message: "expected ']' after the title of the subgraph"})
+				return
+			}
+			label = p.text(text[1:len(text)-1], textPos)
+		case !s.done() || id == "":
+			s.i = start
+			id = s.rest()
+			label = p.text(id, pos)
+		default:
+			label = id
+		}
+	}
+
+	g := &mermaidGroup{id: id, label: label, pos: pos}
+	parent := p.root
+	if len(p.open) > 0 {
+		parent = p.open[len(p.open)-1]
+	}
+	parent.groups = append(parent.groups, g)
+	p.groups[id] = g
+	p.open = append(p.open, g)
+}
+
+// chain parses nodes and the links between them: A --> B & C -->|ja| D
+func (p *mermaidParser) chain(s *mermaidScanner, pos Position) {
+	from, ok := p.ends(s)
+	if !ok {
+		return
+	}
+	for {
+		s.skipSpace()
+		if s.done() {
+			return
+		}
+		link, ok := p.link(s)
+		if !ok {
+			return
+		}
+		s.skipSpace()
+		to, ok := p.ends(s)
+		if !ok {
+			return
+		}
+		for _, a := range from {
+			for _, b := range to {
+				p.addEdge(a, b, link, pos)
+			}
+		}
+		from = to
+	}
+}
+
+// ends parses the nodes at one end of a link: A or A & B
+func (p *mermaidParser) ends(s *mermaidScanner) ([]string, bool) {
+	var ids []string
+	for {
+		id, ok := p.nodeRef(s)
+		if !ok {
+			return nil, false
+		}
+		ids = append(ids, id)
+		s.skipSpace()
+		if s.peek() != '&' {
+			return ids, true
+		}
+		s.i++
+		s.skipSpace()
+	}
+}
+
+// nodeRef parses a node with an optional shape and class: A, A[Text]:::cls
+func (p *mermaidParser) nodeRef(s *mermaidScanner) (string, bool) {
+	pos := s.at()
+	id := s.word()
+	if id == "" {
+		if s.done() {
+			p.fail(&Diagnostic{Pos: pos, Message: "expected a node at the end of the statement"})
+		} else {
+			p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("expected a node, found '%c'", s.peek())})
+		}
+		return "", false
+	}
+	// A subgraph can be the end of a link
+	if _, ok := p.groups[id]; ok && p.byID[id] == nil && !strings.ContainsRune("[({>", s.peek()) {
+		return id, true
+	}
+
+	n := p.node(id, pos)
+	if !p.shape(s, n) {
+		return "", false
+	}
+	if s.hasPrefix("@{") {
+		p.warnOnce(s.at(), "@{", "the @{ } node syntax is not supported and is left out")
+		if end := s.index("}"); end >= 0 {
+			s.i += end + 1
+		} else {
+			s.i = s.end
+		}
+	}
+	if s.hasPrefix(":::") {
+		s.i += 3
+		classPos := s.at()
+		n.classes = append(n.classes, mermaidProp{key: s.name(), pos: classPos})
+	}
+	return id, true
+}
+
+// lookup returns the node with the Mermaid id, created the first time
+func (p *mermaidParser) lookup(id string, pos Position) *mermaidNode {
+	n, ok := p.byID[id]
+	if !ok {
+		n = &mermaidNode{id: id, label: id, shape: "rect", pos: pos}
+		p.byID[id] = n
+		p.nodes = append(p.nodes, n)
+	}
+	return n
+}
+
+// node is lookup for a node named in a statement. A node belongs to the
+// first subgraph it is named in.
+func (p *mermaidParser) node(id string, pos Position) *mermaidNode {
+	n := p.lookup(id, pos)
+	if n.group == nil && len(p.open) > 0 {
+		n.group = p.open[len(p.open)-1]
+		n.group.nodes = append(n.group.nodes, n)
+	}
+	return n
+}
+
+// shape parses the brackets and text after the id of a node, if any
+func (p *mermaidParser) shape(s *mermaidScanner, n *mermaidNode) bool {
+	for i, sh := range mermaidShapes {
+		if !s.hasPrefix(sh.open) {
+			continue
+		}
+		pos := s.at()
+		s.i += len([]rune(sh.open))
+		textStart := s.i
+		quoted := s.peek() == '"'
+		if quoted {
+			s.i++
+			end := s.index(`"`)
+			if end < 0 {
+				p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("the text of node %s has no closing '\"'", n.id)})
+				return false
+			}
+			s.i += end + 1
+		}
+
+		// The first closing bracket of the opening one ends the text
+		match, best := sh, -1
+		for _, other := range mermaidShapes[i:] {
+			if other.open != sh.open {
+				continue
+			}
+			if at := s.index(other.close); at >= 0 && (best < 0 || at < best) && (!quoted || at == 0) {
+				match, best = other, at
+			}
+		}
+		if best < 0 {
+			p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("the text of node %s has no closing '%s'", n.id, sh.close)})
+			return false
+		}
+		text := string(s.runes[textStart : s.i+best])
+		s.i += best + len([]rune(match.close))
+
+		n.label = p.text(text, pos)
+		n.shape = match.shape
+		if match.name != "" {
+			p.warnOnce(pos, "shape "+match.name, fmt.Sprintf("the Mermaid shape %s has no equivalent, it is drawn as a %s", match.name, match.shape))
+		}
+		return true
+	}
+	return true
+}
+
+// mermaidLines are the lines of links: -- normal, == thick, -.- dotted
+// and ~~~ invisible
+var mermaidLines = map[rune]*regexp.Regexp{
+	'-': regexp.MustCompile(`^-{2,}$`),
+	'=': regexp.MustCompile(`^={2,}$`),
+	'.': regexp.MustCompile(`^-\.+-$`),
+	'~': regexp.MustCompile(`^~{3,}$`),
+}
+
+// link parses a link: -->, ---, -.->, ==>, <-->, ~~~ with the text as
+// -- text --> or -->|text|. The number of - only changes the length of
+// the link in Mermaid.
+func (p *mermaidParser) link(s *mermaidScanner) (mermaidLink, bool) {
+	pos := s.at()
+	var l mermaidLink
+	if s.peek() == '<' {
+		l.both = true
+		s.i++
+	}
+	body := s.run("-=.~")
+	head := p.head(s)
+
+	// -- text -->, == text ==> and -. text .->
+	if closing := map[string]string{"--": "--", "==": "==", "-.": ".-"}[body]; closing != "" && head == 0 {
+		end := s.index(closing)
+		if end < 0 {
+			p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("expected '%s' after the text of the link", closing)})
+			return l, false
+		}
+		l.label = p.text(string(s.runes[s.i:s.i+end]), pos)
+		s.i += end
+		body += s.run("-=.")
+		head = p.head(s)
+	}
+
+	for line, re := range mermaidLines {
+		if re.MatchString(body) {
+			l.line = line
+		}
+	}
+	l.head = head != 0
+	if l.line == 0 || (l.line == '-' && !l.head && len(body) < 3) || (l.both && !l.head) {
+		if body == "" && !l.both {
+			p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("expected a link like --> or the end of the statement, found '%c'", s.peek())})
+			return l, false
+		}
+		text := body
+		if l.both {
+			text = "<" + text
+		}
+		if l.head {
+			text += string(head)
+		}
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("unknown link '%s'", text)})
+		return l, false
+	}
+	if head == 'o' || head == 'x' {
+		p.warnOnce(pos, "arrow head", "circle and cross arrow heads are drawn as arrows")
+	}
+
+	s.skipSpace()
+	if s.peek() == '|' {
+		s.i++
+		end := s.index("|")
+		if end < 0 {
+			p.fail(&Diagnostic{Pos: pos, Message: "expected '|' after the text of the link"})
+			return l, false
+		}
+		l.label = p.text(string(s.runes[s.i:s.i+end]), pos)
+		s.i += end + 1
+	}
+	return l, true
+}
+
+// head moves past the arrow head of a link, o and x only when a space
+// follows so they are not read as the start of a node id
+func (p *mermaidParser) head(s *mermaidScanner) rune {
+	switch r := s.peek(); {
+	case r == '>':
+		s.i++
+		return r
+	case r == 'o' || r == 'x':
+		if s.i+1 == s.end || unicode.IsSpace(s.runes[s.i+1]) {
+			s.i++
+			return r
+		}
+	}
+	return 0
+}
+
+// addEdge adds the link from a to b
+func (p *mermaidParser) addEdge(a, b string, l mermaidLink, pos Position) {
+	e := &mermaidEdge{from: a, to: b, kind: EdgeDirected, label: l.label, pos: pos}
+	p.edges = append(p.edges, e)
+	switch {
+	case l.line == '~':
+		p.warnOnce(pos, "invisible", "invisible links are left out")
+		e.skip = true
+		return
+	case p.byID[a] == nil || p.byID[b] == nil:
+		p.warnOnce(pos, "subgraph link", "links to subgraphs are not supported and are left out")
+		e.skip = true
+		return
+	}
+
+	switch {
+	case l.both:
+		e.kind = EdgeBidirectional
+	case !l.head:
+		e.kind = EdgeUndirected
+	case l.line == '.':
+		e.kind = EdgeDotted
+	case l.line == '=':
+		e.kind = EdgeThick
+	}
+	if l.line == '.' && e.kind != EdgeDotted {
+		p.warnOnce(pos, "dotted "+string(e.kind), fmt.Sprintf("%s links can not be dotted, they are drawn solid", e.kind))
+	}
+	e.thick = l.line == '=' && e.kind != EdgeThick
+}
+
+// props parses the rest of a statement as styles: fill:#f9f,stroke:#333
+func (p *mermaidParser) props(s *mermaidScanner) []mermaidProp {
+	var props []mermaidProp
+	for !s.done() {
+		s.skipSpace()
+		pos := s.at()
+		start, depth := s.i, 0
+		for ; !s.done() && (s.peek() != ',' || depth > 0); s.i++ {
+			switch s.peek() {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+		}
+		key, value, _ := strings.Cut(string(s.runes[start:s.i]), ":")
+		if key = strings.TrimSpace(key); key != "" {
+			props = append(props, mermaidProp{key: key, value: strings.TrimSpace(value), pos: pos})
+		}
+		s.i++ // ,
+	}
+	s.i = s.end
+	return props
+}
+
+// style parses: style ID props, ID is a node or a subgraph
+func (p *mermaidParser) style(s *mermaidScanner, pos Position) {
+	id := s.word()
+	if id == "" {
+		p.fail(&Diagnostic{Pos: s.at(), Message: "expected a node or subgraph after 'style'"})
+		return
+	}
+	props := p.props(s)
+	if g, ok := p.groups[id]; ok && p.byID[id] == nil {
+		g.props = append(g.props, props...)
+		return
+	}
+	n := p.lookup(id, pos)
+	n.props = append(n.props, props...)
+}
+
+// class parses: class ID1,ID2 NAME
+func (p *mermaidParser) class(s *mermaidScanner, pos Position) {
+	var ids []string
+	for {
+		s.skipSpace()
+		id := s.word()
+		if id == "" {
+			p.fail(&Diagnostic{Pos: s.at(), Message: "expected a node or subgraph after 'class'"})
+			return
+		}
+		ids = append(ids, id)
+		s.skipSpace()
+		if s.peek() != ',' {
+			break
+		}
+		s.i++
+	}
+	name := s.rest()
+	if name == "" {
+		p.fail(&Diagnostic{Pos: s.at(), Message: "expected a class name after the nodes"})
+		return
+	}
+	for _, id := range ids {
+		if g, ok := p.groups[id]; ok && p.byID[id] == nil {
+			g.classes = append(g.classes, mermaidProp{key: name, pos: pos})
+			continue
+		}
+		n := p.lookup(id, pos)
+		n.classes = append(n.classes, mermaidProp{key: name, pos: pos})
+	}
+}
+
+// linkStyle parses: linkStyle 0,1 props | linkStyle default props
+func (p *mermaidParser) linkStyle(s *mermaidScanner, pos Position) {
+	ls := mermaidLinkStyle{pos: pos}
+	if s.hasPrefix("default") {
+		s.i += len("default")
+	} else {
+		for {
+			numPos := s.at()
+			n, err := strconv.Atoi(s.run("0123456789"))
+			if err != nil {
+				p.fail(&Diagnostic{Pos: numPos, Message: "expected the number of a link or 'default' after 'linkStyle'"})
+				return
+			}
+			ls.indexes = append(ls.indexes, n)
+			if s.peek() != ',' {
+				break
+			}
+			s.i++
+		}
+	}
+	s.skipSpace()
+	ls.props = p.props(s)
+	p.linkStyles = append(p.linkStyles, ls)
+}
+
+var (
+	mermaidBreak  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mermaidEntity = regexp.MustCompile(`#(\w+);`)
+)
+
+// mermaidEntities are the named entity codes, #quot; is "
+var mermaidEntities = map[string]string{
+	"quot": `"`, "amp": "&", "lt": "<", "gt": ">", "apos": "'", "nbsp": " ", "num": "#", "semi": ";",
+}
+
+// text returns the text of a node or link without the quotes. <br> is a
+// line break and #quot; or #35; an entity code, other HTML and Markdown
+// keep only their text.
+func (p *mermaidParser) text(raw string, pos Position) string {
+	text := strings.TrimSpace(raw)
+	if len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`) {
+		text = text[1 : len(text)-1]
+	}
+	if len(text) >= 2 && strings.HasPrefix(text, "`") && strings.HasSuffix(text, "`") {
+		p.warnOnce(pos, "markdown", "Markdown texts are not supported, only the text is kept")
+		text = strings.ReplaceAll(text[1:len(text)-1], "**", "")
+	}
+	text = mermaidBreak.ReplaceAllString(text, "\n")
+	if dotHTMLTag.MatchString(text) {
+		p.warnOnce(pos, "html", "HTML in texts is not supported, only the text is kept")
+		text = dotHTMLTag.ReplaceAllString(text, "")
+	}
+	return mermaidEntity.ReplaceAllStringFunc(text, func(code string) string {
+		name := code[1 : len(code)-1]
+		if n, err := strconv.Atoi(name); err == nil {
+			return string(rune(n))
+		}
+		if s, ok := mermaidEntities[name]; ok {
+			return s
+		}
+		return code
+	})
+}
+
+// diagram turns what was read into a flowchart
+func (p *mermaidParser) diagram() Diagram {
+	d := Diagram{Name: "flowchart", Layout: p.layout, Theme: p.theme}
+	if d.Layout != "" {
+		d.Attrs = []Attribute{{Key: "layout", Value: d.Layout}}
+	}
+
+	for _, ls := range p.linkStyles {
+		if ls.indexes == nil {
+			attrs := p.edgeAttrs(ls.props)
+			if len(attrs) > 0 {
+				d.Styles = append(d.Styles, Style{Name: "edge", Attrs: attrs, Pos: ls.pos})
+			}
+			continue
+		}
+		for _, i := range ls.indexes {
+			if i >= len(p.edges) {
+				p.warn(ls.pos, fmt.Sprintf("linkStyle %d: there is no link %d, the links are counted from 0", i, i))
+				continue
+			}
+			p.edges[i].props = append(p.edges[i].props, ls.props...)
+		}
+	}
+
+	// classDef default is the style of every node
+	styles := map[string]string{}
+	for _, c := range p.classes {
+		if _, ok := styles[c.name]; ok {
+			continue
+		}
+		name := "node"
+		if c.name != "default" {
+			name = p.unique(c.name)
+		}
+		styles[c.name] = name
+		d.Styles = append(d.Styles, Style{Name: name, Attrs: p.colorAttrs(c.props), Pos: c.pos})
+	}
+	class := func(classes []mermaidProp, pos Position) []Attribute {
+		var names []string
+		for _, c := range classes {
+			if name, ok := styles[c.key]; ok && c.key != "default" {
+				names = append(names, name)
+			} else if !ok {
+				p.warnOnce(c.pos, "class "+c.key, fmt.Sprintf("the class '%s' is not defined with classDef and is left out", c.key))
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		return []Attribute{{Key: "class", Value: strings.Join(names, " "), Pos: pos}}
+	}
+
+	for _, n := range p.nodes {
+		attrs := append(class(n.classes, n.pos), p.colorAttrs(n.props)...)
+		if n.shape != "rect" {
+			attrs = append(attrs, Attribute{Key: "shape", Value: n.shape})
+		}
+		sortAttributes(attrs)
+		d.Nodes = append(d.Nodes, p.newNode(p.ident(n.id), n.label, n.shape, attrs, n.pos))
+	}
+
+	var convert func(g *mermaidGroup) Group
+	convert = func(g *mermaidGroup) Group {
+		attrs := append(class(g.classes, g.pos), p.colorAttrs(g.props)...)
+		sortAttributes(attrs)
+		group := p.newGroup(p.unique(g.id), g.label, attrs, g.pos)
+		for _, n := range g.nodes {
+			group.Nodes = append(group.Nodes, p.ident(n.id))
+		}
+		for _, inner := range g.groups {
+			group.Groups = append(group.Groups, convert(inner))
+		}
+		return group
+	}
+	for _, g := range p.root.groups {
+		d.Groups = append(d.Groups, convert(g))
+	}
+
+	for _, me := range p.edges {
+		if me.skip {
+			continue
+		}
+		e := Edge{
+			From:  p.ident(me.from),
+			To:    p.ident(me.to),
+			Kind:  me.kind,
+			Label: me.label,
+			Color: p.theme.EdgeColor,
+			Width: Length{Value: 2},
+			Pos:   me.pos,
+		}
+		if me.kind == EdgeThick {
+			e.Width = Length{Value: 4}
+		}
+		attrs := p.edgeAttrs(me.props)
+		if me.thick && !hasAttribute(attrs, "width") {
+			attrs = append(attrs, Attribute{Key: "width", Value: "4", Pos: me.pos})
+		}
+		for _, attr := range attrs {
+			switch attr.Key {
+			case "color":
+				p.color(attr, &e.Color)
+			case "width":
+				p.length(attr, &e.Width)
+			}
+		}
+		sortAttributes(attrs)
+		e.Attrs = attrs
+		d.Edges = append(d.Edges, e)
+	}
+
+	p.applyStyles(&d)
+	return d
+}
+
+// colorAttrs returns the styles of a node or subgraph as attributes:
+// fill is color, stroke is border and color is text
+func (p *mermaidParser) colorAttrs(props []mermaidProp) []Attribute {
+	var attrs []Attribute
+	for _, pr := range props {
+		key, ok := map[string]string{"fill": "color", "stroke": "border", "color": "text"}[pr.key]
+		if !ok {
+			p.warnOnce(pr.pos, "style "+pr.key, fmt.Sprintf("the Mermaid style '%s' has no equivalent and is left out", pr.key))
+			continue
+		}
+		if c, ok := p.mermaidColor(pr); ok {
+			attrs = setAttribute(attrs, Attribute{Key: key, Value: c, Pos: pr.pos})
+		}
+	}
+	return attrs
+}
+
+// edgeAttrs returns the styles of a link as attributes: stroke is color
+// and stroke-width is width
+func (p *mermaidParser) edgeAttrs(props []mermaidProp) []Attribute {
+	var attrs []Attribute
+	for _, pr := range props {
+		switch pr.key {
+		case "stroke":
+			if c, ok := p.mermaidColor(pr); ok {
+				attrs = setAttribute(attrs, Attribute{Key: "color", Value: c, Pos: pr.pos})
+			}
+		case "stroke-width":
+			if _, err := ParseLength(pr.value); err != nil {
+				p.warnOnce(pr.pos, "width "+pr.value, fmt.Sprintf("stroke-width: invalid width '%s' is left out", pr.value))
+				continue
+			}
+			attrs = setAttribute(attrs, Attribute{Key: "width", Value: pr.value, Pos: pr.pos})
+		case "fill":
+			if pr.value != "none" {
+				p.warnOnce(pr.pos, "link fill", "links can not be filled, fill is left out")
+			}
+		default:
+			p.warnOnce(pr.pos, "link style "+pr.key, fmt.Sprintf("the Mermaid link style '%s' has no equivalent and is left out", pr.key))
+		}
+	}
+	return attrs
+}
+
+// setAttribute sets attr in attrs, the last value of a key wins
+func setAttribute(attrs []Attribute, attr Attribute) []Attribute {
+	for i := range attrs {
+		if attrs[i].Key == attr.Key {
+			attrs[i] = attr
+			return attrs
+		}
+	}
+	return append(attrs, attr)
+}
+
+// mermaidColor returns the colour of a style, Mermaid styles are CSS
+func (p *mermaidParser) mermaidColor(pr mermaidProp) (string, bool) {
+	value := strings.TrimSuffix(strings.TrimSpace(pr.value), "!important")
+	if value == "none" {
+		value = "transparent"
+	}
+	c, err := ParseColor(value)
+	if err != nil {
+		p.warnOnce(pr.pos, "colour "+value, fmt.Sprintf("%s: the colour '%s' is not supported and is left out", pr.key, pr.value))
+		return "", false
+	}
+	return string(c), true
+}
+
+// mermaidKeywords can not be used as ids in Mermaid
+var mermaidKeywords = map[string]bool{
+	"end": true, "graph": true, "flowchart": true, "subgraph": true, "style": true, "classDef": true,
+	"class": true, "linkStyle": true, "click": true, "call": true, "href": true, "callback": true,
+	"default": true, "direction": true, "interpolate": true,
+}
+
+// mermaidArrows are the links of the kinds of edges
+var mermaidArrows = map[EdgeKind]string{
+	"":                "-->",
+	EdgeDirected:      "-->",
+	EdgeUndirected:    "---",
+	EdgeBidirectional: "<-->",
+	EdgeDashed:        "-.->",
+	EdgeDotted:        "-.->",
+	EdgeThick:         "==>",
+}
+
+// mermaidWriter writes a diagram as a Mermaid flowchart
+type mermaidWriter struct {
+	foreignIDs // diagra ids to Mermaid ids
+	onceWarner
+	d     Diagram
+	sb    strings.Builder
+	diags Diagnostics
+}
+
+// ExportMermaid returns a flowchart or a tree as a Mermaid flowchart. The
+// diagnostics are warnings about what could not be written, at the
+// positions in the diagram. Other types of diagrams give an error.
+func ExportMermaid(d Diagram) (string, Diagnostics, error) {
+	if d.Name != "flowchart" && d.Name != "tree" {
+		return "", nil, fmt.Errorf("only flowcharts and trees can be written as Mermaid, not %s diagrams", d.Name)
+	}
+	w := &mermaidWriter{d: d, foreignIDs: newForeignIDs(mermaidKeywords)}
+	w.onceWarner = newOnceWarner(&w.diags)
+	w.write()
+	sortDiagnostics(w.diags)
+	return w.sb.String(), w.diags, nil
+}
+
+func (w *mermaidWriter) line(depth int, text string) {
+	w.sb.WriteString(strings.Repeat("    ", depth) + text + "\n")
+}
+
+func (w *mermaidWriter) write() {
+	d := w.d
+	dir := "LR"
+	if d.Layout == "vertical" || d.Name == "tree" {
+		dir = "TD"
+	}
+	w.line(0, "flowchart "+dir)
+	if d.Theme.Name != "" && d.Theme.Name != DefaultTheme {
+		pos := Position{}
+		for _, a := range d.Attrs {
+			if a.Key == "theme" {
+				pos = a.Pos
+			}
+		}
+		w.warnOnce(pos, "theme", fmt.Sprintf("the theme %s is not written, Mermaid draws with its own colours", d.Theme.Name))
+	}
+
+	// Ids are taken in the order of the diagram, so they stay the same.
+	// The ids of included nodes have dots, they and keywords become other ids.
+	for _, n := range d.Nodes {
+		w.ident(n.ID)
+	}
+	inGroup := map[string]bool{}
+	var mark func(gs []Group)
+	mark = func(gs []Group) {
+		for _, g := range gs {
+			for _, id := range g.Nodes {
+				inGroup[id] = true
+			}
+			mark(g.Groups)
+		}
+	}
+	mark(d.Groups)
+
+	nodes := map[string]Node{}
+	for _, n := range d.Nodes {
+		nodes[n.ID] = n
+		if !inGroup[n.ID] {
+			w.node(1, n)
+		}
+	}
+	var group func(depth int, g Group)
+	group = func(depth int, g Group) {
+		w.line(depth, fmt.Sprintf("subgraph %s [%s]", w.ident(g.ID), mermaidText(g.Label)))
+		for _, id := range g.Nodes {
+			w.node(depth+1, nodes[id])
+		}
+		for _, inner := range g.Groups {
+			group(depth+1, inner)
+		}
+		w.line(depth, "end")
+	}
+	for _, g := range d.Groups {
+		group(1, g)
+	}
+
+	var linkStyles []string
+	for i, e := range d.Edges {
+		arrow := mermaidArrows[e.Kind]
+		thick := e.Width == Length{Value: 4}
+		switch {
+		case e.Kind == EdgeDashed:
+			w.warnOnce(e.Pos, "dashed", "Mermaid has no dashed links, dashed edges are written as dotted links")
+		case e.Kind == EdgeDotted:
+			w.warnOnce(e.Pos, "dotted", "Mermaid draws dotted links like dashed ones, dotted edges are written as -.->")
+		case thick && e.Kind == EdgeUndirected:
+			arrow = "==="
+		case thick && e.Kind == EdgeBidirectional:
+			arrow = "<==>"
+		}
+		if e.Label != "" {
+			arrow += "|" + mermaidText(e.Label) + "|"
+		}
+		w.line(1, w.ident(e.From)+" "+arrow+" "+w.ident(e.To))
+
+		var props []string
+		if e.Color != d.Theme.EdgeColor {
+			props = append(props, "stroke:"+e.Color.Hex())
+		}
+		width := Length{Value: 2}
+		if e.Kind == EdgeThick || (thick && (e.Kind == EdgeUndirected || e.Kind == EdgeBidirectional)) {
+			width = Length{Value: 4}
+		}
+		if e.Width != width {
+			if px, ok := e.Width.Pixels(); ok {
+				props = append(props, "stroke-width:"+strconv.FormatFloat(px, 'f', -1, 64)+"px")
+			} else {
+				w.warnOnce(e.Pos, "width "+e.Width.String(), fmt.Sprintf("the width %s can not be written and is left out", e.Width))
+			}
+		}
+		if len(props) > 0 {
+			linkStyles = append(linkStyles, fmt.Sprintf("linkStyle %d %s", i, strings.Join(props, ",")))
+		}
+	}
+
+	for _, n := range d.Nodes {
+		w.style(n.ID, [3]Color{n.Color, n.Border, n.Text}, [3]Color{d.Theme.NodeColor, d.Theme.NodeBorder, d.Theme.NodeText})
+		if n.Width.Value != 0 || n.Height.Value != 0 {
+			w.warnOnce(n.Pos, "size", "Mermaid sizes nodes by their text, width and height are left out")
+		}
+	}
+	var styleGroups func(gs []Group)
+	styleGroups = func(gs []Group) {
+		for _, g := range gs {
+			w.style(g.ID, [3]Color{g.Color, g.Border, g.Text}, [3]Color{d.Theme.GroupColor, d.Theme.GroupBorder, d.Theme.GroupText})
+			styleGroups(g.Groups)
+		}
+	}
+	styleGroups(d.Groups)
+	for _, ls := range linkStyles {
+		w.line(1, ls)
+	}
+}
+
+// mermaidBrackets are the brackets of the shapes that Mermaid has
+var mermaidBrackets = map[string][2]string{
+	"rect":          {"[", "]"},
+	"ellipse":       {"(", ")"},
+	"circle":        {"((", "))"},
+	"diamond":       {"{", "}"},
+	"parallelogram": {"[/", "/]"},
+	"hexagon":       {"{{", "}}"},
+	"cylinder":      {"[(", ")]"},
+}
+
+// node writes the declaration of a node, just the id for a rectangle
+// with the id as its text
+func (w *mermaidWriter) node(depth int, n Node) {
+	id := w.ident(n.ID)
+	brackets, ok := mermaidBrackets[n.Shape]
+	if !ok {
+		w.warnOnce(n.Pos, "shape "+n.Shape, fmt.Sprintf("Mermaid has no %s shape, it is written as a rectangle", n.Shape))
+		brackets = mermaidBrackets["rect"]
+	}
+	if n.Label == n.ID && brackets[0] == "[" && id == n.ID {
+		w.line(depth, id)
+		return
+	}
+	w.line(depth, id+brackets[0]+mermaidText(n.Label)+brackets[1])
+}
+
+// style writes the colours that are not the theme's: fill, stroke, color
+func (w *mermaidWriter) style(id string, colors, defaults [3]Color) {
+	var props []string
+	for i, key := range []string{"fill", "stroke", "color"} {
+		if colors[i] != defaults[i] && colors[i] != "" {
+			props = append(props, key+":"+colors[i].Hex())
+		}
+	}
+	if len(props) > 0 {
+		w.line(1, "style "+w.ident(id)+" "+strings.Join(props, ","))
+	}
+}
+
+// mermaidText returns a text for the brackets of a node or the bars of a
+// link, quoted when Mermaid would read some of it as syntax. Quotes are
+// written as #quot; and line breaks as <br>.
+func mermaidText(s string) string {
+	if s != "" && s == strings.TrimSpace(s) && !strings.ContainsAny(s, "\"[](){}<>|#;&:%@`/\\\n") {
+		return s
+	}
+	s = strings.NewReplacer(`"`, "#quot;", "\n", "<br>").Replace(s)
+	return `"` + s + `"`
+}
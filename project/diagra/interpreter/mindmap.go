@@ -0,0 +1,159 @@
+package interpreter
+
+// This file contains the parts of the parser that are only used for
+// mind maps. Topics are nested in the topic they belong to:
+//
+//	diagram mindmap {
+//		topic Root "Project" {
+//			topic Goals "Goals" (color=#ffcc80) {
+//				topic Fast "Faster builds"
+//			}
+//			topic Risks "Risks"
+//		}
+//	}
+//
+// Topics are nodes with Shape "topic", every nested topic gets an edge
+// from its parent. The branches below the central topic get a colour each
+// from the palette of the theme, and the topics in a branch inherit the colours of
+// their parent unless they set their own. A mind map is checked like a
+// tree: one central topic, one parent per topic and no cycles.
+
+// mindmapKeywords are the keywords that only make sense in mind maps
+var mindmapKeywords = map[string]bool{
+	"topic": true,
+}
+
+// parseMindmapStatement parses the statements that start with a keyword
+// in a mind map. Mind maps have no edges, the branches come from nesting
+// topics and a statement that starts with an id is an error.
+func (p *parser) parseMindmapStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "topic":
+		return p.parseTopic(d, nil, 0, 0)
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'topic' instead of 'node' in mind maps"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in mind maps"})
+	return false
+}
+
+// parseTopic parses: topic ID "Label" (attributes) { topics }
+// parent is the topic it is nested in, nil for the central topic, depth
+// is 0 for the central topic and branch is the number of topics before
+// it in the same parent.
+func (p *parser) parseTopic(d *Diagram, parent *Node, depth, branch int) bool {
+	pos := p.currentToken().Pos
+	p.advance() // topic
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("topic id", "expected topic id after 'topic'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("topic")
+	}
+
+	// The colours come from the branch, the topic's own attributes win
+	n := p.newNode(id, label, "topic", nil, pos)
+	switch {
+	case parent == nil:
+		n.Color, n.Border, n.Text = p.theme.EdgeColor, p.theme.Text, p.theme.Surface // stands out, it is the centre
+	case depth == 1 && len(p.theme.Palette) > 0:
+		colors := p.theme.Palette[branch%len(p.theme.Palette)]
+		n.Color, n.Border, n.Text = colors[0], colors[1], p.theme.Text
+	default:
+		n.Color, n.Border, n.Text = parent.Color, parent.Border, parent.Text
+		p.branches[id] = parent.ID
+	}
+	n.Attrs = attrs
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "color":
+			p.color(attr, &n.Color)
+		case "text":
+			p.color(attr, &n.Text)
+		case "shape":
+			p.fixedShapes(attr)
+		case "border":
+			p.color(attr, &n.Border)
+		}
+	}
+	p.addNode(d, n)
+
+	if parent != nil {
+		d.Edges = append(d.Edges, Edge{From: parent.ID, To: id, Color: n.Border, Width: Length{Value: 2}, Pos: pos})
+	}
+
+	if !p.match(TOKEN_LBRACE) {
+		return true
+	}
+	children := 0
+	for p.currentToken().Type != TOKEN_RBRACE && p.currentToken().Type != TOKEN_EOF {
+		tok := p.currentToken()
+		if !p.isKeyword(tok) || tok.Value != "topic" {
+			p.fail(p.expected("'topic'", "expected topic inside topic "+id))
+			p.synchronize()
+			continue
+		}
+		if !p.parseTopic(d, &n, depth+1, children) {
+			p.synchronize()
+		}
+		children++
+	}
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of topic "+id))
+	}
+	return true
+}
+
+// inheritBranch gives a topic the colours of its parent again once the
+// parent is styled, so a style class on a topic colours its whole branch.
+// The topic's own attributes still win, its classes are applied after
+// this. Parents come before their children in d.Nodes.
+func (p *parser) inheritBranch(d *Diagram, n *Node) {
+	parent, ok := p.branches[n.ID]
+	if !ok {
+		return
+	}
+	for _, other := range d.Nodes {
+		if other.ID == parent {
+			n.Color, n.Border, n.Text = other.Color, other.Border, other.Text
+		}
+	}
+	for _, attr := range n.Attrs {
+		switch attr.Key {
+		case "color":
+			setColor(attr, &n.Color)
+		case "text":
+			setColor(attr, &n.Text)
+		case "border":
+			setColor(attr, &n.Border)
+		}
+	}
+}
+
+// colorBranches gives every branch line the border colour of the topic
+// it leads to, also when that colour comes from a style
+func colorBranches(d *Diagram) {
+	border := map[string]Color{}
+	for _, n := range d.Nodes {
+		border[n.ID] = n.Border
+	}
+	for i := range d.Edges {
+		d.Edges[i].Color = border[d.Edges[i].To]
+	}
+}
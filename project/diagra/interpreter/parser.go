@@ -1,17 +1,83 @@
 package interpreter
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Parser struct for parsing diagram definitions
 type parser struct {
 	tokens  []Token
 	current int
+	diags   Diagnostics
+	group   *Group  // the group being parsed, nil at the top level
+	steps   *[]Step // the sequence steps being parsed, nil at the top level
+	theme   Theme   // the default colours
+
+	file       string              // the file being parsed, includes are relative to it
+	includes   []string            // absolute paths of the files being included, to find cycles
+	namespaces map[string]Position // the namespaces of the includes, and where they were included
+	included   []Style             // the styles of included files, they come after the diagram's own
+	diagram    string              // the type of the diagram being parsed
+	keywords   map[string]bool     // the keywords of the diagram type, they are lexed as identifiers
+	usedIn     map[string]string   // the group each edge end was first used in, "" at the top level
+	branches   map[string]string   // the parent of each topic that has the colours of its branch
+	undefined  map[styleUse]bool   // the undefined classes that are reported, shared with includes
 }
 
-// Parse starts parsing the tokens and returns a Diagram object
+// Parse starts parsing the tokens and returns a Diagram object.
+// The parser does not stop at the first problem, if there are errors
+// the returned error is a Diagnostics with all of them and the Diagram
+// holds everything that could be parsed.
 func Parse(tokens []Token) (Diagram, error) {
-	p := &parser{tokens: tokens, current: 0}
-	return p.parseDiagram()
+	d, diags := ParseDiagnostics(tokens)
+	if diags.HasErrors() {
+		return d, diags
+	}
+	return d, nil
+}
+
+// ParseDiagnostics parses the tokens and returns the (possibly partial)
+// Diagram together with every error and warning found on the way.
+func ParseDiagnostics(tokens []Token) (Diagram, Diagnostics) {
+	return ParseWithTheme(tokens, "")
+}
+
+// ParseWithTheme is ParseDiagnostics with the theme chosen from outside,
+// like --theme on the command line. It wins over theme= in the diagram,
+// "" uses the theme of the diagram.
+// Includes are resolved relative to the working directory, use ParseFile
+// for a diagram read from a file.
+func ParseWithTheme(tokens []Token, theme string) (Diagram, Diagnostics) {
+	return ParseFile(tokens, "", theme)
+}
+
+// newParser creates a parser for the tokens of file
+func newParser(tokens []Token, file string) *parser {
+	p := &parser{current: 0, file: file, namespaces: map[string]Position{}, usedIn: map[string]string{}, branches: map[string]string{}, undefined: map[styleUse]bool{}}
+	p.theme, _ = LookupTheme(DefaultTheme)
+	p.tokens = p.dropIllegal(tokens)
+	return p
+}
+
+// dropIllegal reports the TOKEN_ILLEGAL tokens from the lexer as errors
+// and returns the tokens without them. Their comments move to the next token.
+func (p *parser) dropIllegal(tokens []Token) []Token {
+	var kept []Token
+	var comments []Comment
+	for _, tok := range tokens {
+		if tok.Type == TOKEN_ILLEGAL {
+			p.fail(&Diagnostic{Pos: tok.Pos, Message: tok.Value})
+			comments = append(comments, tok.Comments...)
+			continue
+		}
+		if len(comments) > 0 {
+			tok.Comments = append(comments, tok.Comments...)
+			comments = nil
+		}
+		kept = append(kept, tok)
+	}
+	return kept
 }
 
 // --- Internal help functions ---
@@ -20,11 +86,23 @@ func Parse(tokens []Token) (Diagram, error) {
 // If the current index is out of bounds, it returns an EOF token
 func (p *parser) currentToken() Token {
 	if p.current >= len(p.tokens) {
-		return Token{Type: TOKEN_EOF}
+		eof := Token{Type: TOKEN_EOF}
+		if len(p.tokens) > 0 {
+			eof.Pos = p.tokens[len(p.tokens)-1].Pos
+		}
+		return eof
 	}
 	return p.tokens[p.current]
 }
 
+// peek returns the token after the current one
+func (p *parser) peek() Token {
+	p.current++
+	tok := p.currentToken()
+	p.current--
+	return tok
+}
+
 // advance moves the current token index forward
 func (p *parser) advance() {
 	p.current++
@@ -39,193 +117,811 @@ func (p *parser) match(typ TokenType) bool {
 	return false
 }
 
-func (p *parser) parseDiagram() (Diagram, error) {
+// isSymbol reports whether the current token is the symbol s
+func (p *parser) isSymbol(s string) bool {
+	tok := p.currentToken()
+	return tok.Type == TOKEN_SYMBOL && tok.Value == s
+}
+
+// expected returns a diagnostic at the current token saying that
+// something else was expected there. message describes the context.
+func (p *parser) expected(what, message string) *Diagnostic {
+	tok := p.currentToken()
+	return &Diagnostic{
+		Pos:      tok.Pos,
+		Message:  message,
+		Expected: what,
+		Found:    describe(tok),
+	}
+}
+
+// fail records an error diagnostic
+func (p *parser) fail(d *Diagnostic) {
+	p.diags = append(p.diags, d)
+}
+
+// warn records a warning at pos
+func (p *parser) warn(pos Position, message string) {
+	p.diags = append(p.diags, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: message})
+}
+
+// diagramKeywords maps the diagram types to the keywords that only
+// make sense in them
+var diagramKeywords = map[string]map[string]bool{
+	"sequence": sequenceKeywords,
+	"state":    stateKeywords,
+	"er":       erKeywords,
+	"class":    classKeywords,
+	"gantt":    ganttKeywords,
+	"mindmap":  mindmapKeywords,
+}
+
+// typeNames are the diagram types with keywords of their own, as they
+// are called in messages
+var typeNames = map[string]string{
+	"sequence": "sequence diagrams",
+	"state":    "state diagrams",
+	"er":       "ER diagrams",
+	"class":    "class diagrams",
+	"gantt":    "gantt diagrams",
+	"mindmap":  "mind maps",
+}
+
+// isKeyword reports whether tok is a keyword in the diagram being parsed,
+// either one of every diagram type or one of its own type
+func (p *parser) isKeyword(tok Token) bool {
+	return tok.Type == TOKEN_KEYWORD || (tok.Type == TOKEN_IDENTIFIER && p.keywords[tok.Value])
+}
+
+// atKeyword reports whether the current token starts a keyword statement.
+// A keyword of another diagram type starts one too, so that it is reported
+// as used in the wrong type, unless it is a node id starting an edge.
+func (p *parser) atKeyword() bool {
+	tok := p.currentToken()
+	return p.isKeyword(tok) || (tok.Type == TOKEN_IDENTIFIER && keywordOf(tok.Value) != "" && !p.atEdgeStart())
+}
+
+// atInclude reports whether the current token starts an include.
+// include is not a keyword, it is one only when a file name follows.
+func (p *parser) atInclude() bool {
+	tok := p.currentToken()
+	return tok.Type == TOKEN_IDENTIFIER && tok.Value == "include" && p.peek().Type == TOKEN_STRING
+}
+
+// atStatementStart reports whether the current token can start a statement
+// or end a block. These are the points where the parser resynchronises.
+func (p *parser) atStatementStart() bool {
+	tok := p.currentToken()
+	switch tok.Type {
+	case TOKEN_KEYWORD, TOKEN_RBRACE, TOKEN_EOF:
+		return true
+	case TOKEN_IDENTIFIER:
+		return p.atKeyword() || p.atInclude() || p.atEdgeStart()
+	case TOKEN_NUMBER:
+		return isNodeID(tok) && p.atEdgeStart()
+	}
+	return false
+}
+
+// isNodeID reports whether tok can be a node id. A number without a unit
+// or decimals is one too, like node 1 "One" and 1 -> 2.
+func isNodeID(tok Token) bool {
+	if tok.Type == TOKEN_NUMBER {
+		return strings.Trim(tok.Value, "0123456789") == ""
+	}
+	return tok.Type == TOKEN_IDENTIFIER
+}
+
+// synchronize skips tokens after an error until the next statement
+// boundary (node, edge or closing brace) so parsing can continue.
+// It always moves forward at least one token unless it is at EOF.
+func (p *parser) synchronize() {
+	if p.currentToken().Type != TOKEN_EOF {
+		p.advance()
+	}
+	for !p.atStatementStart() {
+		p.advance()
+	}
+}
+
+func (p *parser) parseDiagram(theme string) Diagram {
 	var d Diagram
 
 	// Expect: "diagram"
 	if p.currentToken().Type != TOKEN_KEYWORD || p.currentToken().Value != "diagram" {
-		return d, fmt.Errorf("expected 'diagram' keyword")
+		p.fail(p.expected("'diagram'", "expected 'diagram' keyword"))
+		// Skip ahead to the header if it is there at all
+		for p.currentToken().Type != TOKEN_EOF && p.currentToken().Value != "diagram" {
+			p.advance()
+		}
+		if p.currentToken().Type == TOKEN_EOF {
+			return d
+		}
 	}
 	p.advance()
 
 	// Expect: diagram type name
+	if t := p.currentToken().Type; t != TOKEN_IDENTIFIER && t != TOKEN_KEYWORD {
+		p.fail(p.expected("diagram type", "expected diagram type name"))
+	} else {
+		typeTok := p.currentToken()
+		d.Name = typeTok.Value
+		p.diagram, p.keywords = d.Name, diagramKeywords[d.Name]
+		p.advance()
+
+		if !allowedTypes[d.Name] {
+			p.fail(&Diagnostic{Pos: typeTok.Pos, Message: "okänd diagramtyp: " + d.Name})
+		}
+	}
+
+	// Optional attributes: (layout, theme)
+	if p.isSymbol("(") {
+		d.Attrs = p.parseAttributes("diagram")
+		for _, attr := range d.Attrs {
+			switch attr.Key {
+			case "layout":
+				d.Layout = attr.Value
+			case "theme":
+				if theme == "" {
+					p.setTheme(attr.Value, attr.Pos)
+				}
+			}
+		}
+	}
+	if theme != "" {
+		p.setTheme(theme, Position{Line: 1, Col: 1})
+	}
+	d.Theme = p.theme
+
+	// Expect: "{"
+	// This is where the diagram content starts
+	if !p.match(TOKEN_LBRACE) {
+		p.fail(p.expected("'{'", "expected '{' after diagram type"))
+	}
+
+	p.parseBlock(&d)
+	p.implicitNodes(&d)
+	d.Styles = append(d.Styles, p.included...)
+	p.applyStyles(&d)
+
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of diagram"))
+	}
+	if tok := p.currentToken(); tok.Type != TOKEN_EOF {
+		p.warn(tok.Pos, "content after the closing '}' is ignored")
+	}
+	return d
+}
+
+// setTheme makes the theme with the given name the default colours
+func (p *parser) setTheme(name string, pos Position) {
+	t, ok := LookupTheme(name)
+	if !ok {
+		p.fail(&Diagnostic{Pos: pos, Message: fmt.Sprintf("unknown theme '%s', expected one of: %s", name, strings.Join(ThemeNames(), ", "))})
+		return
+	}
+	p.theme = t
+}
+
+// parseBlock parses statements until a closing brace or EOF.
+// The closing brace itself is left for the caller.
+func (p *parser) parseBlock(d *Diagram) {
+	for p.currentToken().Type != TOKEN_RBRACE && p.currentToken().Type != TOKEN_EOF {
+		start := p.current
+		if !p.parseStatement(d) {
+			p.synchronize()
+		}
+		if p.current == start {
+			p.advance() // never loop on the same token
+		}
+	}
+}
+
+// parseStatement parses one node or edge statement.
+// It returns false after recording an error, the caller then resynchronises.
+func (p *parser) parseStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch {
+	case tok.Type == TOKEN_KEYWORD && tok.Value == "style":
+		return p.parseStyle(d)
+	case p.atInclude():
+		return p.parseInclude(d)
+	case d.Name == "sequence" && p.atKeyword():
+		return p.parseSequenceStatement(d)
+	case d.Name == "state" && p.atKeyword():
+		return p.parseStateStatement(d)
+	case d.Name == "er" && p.atKeyword():
+		return p.parseERStatement(d)
+	case d.Name == "class" && p.atKeyword():
+		return p.parseClassStatement(d)
+	case d.Name == "gantt" && p.atKeyword():
+		return p.parseGanttStatement(d)
+	case d.Name == "gantt" && isNodeID(tok):
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'after' instead of edges for dependencies in gantt diagrams"})
+		return false
+	case d.Name == "mindmap" && p.atKeyword():
+		return p.parseMindmapStatement(d)
+	case d.Name == "mindmap" && isNodeID(tok):
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use nested topics instead of edges in mind maps"})
+		return false
+	case tok.Type == TOKEN_KEYWORD && tok.Value == "node":
+		return p.parseNode(d)
+	case tok.Type == TOKEN_KEYWORD && tok.Value == "group":
+		return p.parseGroup(d)
+	case tok.Type == TOKEN_IDENTIFIER && p.atKeyword():
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is only allowed in " + keywordOf(tok.Value)})
+		return false
+	case isNodeID(tok):
+		return p.parseEdge(d)
+	}
+
+	p.fail(p.expected("statement", "expected node, group or edge"))
+	return false
+}
+
+// atEdgeStart reports whether the current identifier starts an edge,
+// it is followed by an arrow or a port
+func (p *parser) atEdgeStart() bool {
+	next := p.peek()
+	return next.Type == TOKEN_ARROW || (next.Type == TOKEN_SYMBOL && next.Value == ".")
+}
+
+// keywordOf returns the diagram type that word is a keyword of, for
+// error messages, or "" when it is not a keyword of any type
+func keywordOf(word string) string {
+	for name, words := range diagramKeywords {
+		if words[word] {
+			return typeNames[name]
+		}
+	}
+	return ""
+}
+
+// parseGroup parses: group ID "Label" (attributes) { statements }
+// Groups can be nested. Nodes declared in the block belong to the group,
+// edges are added to the diagram as usual.
+func (p *parser) parseGroup(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	p.advance() // group
+
 	if p.currentToken().Type != TOKEN_IDENTIFIER {
-		return d, fmt.Errorf("expected diagram type name")
+		p.fail(p.expected("group id", "expected group id after 'group'"))
+		return false
 	}
-	d.Name = p.currentToken().Value
+	id := p.currentToken().Value
 	p.advance()
 
-	if !allowedTypes[d.Name] {
-		return d, fmt.Errorf("okänd diagramtyp: %s", d.Name)
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("group")
+	}
+
+	if !p.match(TOKEN_LBRACE) {
+		p.fail(p.expected("'{'", "expected '{' after group "+id))
+		return false
+	}
+	p.parseGroupBlock(d, p.newGroup(id, label, attrs, pos))
+	return true
+}
+
+// newGroup creates a group with the default colours and applies attrs
+func (p *parser) newGroup(id, label string, attrs []Attribute, pos Position) Group {
+	g := Group{
+		ID:     id,
+		Label:  label,
+		Color:  p.theme.GroupColor,
+		Border: p.theme.GroupBorder,
+		Text:   p.theme.GroupText,
+		Attrs:  attrs,
+		Pos:    pos,
 	}
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "color":
+			p.color(attr, &g.Color)
+		case "border":
+			p.color(attr, &g.Border)
+		case "text":
+			p.color(attr, &g.Text)
+		}
+	}
+	return g
+}
+
+// parseGroupBlock parses the statements of a group after its '{',
+// and the closing '}', then adds the group to its parent or the diagram
+func (p *parser) parseGroupBlock(d *Diagram, g Group) {
+	parent := p.group
+	p.group = &g
+	p.parseBlock(d)
+	p.group = parent
 
-	// Optional attribute: (layout)
-	if p.currentToken().Value == "(" {
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of group "+g.ID))
+	}
+
+	if parent != nil {
+		parent.Groups = append(parent.Groups, g)
+	} else {
+		d.Groups = append(d.Groups, g)
+	}
+}
+
+// parseNode parses: node ID "Label" (attributes)
+// It also parses the participants of a sequence diagram,
+// participant ID "Label" and actor ID "Label", where the label is optional.
+func (p *parser) parseNode(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	keyword := p.currentToken().Value
+	p.advance() // node
+
+	if !isNodeID(p.currentToken()) {
+		p.fail(p.expected(keyword+" id", "expected "+keyword+" id after '"+keyword+"'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
 		p.advance()
-		for {
-			if p.currentToken().Value == ")" || p.currentToken().Type == TOKEN_EOF {
-				break
-			}
+	} else if keyword == "node" {
+		p.fail(p.expected("label", "expected label for node "+id))
+	}
 
-			key := p.currentToken().Value
-			p.advance()
+	shape := "rect" // default shape: rectangle
+	if keyword != "node" {
+		shape = keyword // participant or actor
+	}
 
-			if p.currentToken().Value != "=" {
-				return d, fmt.Errorf("expected '=' after attributename")
-			}
-			p.advance()
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("node")
+	}
 
-			value := p.currentToken().Value
-			p.advance()
+	p.addNode(d, p.newNode(id, label, shape, attrs, pos))
+	return true
+}
 
-			if key == "layout" {
-				d.Layout = value
+// newNode creates a node with the default colours and applies attrs
+func (p *parser) newNode(id, label, shape string, attrs []Attribute, pos Position) Node {
+	n := Node{
+		ID:     id,
+		Label:  label,
+		Color:  p.theme.NodeColor,
+		Text:   p.theme.NodeText,
+		Shape:  shape,
+		Border: p.theme.NodeBorder,
+		Attrs:  attrs,
+		Pos:    pos,
+	}
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "color":
+			p.color(attr, &n.Color)
+		case "text":
+			p.color(attr, &n.Text)
+		case "shape":
+			if p.fixedShapes(attr) {
+				continue
 			}
+			n.Shape = attr.Value
+		case "border":
+			p.color(attr, &n.Border)
+		case "width":
+			p.size(attr, &n.Width)
+		case "height":
+			p.size(attr, &n.Height)
+		}
+	}
+	return n
+}
+
+// fixedShapes reports a shape attribute in the diagram types with keywords
+// of their own. Their nodes are drawn by kind and Shape is the kind, like
+// initial or entity, so it can not be changed.
+func (p *parser) fixedShapes(attr Attribute) bool {
+	if p.keywords == nil {
+		return false
+	}
+	p.fail(&Diagnostic{Pos: attr.Pos, Message: "'shape' is not allowed in " + typeNames[p.diagram] + ", the keyword decides how a node is drawn"})
+	return true
+}
+
+// addNode adds a node to the diagram and to the group being parsed
+func (p *parser) addNode(d *Diagram, n Node) {
+	if p.group != nil {
+		p.group.Nodes = append(p.group.Nodes, n.ID)
+	}
+	d.Nodes = append(d.Nodes, n)
+}
+
+// parseEdge parses: From -> To "Label" (attributes)
+// and chains of edges: A -> B -> C "Label" (attributes), where the label
+// and the attributes are used by every edge of the chain
+func (p *parser) parseEdge(d *Diagram) bool {
+	type end struct {
+		id, port string
+		arrow    Token // the arrow into this end, unused for the first one
+		pos      Position
+	}
 
-			if p.currentToken().Value == "," {
-				p.advance()
+	pos := p.currentToken().Pos
+	from := p.reference()
+	fromPort, ok := p.parsePort(d)
+	if !ok {
+		return false
+	}
+	ends := []end{{id: from, port: fromPort, pos: pos}}
+
+	for {
+		arrow := p.currentToken()
+		if !p.match(TOKEN_ARROW) {
+			if len(ends) > 1 {
+				break
 			}
+			p.fail(p.expected("'->'", "expected '->' after "+from))
+			return false
 		}
-		p.match(TOKEN_SYMBOL) // ")"
+		p.checkArrow(d, arrow)
+
+		if !isNodeID(p.currentToken()) {
+			p.fail(p.expected("node id", "expected target node after '"+arrow.Value+"'"))
+			return false
+		}
+		toPos := p.currentToken().Pos
+		to := p.reference()
+		toPort, ok := p.parsePort(d)
+		if !ok {
+			return false
+		}
+		ends = append(ends, end{id: to, port: toPort, arrow: arrow, pos: toPos})
 	}
 
-	// Expect: "{"
-	// This is where the diagram content starts
-	if !p.match(TOKEN_LBRACE) {
-		return d, fmt.Errorf("expected '{' after diagram type")
+	label := ""
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
 	}
 
-	for p.currentToken().Type != TOKEN_RBRACE && p.currentToken().Type != TOKEN_EOF {
-		tok := p.currentToken()
+	color := p.theme.EdgeColor
+	width := Length{Value: 2} // default width: 2
+	widthSet := false
 
-		// --- Nodes ---
-		if tok.Type == TOKEN_KEYWORD && tok.Value == "node" {
-			p.advance()
-			id := p.currentToken().Value
-			p.advance()
-			label := p.currentToken().Value
-			p.advance()
+	// ER relationships go from a foreign key to a key, many to one
+	var fromCard, toCard Cardinality
+	if d.Name == "er" {
+		fromCard, toCard = CardinalityMany, CardinalityOne
+	}
+
+	// Class diagrams: the kind of relationship and the multiplicities
+	var relation Relation
+	var fromMultiplicity, toMultiplicity string
+	if d.Name == "class" {
+		relation = RelationAssociation
+	}
 
-			// Default values
-			color := "#e0f7fa"     // light cyan
-			textColor := "#004d40" // dark cyan
-			shape := "rect"        // default shape: rectangle
-			border := "#00796b"    // default border color: dark cyan
-
-			if p.currentToken().Value == "(" {
-				p.advance()
-				for {
-					if p.currentToken().Value == ")" || p.currentToken().Type == TOKEN_EOF {
-						break
-					}
-
-					key := p.currentToken().Value
-					p.advance()
-
-					if p.currentToken().Value != "=" {
-						return d, fmt.Errorf("expected '=' in node attribute")
-					}
-					p.advance()
-
-					value := p.currentToken().Value
-					p.advance()
-
-					switch key {
-					case "color":
-						color = value
-					case "text":
-						textColor = value
-					case "shape":
-						shape = value
-					case "border":
-						border = value
-					}
-
-					if p.currentToken().Value == "," {
-						p.advance()
-					}
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("edge")
+		for _, attr := range attrs {
+			switch attr.Key {
+			case "color":
+				p.color(attr, &color)
+			case "width":
+				p.length(attr, &width)
+				widthSet = true
+			case "from":
+				switch d.Name {
+				case "er":
+					p.cardinality(attr, &fromCard)
+				case "class":
+					fromMultiplicity = attr.Value
+				}
+			case "to":
+				switch d.Name {
+				case "er":
+					p.cardinality(attr, &toCard)
+				case "class":
+					toMultiplicity = attr.Value
+				}
+			case "relation":
+				if d.Name == "class" {
+					p.relation(attr, &relation)
 				}
-				p.advance() // close ")"
 			}
+		}
+	}
 
-			d.Nodes = append(d.Nodes, Node{
-				ID:     id,
-				Label:  label,
-				Color:  color,
-				Text:   textColor,
-				Shape:  shape,
-				Border: border,
-			})
-			continue
+	var transition Transition
+	if d.Name == "state" {
+		// Broken labels are reported by Validate and kept only as Label
+		if t, err := ParseTransition(label); err == nil {
+			transition = t
 		}
+	}
 
-		// --- Edges ---
-		if tok.Type == TOKEN_IDENTIFIER {
-			from := tok.Value
-			p.advance()
+	// One edge for every link of the chain, at the position of its start
+	for i := 1; i < len(ends); i++ {
+		var kind EdgeKind
+		if d.Name != "sequence" {
+			kind = edgeKinds[ends[i].arrow.Value]
+		}
+		width := width
+		if kind == EdgeThick && !widthSet {
+			width = Length{Value: 4}
+		}
+		e := Edge{
+			Kind:             kind,
+			Transition:       transition,
+			From:             ends[i-1].id,
+			FromPort:         ends[i-1].port,
+			FromCard:         fromCard,
+			To:               ends[i].id,
+			ToPort:           ends[i].port,
+			ToCard:           toCard,
+			Relation:         relation,
+			FromMultiplicity: fromMultiplicity,
+			ToMultiplicity:   toMultiplicity,
+			Label:            label,
+			Color:            color,
+			Width:            width,
+			Attrs:            attrs,
+			Pos:              ends[i-1].pos,
+		}
+		if d.Name == "sequence" {
+			p.addStep(d, Step{Kind: StepMessage, Message: messageKinds[ends[i].arrow.Value], Edge: e, Pos: e.Pos})
+			continue
+		}
+		d.Edges = append(d.Edges, e)
+	}
 
-			if !p.match(TOKEN_ARROW) {
-				return d, fmt.Errorf("expected '->' after %s", from)
+	// An implicit node belongs to the group where it is first used
+	for _, end := range ends {
+		if _, ok := p.usedIn[end.id]; !ok {
+			p.usedIn[end.id] = ""
+			if p.group != nil {
+				p.usedIn[end.id] = p.group.ID
 			}
+		}
+	}
+	return true
+}
 
-			to := p.currentToken().Value
-			p.advance()
+// checkArrow reports an arrow that the diagram type does not have.
+// Sequence diagrams have their own arrows, ER and class diagrams only ->
+// because the ends of their edges are drawn from from=, to= and relation=.
+func (p *parser) checkArrow(d *Diagram, arrow Token) {
+	_, message := messageKinds[arrow.Value]
+	_, edge := edgeKinds[arrow.Value]
+	switch {
+	case d.Name == "sequence" && !message:
+		p.fail(&Diagnostic{Pos: arrow.Pos, Message: "arrow '" + arrow.Value + "' is not allowed in sequence diagrams"})
+	case d.Name != "sequence" && !edge:
+		p.fail(&Diagnostic{Pos: arrow.Pos, Message: "arrow '" + arrow.Value + "' is only allowed in sequence diagrams"})
+	case (d.Name == "er" || d.Name == "class") && arrow.Value != "->":
+		p.fail(&Diagnostic{Pos: arrow.Pos, Message: "arrow '" + arrow.Value + "' is only allowed in flowcharts, trees and state diagrams"})
+	}
+}
 
-			label := ""
-			if p.currentToken().Type == TOKEN_STRING {
-				label = p.currentToken().Value
-				p.advance()
+// implicitNodes creates the nodes that are only used by edges, with the
+// id as label, so a quick sketch does not have to declare every node.
+// Only flowcharts, trees and state diagrams have them, the other types
+// need more than a label. Ids in the namespace of an include are not
+// created, a missing node there is a mistake. A node is added to the group
+// where an edge first used it, the way addNode does for declared nodes,
+// and it comes right after the group's other nodes in d.Nodes because the
+// layouts place the nodes in that order. That is only known once the whole
+// diagram is parsed, a node may be declared after the edges that use it.
+func (p *parser) implicitNodes(d *Diagram) {
+	switch d.Name {
+	case "flowchart", "tree", "state":
+	default:
+		return
+	}
+	declared := map[string]bool{}
+	for _, n := range d.Nodes {
+		declared[n.ID] = true
+	}
+	declare := func(id string, pos Position) {
+		if declared[id] || strings.Contains(id, ".") {
+			return
+		}
+		if d.Name == "state" && findGroup(d.Groups, id) != nil {
+			return // a composite state
+		}
+		declared[id] = true
+		n := p.newNode(id, id, "rect", nil, pos)
+		n.Implicit = true
+		g := findGroup(d.Groups, p.usedIn[id])
+		if g == nil || p.usedIn[id] == "" {
+			d.Nodes = append(d.Nodes, n)
+			return
+		}
+		members := map[string]bool{}
+		groupMembers(*g, members)
+		at := len(d.Nodes)
+		for i, other := range d.Nodes {
+			if members[other.ID] {
+				at = i + 1
 			}
+		}
+		d.Nodes = append(d.Nodes[:at], append([]Node{n}, d.Nodes[at:]...)...)
+		g.Nodes = append(g.Nodes, id)
+	}
+	for _, e := range d.Edges {
+		declare(e.From, e.Pos)
+		declare(e.To, e.Pos)
+	}
+}
 
-			color := "#37474f" // default color: dark grey
-			width := "2"       // default width: 2
-
-			if p.currentToken().Value == "(" {
-				p.advance()
-				for {
-					// fmt.Println("Edge attr loop at:", p.currentToken())
-					if p.currentToken().Value == ")" || p.currentToken().Type == TOKEN_EOF {
-						break
-					}
-
-					key := p.currentToken().Value
-					p.advance()
-
-					if p.currentToken().Value != "=" {
-						return d, fmt.Errorf("expected '=' in edge attribute")
-					}
-					p.advance()
-
-					value := p.currentToken().Value
-					p.advance()
-
-					switch key {
-					case "color":
-						color = value
-					case "width":
-						width = value
-					}
-
-					if p.currentToken().Value == "," {
-						p.advance()
-					}
-				}
-				p.advance() // close ")"
-			}
+// groupMembers adds the ids of the nodes in g and its nested groups to members
+func groupMembers(g Group, members map[string]bool) {
+	for _, id := range g.Nodes {
+		members[id] = true
+	}
+	for _, inner := range g.Groups {
+		groupMembers(inner, members)
+	}
+}
+
+// parsePort parses the optional ".column" after an entity in an ER diagram
+func (p *parser) parsePort(d *Diagram) (string, bool) {
+	if !p.isSymbol(".") {
+		return "", true
+	}
+	dot := p.currentToken()
+	p.advance()
+	if d.Name != "er" {
+		p.fail(&Diagnostic{Pos: dot.Pos, Message: "'.column' is only allowed in ER diagrams"})
+		return "", false
+	}
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("column name", "expected column name after '.'"))
+		return "", false
+	}
+	port := p.currentToken().Value
+	p.advance()
+	return port, true
+}
+
+// parseAttributes parses an attribute list: (key=value, key=value)
+// The current token must be the opening parenthesis. A broken attribute
+// is reported and skipped, the rest of the list is still parsed.
+func (p *parser) parseAttributes(context string) []Attribute {
+	var attrs []Attribute
+	seen := map[string]bool{}
+	p.advance() // (
 
-			d.Edges = append(d.Edges, Edge{
-				From:  from,
-				To:    to,
-				Label: label,
-				Color: color,
-				Width: width,
-			})
+	for !p.isSymbol(")") {
+		tok := p.currentToken()
+		if tok.Type == TOKEN_EOF || tok.Type == TOKEN_LBRACE || tok.Type == TOKEN_RBRACE || p.atKeywordStatement() {
+			p.fail(p.expected("')'", "expected ')' to close "+context+" attributes"))
+			return attrs
+		}
+
+		if !isAttributeName(tok) {
+			p.fail(p.expected("attribute name", "expected attribute name in "+context+" attribute"))
+			p.skipAttribute()
 			continue
 		}
+		p.advance()
 
+		if !p.isSymbol("=") {
+			p.fail(p.expected("'='", "expected '=' in "+context+" attribute"))
+			p.skipAttribute()
+			continue
+		}
 		p.advance()
+
+		// A keyword is a value when it ends the attribute, like label=group
+		value := p.currentToken()
+		next := p.peek()
+		keywordValue := value.Type == TOKEN_KEYWORD && next.Type == TOKEN_SYMBOL && (next.Value == "," || next.Value == ")")
+		if !isValue(value) && !keywordValue {
+			p.fail(p.expected("attribute value", "expected value for "+context+" attribute "+tok.Value))
+			p.skipAttribute()
+			continue
+		}
+		p.advance()
+
+		if seen[tok.Value] {
+			p.warn(tok.Pos, "attribute '"+tok.Value+"' is set more than once, the last value is used")
+		}
+		seen[tok.Value] = true
+		attrs = append(attrs, Attribute{Key: tok.Value, Value: value.Value, Pos: tok.Pos})
+
+		if p.isSymbol(",") {
+			p.advance()
+		} else if !p.isSymbol(")") {
+			p.fail(p.expected("',' or ')'", "expected ',' or ')' after "+context+" attribute"))
+			p.skipAttribute()
+		}
 	}
+	p.advance() // )
+	return attrs
+}
 
-	p.match(TOKEN_RBRACE)
-	return d, nil
+// skipAttribute skips to the next ',' or ')' in a broken attribute list.
+// A ',' is consumed, a ')' and anything that ends the list is left in place.
+func (p *parser) skipAttribute() {
+	for {
+		tok := p.currentToken()
+		switch {
+		case tok.Type == TOKEN_EOF, tok.Type == TOKEN_LBRACE, tok.Type == TOKEN_RBRACE:
+			return
+		case p.atKeywordStatement():
+			return
+		case p.isSymbol(")"):
+			return
+		case p.isSymbol(","):
+			p.advance()
+			return
+		}
+		p.advance()
+	}
+}
+
+// atKeywordStatement reports whether the current token is a keyword that
+// ends an unclosed attribute list. class is also an attribute name, it
+// ends the list only when no '=' follows.
+func (p *parser) atKeywordStatement() bool {
+	next := p.peek()
+	return p.isKeyword(p.currentToken()) && !(next.Type == TOKEN_SYMBOL && next.Value == "=")
+}
+
+// isAttributeName reports whether tok can be an attribute name
+func isAttributeName(tok Token) bool {
+	return tok.Type == TOKEN_IDENTIFIER
+}
+
+// isValue reports whether tok can be an attribute value
+func isValue(tok Token) bool {
+	switch tok.Type {
+	case TOKEN_IDENTIFIER, TOKEN_STRING, TOKEN_NUMBER, TOKEN_COLOR, TOKEN_DATE:
+		return true
+	}
+	return false
+}
+
+// color parses the value of attr as a colour into dst.
+// An invalid colour is reported and dst keeps its default.
+func (p *parser) color(attr Attribute, dst *Color) {
+	c, err := ParseColor(attr.Value)
+	if err != nil {
+		p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("%s: %v", attr.Key, err)})
+		return
+	}
+	*dst = c
+}
+
+// size parses the value of attr as the size of a node into dst,
+// a length that can be turned into pixels
+func (p *parser) size(attr Attribute, dst *Length) {
+	var l Length
+	p.length(attr, &l)
+	if l.Unit == "%" {
+		p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("%s: a node can not be a percentage wide or high", attr.Key)})
+		return
+	}
+	*dst = l
+}
+
+// length parses the value of attr as a number with optional unit into dst.
+// An invalid length is reported and dst keeps its default.
+func (p *parser) length(attr Attribute, dst *Length) {
+	l, err := ParseLength(attr.Value)
+	if err != nil {
+		p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("%s: %v", attr.Key, err)})
+		return
+	}
+	*dst = l
 }
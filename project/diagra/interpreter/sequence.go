@@ -0,0 +1,239 @@
+package interpreter
+
+import "fmt"
+
+// This file contains the parts of the parser and validator that are only
+// used for sequence diagrams:
+//
+//	diagram sequence {
+//		actor U "User"
+//		participant API "API"
+//		U -> API "request"          sync message
+//		API ->> Queue "event"       async message
+//		API --> U "response"        return message
+//		activate API
+//		deactivate API
+//		note over U, API "text"     also: note left of U, note right of U
+//		loop "every 5s" { ... }     also: opt "label" { ... }
+//		alt "ok" { ... } else "error" { ... }
+//	}
+
+// sequenceKeywords are the keywords that only make sense in sequence diagrams
+var sequenceKeywords = map[string]bool{
+	"participant": true,
+	"actor":       true,
+	"activate":    true,
+	"deactivate":  true,
+	"note":        true,
+	"loop":        true,
+	"alt":         true,
+	"else":        true,
+	"opt":         true,
+}
+
+// messageKinds maps the arrows to the kind of message
+var messageKinds = map[string]MessageKind{
+	"->":  MessageSync,
+	"->>": MessageAsync,
+	"-->": MessageReturn,
+}
+
+// addStep adds a step to the fragment section being parsed,
+// or to the diagram when not inside a fragment
+func (p *parser) addStep(d *Diagram, step Step) {
+	if p.steps != nil {
+		*p.steps = append(*p.steps, step)
+		return
+	}
+	d.Steps = append(d.Steps, step)
+}
+
+// parseSequenceStatement parses a statement that starts with a keyword
+// in a sequence diagram. Messages are parsed by parseEdge.
+func (p *parser) parseSequenceStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "participant", "actor":
+		return p.parseNode(d)
+	case "activate", "deactivate":
+		p.advance()
+		if p.currentToken().Type != TOKEN_IDENTIFIER {
+			p.fail(p.expected("participant", "expected participant after '"+tok.Value+"'"))
+			return false
+		}
+		kind := StepActivate
+		if tok.Value == "deactivate" {
+			kind = StepDeactivate
+		}
+		p.addStep(d, Step{Kind: kind, Participants: []string{p.reference()}, Pos: tok.Pos})
+		return true
+	case "note":
+		return p.parseNote(d)
+	case "loop", "alt", "opt":
+		return p.parseFragment(d)
+	case "else":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "'else' without 'alt'"})
+		return false
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'participant' or 'actor' instead of 'node' in sequence diagrams"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in sequence diagrams"})
+	return false
+}
+
+// parseNote parses: note over A "text", note over A, B "text",
+// note left of A "text" and note right of A "text"
+func (p *parser) parseNote(d *Diagram) bool {
+	step := Step{Kind: StepNote, Pos: p.currentToken().Pos}
+	p.advance() // note
+
+	placement := p.currentToken()
+	switch {
+	case placement.Type == TOKEN_IDENTIFIER && placement.Value == "over":
+		step.Placement = "over"
+		p.advance()
+	case placement.Type == TOKEN_IDENTIFIER && (placement.Value == "left" || placement.Value == "right"):
+		step.Placement = placement.Value
+		p.advance()
+		if p.currentToken().Type != TOKEN_IDENTIFIER || p.currentToken().Value != "of" {
+			p.fail(p.expected("'of'", "expected 'of' after 'note "+placement.Value+"'"))
+			return false
+		}
+		p.advance()
+	default:
+		p.fail(p.expected("'over', 'left of' or 'right of'", "expected note placement"))
+		return false
+	}
+
+	for {
+		if p.currentToken().Type != TOKEN_IDENTIFIER {
+			p.fail(p.expected("participant", "expected participant in note"))
+			return false
+		}
+		step.Participants = append(step.Participants, p.reference())
+		if step.Placement != "over" || !p.isSymbol(",") || len(step.Participants) == 2 {
+			break
+		}
+		p.advance() // ,
+	}
+
+	if p.currentToken().Type != TOKEN_STRING {
+		p.fail(p.expected("note text", "expected text for note"))
+		return false
+	}
+	step.Text = p.currentToken().Value
+	p.advance()
+
+	p.addStep(d, step)
+	return true
+}
+
+// parseFragment parses: loop "label" { ... }, opt "label" { ... }
+// and alt "label" { ... } else "label" { ... } with any number of else.
+func (p *parser) parseFragment(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	f := &Fragment{Kind: p.currentToken().Value}
+	p.advance()
+
+	for {
+		section, ok := p.parseSection(d, f.Kind)
+		if !ok {
+			return false
+		}
+		f.Sections = append(f.Sections, section)
+
+		next := p.currentToken()
+		if f.Kind != "alt" || !p.isKeyword(next) || next.Value != "else" {
+			break
+		}
+		p.advance() // else
+	}
+
+	p.addStep(d, Step{Kind: StepFragment, Fragment: f, Pos: pos})
+	return true
+}
+
+// parseSection parses the optional label and the { ... } block of one
+// section of a fragment
+func (p *parser) parseSection(d *Diagram, kind string) (Section, bool) {
+	section := Section{Pos: p.currentToken().Pos}
+	if p.currentToken().Type == TOKEN_STRING {
+		section.Label = p.currentToken().Value
+		p.advance()
+	}
+
+	if !p.match(TOKEN_LBRACE) {
+		p.fail(p.expected("'{'", "expected '{' after '"+kind+"'"))
+		return section, false
+	}
+
+	parent := p.steps
+	p.steps = &section.Steps
+	p.parseBlock(d)
+	p.steps = parent
+
+	if !p.match(TOKEN_RBRACE) {
+		p.fail(p.expected("'}'", "expected '}' at end of '"+kind+"'"))
+	}
+	return section, true
+}
+
+// validateSequence checks that every step refers to a declared participant
+// and that activations are balanced
+func validateSequence(d Diagram, nodes map[string]Node) Diagnostics {
+	var diags Diagnostics
+	active := map[string][]Position{}
+
+	check := func(id string, pos Position) {
+		if _, ok := nodes[id]; !ok {
+			diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf("undefined participant '%s'", id)})
+		}
+	}
+
+	var walk func(steps []Step)
+	walk = func(steps []Step) {
+		for _, s := range steps {
+			switch s.Kind {
+			case StepMessage:
+				check(s.Edge.From, s.Pos)
+				check(s.Edge.To, s.Pos)
+			case StepNote:
+				for _, id := range s.Participants {
+					check(id, s.Pos)
+				}
+			case StepActivate:
+				id := s.Participants[0]
+				check(id, s.Pos)
+				active[id] = append(active[id], s.Pos)
+			case StepDeactivate:
+				id := s.Participants[0]
+				check(id, s.Pos)
+				if len(active[id]) == 0 {
+					diags = append(diags, &Diagnostic{Pos: s.Pos, Message: fmt.Sprintf("deactivate '%s' without activate", id)})
+					continue
+				}
+				active[id] = active[id][:len(active[id])-1]
+			case StepFragment:
+				for _, section := range s.Fragment.Sections {
+					walk(section.Steps)
+				}
+			}
+		}
+	}
+	walk(d.Steps)
+
+	for _, n := range d.Nodes {
+		for _, pos := range active[n.ID] {
+			diags = append(diags, &Diagnostic{
+				Pos:      pos,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("'%s' is activated but never deactivated", n.ID),
+			})
+		}
+		delete(active, n.ID)
+	}
+	return diags
+}
@@ -0,0 +1,274 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains the parts of the parser and validator that are only
+// used for state diagrams:
+//
+//	diagram state {
+//		initial Start
+//		state Idle "Waiting"
+//		state Busy "Working" {          a composite state
+//			initial BusyStart
+//			state Loading
+//			BusyStart -> Loading
+//		}
+//		final End
+//		Start -> Idle
+//		Idle -> Busy "start [ready] / lock()"
+//		Idle -> Idle "tick"              a self-transition
+//		Busy -> End
+//	}
+//
+// Simple states are nodes and composite states are groups, so edges in a
+// state diagram may start or end at a group. Transition labels are parsed
+// into Edge.Transition.
+
+// stateKeywords are the keywords that only make sense in state diagrams
+var stateKeywords = map[string]bool{
+	"state":   true,
+	"initial": true,
+	"final":   true,
+}
+
+// Transition is a state transition label split into its parts:
+// event [guard] / action. Every part is optional.
+type Transition struct {
+	Event  string
+	Guard  string
+	Action string
+}
+
+// String returns the transition in the canonical "event [guard] / action" form
+func (t Transition) String() string {
+	var parts []string
+	if t.Event != "" {
+		parts = append(parts, t.Event)
+	}
+	if t.Guard != "" {
+		parts = append(parts, "["+t.Guard+"]")
+	}
+	if t.Action != "" {
+		parts = append(parts, "/ "+t.Action)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseTransition splits a transition label like "start [ready] / lock()"
+// into event, guard and action
+func ParseTransition(label string) (Transition, error) {
+	var t Transition
+	rest := strings.TrimSpace(label)
+
+	// The action is everything after the first '/' that is not in the guard
+	depth := 0
+	for i, r := range rest {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		if r == '/' && depth <= 0 {
+			t.Action = strings.TrimSpace(rest[i+1:])
+			rest = strings.TrimSpace(rest[:i])
+			if t.Action == "" {
+				return t, fmt.Errorf("missing action after '/' in transition '%s'", label)
+			}
+			break
+		}
+	}
+
+	if open := strings.Index(rest, "["); open >= 0 {
+		end := strings.LastIndex(rest, "]")
+		if end < open {
+			return t, fmt.Errorf("missing ']' in transition '%s'", label)
+		}
+		if strings.TrimSpace(rest[end+1:]) != "" {
+			return t, fmt.Errorf("unexpected text after the guard in transition '%s'", label)
+		}
+		t.Guard = strings.TrimSpace(rest[open+1 : end])
+		rest = strings.TrimSpace(rest[:open])
+	} else if strings.Contains(rest, "]") {
+		return t, fmt.Errorf("missing '[' in transition '%s'", label)
+	}
+
+	t.Event = rest
+	return t, nil
+}
+
+// parseStateStatement parses the statements that start with a keyword
+// in a state diagram. Transitions are parsed by parseEdge.
+func (p *parser) parseStateStatement(d *Diagram) bool {
+	tok := p.currentToken()
+
+	switch tok.Value {
+	case "state":
+		return p.parseState(d)
+	case "initial", "final":
+		p.advance()
+		if p.currentToken().Type != TOKEN_IDENTIFIER {
+			p.fail(p.expected(tok.Value+" state id", "expected id after '"+tok.Value+"'"))
+			return false
+		}
+		id := p.currentToken().Value
+		p.advance()
+		var attrs []Attribute
+		if p.isSymbol("(") {
+			attrs = p.parseAttributes("node")
+		}
+		p.addNode(d, p.newNode(id, "", tok.Value, attrs, tok.Pos))
+		return true
+	case "group":
+		return p.parseGroup(d)
+	case "node":
+		p.fail(&Diagnostic{Pos: tok.Pos, Message: "use 'state' instead of 'node' in state diagrams"})
+		return false
+	}
+
+	p.fail(&Diagnostic{Pos: tok.Pos, Message: "'" + tok.Value + "' is not allowed in state diagrams"})
+	return false
+}
+
+// parseState parses: state ID "Label" (attributes)
+// and the composite state: state ID "Label" (attributes) { statements }
+func (p *parser) parseState(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	p.advance() // state
+
+	if p.currentToken().Type != TOKEN_IDENTIFIER {
+		p.fail(p.expected("state id", "expected state id after 'state'"))
+		return false
+	}
+	id := p.currentToken().Value
+	p.advance()
+
+	label := id
+	if p.currentToken().Type == TOKEN_STRING {
+		label = p.currentToken().Value
+		p.advance()
+	}
+
+	var attrs []Attribute
+	if p.isSymbol("(") {
+		attrs = p.parseAttributes("state")
+	}
+
+	if p.match(TOKEN_LBRACE) {
+		p.parseGroupBlock(d, p.newGroup(id, label, attrs, pos))
+		return true
+	}
+	p.addNode(d, p.newNode(id, label, "rect", attrs, pos))
+	return true
+}
+
+// validateState checks a state diagram: transitions must not lead into an
+// initial state or out of a final state, there should be an initial state
+// and every state should be reachable from one
+func validateState(d Diagram, nodes map[string]Node) Diagnostics {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// members maps composite states to the states directly inside them
+	members := map[string][]string{}
+	var walk func(gs []Group)
+	walk = func(gs []Group) {
+		for _, g := range gs {
+			members[g.ID] = append(append([]string{}, g.Nodes...), groupIDs(g.Groups)...)
+			walk(g.Groups)
+		}
+	}
+	walk(d.Groups)
+
+	out := map[string][]string{}
+	for _, e := range d.Edges {
+		if n, ok := nodes[e.To]; ok && n.Shape == "initial" {
+			errorf(e.Pos, "transition into initial state '%s'", e.To)
+		}
+		if n, ok := nodes[e.From]; ok && n.Shape == "final" {
+			errorf(e.Pos, "transition out of final state '%s'", e.From)
+		}
+		if _, err := ParseTransition(e.Label); err != nil {
+			warnf(e.Pos, "%v", err)
+		}
+		out[e.From] = append(out[e.From], e.To)
+	}
+
+	var initials []string
+	for _, n := range d.Nodes {
+		if n.Shape == "initial" {
+			initials = append(initials, n.ID)
+		}
+	}
+	if len(initials) == 0 {
+		if len(d.Nodes) > 0 {
+			warnf(d.Nodes[0].Pos, "state diagram has no initial state")
+		}
+		return diags
+	}
+
+	// Reaching a composite state reaches everything inside it, and
+	// a transition from a composite state leaves from any state inside
+	reached := map[string]bool{}
+	var visit func(id string)
+	visit = func(id string) {
+		if reached[id] {
+			return
+		}
+		reached[id] = true
+		for _, m := range members[id] {
+			visit(m)
+		}
+		for _, to := range out[id] {
+			visit(to)
+		}
+	}
+	for _, id := range initials {
+		visit(id)
+	}
+	// Transitions out of a composite state count once any state inside is reached
+	for changed := true; changed; {
+		changed = false
+		for g, ms := range members {
+			if reached[g] {
+				continue
+			}
+			for _, m := range ms {
+				if reached[m] {
+					for _, to := range out[g] {
+						if !reached[to] {
+							visit(to)
+							changed = true
+						}
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for _, n := range d.Nodes {
+		if !reached[n.ID] {
+			warnf(n.Pos, "state '%s' can not be reached from an initial state", n.ID)
+			reached[n.ID] = true
+		}
+	}
+	return diags
+}
+
+// groupIDs returns the IDs of the groups
+func groupIDs(gs []Group) []string {
+	ids := make([]string, len(gs))
+	for i, g := range gs {
+		ids[i] = g.ID
+	}
+	return ids
+}
@@ -0,0 +1,213 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains style classes, named sets of attributes that can be
+// used by nodes, edges and groups instead of repeating the attributes:
+//
+//	diagram flowchart {
+//		style node (color=#fff3e0, border=#ef6c00)
+//		style warning (color=red, text=white)
+//
+//		node A "Start"
+//		node B "Fel" (class=warning)
+//		node C "Stopp" (class="warning round", border=black)
+//	}
+//
+// The style named node, edge or group is the default for every element of
+// that kind. The attributes are applied in this order, the last one wins:
+//
+//  1. the default colours of the diagram type
+//  2. the default style (style node, style edge, style group)
+//  3. the classes in the order they are listed in class=...
+//  4. the attributes written on the element itself
+//
+// Styles can be declared anywhere in the diagram, also after they are used.
+// The attributes mean what they mean on the element the style is applied
+// to, width is the width of the box for a node but the stroke width for
+// an edge, so style node (width=3) and style edge (width=3) differ a lot.
+
+// Style is a style class: style NAME (attributes)
+type Style struct {
+	Name  string
+	Attrs []Attribute
+	Pos   Position
+}
+
+// styleAttributes are the attributes a style can set,
+// each is only used by the kinds of elements that have it
+var styleAttributes = map[string]bool{"color": true, "text": true, "border": true, "shape": true, "width": true, "height": true}
+
+// parseStyle parses: style NAME (attributes)
+// The values are checked here, the style is applied by applyStyles.
+func (p *parser) parseStyle(d *Diagram) bool {
+	pos := p.currentToken().Pos
+	p.advance() // style
+
+	// node and group are keywords but also the names of the default styles
+	tok := p.currentToken()
+	if tok.Type != TOKEN_IDENTIFIER && !(tok.Type == TOKEN_KEYWORD && (tok.Value == "node" || tok.Value == "group")) {
+		p.fail(p.expected("style name", "expected style name after 'style'"))
+		return false
+	}
+	name := tok.Value
+	p.advance()
+
+	if !p.isSymbol("(") {
+		p.fail(p.expected("'('", "expected attributes for style "+name))
+		return false
+	}
+	attrs := p.parseAttributes("style")
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "color", "text", "border":
+			p.color(attr, new(Color))
+		case "width", "height":
+			p.length(attr, new(Length))
+		case "shape":
+			p.fixedShapes(attr)
+		}
+	}
+
+	d.Styles = append(d.Styles, Style{Name: name, Attrs: attrs, Pos: pos})
+	return true
+}
+
+// styleUse is a class name at the class attribute it is written in
+type styleUse struct {
+	pos  Position
+	name string
+}
+
+// applyStyles applies the default styles and the classes to every node,
+// edge and group once the whole diagram is parsed. A class that is not
+// declared is reported at the class attribute.
+func (p *parser) applyStyles(d *Diagram) {
+	// The first style with a name is used, Validate reports the others
+	styles := map[string]Style{}
+	for _, s := range d.Styles {
+		if _, ok := styles[s.Name]; !ok {
+			styles[s.Name] = s
+		}
+	}
+
+	// cascade returns the attributes of the default style and the classes,
+	// followed by the element's own attributes so they win
+	cascade := func(kind string, own []Attribute) []Attribute {
+		attrs := append([]Attribute{}, styles[kind].Attrs...)
+		for _, attr := range own {
+			if attr.Key != "class" {
+				continue
+			}
+			for _, name := range strings.Fields(attr.Value) {
+				s, ok := styles[name]
+				if !ok {
+					// Included elements are styled twice, report them once
+					if use := (styleUse{attr.Pos, name}); !p.undefined[use] {
+						p.undefined[use] = true
+						p.fail(&Diagnostic{Pos: attr.Pos, Message: fmt.Sprintf("undefined style '%s'", name)})
+					}
+					continue
+				}
+				attrs = append(attrs, s.Attrs...)
+			}
+		}
+		if len(attrs) == 0 {
+			return nil
+		}
+		return append(attrs, own...)
+	}
+
+	for i := range d.Nodes {
+		n := &d.Nodes[i]
+		p.inheritBranch(d, n)
+		for _, attr := range cascade("node", n.Attrs) {
+			switch attr.Key {
+			case "color":
+				setColor(attr, &n.Color)
+			case "text":
+				setColor(attr, &n.Text)
+			case "border":
+				setColor(attr, &n.Border)
+			case "shape":
+				// Shape is the kind of node when the type has keywords
+				if p.keywords == nil {
+					n.Shape = attr.Value
+				}
+			case "width":
+				setLength(attr, &n.Width)
+			case "height":
+				setLength(attr, &n.Height)
+			}
+		}
+	}
+
+	if d.Name == "mindmap" {
+		colorBranches(d)
+	}
+
+	styleEdge := func(e *Edge) {
+		for _, attr := range cascade("edge", e.Attrs) {
+			switch attr.Key {
+			case "color":
+				setColor(attr, &e.Color)
+			case "width":
+				setLength(attr, &e.Width)
+			}
+		}
+	}
+	for i := range d.Edges {
+		styleEdge(&d.Edges[i])
+	}
+	var styleSteps func(steps []Step)
+	styleSteps = func(steps []Step) {
+		for i := range steps {
+			switch steps[i].Kind {
+			case StepMessage:
+				styleEdge(&steps[i].Edge)
+			case StepFragment:
+				for _, s := range steps[i].Fragment.Sections {
+					styleSteps(s.Steps)
+				}
+			}
+		}
+	}
+	styleSteps(d.Steps)
+
+	var styleGroups func(gs []Group)
+	styleGroups = func(gs []Group) {
+		for i := range gs {
+			g := &gs[i]
+			for _, attr := range cascade("group", g.Attrs) {
+				switch attr.Key {
+				case "color":
+					setColor(attr, &g.Color)
+				case "text":
+					setColor(attr, &g.Text)
+				case "border":
+					setColor(attr, &g.Border)
+				}
+			}
+			styleGroups(g.Groups)
+		}
+	}
+	styleGroups(d.Groups)
+}
+
+// setColor sets dst to the colour in attr. Invalid colours have already
+// been reported where they were written, here they are just skipped.
+func setColor(attr Attribute, dst *Color) {
+	if c, err := ParseColor(attr.Value); err == nil {
+		*dst = c
+	}
+}
+
+// setLength is setColor for lengths
+func setLength(attr Attribute, dst *Length) {
+	if l, err := ParseLength(attr.Value); err == nil {
+		*dst = l
+	}
+}
@@ -0,0 +1,301 @@
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// This file contains the themes, the colours a diagram is drawn with.
+// The parser uses the theme for the default colours of nodes, edges and
+// groups, the renderer for everything else: the background, labels,
+// lines and the boxes of the other diagram types.
+//
+//	diagram flowchart (theme=dark) { ... }
+//
+// A theme can also be loaded from a file, one key = value per line:
+//
+//	# ocean.theme
+//	base = dark
+//	background = #002b36
+//	node_color = #073642
+//	branch = "#073642 #2aa198"
+//
+// Every key is a colour except name and base, which is the built-in
+// theme the file starts from (light if not given). Each branch line is
+// the fill and border of one mind map branch, background = none makes
+// the background transparent.
+
+// DefaultTheme is the theme used when the diagram does not name one
+const DefaultTheme = "light"
+
+// Theme is a named set of colours
+type Theme struct {
+	Name       string
+	Background Color // "" leaves the SVG transparent
+
+	NodeColor, NodeText, NodeBorder    Color
+	GroupColor, GroupBorder, GroupText Color
+
+	EdgeColor  Color // edges, arrowheads and edge labels
+	Text       Color // other text on the background and in boxes
+	Muted      Color // secondary text and lines: types, lifelines, the time axis
+	Grid       Color // light lines and fills: grid lines, row separators
+	Surface    Color // the body of tables, classes and participants
+	NoteColor  Color
+	NoteBorder Color
+	Accent     Color // things that must stand out, like today in a gantt diagram
+
+	Palette [][2]Color // fill and border of the branches of a mind map
+}
+
+// themes are the built-in themes and the ones added with RegisterTheme
+var themes = map[string]Theme{
+	"light": {
+		Name:      "light",
+		NodeColor: "#e0f7fa", NodeText: "#004d40", NodeBorder: "#00796b",
+		GroupColor: "#f5f5f5", GroupBorder: "#90a4ae", GroupText: "#37474f",
+		EdgeColor: "#37474f", Text: "#263238", Muted: "#607d8b", Grid: "#cfd8dc",
+		Surface: "#ffffff", NoteColor: "#fff9c4", NoteBorder: "#fbc02d", Accent: "#e53935",
+		Palette: [][2]Color{
+			{"#ffcdd2", "#c62828"}, // red
+			{"#c8e6c9", "#2e7d32"}, // green
+			{"#bbdefb", "#1565c0"}, // blue
+			{"#ffe0b2", "#ef6c00"}, // orange
+			{"#e1bee7", "#6a1b9a"}, // purple
+			{"#b2dfdb", "#00695c"}, // teal
+			{"#fff9c4", "#f9a825"}, // yellow
+			{"#d7ccc8", "#4e342e"}, // brown
+		},
+	},
+	"dark": {
+		Name:       "dark",
+		Background: "#263238",
+		NodeColor:  "#37474f", NodeText: "#e0f7fa", NodeBorder: "#4db6ac",
+		GroupColor: "#2e3c43", GroupBorder: "#546e7a", GroupText: "#cfd8dc",
+		EdgeColor: "#b0bec5", Text: "#eceff1", Muted: "#90a4ae", Grid: "#455a64",
+		Surface: "#2e3c43", NoteColor: "#4e342e", NoteBorder: "#ffb74d", Accent: "#ff8a80",
+		Palette: [][2]Color{
+			{"#5d2a2a", "#ef9a9a"},
+			{"#1b3d26", "#a5d6a7"},
+			{"#1a3550", "#90caf9"},
+			{"#4e3418", "#ffcc80"},
+			{"#3e2450", "#ce93d8"},
+			{"#16403b", "#80cbc4"},
+		},
+	},
+	"monochrome": {
+		Name:       "monochrome",
+		Background: "#ffffff",
+		NodeColor:  "#ffffff", NodeText: "#000000", NodeBorder: "#000000",
+		GroupColor: "#f5f5f5", GroupBorder: "#616161", GroupText: "#212121",
+		EdgeColor: "#212121", Text: "#000000", Muted: "#616161", Grid: "#bdbdbd",
+		Surface: "#ffffff", NoteColor: "#eeeeee", NoteBorder: "#616161", Accent: "#000000",
+		Palette: [][2]Color{
+			{"#f5f5f5", "#212121"},
+			{"#e0e0e0", "#212121"},
+			{"#bdbdbd", "#000000"},
+		},
+	},
+	"high_contrast": {
+		Name:       "high_contrast",
+		Background: "#000000",
+		NodeColor:  "#000000", NodeText: "#ffffff", NodeBorder: "#ffff00",
+		GroupColor: "#000000", GroupBorder: "#00ffff", GroupText: "#ffffff",
+		EdgeColor: "#ffffff", Text: "#ffffff", Muted: "#00ffff", Grid: "#808080",
+		Surface: "#000000", NoteColor: "#000000", NoteBorder: "#ffff00", Accent: "#ff00ff",
+		Palette: [][2]Color{
+			{"#000000", "#ffff00"},
+			{"#000000", "#00ffff"},
+			{"#000000", "#ff00ff"},
+			{"#000000", "#00ff00"},
+		},
+	},
+	// The Okabe-Ito colours, that can be told apart with every kind of colour blindness
+	"colorblind": {
+		Name:      "colorblind",
+		NodeColor: "#e8f4fb", NodeText: "#003f5c", NodeBorder: "#0072b2",
+		GroupColor: "#f5f5f5", GroupBorder: "#999999", GroupText: "#333333",
+		EdgeColor: "#333333", Text: "#222222", Muted: "#666666", Grid: "#cccccc",
+		Surface: "#ffffff", NoteColor: "#fdf1d6", NoteBorder: "#e69f00", Accent: "#d55e00",
+		Palette: [][2]Color{
+			{"#fbe3cc", "#e69f00"}, // orange
+			{"#d6ecf8", "#56b4e9"}, // sky blue
+			{"#cce9e0", "#009e73"}, // bluish green
+			{"#fdf8cc", "#f0e442"}, // yellow
+			{"#cce3f0", "#0072b2"}, // blue
+			{"#f6dccc", "#d55e00"}, // vermillion
+			{"#f3e2ec", "#cc79a7"}, // reddish purple
+		},
+	},
+}
+
+// themeName normalises a theme name, high-contrast is high_contrast
+func themeName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+}
+
+// LookupTheme returns the theme with the given name
+func LookupTheme(name string) (Theme, bool) {
+	t, ok := themes[themeName(name)]
+	return t, ok
+}
+
+// ThemeNames returns the names of all themes in alphabetical order
+func ThemeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterTheme makes a theme available by its name, replacing any
+// theme with the same name. It is meant to be called before parsing.
+func RegisterTheme(t Theme) {
+	t.Name = themeName(t.Name)
+	themes[t.Name] = t
+}
+
+// colors returns the colour keys of a theme file and the fields they set
+func (t *Theme) colors() map[string]*Color {
+	return map[string]*Color{
+		"background":   &t.Background,
+		"node_color":   &t.NodeColor,
+		"node_text":    &t.NodeText,
+		"node_border":  &t.NodeBorder,
+		"group_color":  &t.GroupColor,
+		"group_border": &t.GroupBorder,
+		"group_text":   &t.GroupText,
+		"edge_color":   &t.EdgeColor,
+		"text":         &t.Text,
+		"muted":        &t.Muted,
+		"grid":         &t.Grid,
+		"surface":      &t.Surface,
+		"note_color":   &t.NoteColor,
+		"note_border":  &t.NoteBorder,
+		"accent":       &t.Accent,
+	}
+}
+
+// LoadTheme reads a theme file. The name of the theme is the name key,
+// or the file name without its extension. A file with problems gives an
+// error that is a Diagnostics.
+func LoadTheme(path string) (Theme, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return Theme{}, fmt.Errorf("could not read theme: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	t, diags := ParseTheme(string(src), name)
+	if diags.HasErrors() {
+		AttachSource(diags, string(src))
+		return t, diags
+	}
+	return t, nil
+}
+
+// ParseTheme parses the source of a theme file, name is used when the
+// file has no name key. Every line is key = value, the value of a
+// branch line is a string with two colours.
+func ParseTheme(src, name string) (Theme, Diagnostics) {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Group the tokens by line, a line is one setting
+	var lines [][]Token
+	last := 0
+	for _, tok := range Lex(src) {
+		switch {
+		case tok.Type == TOKEN_EOF:
+			continue
+		case tok.Type == TOKEN_ILLEGAL:
+			errorf(tok.Pos, "%s", tok.Value)
+			continue
+		case tok.Pos.Line != last:
+			lines = append(lines, nil)
+			last = tok.Pos.Line
+		}
+		lines[len(lines)-1] = append(lines[len(lines)-1], tok)
+	}
+
+	// The base theme first, the other keys change it
+	base := DefaultTheme
+	for _, line := range lines {
+		if len(line) == 3 && line[0].Value == "base" {
+			base = line[2].Value
+			if _, ok := LookupTheme(base); !ok {
+				errorf(line[2].Pos, "unknown base theme '%s'", base)
+				base = DefaultTheme
+			}
+		}
+	}
+	t, _ := LookupTheme(base)
+	t.Name = name
+	colors := t.colors()
+	var palette [][2]Color
+
+	seen := map[string]bool{}
+	for _, line := range lines {
+		key := line[0]
+		if key.Type != TOKEN_IDENTIFIER || len(line) < 3 || line[1].Value != "=" {
+			errorf(key.Pos, "expected key = value")
+			continue
+		}
+		values := line[2:]
+		if key.Value != "branch" {
+			if seen[key.Value] {
+				errorf(key.Pos, "'%s' is set more than once", key.Value)
+			}
+			seen[key.Value] = true
+		}
+
+		switch {
+		case key.Value == "name":
+			t.Name = values[0].Value
+		case key.Value == "base":
+			// already used
+		case key.Value == "branch":
+			parts := strings.Fields(values[0].Value)
+			if len(parts) != 2 {
+				errorf(values[0].Pos, "branch needs two colours, the fill and the border")
+				continue
+			}
+			fill, err := ParseColor(parts[0])
+			if err != nil {
+				errorf(values[0].Pos, "branch: %v", err)
+				continue
+			}
+			border, err := ParseColor(parts[1])
+			if err != nil {
+				errorf(values[0].Pos, "branch: %v", err)
+				continue
+			}
+			palette = append(palette, [2]Color{fill, border})
+		case key.Value == "background" && values[0].Value == "none":
+			t.Background = ""
+		case colors[key.Value] != nil:
+			c, err := ParseColor(values[0].Value)
+			if err != nil {
+				errorf(values[0].Pos, "%s: %v", key.Value, err)
+				continue
+			}
+			*colors[key.Value] = c
+		default:
+			errorf(key.Pos, "unknown theme key '%s'", key.Value)
+			continue
+		}
+		if len(values) > 1 {
+			errorf(values[1].Pos, "expected one value for '%s'", key.Value)
+		}
+	}
+	if len(palette) > 0 {
+		t.Palette = palette
+	}
+	return t, diags
+}
@@ -12,43 +12,193 @@ const (
 	TOKEN_KEYWORD    TokenType = "KEYWORD"
 	TOKEN_IDENTIFIER TokenType = "IDENTIFIER"
 	TOKEN_STRING     TokenType = "STRING"
+	TOKEN_NUMBER     TokenType = "NUMBER"
+	TOKEN_COLOR      TokenType = "COLOR"
+	TOKEN_DATE       TokenType = "DATE" // 2024-03-15
 	TOKEN_ARROW      TokenType = "ARROW"
 	TOKEN_LBRACE     TokenType = "LBRACE"
 	TOKEN_RBRACE     TokenType = "RBRACE"
 	TOKEN_SYMBOL     TokenType = "SYMBOL"
+	TOKEN_ILLEGAL    TokenType = "ILLEGAL" // Value is the error message
 	TOKEN_EOF        TokenType = "EOF"
 )
 
+// Token is a single lexical unit. Pos points at the first character
+// of the token in the source. Comments holds the comments that came
+// right before the token, so tools like a formatter can keep them.
 type Token struct {
-	Type  TokenType
+	Type     TokenType
+	Value    string
+	Pos      Position
+	Comments []Comment
+}
+
+// Comment is a comment found by the lexer.
+// Text is the comment exactly as written, including the # // or /* */.
+// Trailing is true when the comment is on the same line as the token before it.
+type Comment struct {
+	Text     string
+	Pos      Position
+	Block    bool
+	Trailing bool
+}
+
+// Attribute is one key=value pair from an attribute list like (color=red)
+type Attribute struct {
+	Key   string
 	Value string
+	Pos   Position
 }
 
+// Diagram is the parsed diagram. Attrs, and Attrs on Node and Edge,
+// keep the attributes exactly as written so Validate can check them.
 type Diagram struct {
-	Name   string
-	Layout string
-	Nodes  []Node
-	Edges  []Edge
+	Name     string
+	Layout   string
+	Attrs    []Attribute
+	Nodes    []Node
+	Edges    []Edge
+	Groups   []Group
+	Steps    []Step    // sequence diagrams: messages, notes etc. in order
+	Styles   []Style   // style classes, already applied to the nodes, edges and groups
+	Includes []Include // the include statements, what they include is already in the diagram
+	Theme    Theme     // the colours, already used as the defaults of nodes, edges and groups
 }
 
 type Node struct {
+	ID       string
+	Label    string
+	Color    Color
+	Text     Color
+	Shape    string
+	Border   Color
+	Width    Length // the width and height attributes, not set means the size of the label
+	Height   Length
+	Columns  []Column // ER diagrams: the columns of an entity
+	Members  []Member // class diagrams: the fields and methods of a class
+	Task     *Task    // gantt diagrams: the schedule of a task or milestone
+	Attrs    []Attribute
+	Implicit bool // created because an edge uses it, not declared
+	Pos      Position
+}
+
+type Edge struct {
+	From     string
+	FromPort string      // ER diagrams: the column the edge starts at, "" for the entity
+	FromCard Cardinality // ER diagrams: the cardinality at the From end
+	To       string
+	ToPort   string
+	ToCard   Cardinality
+	Kind     EdgeKind // the arrow: directed, undirected, dashed, ...
+	Relation Relation // class diagrams: inheritance, composition, ...
+	// class diagrams: the multiplicities written at the ends, like "1" and "*"
+	FromMultiplicity string
+	ToMultiplicity   string
+	Label            string
+	Color            Color
+	Width            Length
+	Transition       Transition // state diagrams: the label split into event [guard] / action
+	Attrs            []Attribute
+	Pos              Position
+}
+
+// Group is a labelled region of the diagram, like "frontend" or "database".
+// Nodes holds the IDs of the nodes declared directly in the group and
+// Groups the nested groups, the nodes themselves are in Diagram.Nodes.
+type Group struct {
 	ID     string
 	Label  string
-	Color  string
-	Text   string
-	Shape  string
-	Border string
+	Color  Color
+	Border Color
+	Text   Color
+	Nodes  []string
+	Groups []Group
+	Attrs  []Attribute
+	Pos    Position
 }
 
-type Edge struct {
-	From  string
-	To    string
+// EdgeKind is the kind of an edge, given by the arrow.
+// "" is drawn like EdgeDirected.
+type EdgeKind string
+
+const (
+	EdgeDirected      EdgeKind = "directed"      // ->
+	EdgeUndirected    EdgeKind = "undirected"    // --
+	EdgeBidirectional EdgeKind = "bidirectional" // <->
+	EdgeDashed        EdgeKind = "dashed"        // -->
+	EdgeDotted        EdgeKind = "dotted"        // ..>
+	EdgeThick         EdgeKind = "thick"         // ==>
+)
+
+// edgeKinds maps the arrows to the kind of edge
+var edgeKinds = map[string]EdgeKind{
+	"->":  EdgeDirected,
+	"--":  EdgeUndirected,
+	"<->": EdgeBidirectional,
+	"-->": EdgeDashed,
+	"..>": EdgeDotted,
+	"==>": EdgeThick,
+}
+
+// MessageKind is the kind of a message in a sequence diagram,
+// given by the arrow: -> sync, ->> async and --> return
+type MessageKind string
+
+const (
+	MessageSync   MessageKind = "sync"
+	MessageAsync  MessageKind = "async"
+	MessageReturn MessageKind = "return"
+)
+
+// StepKind tells what a Step in a sequence diagram is
+type StepKind string
+
+const (
+	StepMessage    StepKind = "message"
+	StepActivate   StepKind = "activate"
+	StepDeactivate StepKind = "deactivate"
+	StepNote       StepKind = "note"
+	StepFragment   StepKind = "fragment"
+)
+
+// Step is one statement of a sequence diagram, in the order written.
+// Which fields are used depends on Kind:
+//   - StepMessage: Message and Edge (From, To, Label, Color, Width)
+//   - StepActivate, StepDeactivate: Participants[0]
+//   - StepNote: Participants (one, or two for "over A, B"), Placement and Text
+//   - StepFragment: Fragment
+type Step struct {
+	Kind         StepKind
+	Message      MessageKind
+	Edge         Edge
+	Participants []string
+	Placement    string // "over", "left" or "right"
+	Text         string
+	Fragment     *Fragment
+	Pos          Position
+}
+
+// Fragment is a loop, alt or opt block in a sequence diagram.
+// An alt has one section per branch (the first one and each else).
+type Fragment struct {
+	Kind     string
+	Sections []Section
+}
+
+// Section is one branch of a Fragment
+type Section struct {
 	Label string
-	Color string
-	Width string
+	Steps []Step
+	Pos   Position
 }
 
 var allowedTypes = map[string]bool{
 	"flowchart": true,
 	"tree":      true,
+	"sequence":  true,
+	"state":     true,
+	"er":        true,
+	"class":     true,
+	"gantt":     true,
+	"mindmap":   true,
 }
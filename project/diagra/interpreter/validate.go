@@ -0,0 +1,311 @@
+package interpreter
+
+import (
+	"fmt"
+)
+
+// Known attribute keys per context, anything else gives a warning
+var (
+	diagramAttributes = map[string]bool{"layout": true, "theme": true}
+	nodeAttributes    = map[string]bool{"color": true, "text": true, "shape": true, "border": true, "width": true, "height": true, "class": true}
+	edgeAttributes    = map[string]bool{"color": true, "width": true, "class": true}
+	groupAttributes   = map[string]bool{"color": true, "border": true, "text": true, "class": true}
+)
+
+// knownShapes are the node shapes the renderer can draw
+var knownShapes = map[string]bool{
+	"rect":          true,
+	"ellipse":       true,
+	"circle":        true,
+	"diamond":       true,
+	"parallelogram": true,
+	"hexagon":       true,
+	"cylinder":      true,
+	"note":          true,
+	"participant":   true,
+	"actor":         true,
+	"initial":       true,
+	"final":         true,
+	"entity":        true,
+	"class":         true,
+	"interface":     true,
+	"task":          true,
+	"milestone":     true,
+	"topic":         true,
+}
+
+// Validate checks that a parsed diagram makes sense.
+// Parse only checks the syntax, Validate checks the meaning:
+// edges must point to declared nodes, node IDs must be unique,
+// a tree must have one root and no cycles, and so on.
+// It should run between Parse and RenderSVG.
+func Validate(d Diagram) Diagnostics {
+	var diags Diagnostics
+	errorf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(pos Position, format string, args ...any) {
+		diags = append(diags, &Diagnostic{Pos: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Unique node IDs
+	nodes := map[string]Node{}
+	for _, n := range d.Nodes {
+		if first, ok := nodes[n.ID]; ok {
+			errorf(n.Pos, "duplicate node id '%s', first declared at %s", n.ID, first.Pos)
+			continue
+		}
+		nodes[n.ID] = n
+	}
+
+	// Edges must point to declared nodes,
+	// in state diagrams they can also point to composite states (groups)
+	endpoint := func(id string) bool {
+		if _, ok := nodes[id]; ok {
+			return true
+		}
+		return d.Name == "state" && findGroup(d.Groups, id) != nil
+	}
+	for _, e := range d.Edges {
+		if !endpoint(e.From) {
+			errorf(e.Pos, "edge from undefined node '%s'", e.From)
+		}
+		if !endpoint(e.To) {
+			errorf(e.Pos, "edge to undefined node '%s'", e.To)
+		}
+	}
+
+	// Attributes and shapes
+	checkAttributes := func(attrs []Attribute, known map[string]bool, context string) {
+		for _, attr := range attrs {
+			if !known[attr.Key] {
+				warnf(attr.Pos, "unknown %s attribute '%s'", context, attr.Key)
+			}
+		}
+	}
+	if d.Name == "gantt" {
+		checkAttributes(d.Attrs, ganttDiagramAttributes, "diagram")
+	} else {
+		checkAttributes(d.Attrs, diagramAttributes, "diagram")
+	}
+	for _, n := range d.Nodes {
+		if n.Task != nil {
+			checkAttributes(n.Attrs, ganttTaskAttributes, n.Shape)
+		} else {
+			checkAttributes(n.Attrs, nodeAttributes, "node")
+		}
+		if !knownShapes[n.Shape] {
+			warnf(n.Pos, "unknown shape '%s' for node '%s', drawn as rect", n.Shape, n.ID)
+		}
+	}
+	for _, e := range d.Edges {
+		switch d.Name {
+		case "er":
+			checkAttributes(e.Attrs, erEdgeAttributes, "relationship")
+			continue
+		case "class":
+			checkAttributes(e.Attrs, classEdgeAttributes, "relationship")
+			continue
+		}
+		checkAttributes(e.Attrs, edgeAttributes, "edge")
+	}
+
+	// Groups: unique IDs that do not clash with nodes, and not empty
+	groups := map[string]Position{}
+	var checkGroups func(gs []Group)
+	checkGroups = func(gs []Group) {
+		for _, g := range gs {
+			if first, ok := groups[g.ID]; ok {
+				errorf(g.Pos, "duplicate group id '%s', first declared at %s", g.ID, first)
+			} else if n, ok := nodes[g.ID]; ok {
+				errorf(g.Pos, "group id '%s' is already used by the node at %s", g.ID, n.Pos)
+			}
+			groups[g.ID] = g.Pos
+			if len(g.Nodes) == 0 && len(g.Groups) == 0 {
+				warnf(g.Pos, "group '%s' is empty", g.ID)
+			}
+			checkAttributes(g.Attrs, groupAttributes, "group")
+			checkGroups(g.Groups)
+		}
+	}
+	checkGroups(d.Groups)
+
+	// Styles: unique names and attributes a style can set
+	styles := map[string]Position{}
+	for _, s := range d.Styles {
+		// A style of the diagram may replace one from an included file
+		if first, ok := styles[s.Name]; ok && first.File == s.Pos.File {
+			errorf(s.Pos, "duplicate style '%s', first declared at %s", s.Name, first)
+		}
+		styles[s.Name] = s.Pos
+		checkAttributes(s.Attrs, styleAttributes, "style")
+	}
+
+	switch d.Name {
+	case "sequence":
+		diags = append(diags, validateSequence(d, nodes)...)
+	case "state":
+		diags = append(diags, validateState(d, nodes)...)
+	case "er":
+		diags = append(diags, validateER(d, nodes)...)
+	case "class":
+		diags = append(diags, validateClass(d, nodes)...)
+	case "gantt":
+		diags = append(diags, validateGantt(d)...)
+	default:
+		diags = append(diags, validateStructure(d, nodes)...)
+	}
+
+	sortDiagnostics(diags)
+	return diags
+}
+
+// validateStructure checks the shape of the graph: nodes that can not be
+// reached, and for trees and mind maps a single root, one parent per node
+// and no cycles.
+func validateStructure(d Diagram, nodes map[string]Node) Diagnostics {
+	var diags Diagnostics
+	if len(d.Nodes) == 0 {
+		return diags
+	}
+
+	children := map[string][]string{}
+	parents := map[string][]Edge{}
+	for _, e := range d.Edges {
+		if _, ok := nodes[e.From]; !ok {
+			continue
+		}
+		if _, ok := nodes[e.To]; !ok {
+			continue
+		}
+		children[e.From] = append(children[e.From], e.To)
+		parents[e.To] = append(parents[e.To], e)
+	}
+
+	// Roots are nodes that no edge points to, in declaration order
+	var roots []string
+	for _, n := range d.Nodes {
+		if len(parents[n.ID]) == 0 && nodes[n.ID].Pos == n.Pos {
+			roots = append(roots, n.ID)
+		}
+	}
+
+	tree := d.Name == "tree" || d.Name == "mindmap"
+	if tree {
+		switch {
+		case len(roots) == 0:
+			diags = append(diags, &Diagnostic{Pos: d.Nodes[0].Pos, Message: d.Name + " has no root, every node has a parent"})
+		case len(roots) > 1:
+			for _, id := range roots[1:] {
+				diags = append(diags, &Diagnostic{
+					Pos:     nodes[id].Pos,
+					Message: fmt.Sprintf("%s has more than one root: '%s' and '%s'", d.Name, roots[0], id),
+				})
+			}
+		}
+		for _, n := range d.Nodes {
+			if ps := parents[n.ID]; len(ps) > 1 {
+				diags = append(diags, &Diagnostic{
+					Pos:     ps[1].Pos,
+					Message: fmt.Sprintf("node '%s' has more than one parent in a %s", n.ID, d.Name),
+				})
+			}
+		}
+		for _, e := range cycleEdges(d, children) {
+			diags = append(diags, &Diagnostic{
+				Pos:     e.Pos,
+				Message: fmt.Sprintf("edge %s -> %s creates a cycle in a %s", e.From, e.To, d.Name),
+			})
+		}
+		if len(roots) > 0 {
+			roots = roots[:1]
+		}
+	}
+
+	// A flowchart where every node is part of a cycle has no natural start
+	if len(roots) == 0 {
+		return diags
+	}
+
+	reached := map[string]bool{}
+	var visit func(id string)
+	visit = func(id string) {
+		if reached[id] {
+			return
+		}
+		reached[id] = true
+		for _, c := range children[id] {
+			visit(c)
+		}
+	}
+	for _, id := range roots {
+		visit(id)
+	}
+	from := "any start node"
+	if tree {
+		from = "root '" + roots[0] + "'"
+	}
+	for _, n := range d.Nodes {
+		if !reached[n.ID] {
+			diags = append(diags, &Diagnostic{
+				Pos:      n.Pos,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("node '%s' can not be reached from %s", n.ID, from),
+			})
+			reached[n.ID] = true // only once per duplicate id
+		}
+	}
+	return diags
+}
+
+// findGroup returns the group with the given ID, searching nested groups too
+func findGroup(gs []Group, id string) *Group {
+	for i := range gs {
+		if gs[i].ID == id {
+			return &gs[i]
+		}
+		if g := findGroup(gs[i].Groups, id); g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+// cycleEdges returns the edges that close a cycle, found with a depth first search
+func cycleEdges(d Diagram, children map[string][]string) []Edge {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := map[string]int{}
+	closing := map[[2]string]bool{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = active
+		for _, c := range children[id] {
+			switch state[c] {
+			case unvisited:
+				visit(c)
+			case active:
+				closing[[2]string{id, c}] = true
+			}
+		}
+		state[id] = done
+	}
+	for _, n := range d.Nodes {
+		if state[n.ID] == unvisited {
+			visit(n.ID)
+		}
+	}
+
+	var edges []Edge
+	for _, e := range d.Edges {
+		if closing[[2]string{e.From, e.To}] {
+			edges = append(edges, e)
+			delete(closing, [2]string{e.From, e.To})
+		}
+	}
+	return edges
+}
@@ -0,0 +1,239 @@
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Color is a validated CSS colour. It is either a hex value (#rgb, #rgba,
+// #rrggbb, #rrggbbaa), rgb()/rgba() or one of the named CSS colours.
+// It is stored normalised (lower case) and can be written straight into SVG.
+type Color string
+
+// Length is a number with an optional unit, like 2, 2.5 or 3px
+type Length struct {
+	Value float64
+	Unit  string
+}
+
+// String returns the length the way it is written in SVG, "2.5px"
+func (l Length) String() string {
+	return strconv.FormatFloat(l.Value, 'f', -1, 64) + l.Unit
+}
+
+// Pixels returns the length in pixels, with 14px to the em like the
+// labels. It is false for a length that is not set and for percentages,
+// which have nothing to be a percentage of.
+func (l Length) Pixels() (float64, bool) {
+	factors := map[string]float64{
+		"": 1, "px": 1, "pt": 4.0 / 3, "em": 14, "rem": 14,
+		"mm": 96 / 25.4, "cm": 96 / 2.54, "in": 96,
+	}
+	factor, ok := factors[l.Unit]
+	if !ok || l.Value <= 0 {
+		return 0, false
+	}
+	return l.Value * factor, true
+}
+
+// lengthUnits are the units a Length can have, "" means no unit
+var lengthUnits = map[string]bool{
+	"": true, "px": true, "pt": true, "em": true, "rem": true,
+	"mm": true, "cm": true, "in": true, "%": true,
+}
+
+// ParseColor checks that s is a colour and returns it normalised
+func ParseColor(s string) (Color, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case strings.HasPrefix(value, "#"):
+		hex := value[1:]
+		switch len(hex) {
+		case 3, 4, 6, 8:
+		default:
+			return "", fmt.Errorf("invalid hex colour '%s', expected 3, 4, 6 or 8 hex digits", s)
+		}
+		for _, r := range hex {
+			if !isHexDigit(r) {
+				return "", fmt.Errorf("invalid hex colour '%s'", s)
+			}
+		}
+		return Color(value), nil
+
+	case strings.HasPrefix(value, "rgb"):
+		return parseRGB(s, value)
+
+	case namedColors[value]:
+		return Color(value), nil
+	}
+	return "", fmt.Errorf("unknown colour '%s'", s)
+}
+
+// parseRGB parses rgb(r, g, b) and rgba(r, g, b, a).
+// The colour channels are 0-255 or percentages, alpha is 0-1.
+func parseRGB(original, value string) (Color, error) {
+	name, args, ok := strings.Cut(value, "(")
+	if !ok || !strings.HasSuffix(args, ")") || (name != "rgb" && name != "rgba") {
+		return "", fmt.Errorf("invalid colour '%s', expected rgb(r, g, b) or rgba(r, g, b, a)", original)
+	}
+	parts := strings.Split(strings.TrimSuffix(args, ")"), ",")
+	want := 3
+	if name == "rgba" {
+		want = 4
+	}
+	if len(parts) != want {
+		return "", fmt.Errorf("%s() takes %d values, got %d in '%s'", name, want, len(parts), original)
+	}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		parts[i] = part
+		limit := 255.0
+		number := part
+		if i == 3 {
+			limit = 1
+		} else if strings.HasSuffix(part, "%") {
+			limit = 100
+			number = strings.TrimSuffix(part, "%")
+		}
+		v, err := strconv.ParseFloat(number, 64)
+		if err != nil || v < 0 || v > limit {
+			return "", fmt.Errorf("invalid value '%s' in '%s'", part, original)
+		}
+	}
+	return Color(name + "(" + strings.Join(parts, ", ") + ")"), nil
+}
+
+// Hex returns a hex or rgb() colour as #rrggbb, or #rrggbbaa when it has
+// alpha, for formats that only read those. Named colours are returned as
+// they are.
+func (c Color) Hex() string {
+	s := string(c)
+	switch {
+	case strings.HasPrefix(s, "#"):
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var long strings.Builder
+			for _, r := range hex {
+				long.WriteString(strings.Repeat(string(r), 2))
+			}
+			hex = long.String()
+		}
+		return "#" + hex
+	case strings.HasPrefix(s, "rgb"):
+		_, args, _ := strings.Cut(strings.TrimSuffix(s, ")"), "(")
+		hex := "#"
+		for i, part := range strings.Split(args, ",") {
+			part = strings.TrimSpace(part)
+			v, _ := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+			switch {
+			case i == 3:
+				v *= 255
+			case strings.HasSuffix(part, "%"):
+				v = v * 255 / 100
+			}
+			hex += fmt.Sprintf("%02x", int(v+0.5))
+		}
+		return hex
+	}
+	return s
+}
+
+// ParseLength parses a number with an optional unit, like 2, 2.5 or 3px
+func ParseLength(s string) (Length, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return Length{}, fmt.Errorf("invalid number '%s'", s)
+	}
+	unit := strings.ToLower(s[i:])
+	if !lengthUnits[unit] {
+		return Length{}, fmt.Errorf("unknown unit '%s' in '%s'", unit, s)
+	}
+	return Length{Value: value, Unit: unit}, nil
+}
+
+// DateLayout is how dates are written, ISO 8601: 2024-03-15
+const DateLayout = "2006-01-02"
+
+// ParseDate parses an ISO date like 2024-03-15
+func ParseDate(s string) (time.Time, error) {
+	t, err := time.Parse(DateLayout, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// ParseDays parses a duration in whole days: 5d, 2w or just 5
+func ParseDays(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	switch strings.ToLower(s[i:]) {
+	case "", "d":
+		return n, nil
+	case "w":
+		return n * 7, nil
+	}
+	return 0, fmt.Errorf("unknown unit '%s' in duration '%s', expected d or w", s[i:], s)
+}
+
+func isHexDigit(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F'
+}
+
+// namedColors are the named colours from CSS Color Module Level 4
+var namedColors = map[string]bool{
+	"aliceblue": true, "antiquewhite": true, "aqua": true, "aquamarine": true,
+	"azure": true, "beige": true, "bisque": true, "black": true,
+	"blanchedalmond": true, "blue": true, "blueviolet": true, "brown": true,
+	"burlywood": true, "cadetblue": true, "chartreuse": true, "chocolate": true,
+	"coral": true, "cornflowerblue": true, "cornsilk": true, "crimson": true,
+	"cyan": true, "darkblue": true, "darkcyan": true, "darkgoldenrod": true,
+	"darkgray": true, "darkgreen": true, "darkgrey": true, "darkkhaki": true,
+	"darkmagenta": true, "darkolivegreen": true, "darkorange": true, "darkorchid": true,
+	"darkred": true, "darksalmon": true, "darkseagreen": true, "darkslateblue": true,
+	"darkslategray": true, "darkslategrey": true, "darkturquoise": true, "darkviolet": true,
+	"deeppink": true, "deepskyblue": true, "dimgray": true, "dimgrey": true,
+	"dodgerblue": true, "firebrick": true, "floralwhite": true, "forestgreen": true,
+	"fuchsia": true, "gainsboro": true, "ghostwhite": true, "gold": true,
+	"goldenrod": true, "gray": true, "green": true, "greenyellow": true,
+	"grey": true, "honeydew": true, "hotpink": true, "indianred": true,
+	"indigo": true, "ivory": true, "khaki": true, "lavender": true,
+	"lavenderblush": true, "lawngreen": true, "lemonchiffon": true, "lightblue": true,
+	"lightcoral": true, "lightcyan": true, "lightgoldenrodyellow": true, "lightgray": true,
+	"lightgreen": true, "lightgrey": true, "lightpink": true, "lightsalmon": true,
+	"lightseagreen": true, "lightskyblue": true, "lightslategray": true, "lightslategrey": true,
+	"lightsteelblue": true, "lightyellow": true, "lime": true, "limegreen": true,
+	"linen": true, "magenta": true, "maroon": true, "mediumaquamarine": true,
+	"mediumblue": true, "mediumorchid": true, "mediumpurple": true, "mediumseagreen": true,
+	"mediumslateblue": true, "mediumspringgreen": true, "mediumturquoise": true, "mediumvioletred": true,
+	"midnightblue": true, "mintcream": true, "mistyrose": true, "moccasin": true,
+	"navajowhite": true, "navy": true, "oldlace": true, "olive": true,
+	"olivedrab": true, "orange": true, "orangered": true, "orchid": true,
+	"palegoldenrod": true, "palegreen": true, "paleturquoise": true, "palevioletred": true,
+	"papayawhip": true, "peachpuff": true, "peru": true, "pink": true,
+	"plum": true, "powderblue": true, "purple": true, "rebeccapurple": true,
+	"red": true, "rosybrown": true, "royalblue": true, "saddlebrown": true,
+	"salmon": true, "sandybrown": true, "seagreen": true, "seashell": true,
+	"sienna": true, "silver": true, "skyblue": true, "slateblue": true,
+	"slategray": true, "slategrey": true, "snow": true, "springgreen": true,
+	"steelblue": true, "tan": true, "teal": true, "thistle": true,
+	"tomato": true, "transparent": true, "turquoise": true, "violet": true,
+	"wheat": true, "white": true, "whitesmoke": true, "yellow": true,
+	"yellowgreen": true,
+}
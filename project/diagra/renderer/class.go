@@ -0,0 +1,278 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout and SVG for class diagrams.
+// Classes are boxes with three compartments: the name, the fields and
+// the methods. They are placed in layers with parents above children and
+// the whole above its parts, the relationships get the UML arrowheads.
+
+// Class layout constants
+const (
+	classHeaderH   = 30  // height of the class name
+	classStereoH   = 14  // extra height for «interface»
+	classRowH      = 18  // height of a member row
+	classPadding   = 5   // space above and below the members of a compartment
+	classCharW     = 7   // estimated width of a character in a member
+	classMinWidth  = 120 // minimum width of a class
+	classGapX      = 60  // horizontal space between classes
+	classGapY      = 80  // vertical space between layers
+	classMargin    = 40  // space around the whole diagram
+	classTextInset = 8   // space between the text and the border
+)
+
+// ClassLayout is the computed layout of a class diagram
+type ClassLayout struct {
+	Classes       []PositionedClass
+	Relationships []PositionedEdge
+	Width         int
+	Height        int
+}
+
+// PositionedClass is a class box, X, Y is the top left corner
+type PositionedClass struct {
+	Node       interpreter.Node
+	X, Y, W, H int
+}
+
+// classCompartments returns the fields and the methods of a class
+func classCompartments(n interpreter.Node) (fields, methods []interpreter.Member) {
+	for _, m := range n.Members {
+		if m.Method {
+			methods = append(methods, m)
+		} else {
+			fields = append(fields, m)
+		}
+	}
+	return fields, methods
+}
+
+// classHeaderHeight returns the height of the name compartment
+func classHeaderHeight(n interpreter.Node) int {
+	h := classHeaderH + strings.Count(n.Label, "\n")*17
+	if n.Shape == "interface" {
+		h += classStereoH
+	}
+	return h
+}
+
+// compartmentHeight returns the height of a compartment with n members
+func compartmentHeight(n int) int {
+	return n*classRowH + 2*classPadding
+}
+
+// classSize returns the width and height of a class box
+func classSize(n interpreter.Node) (int, int) {
+	w := max(classMinWidth, participantWidth(n.Label))
+	for _, m := range n.Members {
+		w = max(w, utf8.RuneCountInString(m.String())*classCharW+2*classTextInset)
+	}
+	fields, methods := classCompartments(n)
+	return w, classHeaderHeight(n) + compartmentHeight(len(fields)) + compartmentHeight(len(methods))
+}
+
+// ComputeClassLayout places the classes in layers and connects the relationships
+func ComputeClassLayout(d interpreter.Diagram) ClassLayout {
+	var l ClassLayout
+
+	index := map[string]int{}
+	boxes := make([]*layerBox, len(d.Nodes))
+	for i, n := range d.Nodes {
+		if _, ok := index[n.ID]; !ok {
+			index[n.ID] = i
+		}
+		w, h := classSize(n)
+		boxes[i] = &layerBox{W: w, H: h}
+	}
+
+	// Parents above children, the whole above its parts
+	var links [][2]int
+	for _, e := range d.Edges {
+		from, okFrom := index[e.From]
+		to, okTo := index[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		switch e.Relation {
+		case interpreter.RelationInheritance, interpreter.RelationImplementation:
+			links = append(links, [2]int{to, from})
+		default:
+			links = append(links, [2]int{from, to})
+		}
+	}
+
+	width, height := layerLayout(boxes, links, classGapX, classGapY)
+	for i, n := range d.Nodes {
+		b := boxes[i]
+		l.Classes = append(l.Classes, PositionedClass{Node: n, X: b.X + classMargin, Y: b.Y + classMargin, W: b.W, H: b.H})
+	}
+	l.Width = width + 2*classMargin
+	l.Height = height + 2*classMargin
+
+	// Straight lines between the borders of the boxes
+	clip := func(c PositionedClass, tx, ty int) (int, int) {
+		cx, cy := c.X+c.W/2, c.Y+c.H/2
+		dx, dy := float64(tx-cx), float64(ty-cy)
+		if dx == 0 && dy == 0 {
+			return cx, cy
+		}
+		t := math.Inf(1)
+		if dx != 0 {
+			t = float64(c.W/2) / math.Abs(dx)
+		}
+		if dy != 0 {
+			t = math.Min(t, float64(c.H/2)/math.Abs(dy))
+		}
+		return cx + int(math.Round(dx*t)), cy + int(math.Round(dy*t))
+	}
+	for _, e := range d.Edges {
+		fi, okFrom := index[e.From]
+		ti, okTo := index[e.To]
+		if !okFrom || !okTo || fi == ti {
+			continue
+		}
+		from, to := l.Classes[fi], l.Classes[ti]
+		pe := PositionedEdge{Edge: e}
+		pe.FromX, pe.FromY = clip(from, to.X+to.W/2, to.Y+to.H/2)
+		pe.ToX, pe.ToY = clip(to, from.X+from.W/2, from.Y+from.H/2)
+		l.Relationships = append(l.Relationships, pe)
+	}
+	return l
+}
+
+// classMarkers gives the markers and dash pattern of each kind of relationship
+var classMarkers = map[interpreter.Relation]struct {
+	start, end, dash string
+}{
+	interpreter.RelationAssociation:    {"", "class-open", ""},
+	interpreter.RelationDependency:     {"", "class-open", "6,4"},
+	interpreter.RelationInheritance:    {"", "class-triangle", ""},
+	interpreter.RelationImplementation: {"", "class-triangle", "6,4"},
+	interpreter.RelationComposition:    {"class-diamond-filled", "", ""},
+	interpreter.RelationAggregation:    {"class-diamond", "", ""},
+}
+
+// RenderClassSVG draws a class diagram
+func RenderClassSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	l := ComputeClassLayout(d)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Classes
+	for _, c := range l.Classes {
+		n := c.Node
+		headerH := classHeaderHeight(n)
+		fields, methods := classCompartments(n)
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			c.X, c.Y, c.W, c.H, th.Surface, n.Border,
+		))
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			c.X, c.Y, c.W, headerH, n.Color, n.Border,
+		))
+		nameY := c.Y + headerH/2 + 5
+		if n.Shape == "interface" {
+			writeText(&sb, c.X+c.W/2, c.Y+16, 11, "middle", string(n.Text), "«interface»")
+			nameY += classStereoH / 2
+		}
+		writeText(&sb, c.X+c.W/2, nameY, 14, "middle", string(n.Text), n.Label)
+
+		// Fields, a line, then methods
+		y := c.Y + headerH
+		writeMembers(&sb, c.X+classTextInset, y+classPadding, fields, th.Text)
+		y += compartmentHeight(len(fields))
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1"/>`+"\n",
+			c.X, y, c.X+c.W, y, n.Border,
+		))
+		writeMembers(&sb, c.X+classTextInset, y+classPadding, methods, th.Text)
+	}
+
+	// Relationships
+	for _, r := range l.Relationships {
+		e := r.Edge
+		m := classMarkers[e.Relation]
+		attrs := ""
+		if m.dash != "" {
+			attrs += fmt.Sprintf(` stroke-dasharray="%s"`, m.dash)
+		}
+		if m.start != "" {
+			attrs += fmt.Sprintf(` marker-start="url(#%s)"`, m.start)
+		}
+		if m.end != "" {
+			attrs += fmt.Sprintf(` marker-end="url(#%s)"`, m.end)
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s"%s/>`+"\n",
+			r.FromX, r.FromY, r.ToX, r.ToY, e.Color, e.Width, attrs,
+		))
+		writeText(&sb, (r.FromX+r.ToX)/2+8, (r.FromY+r.ToY)/2-4, 12, "start", string(th.EdgeColor), e.Label)
+
+		// Multiplicities near the ends, beside the line
+		dx, dy := float64(r.ToX-r.FromX), float64(r.ToY-r.FromY)
+		length := math.Max(math.Hypot(dx, dy), 1)
+		ux, uy := dx/length, dy/length
+		near := func(x, y int, dir float64) (int, int) {
+			return x + int(dir*ux*22-uy*12), y + int(dir*uy*22+ux*12) + 4
+		}
+		if e.FromMultiplicity != "" {
+			x, y := near(r.FromX, r.FromY, 1)
+			writeText(&sb, x, y, 12, "middle", string(th.EdgeColor), e.FromMultiplicity)
+		}
+		if e.ToMultiplicity != "" {
+			x, y := near(r.ToX, r.ToY, -1)
+			writeText(&sb, x, y, 12, "middle", string(th.EdgeColor), e.ToMultiplicity)
+		}
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+  <defs>
+    <marker id="class-open" viewBox="0 0 10 10" refX="9" refY="5"
+            markerWidth="7" markerHeight="7" orient="auto-start-reverse">
+      <path d="M 0 0 L 10 5 L 0 10" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="class-triangle" viewBox="0 0 16 16" refX="15" refY="8" markerUnits="userSpaceOnUse"
+            markerWidth="16" markerHeight="16" orient="auto-start-reverse">
+      <path d="M 1 1 L 15 8 L 1 15 z" fill="%[2]s" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="class-diamond" viewBox="0 0 20 12" refX="19" refY="6" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="12" orient="auto-start-reverse">
+      <path d="M 1 6 L 10 1 L 19 6 L 10 11 z" fill="%[2]s" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="class-diamond-filled" viewBox="0 0 20 12" refX="19" refY="6" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="12" orient="auto-start-reverse">
+      <path d="M 1 6 L 10 1 L 19 6 L 10 11 z" fill="%[1]s" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+  </defs>
+`, th.EdgeColor, th.Surface))
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
+
+// writeMembers writes one row per member starting at top.
+// Static members are underlined and abstract ones in italics, as in UML.
+func writeMembers(sb *strings.Builder, x, top int, members []interpreter.Member, fill interpreter.Color) {
+	for i, m := range members {
+		style := ""
+		if m.Static {
+			style += ` text-decoration="underline"`
+		}
+		if m.Abstract {
+			style += ` font-style="italic"`
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <text x="%d" y="%d" font-size="12" text-anchor="start" fill="%s"%s>%s</text>`+"\n",
+			x, top+i*classRowH+classRowH/2+4, fill, style, escapeXML(m.String()),
+		))
+	}
+}
@@ -0,0 +1,473 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RenderDOT takes a diagram and writes it in the Graphviz DOT language,
+// so it can be fed to dot and the tools around it. Shapes, colours,
+// labels and widths are mapped to DOT attributes, the positions are left
+// to Graphviz. Groups become clusters, ER entities and classes become
+// HTML-like tables. Notes and fragments of sequence diagrams have no
+// DOT equivalent and are left out.
+func RenderDOT(d interpreter.Diagram) string {
+	th := themeOf(d)
+	w := &dotWriter{d: d, th: th, groupNode: map[string]string{}}
+	if d.Name == "gantt" {
+		w.spans, _ = interpreter.Schedule(d)
+	}
+	for _, g := range d.Groups {
+		w.firstNodes(g)
+	}
+
+	w.line("digraph %s {", dotID(d.Name))
+	w.depth++
+	w.line("rankdir=%s", dotRankdir(d))
+	if th.Background != "" {
+		w.line("bgcolor=%s", dotColor(th.Background))
+	}
+	for _, e := range d.Edges {
+		_, from := w.groupNode[e.From]
+		_, to := w.groupNode[e.To]
+		if from || to {
+			w.line("compound=true") // edges to composite states end at the cluster
+			break
+		}
+	}
+	w.line("node [fontsize=%d, style=filled]", nodeFontSize)
+	w.line("edge [fontsize=12, fontcolor=%s]", dotColor(th.EdgeColor))
+
+	inGroup := map[string]bool{}
+	var mark func(gs []interpreter.Group)
+	mark = func(gs []interpreter.Group) {
+		for _, g := range gs {
+			for _, id := range g.Nodes {
+				inGroup[id] = true
+			}
+			mark(g.Groups)
+		}
+	}
+	mark(d.Groups)
+
+	for _, n := range d.Nodes {
+		if !inGroup[n.ID] {
+			w.node(n)
+		}
+	}
+	for _, g := range d.Groups {
+		w.group(g)
+	}
+	if d.Name == "sequence" {
+		w.participants()
+	}
+
+	switch d.Name {
+	case "sequence":
+		count := 0
+		w.messages(d.Steps, &count)
+	case "gantt":
+		w.dependencies()
+	default:
+		for _, e := range d.Edges {
+			w.edge(e)
+		}
+	}
+	w.depth--
+	w.line("}")
+	return w.sb.String()
+}
+
+// dotWriter writes the statements of a DOT graph, indented by depth
+type dotWriter struct {
+	d     interpreter.Diagram
+	th    interpreter.Theme
+	sb    strings.Builder
+	depth int
+
+	// groupNode maps a group to a node inside it, edges to the group
+	// are drawn to that node and clipped at the cluster
+	groupNode map[string]string
+	spans     map[string]interpreter.TaskSpan // gantt diagrams
+}
+
+func (w *dotWriter) line(format string, args ...any) {
+	w.sb.WriteString(strings.Repeat("\t", w.depth))
+	w.sb.WriteString(fmt.Sprintf(format, args...))
+	w.sb.WriteString("\n")
+}
+
+// firstNodes finds a node inside g and each group in it
+func (w *dotWriter) firstNodes(g interpreter.Group) string {
+	first := ""
+	if len(g.Nodes) > 0 {
+		first = g.Nodes[0]
+	}
+	for _, inner := range g.Groups {
+		if id := w.firstNodes(inner); first == "" {
+			first = id
+		}
+	}
+	if first != "" {
+		w.groupNode[g.ID] = first
+	}
+	return first
+}
+
+func (w *dotWriter) group(g interpreter.Group) {
+	w.line("subgraph %s {", dotID("cluster_"+g.ID))
+	w.depth++
+	w.line("label=%s", dotString(g.Label))
+	w.line("style=%s", dotString("rounded,filled"))
+	w.line("fillcolor=%s", dotColor(g.Color))
+	w.line("color=%s", dotColor(g.Border))
+	w.line("fontcolor=%s", dotColor(g.Text))
+	nodes := map[string]interpreter.Node{}
+	for _, n := range w.d.Nodes {
+		nodes[n.ID] = n
+	}
+	for _, id := range g.Nodes {
+		if n, ok := nodes[id]; ok {
+			w.node(n)
+		}
+	}
+	for _, inner := range g.Groups {
+		w.group(inner)
+	}
+	w.depth--
+	w.line("}")
+}
+
+// dotShapes maps the shapes of diagra to the DOT shapes
+var dotShapes = map[string]string{
+	"rect":          "box",
+	"ellipse":       "ellipse",
+	"circle":        "circle",
+	"diamond":       "diamond",
+	"parallelogram": "parallelogram",
+	"hexagon":       "hexagon",
+	"cylinder":      "cylinder",
+	"note":          "note",
+	"participant":   "box",
+	"actor":         "box",
+	"initial":       "point",
+	"final":         "doublecircle",
+	"task":          "box",
+	"milestone":     "diamond",
+	"topic":         "ellipse",
+}
+
+func (w *dotWriter) node(n interpreter.Node) {
+	attrs := []string{}
+	add := func(key, value string) {
+		attrs = append(attrs, key+"="+value)
+	}
+
+	switch {
+	case n.Shape == "entity" || n.Shape == "class" || n.Shape == "interface":
+		add("shape", "plain")
+		add("style", dotString(""))
+		add("label", w.table(n))
+	case n.Shape == "initial" || n.Shape == "final":
+		add("shape", dotShapes[n.Shape])
+		add("label", dotString(""))
+		add("width", "0.25")
+		add("fillcolor", dotColor(n.Border))
+		add("color", dotColor(n.Border))
+	default:
+		shape, ok := dotShapes[n.Shape]
+		if !ok {
+			shape = "box"
+		}
+		add("shape", shape)
+		if w.d.Name == "state" {
+			add("style", dotString("rounded,filled"))
+		}
+		add("label", dotString(w.nodeLabel(n)))
+		add("fillcolor", dotColor(n.Color))
+		add("fontcolor", dotColor(n.Text))
+		add("color", dotColor(n.Border))
+		if px, ok := n.Width.Pixels(); ok {
+			add("width", dotInches(px))
+		}
+		if px, ok := n.Height.Pixels(); ok {
+			add("height", dotInches(px))
+		}
+	}
+	w.line("%s [%s]", dotID(n.ID), strings.Join(attrs, ", "))
+}
+
+// nodeLabel returns the label of a node, tasks get their dates on
+// a second line
+func (w *dotWriter) nodeLabel(n interpreter.Node) string {
+	span, ok := w.spans[n.ID]
+	if !ok {
+		return n.Label
+	}
+	dates := span.Start.Format(interpreter.DateLayout)
+	if span.End.After(span.Start) {
+		dates += " – " + span.End.AddDate(0, 0, -1).Format(interpreter.DateLayout)
+	}
+	return n.Label + "\n" + dates
+}
+
+// table returns an HTML-like label for an ER entity or a class, with a
+// port for each column so relationships can start at the row
+func (w *dotWriter) table(n interpreter.Node) string {
+	var sb strings.Builder
+	cell := func(attrs, text string) {
+		sb.WriteString(fmt.Sprintf("<TR><TD%s>%s</TD></TR>", attrs, text))
+	}
+	sb.WriteString(fmt.Sprintf(`<<TABLE BORDER="1" CELLBORDER="0" CELLSPACING="0" CELLPADDING="4" COLOR="%s" BGCOLOR="%s">`,
+		dotHTMLColor(n.Border), dotHTMLColor(w.th.Surface)))
+
+	header := fmt.Sprintf(`<FONT COLOR="%s"><B>%s</B></FONT>`, dotHTMLColor(n.Text), dotHTML(n.Label))
+	if n.Shape == "interface" {
+		header = fmt.Sprintf(`<FONT COLOR="%s">«interface»<BR/><B>%s</B></FONT>`, dotHTMLColor(n.Text), dotHTML(n.Label))
+	}
+	cell(fmt.Sprintf(` BGCOLOR="%s"`, dotHTMLColor(n.Color)), header)
+
+	for _, c := range n.Columns {
+		text := c.Name + ": " + c.Type
+		var keys []string
+		if c.PK {
+			keys = append(keys, "PK")
+		}
+		if c.FK {
+			keys = append(keys, "FK")
+		}
+		if c.Unique {
+			keys = append(keys, "UK")
+		}
+		if len(keys) > 0 {
+			text = strings.Join(keys, " ") + " " + text
+		}
+		cell(fmt.Sprintf(` PORT="%s" ALIGN="LEFT"`, dotHTML(c.Name)), dotHTML(text))
+	}
+
+	if n.Shape != "entity" {
+		fields, methods := classCompartments(n)
+		for i, members := range [][]interpreter.Member{fields, methods} {
+			if i == 1 {
+				sb.WriteString("<HR/>")
+			}
+			var lines []string
+			for _, m := range members {
+				text := dotHTML(m.String())
+				switch {
+				case m.Static:
+					text = "<U>" + text + "</U>"
+				case m.Abstract:
+					text = "<I>" + text + "</I>"
+				}
+				lines = append(lines, text)
+			}
+			cell(` ALIGN="LEFT" BALIGN="LEFT"`, strings.Join(lines, "<BR/>"))
+		}
+	}
+	sb.WriteString("</TABLE>>")
+	return sb.String()
+}
+
+// dotArrows gives the DOT attributes of each kind of edge
+var dotArrows = map[interpreter.EdgeKind]string{
+	interpreter.EdgeUndirected:    "dir=none",
+	interpreter.EdgeBidirectional: "dir=both",
+	interpreter.EdgeDashed:        "style=dashed",
+	interpreter.EdgeDotted:        "style=dotted",
+}
+
+// dotRelations gives the DOT attributes of each kind of class relationship,
+// like classMarkers does for the SVG
+var dotRelations = map[interpreter.Relation]string{
+	interpreter.RelationAssociation:    "arrowhead=vee",
+	interpreter.RelationDependency:     "arrowhead=vee, style=dashed",
+	interpreter.RelationInheritance:    "arrowhead=empty",
+	interpreter.RelationImplementation: "arrowhead=empty, style=dashed",
+	interpreter.RelationComposition:    "dir=back, arrowtail=diamond",
+	interpreter.RelationAggregation:    "dir=back, arrowtail=odiamond",
+}
+
+// dotCardinalities gives the crow's foot arrow of each cardinality,
+// the first shape is the one at the entity
+var dotCardinalities = map[interpreter.Cardinality]string{
+	interpreter.CardinalityOne:        "teetee",
+	interpreter.CardinalityZeroOrOne:  "teeodot",
+	interpreter.CardinalityMany:       "crowtee",
+	interpreter.CardinalityZeroOrMany: "crowodot",
+}
+
+func (w *dotWriter) edge(e interpreter.Edge) {
+	w.edgeWith(e, "")
+}
+
+// edgeWith writes an edge, extra are more attributes from the caller
+func (w *dotWriter) edgeWith(e interpreter.Edge, extra string) {
+	var attrs []string
+	from, to := dotID(e.From), dotID(e.To)
+	if e.FromPort != "" {
+		from += ":" + dotID(e.FromPort)
+	}
+	if e.ToPort != "" {
+		to += ":" + dotID(e.ToPort)
+	}
+	if inner, ok := w.groupNode[e.From]; ok {
+		from = dotID(inner)
+		attrs = append(attrs, "ltail="+dotID("cluster_"+e.From))
+	}
+	if inner, ok := w.groupNode[e.To]; ok {
+		to = dotID(inner)
+		attrs = append(attrs, "lhead="+dotID("cluster_"+e.To))
+	}
+
+	label := e.Label
+	switch w.d.Name {
+	case "state":
+		label = transitionLabel(e)
+	case "er":
+		attrs = append(attrs, "dir=both",
+			"arrowtail="+dotCardinalities[e.FromCard], "arrowhead="+dotCardinalities[e.ToCard])
+	case "class":
+		attrs = append(attrs, dotRelations[e.Relation])
+		if e.FromMultiplicity != "" {
+			attrs = append(attrs, "taillabel="+dotString(e.FromMultiplicity))
+		}
+		if e.ToMultiplicity != "" {
+			attrs = append(attrs, "headlabel="+dotString(e.ToMultiplicity))
+		}
+	case "mindmap":
+		attrs = append(attrs, "dir=none")
+	}
+	if arrows, ok := dotArrows[e.Kind]; ok {
+		attrs = append(attrs, arrows)
+	}
+	if extra != "" {
+		attrs = append(attrs, extra)
+	}
+	if label != "" {
+		attrs = append(attrs, "label="+dotString(label))
+	}
+	if e.Color != "" {
+		attrs = append(attrs, "color="+dotColor(e.Color))
+	}
+	if px, ok := e.Width.Pixels(); ok {
+		attrs = append(attrs, "penwidth="+strconv.FormatFloat(px, 'f', -1, 64))
+	}
+
+	if len(attrs) == 0 {
+		w.line("%s -> %s", from, to)
+		return
+	}
+	w.line("%s -> %s [%s]", from, to, strings.Join(attrs, ", "))
+}
+
+// participants keeps the participants of a sequence diagram on one row
+// in the order they were declared
+func (w *dotWriter) participants() {
+	var ids []string
+	for _, n := range w.d.Nodes {
+		ids = append(ids, dotID(n.ID))
+	}
+	if len(ids) > 1 {
+		w.line("{ rank=same; %s }", strings.Join(ids, "; "))
+	}
+}
+
+// dotMessages gives the DOT attributes of each kind of message
+var dotMessages = map[interpreter.MessageKind]string{
+	interpreter.MessageSync:   "arrowhead=normal",
+	interpreter.MessageAsync:  "arrowhead=vee",
+	interpreter.MessageReturn: "arrowhead=vee, style=dashed",
+}
+
+// messages writes the messages of a sequence diagram as numbered edges,
+// the steps inside fragments are numbered in the order they are written
+func (w *dotWriter) messages(steps []interpreter.Step, count *int) {
+	for _, s := range steps {
+		switch s.Kind {
+		case interpreter.StepMessage:
+			*count++
+			e := s.Edge
+			e.Label = strings.TrimSpace(fmt.Sprintf("%d. %s", *count, e.Label))
+			w.edgeWith(e, dotMessages[s.Message])
+		case interpreter.StepFragment:
+			for _, section := range s.Fragment.Sections {
+				w.messages(section.Steps, count)
+			}
+		}
+	}
+}
+
+// dependencies writes an edge for every task a gantt task starts after
+func (w *dotWriter) dependencies() {
+	for _, n := range w.d.Nodes {
+		if n.Task == nil {
+			continue
+		}
+		for _, after := range n.Task.After {
+			w.line("%s -> %s [color=%s]", dotID(after), dotID(n.ID), dotColor(w.th.Muted))
+		}
+	}
+}
+
+// dotRankdir returns the direction dot lays out the diagram in
+func dotRankdir(d interpreter.Diagram) string {
+	switch {
+	case d.Name == "flowchart" && d.Layout != "vertical", d.Name == "gantt", d.Name == "mindmap":
+		return "LR"
+	}
+	return "TB"
+}
+
+// dotKeywords can not be used as IDs without quotes, in any case
+var dotKeywords = map[string]bool{
+	"node": true, "edge": true, "graph": true, "digraph": true, "subgraph": true, "strict": true,
+}
+
+var dotPlainID = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*|-?(\.[0-9]+|[0-9]+(\.[0-9]*)?))$`)
+
+// dotID returns s as a DOT ID, quoted when it has to be
+func dotID(s string) string {
+	if dotPlainID.MatchString(s) && !dotKeywords[strings.ToLower(s)] {
+		return s
+	}
+	return dotString(s)
+}
+
+// dotString returns s as a quoted DOT string, line breaks become \n
+// so dot centres the lines
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// dotHTML escapes s for an HTML-like label
+func dotHTML(s string) string {
+	return strings.ReplaceAll(escapeXML(s), "\n", "<BR/>")
+}
+
+// dotInches returns a length in pixels in inches, the unit of width and
+// height in DOT, with 72 points to the inch like Graphviz
+func dotInches(px float64) string {
+	return strconv.FormatFloat(px/72, 'f', 2, 64)
+}
+
+// dotColor returns a colour the way Graphviz reads it: hex values as
+// #rrggbb or #rrggbbaa and named colours from the SVG colour scheme,
+// which is the same as the CSS colours
+func dotColor(c interpreter.Color) string {
+	return dotString(dotHTMLColor(c))
+}
+
+// dotHTMLColor is dotColor without the quotes, for HTML-like labels
+func dotHTMLColor(c interpreter.Color) string {
+	if hex := c.Hex(); strings.HasPrefix(hex, "#") || hex == "transparent" {
+		return hex
+	}
+	return "/svg/" + string(c)
+}
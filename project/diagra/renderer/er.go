@@ -0,0 +1,245 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout and SVG for ER diagrams.
+// Entities are drawn as tables, a header with the name and one row per
+// column, and placed in a grid in the order they are declared.
+// Relationships leave and enter the entities at the side, at the row of
+// their column, with crow's foot markers for the cardinality.
+
+// ER layout constants
+const (
+	erHeaderH   = 30  // height of the entity name
+	erRowH      = 22  // height of a column row
+	erKeyW      = 34  // minimum width of the PK/FK column
+	erCharW     = 8   // estimated width of a character
+	erGapX      = 140 // horizontal space between entities, room for the curves
+	erGapY      = 60  // vertical space between entities
+	erMargin    = 40  // space around the whole diagram
+	erCurve     = 60  // how far a relationship goes out from the side
+	erMinWidth  = 140 // minimum width of an entity
+	erTextInset = 8   // space between the text and the border
+)
+
+// ERLayout is the computed layout of an ER diagram
+type ERLayout struct {
+	Entities      []PositionedEntity
+	Relationships []PositionedRelationship
+	Width         int
+	Height        int
+}
+
+// PositionedEntity is an entity table, X, Y is the top left corner
+type PositionedEntity struct {
+	Node       interpreter.Node
+	X, Y, W, H int
+}
+
+// RowY returns the y of the middle of the row of a column,
+// the middle of the header when the column is not found
+func (e PositionedEntity) RowY(column string) int {
+	headerH := e.HeaderH()
+	for i, c := range e.Node.Columns {
+		if c.Name == column {
+			return e.Y + headerH + i*erRowH + erRowH/2
+		}
+	}
+	return e.Y + headerH/2
+}
+
+// HeaderH returns the height of the header, more than erHeaderH
+// for a label with several lines
+func (e PositionedEntity) HeaderH() int {
+	return e.H - len(e.Node.Columns)*erRowH
+}
+
+// PositionedRelationship is a relationship between two entities.
+// FromSide and ToSide are -1 for the left side and 1 for the right side.
+type PositionedRelationship struct {
+	Edge             interpreter.Edge
+	FromX, FromY     int
+	ToX, ToY         int
+	FromSide, ToSide int
+	LabelX, LabelY   int
+}
+
+// entityKeyWidth returns the width of the PK/FK column of an entity
+func entityKeyWidth(n interpreter.Node) int {
+	w := erKeyW
+	for _, c := range n.Columns {
+		w = max(w, len(c.Keys())*7+erTextInset)
+	}
+	return w
+}
+
+// entitySize returns the width and height of an entity table
+func entitySize(n interpreter.Node) (int, int) {
+	name, typ := 0, 0
+	for _, c := range n.Columns {
+		name = max(name, utf8.RuneCountInString(c.Name))
+		typ = max(typ, utf8.RuneCountInString(c.Type))
+	}
+	w := entityKeyWidth(n) + (name+typ)*erCharW + 3*erTextInset
+	for _, line := range strings.Split(n.Label, "\n") {
+		w = max(w, utf8.RuneCountInString(line)*erCharW+2*erTextInset)
+	}
+	lines := strings.Count(n.Label, "\n")
+	return max(w, erMinWidth), erHeaderH + lines*17 + len(n.Columns)*erRowH
+}
+
+// ComputeERLayout places the entities in a grid and routes the relationships
+func ComputeERLayout(d interpreter.Diagram) ERLayout {
+	var l ERLayout
+
+	// A grid about as wide as it is high
+	cols := int(math.Ceil(math.Sqrt(float64(len(d.Nodes)))))
+	colW := make([]int, cols)
+	var rowH []int
+	for i, n := range d.Nodes {
+		w, h := entitySize(n)
+		if i/cols >= len(rowH) {
+			rowH = append(rowH, 0)
+		}
+		colW[i%cols] = max(colW[i%cols], w)
+		rowH[i/cols] = max(rowH[i/cols], h)
+	}
+
+	entities := map[string]PositionedEntity{}
+	y := erMargin
+	for r := range rowH {
+		x := erMargin
+		for c := 0; c < cols && r*cols+c < len(d.Nodes); c++ {
+			n := d.Nodes[r*cols+c]
+			w, h := entitySize(n)
+			e := PositionedEntity{Node: n, X: x + (colW[c]-w)/2, Y: y, W: w, H: h}
+			l.Entities = append(l.Entities, e)
+			if _, ok := entities[n.ID]; !ok {
+				entities[n.ID] = e
+			}
+			x += colW[c] + erGapX
+		}
+		l.Width = max(l.Width, x-erGapX+erMargin)
+		y += rowH[r] + erGapY
+	}
+	l.Height = y - erGapY + erMargin
+
+	for _, e := range d.Edges {
+		from, okFrom := entities[e.From]
+		to, okTo := entities[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		r := PositionedRelationship{Edge: e}
+
+		// Use the sides that face each other, or the right side of
+		// both when the entities are above each other
+		fromMid, toMid := from.X+from.W/2, to.X+to.W/2
+		switch {
+		case to.X > from.X+from.W:
+			r.FromSide, r.ToSide = 1, -1
+		case to.X+to.W < from.X:
+			r.FromSide, r.ToSide = -1, 1
+		default:
+			r.FromSide, r.ToSide = 1, 1
+		}
+		r.FromX, r.FromY = fromMid+r.FromSide*from.W/2, from.RowY(e.FromPort)
+		r.ToX, r.ToY = toMid+r.ToSide*to.W/2, to.RowY(e.ToPort)
+
+		// The middle of the curve
+		c1x, c2x := r.FromX+r.FromSide*erCurve, r.ToX+r.ToSide*erCurve
+		r.LabelX = (r.FromX + 3*c1x + 3*c2x + r.ToX) / 8
+		r.LabelY = (r.FromY+r.ToY)/2 - 6
+		l.Width = max(l.Width, c1x+erMargin, c2x+erMargin)
+
+		l.Relationships = append(l.Relationships, r)
+	}
+	return l
+}
+
+// RenderERSVG draws an ER diagram
+func RenderERSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	l := ComputeERLayout(d)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Entities
+	for _, e := range l.Entities {
+		n := e.Node
+		headerH := e.HeaderH()
+		keyW := entityKeyWidth(n)
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="4" ry="4" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			e.X, e.Y, e.W, e.H, th.Surface, n.Border,
+		))
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="4" ry="4" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			e.X, e.Y, e.W, headerH, n.Color, n.Border,
+		))
+		writeText(&sb, e.X+e.W/2, e.Y+headerH/2+5, 14, "middle", string(n.Text), n.Label)
+
+		for i, c := range n.Columns {
+			top := e.Y + headerH + i*erRowH
+			if i > 0 {
+				sb.WriteString(fmt.Sprintf(
+					`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1"/>`+"\n",
+					e.X, top, e.X+e.W, top, th.Grid,
+				))
+			}
+			textY := top + erRowH/2 + 4
+			writeText(&sb, e.X+erTextInset, textY, 11, "start", string(n.Text), c.Keys())
+			writeText(&sb, e.X+keyW+erTextInset, textY, 12, "start", string(th.Text), c.Name)
+			writeText(&sb, e.X+e.W-erTextInset, textY, 12, "end", string(th.Muted), c.Type)
+		}
+	}
+
+	// Relationships, the markers show the cardinality
+	for _, r := range l.Relationships {
+		e := r.Edge
+		sb.WriteString(fmt.Sprintf(
+			`  <path d="M %d %d C %d %d, %d %d, %d %d" fill="none" stroke="%s" stroke-width="%s" marker-start="url(#er-%s)" marker-end="url(#er-%s)"/>`+"\n",
+			r.FromX, r.FromY,
+			r.FromX+r.FromSide*erCurve, r.FromY,
+			r.ToX+r.ToSide*erCurve, r.ToY,
+			r.ToX, r.ToY,
+			e.Color, e.Width, e.FromCard, e.ToCard,
+		))
+		writeText(&sb, r.LabelX, r.LabelY, 12, "middle", string(th.EdgeColor), e.Label)
+	}
+
+	// Crow's foot markers, drawn pointing into the entity at the end of the
+	// path and flipped by auto-start-reverse at the start
+	sb.WriteString(fmt.Sprintf(`
+  <defs>
+    <marker id="er-one" viewBox="0 0 20 20" refX="20" refY="10" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="20" orient="auto-start-reverse">
+      <path d="M 10 3 V 17 M 14 3 V 17 M 0 10 H 20" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="er-zero_or_one" viewBox="0 0 20 20" refX="20" refY="10" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="20" orient="auto-start-reverse">
+      <path d="M 14 3 V 17 M 12 10 H 20" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+      <circle cx="7" cy="10" r="4" fill="%[2]s" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="er-many" viewBox="0 0 20 20" refX="20" refY="10" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="20" orient="auto-start-reverse">
+      <path d="M 8 3 V 17 M 0 10 H 20 M 10 10 L 20 3 M 10 10 L 20 17" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+    <marker id="er-zero_or_many" viewBox="0 0 20 20" refX="20" refY="10" markerUnits="userSpaceOnUse"
+            markerWidth="20" markerHeight="20" orient="auto-start-reverse">
+      <path d="M 10 10 H 20 M 10 10 L 20 3 M 10 10 L 20 17" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+      <circle cx="5" cy="10" r="4" fill="%[2]s" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+  </defs>
+`, th.EdgeColor, th.Surface))
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
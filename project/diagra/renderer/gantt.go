@@ -0,0 +1,327 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Layout and SVG for gantt diagrams.
+// Every task gets a row with its label to the left and a bar on a time
+// axis, sections get a header row and a band behind their tasks.
+// The dates come from interpreter.Schedule.
+
+// Gantt layout constants
+const (
+	ganttAxisH     = 40   // height of the time axis at the top
+	ganttRowH      = 30   // height of a task row
+	ganttSectionH  = 26   // height of a section header row
+	ganttBarH      = 18   // height of a task bar
+	ganttMilestone = 9    // half the size of a milestone diamond
+	ganttMargin    = 20   // space around the whole diagram
+	ganttChartW    = 1000 // width the time axis aims for
+	ganttMinDay    = 4    // minimum width of a day
+	ganttMaxDay    = 30   // maximum width of a day
+	ganttTickW     = 50   // minimum space between two ticks on the axis
+)
+
+// GanttLayout is the computed layout of a gantt diagram.
+// The time axis starts at ChartX with the day Start.
+type GanttLayout struct {
+	Rows          []GanttRow
+	Sections      []GanttSection
+	Ticks         []GanttTick
+	Dependencies  []GanttDependency
+	ChartX        int
+	Start         time.Time
+	DayWidth      int
+	TodayX        int // -1 when today is not on the axis
+	Width, Height int
+}
+
+// GanttRow is a task or milestone. Y is the middle of the row,
+// the bar goes from X1 to X2 (X1 == X2 for a milestone).
+type GanttRow struct {
+	Node   interpreter.Node
+	Span   interpreter.TaskSpan
+	X1, X2 int
+	Y      int
+	Depth  int // how many sections the task is inside
+}
+
+// GanttSection is the header and band of a section, Y is the top
+type GanttSection struct {
+	Group interpreter.Group
+	Y, H  int
+	Depth int
+}
+
+// GanttTick is a date on the time axis
+type GanttTick struct {
+	X     int
+	Label string
+}
+
+// GanttDependency is an arrow from the end of a task to the start of
+// a task that comes after it, as a line through Points
+type GanttDependency struct {
+	From, To string
+	Points   [][2]int
+}
+
+// daysBetween returns the number of whole days from a to b
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// ComputeGanttLayout places the tasks of a gantt diagram on a time axis.
+// today is marked on the axis when it is inside the schedule.
+func ComputeGanttLayout(d interpreter.Diagram, today time.Time) GanttLayout {
+	var l GanttLayout
+	spans, _ := interpreter.Schedule(d)
+
+	nodes := map[string]interpreter.Node{}
+	inGroup := map[string]bool{}
+	for _, n := range d.Nodes {
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+		}
+	}
+	var mark func(gs []interpreter.Group)
+	mark = func(gs []interpreter.Group) {
+		for _, g := range gs {
+			for _, id := range g.Nodes {
+				inGroup[id] = true
+			}
+			mark(g.Groups)
+		}
+	}
+	mark(d.Groups)
+
+	// The date range, with a day to spare on each side
+	var first, last time.Time
+	for _, span := range spans {
+		if first.IsZero() || span.Start.Before(first) {
+			first = span.Start
+		}
+		if last.IsZero() || span.End.After(last) {
+			last = span.End
+		}
+	}
+	if first.IsZero() {
+		first, last = today, today
+	}
+	l.Start = first.AddDate(0, 0, -1)
+	total := daysBetween(l.Start, last) + 2
+	l.DayWidth = min(ganttMaxDay, max(ganttMinDay, ganttChartW/total))
+
+	// The label column is as wide as the longest label
+	labelW := 120
+	for _, n := range d.Nodes {
+		for _, line := range strings.Split(n.Label, "\n") {
+			labelW = max(labelW, utf8.RuneCountInString(line)*7+40)
+		}
+	}
+	l.ChartX = ganttMargin + labelW
+	xOf := func(t time.Time) int {
+		return l.ChartX + daysBetween(l.Start, t)*l.DayWidth
+	}
+
+	// Rows in the order written, sections with their tasks
+	y := ganttMargin + ganttAxisH
+	var place func(ids []string, groups []interpreter.Group, depth int)
+	place = func(ids []string, groups []interpreter.Group, depth int) {
+		type entry struct {
+			offset int
+			node   *interpreter.Node
+			group  *interpreter.Group
+		}
+		var entries []entry
+		for _, id := range ids {
+			n := nodes[id]
+			entries = append(entries, entry{offset: n.Pos.Offset, node: &n})
+		}
+		for i := range groups {
+			entries = append(entries, entry{offset: groups[i].Pos.Offset, group: &groups[i]})
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+
+		for _, e := range entries {
+			if e.group != nil {
+				index := len(l.Sections)
+				l.Sections = append(l.Sections, GanttSection{Group: *e.group, Y: y, Depth: depth})
+				y += ganttSectionH
+				place(e.group.Nodes, e.group.Groups, depth+1)
+				l.Sections[index].H = y - l.Sections[index].Y
+				continue
+			}
+			span, ok := spans[e.node.ID]
+			row := GanttRow{Node: *e.node, Span: span, Y: y + ganttRowH/2, Depth: depth}
+			if ok {
+				row.X1, row.X2 = xOf(span.Start), xOf(span.End)
+			}
+			l.Rows = append(l.Rows, row)
+			y += ganttRowH
+		}
+	}
+	var topIDs []string
+	for _, n := range d.Nodes {
+		if !inGroup[n.ID] && n.Task != nil {
+			topIDs = append(topIDs, n.ID)
+		}
+	}
+	place(topIDs, d.Groups, 0)
+
+	l.Width = l.ChartX + total*l.DayWidth + ganttMargin
+	l.Height = y + ganttMargin
+
+	// Ticks far enough apart for their labels
+	step := 1
+	for _, s := range []int{1, 2, 7, 14, 28} {
+		step = s
+		if s*l.DayWidth >= ganttTickW {
+			break
+		}
+	}
+	for day := 0; day < total; day += step {
+		t := l.Start.AddDate(0, 0, day)
+		l.Ticks = append(l.Ticks, GanttTick{X: xOf(t), Label: t.Format("01-02")})
+	}
+
+	l.TodayX = -1
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if !today.Before(l.Start) && daysBetween(l.Start, today) < total {
+		l.TodayX = xOf(today)
+	}
+
+	// Dependencies, into the start of the task from the left
+	rows := map[string]GanttRow{}
+	for _, r := range l.Rows {
+		rows[r.Node.ID] = r
+	}
+	for _, r := range l.Rows {
+		if r.Node.Task == nil {
+			continue
+		}
+		for _, dep := range r.Node.Task.After {
+			from, ok := rows[dep]
+			if !ok || from.Span.End.IsZero() || r.Span.Start.IsZero() {
+				continue
+			}
+			x1, y1, x2, y2 := from.X2, from.Y, r.X1, r.Y
+			points := [][2]int{{x1, y1}, {x1 + 6, y1}}
+			if x2-8 >= x1+6 {
+				points = append(points, [2]int{x1 + 6, y2})
+			} else {
+				// Not enough room, go around between the rows
+				between := y2 - ganttRowH/2
+				if y2 < y1 {
+					between = y2 + ganttRowH/2
+				}
+				points = append(points, [2]int{x1 + 6, between}, [2]int{x2 - 8, between}, [2]int{x2 - 8, y2})
+			}
+			points = append(points, [2]int{x2, y2})
+			l.Dependencies = append(l.Dependencies, GanttDependency{From: dep, To: r.Node.ID, Points: points})
+		}
+	}
+	return l
+}
+
+// RenderGanttSVG draws a gantt diagram. Today is the today attribute
+// of the diagram, or the current date.
+func RenderGanttSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	today, ok := d.Today()
+	if !ok {
+		today = time.Now()
+	}
+	l := ComputeGanttLayout(d, today)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Section bands, outer ones first.
+	// Every other section is lighter so they are easy to tell apart
+	for i, s := range l.Sections {
+		opacity := "1"
+		if i%2 == 1 {
+			opacity = "0.5"
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="%s" stroke="%s" stroke-width="1"/>`+"\n",
+			ganttMargin+s.Depth*10, s.Y, l.Width-2*ganttMargin-s.Depth*10, s.H, s.Group.Color, opacity, s.Group.Border,
+		))
+		writeText(&sb, ganttMargin+s.Depth*10+8, s.Y+ganttSectionH/2+5, 13, "start", string(s.Group.Text), s.Group.Label)
+	}
+
+	// Time axis with a grid line for every tick
+	bottom := l.Height - ganttMargin
+	for _, t := range l.Ticks {
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1"/>`+"\n",
+			t.X, ganttMargin+ganttAxisH-8, t.X, bottom, th.Grid,
+		))
+		writeText(&sb, t.X+3, ganttMargin+ganttAxisH-14, 11, "start", string(th.Muted), t.Label)
+	}
+
+	// Tasks and milestones
+	for _, r := range l.Rows {
+		n := r.Node
+		writeText(&sb, ganttMargin+r.Depth*10+8, r.Y+4, 12, "start", string(th.Text), n.Label)
+		if r.Span.Start.IsZero() {
+			continue // could not be scheduled
+		}
+		if n.Shape == "milestone" {
+			m := ganttMilestone
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d l %d %d l %d %d l %d %d z" fill="%s" stroke="%s" stroke-width="1.5"/>`+"\n",
+				r.X1, r.Y-m, m, m, -m, m, -m, -m, n.Color, n.Border,
+			))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="3" ry="3" fill="%s" stroke="%s" stroke-width="1.5"/>`+"\n",
+			r.X1, r.Y-ganttBarH/2, max(r.X2-r.X1, 1), ganttBarH, n.Color, n.Border,
+		))
+	}
+
+	// Dependency arrows
+	for _, dep := range l.Dependencies {
+		var path strings.Builder
+		for i, p := range dep.Points {
+			if i == 0 {
+				path.WriteString(fmt.Sprintf("M %d %d", p[0], p[1]))
+			} else {
+				path.WriteString(fmt.Sprintf(" L %d %d", p[0], p[1]))
+			}
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <path d="%s" fill="none" stroke="%s" stroke-width="1.5" marker-end="url(#gantt-arrow)"/>`+"\n",
+			path.String(), th.Muted,
+		))
+	}
+
+	// Today marker
+	if l.TodayX >= 0 {
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2" stroke-dasharray="4,3"/>`+"\n",
+			l.TodayX, ganttMargin+ganttAxisH-8, l.TodayX, bottom, th.Accent,
+		))
+		writeText(&sb, l.TodayX, ganttMargin+10, 11, "middle", string(th.Accent), "today")
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+  <defs>
+    <marker id="gantt-arrow" viewBox="0 0 10 10" refX="9" refY="5"
+            markerWidth="6" markerHeight="6" orient="auto-start-reverse">
+      <path d="M 0 0 L 10 5 L 0 10 z" fill="%[1]s"/>
+    </marker>
+  </defs>
+`, th.Muted))
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
@@ -1,6 +1,10 @@
 package renderer
 
-import "diagra/interpreter"
+import (
+	"diagra/interpreter"
+	"math"
+	"sort"
+)
 
 // PositionedNode is a struct that represents a node in the diagram with its position
 type PositionedNode struct {
@@ -15,87 +19,91 @@ type PositionedEdge struct {
 	ToX, ToY     int
 }
 
+// PositionedGroup is a group with the box that surrounds its members.
+// X, Y is the top left corner. Depth is 0 for top level groups.
+type PositionedGroup struct {
+	Group interpreter.Group
+	X, Y  int
+	W, H  int
+	Depth int
+}
+
+// Group spacing
+const (
+	groupGap     = 40 // extra space between nodes in different groups
+	groupPadding = 15 // space between the members and the group border
+	groupLabel   = 22 // space for the group label above the members
+)
+
 // Computelayout returns a layout for the diagram
 // It uses a simple horizontal layout for nodes and edges
-// The nodes are placed in a row with a fixed gap between them
+// The nodes are placed in a row with a fixed gap between them,
+// gapX is the distance between the centres of two nodes of the smallest
+// size and wider nodes get as much more room as they need
 func ComputeLayout(d interpreter.Diagram) ([]PositionedNode, []PositionedEdge) {
 	var pNodes []PositionedNode
-	var pEdges []PositionedEdge
 
 	startX, startY := 150, 150
 	gapX := 200
 
+	// All nodes are centred on the same line, below the top of the tallest
+	tallest := nodeMinH
+	for _, n := range d.Nodes {
+		_, h := nodeSize(n)
+		tallest = max(tallest, h)
+	}
+	y := startY + (tallest-nodeMinH)/2
+
 	// Place nodes horizontally (in a row)
+	// with some extra space where one group ends and the next starts
+	groupOf := nodeGroups(d)
+	x := startX - nodeMinW/2
+	prevW := 0
 	for i, n := range d.Nodes {
-		x := startX + i*gapX
-		y := startY
+		w, _ := nodeSize(n)
+		if i > 0 {
+			x += gapX - nodeMinW + prevW/2
+			if groupOf[n.ID] != groupOf[d.Nodes[i-1].ID] {
+				x += groupGap
+			}
+		}
+		x += w / 2
+		prevW = w
 		pNodes = append(pNodes, PositionedNode{Node: n, X: x, Y: y})
 	}
 
-	// Create a position map for nodes
-	// This map will be used to find the coordinates of each node
-	posMap := map[string][2]int{}
-	for _, pn := range pNodes {
-		posMap[pn.Node.ID] = [2]int{pn.X, pn.Y}
-	}
-
-	// Connect nodes with directed edges
-	// The edges are drawn from the center of the "from" node to the center of the "to" node
-	for _, e := range d.Edges {
-		from := posMap[e.From]
-		to := posMap[e.To]
-		offset := 60 // för att inte peka rakt in i boxarna
-
-		pEdges = append(pEdges, PositionedEdge{
-			Edge:  e,
-			FromX: from[0] + offset,
-			FromY: from[1],
-			ToX:   to[0] - offset,
-			ToY:   to[1],
-		})
-	}
-
-	return pNodes, pEdges
+	return pNodes, connect(d, pNodes)
 }
 
 // ComputeVerticalLayout returns a layout for the diagram
 // It uses a simple vertical layout for nodes and edges
 func ComputeVerticalLayout(d interpreter.Diagram) ([]PositionedNode, []PositionedEdge) {
 	var pNodes []PositionedNode
-	var pEdges []PositionedEdge
 
 	startX, startY := 400, 100 // middle of the screen
 	gapY := 120
 
 	// Place nodes vertically (in a column)
+	// with some extra space where one group ends and the next starts.
+	// Like gapX in ComputeLayout, taller nodes get more room.
+	groupOf := nodeGroups(d)
+	y := startY - nodeMinH/2
+	prevH := 0
 	for i, n := range d.Nodes {
+		_, h := nodeSize(n)
+		if i > 0 {
+			y += gapY - nodeMinH + prevH/2
+			if groupOf[n.ID] != groupOf[d.Nodes[i-1].ID] {
+				y += groupGap
+			}
+		}
+		y += h / 2
+		prevH = h
 		x := startX
-		y := startY + i*gapY
 		pNodes = append(pNodes, PositionedNode{Node: n, X: x, Y: y})
 	}
 
-	// Position map for nodes
-	posMap := map[string][2]int{}
-	for _, pn := range pNodes {
-		posMap[pn.Node.ID] = [2]int{pn.X, pn.Y}
-	}
-
-	// Connect nodes with directed edges
-	for _, e := range d.Edges {
-		from := posMap[e.From]
-		to := posMap[e.To]
-		offset := 30 // half height of the node
-
-		pEdges = append(pEdges, PositionedEdge{
-			Edge:  e,
-			FromX: from[0],
-			FromY: from[1] + offset,
-			ToX:   to[0],
-			ToY:   to[1] - offset,
-		})
-	}
-
-	return pNodes, pEdges
+	return pNodes, connect(d, pNodes)
 }
 
 // ComputeTreeLayout returns a layout for the diagram of type tree
@@ -156,41 +164,45 @@ func ComputeTreeLayout(d interpreter.Diagram) ([]PositionedNode, []PositionedEdg
 		}
 	}
 
-	// Assign positions
+	// Assign positions, with more room around the nodes that are
+	// larger than the smallest size
 	yStart := 100
 	xStart := 100
 	xGap := 160
 	yGap := 120
 
-	posMap := map[string][2]int{}
-
-	for level, ids := range levels {
-		for i, id := range ids {
-			x := xStart + i*xGap
-			y := yStart + level*yGap
+	y := yStart - nodeMinH/2
+	prevH := 0
+	for level := 0; level < len(levels); level++ {
+		tallest := nodeMinH
+		for _, id := range levels[level] {
+			_, h := nodeSize(nodeMap[id])
+			tallest = max(tallest, h)
+		}
+		if level > 0 {
+			y += yGap - nodeMinH + prevH/2
+		}
+		y += tallest / 2
+		prevH = tallest
+
+		x := xStart - nodeMinW/2
+		prevW := 0
+		for i, id := range levels[level] {
+			w, _ := nodeSize(nodeMap[id])
+			if i > 0 {
+				x += xGap - nodeMinW + prevW/2
+			}
+			x += w / 2
+			prevW = w
 			pNodes = append(pNodes, PositionedNode{
 				Node: nodeMap[id],
 				X:    x,
 				Y:    y,
 			})
-			posMap[id] = [2]int{x, y}
 		}
 	}
 
-	// Edges
-	for _, e := range d.Edges {
-		from := posMap[e.From]
-		to := posMap[e.To]
-		pEdges = append(pEdges, PositionedEdge{
-			Edge:  e,
-			FromX: from[0],
-			FromY: from[1] + 25, // halva höjden ner
-			ToX:   to[0],
-			ToY:   to[1] - 25, // halva höjden upp
-		})
-	}
-
-	return pNodes, pEdges
+	return pNodes, connect(d, pNodes)
 }
 
 // ComputeTreeLayoutRecursive returns a layout for the diagram of type tree
@@ -276,18 +288,232 @@ func ComputeTreeLayoutRecursive(d interpreter.Diagram) ([]PositionedNode, []Posi
 	}
 
 	// Uppdate edges with the correct positions
-	posMap := map[string][2]int{}
+	posMap := map[string]PositionedNode{}
 	for _, pn := range pNodes {
-		posMap[pn.Node.ID] = [2]int{pn.X, pn.Y}
+		posMap[pn.Node.ID] = pn
 	}
 	for i, e := range edges {
 		from := posMap[e.Edge.From]
 		to := posMap[e.Edge.To]
-		edges[i].FromX = from[0]
-		edges[i].FromY = from[1] + 25
-		edges[i].ToX = to[0]
-		edges[i].ToY = to[1] - 25
+		edges[i].FromX, edges[i].FromY, edges[i].ToX, edges[i].ToY = attach(from, to)
 	}
 
 	return pNodes, edges
 }
+
+// connect positions the edges between the positioned nodes, from outline
+// to outline. Edges to nodes that do not exist or were not placed are
+// left out, Validate reports them.
+func connect(d interpreter.Diagram, pNodes []PositionedNode) []PositionedEdge {
+	posMap := map[string]PositionedNode{}
+	for _, pn := range pNodes {
+		posMap[pn.Node.ID] = pn
+	}
+	var pEdges []PositionedEdge
+	for _, e := range d.Edges {
+		from, okFrom := posMap[e.From]
+		to, okTo := posMap[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		pe := PositionedEdge{Edge: e}
+		pe.FromX, pe.FromY, pe.ToX, pe.ToY = attach(from, to)
+		pEdges = append(pEdges, pe)
+	}
+	return pEdges
+}
+
+// nodeGroups maps every node in a group to the ID of its innermost group
+func nodeGroups(d interpreter.Diagram) map[string]string {
+	groupOf := map[string]string{}
+	var walk func(gs []interpreter.Group)
+	walk = func(gs []interpreter.Group) {
+		for _, g := range gs {
+			for _, id := range g.Nodes {
+				groupOf[id] = g.ID
+			}
+			walk(g.Groups)
+		}
+	}
+	walk(d.Groups)
+	return groupOf
+}
+
+// ComputeGroupBoxes returns a box for every group that surrounds the
+// positioned nodes of the group and the boxes of its nested groups.
+// Outer groups come first so they are drawn below the inner ones.
+// Groups without any positioned members are left out.
+func ComputeGroupBoxes(d interpreter.Diagram, pNodes []PositionedNode) []PositionedGroup {
+	posMap := map[string]PositionedNode{}
+	for _, pn := range pNodes {
+		posMap[pn.Node.ID] = pn
+	}
+
+	var boxes []PositionedGroup
+	var place func(g interpreter.Group, depth int) (PositionedGroup, bool)
+	place = func(g interpreter.Group, depth int) (PositionedGroup, bool) {
+		minX, minY := math.MaxInt, math.MaxInt
+		maxX, maxY := math.MinInt, math.MinInt
+		extend := func(x1, y1, x2, y2 int) {
+			minX, minY = min(minX, x1), min(minY, y1)
+			maxX, maxY = max(maxX, x2), max(maxY, y2)
+		}
+
+		for _, id := range g.Nodes {
+			if pn, ok := posMap[id]; ok {
+				w, h := nodeSize(pn.Node)
+				extend(pn.X-w/2, pn.Y-h/2, pn.X+w/2, pn.Y+h/2)
+			}
+		}
+		for _, child := range g.Groups {
+			if box, ok := place(child, depth+1); ok {
+				extend(box.X, box.Y, box.X+box.W, box.Y+box.H)
+			}
+		}
+		if minX == math.MaxInt {
+			return PositionedGroup{}, false
+		}
+
+		box := PositionedGroup{
+			Group: g,
+			X:     minX - groupPadding,
+			Y:     minY - groupPadding - groupLabel,
+			W:     maxX - minX + 2*groupPadding,
+			H:     maxY - minY + 2*groupPadding + groupLabel,
+			Depth: depth,
+		}
+		boxes = append(boxes, box)
+		return box, true
+	}
+	for _, g := range d.Groups {
+		place(g, 0)
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].Depth < boxes[j].Depth
+	})
+	return boxes
+}
+
+// layerBox is something placed by layerLayout, X, Y is the top left corner
+type layerBox struct {
+	X, Y, W, H int
+}
+
+// layerLayout places boxes in layers from top to bottom so that every link
+// [from, to] goes down to a lower layer. Links that close a cycle are
+// ignored. Boxes without links between them are placed in the order given.
+// It sets X and Y of the boxes and returns the total width and height.
+func layerLayout(boxes []*layerBox, links [][2]int, gapX, gapY int) (int, int) {
+	if len(boxes) == 0 {
+		return 0, 0
+	}
+	out := make([][]int, len(boxes))
+	for _, l := range links {
+		if l[0] != l[1] {
+			out[l[0]] = append(out[l[0]], l[1])
+		}
+	}
+
+	// Leave out the links that close a cycle, found with a depth
+	// first search in declaration order, so the rest has no cycles
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make([]int, len(boxes))
+	preds := make([][]int, len(boxes))
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = active
+		for _, j := range out[i] {
+			switch state[j] {
+			case unvisited:
+				preds[j] = append(preds[j], i)
+				visit(j)
+			case done:
+				preds[j] = append(preds[j], i)
+			}
+		}
+		state[i] = done
+	}
+	for i := range boxes {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	// Longest path layering: an item is one layer below its lowest predecessor
+	layer := make([]int, len(boxes))
+	for i := range layer {
+		layer[i] = -1
+	}
+	var layerOf func(i int) int
+	layerOf = func(i int) int {
+		if layer[i] >= 0 {
+			return layer[i]
+		}
+		layer[i] = 0
+		for _, p := range preds[i] {
+			layer[i] = max(layer[i], layerOf(p)+1)
+		}
+		return layer[i]
+	}
+	var layers [][]int
+	for i := range boxes {
+		l := layerOf(i)
+		for len(layers) <= l {
+			layers = append(layers, nil)
+		}
+		layers[l] = append(layers[l], i)
+	}
+
+	// Order each layer by the average position of the predecessors,
+	// which keeps transitions from crossing each other where possible
+	order := make([]float64, len(boxes))
+	for _, ids := range layers {
+		for k, i := range ids {
+			order[i] = float64(k)
+			if len(preds[i]) > 0 {
+				sum := 0.0
+				for _, p := range preds[i] {
+					sum += order[p]
+				}
+				order[i] = sum / float64(len(preds[i]))
+			}
+		}
+		sort.SliceStable(ids, func(a, b int) bool { return order[ids[a]] < order[ids[b]] })
+		for k, i := range ids {
+			order[i] = float64(k)
+		}
+	}
+
+	// Rows, centred under each other
+	width, height := 0, 0
+	rowW := make([]int, len(layers))
+	rowH := make([]int, len(layers))
+	for l, ids := range layers {
+		for k, i := range ids {
+			if k > 0 {
+				rowW[l] += gapX
+			}
+			rowW[l] += boxes[i].W
+			rowH[l] = max(rowH[l], boxes[i].H)
+		}
+		width = max(width, rowW[l])
+	}
+	for l, ids := range layers {
+		if l > 0 {
+			height += gapY
+		}
+		x := (width - rowW[l]) / 2
+		for _, i := range ids {
+			boxes[i].X = x
+			boxes[i].Y = height + (rowH[l]-boxes[i].H)/2
+			x += boxes[i].W + gapX
+		}
+		height += rowH[l]
+	}
+	return width, height
+}
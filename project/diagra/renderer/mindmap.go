@@ -0,0 +1,243 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Layout and SVG for mind maps.
+// The central topic is in the middle and its branches go out in every
+// direction, each level on a ring further out. Every topic gets a part of
+// the circle in proportion to how many leaves it has, so big branches get
+// more room than small ones. Unlike ComputeTreeLayout there is no top or
+// bottom, the colours come from the parser.
+
+// Mindmap layout constants
+const (
+	mindmapRingGap  = 150 // minimum distance between two rings
+	mindmapTopicGap = 20  // minimum space between topics on the same ring
+	mindmapTopicH   = 32  // height of a topic with one line
+	mindmapRootH    = 60  // height of the central topic
+	mindmapMargin   = 30  // space around the whole diagram
+)
+
+// MindmapLayout is the computed layout of a mind map
+type MindmapLayout struct {
+	Topics   []PositionedTopic
+	Branches []PositionedBranch
+	Width    int
+	Height   int
+}
+
+// PositionedTopic is a topic, X, Y is the centre. Angle is the direction
+// from the central topic in radians, 0 is to the right.
+type PositionedTopic struct {
+	Node       interpreter.Node
+	X, Y, W, H int
+	Depth      int
+	Angle      float64
+}
+
+// PositionedBranch is the curve from a topic to one of its children,
+// a quadratic curve from the centre of the parent through Ctrl
+type PositionedBranch struct {
+	Edge         interpreter.Edge
+	FromX, FromY int
+	CtrlX, CtrlY int
+	ToX, ToY     int
+	Depth        int // the depth of the child
+}
+
+// topicSize returns the width and height of a topic
+func topicSize(n interpreter.Node, depth int) (int, int) {
+	w := participantWidth(n.Label)
+	lines := strings.Count(n.Label, "\n")
+	if depth == 0 {
+		return w + 20, mindmapRootH + lines*17
+	}
+	return w, mindmapTopicH + lines*17
+}
+
+// ComputeMindmapLayout places the topics of a mind map on rings around
+// the central topic, the first topic that has no parent
+func ComputeMindmapLayout(d interpreter.Diagram) MindmapLayout {
+	var l MindmapLayout
+	if len(d.Nodes) == 0 {
+		return l
+	}
+
+	nodes := map[string]interpreter.Node{}
+	for _, n := range d.Nodes {
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+		}
+	}
+	children := map[string][]string{}
+	hasParent := map[string]bool{}
+	for _, e := range d.Edges {
+		if _, ok := nodes[e.To]; !ok || hasParent[e.To] {
+			continue
+		}
+		children[e.From] = append(children[e.From], e.To)
+		hasParent[e.To] = true
+	}
+	root := d.Nodes[0].ID
+	for _, n := range d.Nodes {
+		if !hasParent[n.ID] {
+			root = n.ID
+			break
+		}
+	}
+
+	// Leaves below every topic, a topic without children counts as one.
+	// visited stops at cycles, Validate has already reported them.
+	leaves := map[string]int{}
+	visited := map[string]bool{}
+	var count func(id string) int
+	count = func(id string) int {
+		visited[id] = true
+		total := 0
+		for _, c := range children[id] {
+			if !visited[c] {
+				total += count(c)
+			}
+		}
+		leaves[id] = max(total, 1)
+		return leaves[id]
+	}
+	count(root)
+
+	// Give every topic its part of the circle, from start to start+span
+	type placed struct {
+		id    string
+		depth int
+		angle float64
+		span  float64
+	}
+	var order []placed
+	assigned := map[string]bool{}
+	var assign func(id string, depth int, start, span float64)
+	assign = func(id string, depth int, start, span float64) {
+		assigned[id] = true
+		order = append(order, placed{id: id, depth: depth, angle: start + span/2, span: span})
+		for _, c := range children[id] {
+			if assigned[c] {
+				continue // closes a cycle
+			}
+			part := span * float64(leaves[c]) / float64(leaves[id])
+			assign(c, depth+1, start, part)
+			start += part
+		}
+	}
+	// The first branch points straight up, the rest follow clockwise
+	start := -math.Pi / 2
+	if cs := children[root]; len(cs) > 0 {
+		start -= math.Pi * float64(leaves[cs[0]]) / float64(leaves[root])
+	}
+	assign(root, 0, start, 2*math.Pi)
+
+	// Each ring is far enough out for the topics on it to fit their part
+	// of the circle, and far enough from the ring inside it
+	rings := []float64{0}
+	for _, t := range order {
+		for len(rings) <= t.depth {
+			rings = append(rings, rings[len(rings)-1]+mindmapRingGap)
+		}
+		if t.depth == 0 {
+			continue
+		}
+		w, h := topicSize(nodes[t.id], t.depth)
+		need := float64(max(w, h)+mindmapTopicGap) / t.span
+		rings[t.depth] = math.Max(rings[t.depth], need)
+	}
+	for i := 2; i < len(rings); i++ {
+		rings[i] = math.Max(rings[i], rings[i-1]+mindmapRingGap)
+	}
+
+	// Positions around 0, 0 first, then moved inside the margin
+	index := map[string]int{}
+	minX, minY, maxX, maxY := math.MaxInt, math.MaxInt, math.MinInt, math.MinInt
+	for _, t := range order {
+		n := nodes[t.id]
+		w, h := topicSize(n, t.depth)
+		r := rings[t.depth]
+		x := int(math.Round(r * math.Cos(t.angle)))
+		y := int(math.Round(r * math.Sin(t.angle)))
+		index[t.id] = len(l.Topics)
+		l.Topics = append(l.Topics, PositionedTopic{Node: n, X: x, Y: y, W: w, H: h, Depth: t.depth, Angle: t.angle})
+		minX, maxX = min(minX, x-w/2), max(maxX, x+w/2)
+		minY, maxY = min(minY, y-h/2), max(maxY, y+h/2)
+	}
+	for i := range l.Topics {
+		l.Topics[i].X += mindmapMargin - minX
+		l.Topics[i].Y += mindmapMargin - minY
+	}
+	l.Width = maxX - minX + 2*mindmapMargin
+	l.Height = maxY - minY + 2*mindmapMargin
+
+	// Branches leave the parent in the direction of the child
+	for _, e := range d.Edges {
+		fi, okFrom := index[e.From]
+		ti, okTo := index[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		from, to := l.Topics[fi], l.Topics[ti]
+		if to.Depth != from.Depth+1 {
+			continue // not a branch of the tree
+		}
+		r := rings[from.Depth]
+		offsetX, offsetY := mindmapMargin-minX, mindmapMargin-minY
+		l.Branches = append(l.Branches, PositionedBranch{
+			Edge:  e,
+			FromX: from.X, FromY: from.Y,
+			CtrlX: int(math.Round(r*math.Cos(to.Angle))) + offsetX,
+			CtrlY: int(math.Round(r*math.Sin(to.Angle))) + offsetY,
+			ToX:   to.X, ToY: to.Y,
+			Depth: to.Depth,
+		})
+	}
+	return l
+}
+
+// RenderMindmapSVG draws a mind map
+func RenderMindmapSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	l := ComputeMindmapLayout(d)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Branches first so the topics cover their ends,
+	// thinner the further out they are
+	for _, b := range l.Branches {
+		width := max(2, 7-b.Depth*2)
+		sb.WriteString(fmt.Sprintf(
+			`  <path d="M %d %d Q %d %d, %d %d" fill="none" stroke="%s" stroke-width="%d" stroke-linecap="round"/>`+"\n",
+			b.FromX, b.FromY, b.CtrlX, b.CtrlY, b.ToX, b.ToY, b.Edge.Color, width,
+		))
+	}
+
+	// Topics, the central one as an ellipse
+	for _, t := range l.Topics {
+		n := t.Node
+		if t.Depth == 0 {
+			sb.WriteString(fmt.Sprintf(
+				`  <ellipse cx="%d" cy="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="3"/>`+"\n",
+				t.X, t.Y, t.W/2, t.H/2, n.Color, n.Border,
+			))
+			writeText(&sb, t.X, t.Y+6, 18, "middle", string(n.Text), n.Label)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			t.X-t.W/2, t.Y-t.H/2, t.W, t.H, mindmapTopicH/2, mindmapTopicH/2, n.Color, n.Border,
+		))
+		writeText(&sb, t.X, t.Y+5, 14, "middle", string(n.Text), n.Label)
+	}
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
@@ -0,0 +1,433 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout and SVG for sequence diagrams.
+// Participants are placed in a row at the top with a lifeline going down,
+// the steps are placed top to bottom in the order they are written.
+
+// Sequence layout constants
+const (
+	seqStartX      = 100 // x of the first participant
+	seqGapX        = 180 // minimum distance between participants
+	seqHeaderY     = 30  // top of the participant boxes
+	seqHeaderH     = 40  // height of the participant boxes
+	seqMessageGap  = 40  // vertical space per message
+	seqSelfHeight  = 30  // height of the loop of a message to itself
+	seqActivationW = 10  // width of an activation bar
+	seqNoteW       = 140 // width of a note over or beside one participant
+	seqNoteH       = 36  // height of a note
+)
+
+// SequenceLayout is the computed layout of a sequence diagram
+type SequenceLayout struct {
+	Participants []PositionedNode // X is the lifeline, Y the middle of the box
+	Messages     []PositionedMessage
+	Activations  []PositionedActivation
+	Notes        []PositionedNote
+	Fragments    []PositionedFragment
+	Width        int
+	Height       int // also where the lifelines end
+}
+
+// PositionedMessage is a message arrow at height Y
+type PositionedMessage struct {
+	Step       interpreter.Step
+	FromX, ToX int
+	Y          int
+	Self       bool // a message to itself, drawn as a small loop
+}
+
+// PositionedActivation is an activation bar on a lifeline
+type PositionedActivation struct {
+	Participant string
+	X, Y1, Y2   int
+}
+
+// PositionedNote is a note box, X, Y is the top left corner
+type PositionedNote struct {
+	Step       interpreter.Step
+	X, Y, W, H int
+}
+
+// PositionedFragment is a loop/alt/opt box. SectionY is the top of each
+// section, a dashed line is drawn there between the sections of an alt.
+type PositionedFragment struct {
+	Fragment   *interpreter.Fragment
+	X, Y, W, H int
+	SectionY   []int
+}
+
+// participantWidth estimates the width of a participant box from its label
+func participantWidth(label string) int {
+	longest := 0
+	for _, line := range strings.Split(label, "\n") {
+		longest = max(longest, utf8.RuneCountInString(line))
+	}
+	return max(100, longest*8+24)
+}
+
+// ComputeSequenceLayout places the participants and steps of a sequence diagram
+func ComputeSequenceLayout(d interpreter.Diagram) SequenceLayout {
+	var l SequenceLayout
+
+	// Participants in a row, far enough apart for their labels
+	xOf := map[string]int{}
+	index := map[string]int{}
+	x := seqStartX
+	for i, n := range d.Nodes {
+		if i > 0 {
+			prev := d.Nodes[i-1]
+			x += max(seqGapX, participantWidth(prev.Label)/2+participantWidth(n.Label)/2+40)
+		}
+		xOf[n.ID] = x
+		index[n.ID] = i
+		l.Participants = append(l.Participants, PositionedNode{Node: n, X: x, Y: seqHeaderY + seqHeaderH/2})
+	}
+
+	y := seqHeaderY + seqHeaderH + 20
+	active := map[string][]int{} // start heights of open activations
+
+	// side returns the x where a message leaves or reaches a lifeline,
+	// at the edge of the activation bar facing the other participant
+	side := func(id string, towards int) int {
+		px := xOf[id]
+		level := len(active[id])
+		if level == 0 {
+			return px
+		}
+		shift := (level - 1) * seqActivationW / 2 // nested bars are moved right
+		if towards < px {
+			return px - seqActivationW/2 + shift
+		}
+		return px + seqActivationW/2 + shift
+	}
+
+	var walk func(steps []interpreter.Step, depth int)
+	walk = func(steps []interpreter.Step, depth int) {
+		for _, s := range steps {
+			switch s.Kind {
+			case interpreter.StepMessage:
+				y += seqMessageGap
+				from, to := s.Edge.From, s.Edge.To
+				if from == to {
+					px := side(from, xOf[from]+1)
+					l.Messages = append(l.Messages, PositionedMessage{Step: s, FromX: px, ToX: px, Y: y, Self: true})
+					y += seqSelfHeight
+					continue
+				}
+				l.Messages = append(l.Messages, PositionedMessage{
+					Step:  s,
+					FromX: side(from, xOf[to]),
+					ToX:   side(to, xOf[from]),
+					Y:     y,
+				})
+
+			case interpreter.StepActivate:
+				id := s.Participants[0]
+				active[id] = append(active[id], y)
+
+			case interpreter.StepDeactivate:
+				id := s.Participants[0]
+				if n := len(active[id]); n > 0 {
+					l.Activations = append(l.Activations, activationBar(id, xOf[id], n, active[id][n-1], y+10))
+					active[id] = active[id][:n-1]
+				}
+
+			case interpreter.StepNote:
+				y += 15
+				note := PositionedNote{Step: s, Y: y, W: seqNoteW, H: seqNoteH}
+				first := xOf[s.Participants[0]]
+				switch s.Placement {
+				case "left":
+					note.X = first - seqNoteW - 15
+				case "right":
+					note.X = first + 15
+				default:
+					last := first
+					if len(s.Participants) > 1 {
+						last = xOf[s.Participants[1]]
+					}
+					lo, hi := min(first, last), max(first, last)
+					note.X = lo - seqNoteW/2
+					note.W = hi - lo + seqNoteW
+				}
+				lines := strings.Count(s.Text, "\n")
+				note.H += lines * 17
+				l.Notes = append(l.Notes, note)
+				y += note.H
+
+			case interpreter.StepFragment:
+				y += 20
+				lo, hi := fragmentRange(s.Fragment, index)
+				if lo < 0 {
+					lo, hi = 0, len(d.Nodes)-1
+				}
+				inset := depth * 8
+				f := PositionedFragment{Fragment: s.Fragment, Y: y}
+				if len(d.Nodes) > 0 {
+					f.X = l.Participants[lo].X - 80 + inset
+					f.W = l.Participants[hi].X - l.Participants[lo].X + 160 - 2*inset
+				}
+				for i, section := range s.Fragment.Sections {
+					if i > 0 {
+						y += 15
+					}
+					f.SectionY = append(f.SectionY, y)
+					y += 10
+					walk(section.Steps, depth+1)
+					y += 20
+				}
+				f.H = y - f.Y
+				l.Fragments = append(l.Fragments, f)
+			}
+		}
+	}
+	walk(d.Steps, 0)
+
+	y += 30
+
+	// Activations that are never deactivated end at the bottom
+	for _, n := range d.Nodes {
+		for level := len(active[n.ID]); level > 0; level-- {
+			l.Activations = append(l.Activations, activationBar(n.ID, xOf[n.ID], level, active[n.ID][level-1], y-10))
+		}
+	}
+
+	l.Height = y
+	l.Width = x + seqGapX/2 + seqNoteW
+	for _, f := range l.Fragments {
+		l.Width = max(l.Width, f.X+f.W+20)
+	}
+
+	// Notes and fragments can stick out to the left of the first
+	// participant, move everything right so they stay inside the SVG
+	left := 0
+	for _, n := range l.Notes {
+		left = min(left, n.X-10)
+	}
+	for _, f := range l.Fragments {
+		left = min(left, f.X-10)
+	}
+	if left < 0 {
+		l.shift(-left)
+	}
+	return l
+}
+
+// shift moves the whole layout dx to the right
+func (l *SequenceLayout) shift(dx int) {
+	for i := range l.Participants {
+		l.Participants[i].X += dx
+	}
+	for i := range l.Messages {
+		l.Messages[i].FromX += dx
+		l.Messages[i].ToX += dx
+	}
+	for i := range l.Activations {
+		l.Activations[i].X += dx
+	}
+	for i := range l.Notes {
+		l.Notes[i].X += dx
+	}
+	for i := range l.Fragments {
+		l.Fragments[i].X += dx
+	}
+	l.Width += dx
+}
+
+// activationBar returns the bar for the activation at the given nesting level
+func activationBar(id string, x, level, y1, y2 int) PositionedActivation {
+	return PositionedActivation{
+		Participant: id,
+		X:           x - seqActivationW/2 + (level-1)*seqActivationW/2,
+		Y1:          y1,
+		Y2:          y2,
+	}
+}
+
+// fragmentRange returns the lowest and highest index of the participants
+// used inside a fragment, or -1, -1 when no participant is used
+func fragmentRange(f *interpreter.Fragment, index map[string]int) (int, int) {
+	lo, hi := -1, -1
+	use := func(id string) {
+		i, ok := index[id]
+		if !ok {
+			return
+		}
+		if lo < 0 || i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+	}
+
+	var walk func(steps []interpreter.Step)
+	walk = func(steps []interpreter.Step) {
+		for _, s := range steps {
+			switch s.Kind {
+			case interpreter.StepMessage:
+				use(s.Edge.From)
+				use(s.Edge.To)
+			case interpreter.StepFragment:
+				for _, section := range s.Fragment.Sections {
+					walk(section.Steps)
+				}
+			default:
+				for _, id := range s.Participants {
+					use(id)
+				}
+			}
+		}
+	}
+	for _, section := range f.Sections {
+		walk(section.Steps)
+	}
+	return lo, hi
+}
+
+// RenderSequenceSVG renders a sequence diagram with lifelines,
+// activation bars, messages, notes and fragments
+func RenderSequenceSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	l := ComputeSequenceLayout(d)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Fragments in the background
+	for _, f := range l.Fragments {
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="0.4" stroke="%s" stroke-width="1"/>`+"\n",
+			f.X, f.Y, f.W, f.H, th.Grid, th.Muted,
+		))
+		tagW := utf8.RuneCountInString(f.Fragment.Kind)*8 + 16
+		sb.WriteString(fmt.Sprintf(
+			`  <path d="M %d %d h %d v 12 l -8 8 h %d z" fill="%s" stroke="%s" stroke-width="1"/>`+"\n",
+			f.X, f.Y, tagW, -(tagW - 8), th.Grid, th.Muted,
+		))
+		writeText(&sb, f.X+6, f.Y+14, 12, "start", string(th.Text), f.Fragment.Kind)
+
+		for i, section := range f.Fragment.Sections {
+			sy := f.SectionY[i]
+			if i > 0 {
+				sb.WriteString(fmt.Sprintf(
+					`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1" stroke-dasharray="6,4"/>`+"\n",
+					f.X, sy, f.X+f.W, sy, th.Muted,
+				))
+			}
+			if section.Label != "" {
+				labelX := f.X + 10
+				if i == 0 {
+					labelX = f.X + tagW + 8
+				}
+				writeText(&sb, labelX, sy+14, 12, "start", string(th.Text), "["+section.Label+"]")
+			}
+		}
+	}
+
+	// Lifelines
+	for _, p := range l.Participants {
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1" stroke-dasharray="4,4"/>`+"\n",
+			p.X, seqHeaderY+seqHeaderH, p.X, l.Height-10, th.Muted,
+		))
+	}
+
+	// Activation bars
+	for _, a := range l.Activations {
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="1"/>`+"\n",
+			a.X, a.Y1, seqActivationW, a.Y2-a.Y1, th.Surface, th.EdgeColor,
+		))
+	}
+
+	// Messages
+	for _, m := range l.Messages {
+		e := m.Step.Edge
+		dash := ""
+		marker := "seq-open"
+		switch m.Step.Message {
+		case interpreter.MessageSync:
+			marker = "seq-filled"
+		case interpreter.MessageReturn:
+			dash = ` stroke-dasharray="6,4"`
+		}
+
+		if m.Self {
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d h 40 v %d h -40" fill="none" stroke="%s" stroke-width="%s"%s marker-end="url(#%s)"/>`+"\n",
+				m.FromX, m.Y, seqSelfHeight, e.Color, e.Width, dash, marker,
+			))
+			writeText(&sb, m.FromX+46, m.Y+seqSelfHeight/2+4, 12, "start", string(th.EdgeColor), e.Label)
+			continue
+		}
+
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s"%s marker-end="url(#%s)"/>`+"\n",
+			m.FromX, m.Y, m.ToX, m.Y, e.Color, e.Width, dash, marker,
+		))
+		lines := strings.Count(e.Label, "\n")
+		writeText(&sb, (m.FromX+m.ToX)/2, m.Y-6-lines*8, 12, "middle", string(th.EdgeColor), e.Label)
+	}
+
+	// Notes
+	for _, n := range l.Notes {
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="%s" stroke-width="1"/>`+"\n",
+			n.X, n.Y, n.W, n.H, th.NoteColor, th.NoteBorder,
+		))
+		writeText(&sb, n.X+n.W/2, n.Y+n.H/2+4, 12, "middle", string(th.EdgeColor), n.Step.Text)
+	}
+
+	// Participants on top of the lifelines
+	for _, p := range l.Participants {
+		n := p.Node
+		if n.Shape == "actor" {
+			writeActor(&sb, p.X, seqHeaderY, n)
+			continue
+		}
+		w := participantWidth(n.Label)
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="4" ry="4" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			p.X-w/2, seqHeaderY, w, seqHeaderH, n.Color, n.Border,
+		))
+		writeText(&sb, p.X, p.Y+5, 14, "middle", string(n.Text), n.Label)
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+  <defs>
+    <marker id="seq-filled" viewBox="0 0 10 10" refX="9" refY="5"
+            markerWidth="6" markerHeight="6" orient="auto-start-reverse">
+      <path d="M 0 0 L 10 5 L 0 10 z" fill="%[1]s"/>
+    </marker>
+    <marker id="seq-open" viewBox="0 0 10 10" refX="9" refY="5"
+            markerWidth="7" markerHeight="7" orient="auto-start-reverse">
+      <path d="M 0 0 L 10 5 L 0 10" fill="none" stroke="%[1]s" stroke-width="1.5"/>
+    </marker>
+  </defs>
+`, th.EdgeColor))
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
+
+// writeActor draws a stick figure with the label below it
+func writeActor(sb *strings.Builder, x, top int, n interpreter.Node) {
+	sb.WriteString(fmt.Sprintf(
+		`  <g fill="none" stroke="%s" stroke-width="2">`+"\n"+
+			`    <circle cx="%d" cy="%d" r="5" fill="%s"/>`+"\n"+
+			`    <path d="M %d %d v 12 M %d %d h 16 M %d %d l -7 9 M %d %d l 7 9"/>`+"\n"+
+			`  </g>`+"\n",
+		n.Border,
+		x, top+5, n.Color,
+		x, top+10, x-8, top+14, x, top+22, x, top+22,
+	))
+	writeText(sb, x, top+seqHeaderH+2, 13, "middle", string(n.Text), n.Label)
+}
@@ -0,0 +1,210 @@
+package renderer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The node shapes of flowcharts and trees.
+// Every shape can draw itself in a box of w x h around its centre and
+// tell where a line from the centre leaves its outline, so edges end on
+// the outline instead of somewhere inside or outside the node.
+// A shape that is not in the registry is drawn as rect.
+
+// shape is one entry in the shape registry
+type shape struct {
+	// draw writes the outline of the shape centred on x, y
+	draw func(sb *strings.Builder, x, y, w, h int, fill, stroke string)
+	// boundary returns the point, relative to the centre, where a line
+	// from the centre in the direction dx, dy crosses the outline
+	boundary func(w, h int, dx, dy float64) (float64, float64)
+}
+
+// Sizes of the details of some shapes
+const (
+	shapeCorner = 10 // rounding of the corners of rect
+	shapeFold   = 12 // size of the folded corner of a note
+	shapeCap    = 8  // height of half the top and bottom of a cylinder
+)
+
+// shapes is the shape registry
+var shapes = map[string]shape{
+	"rect": {
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			sb.WriteString(fmt.Sprintf(
+				`  <rect x="%d" y="%d" width="%d" height="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				x-w/2, y-h/2, w, h, shapeCorner, shapeCorner, fill, stroke,
+			))
+		},
+		boundary: boxBoundary,
+	},
+	"ellipse": {
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			sb.WriteString(fmt.Sprintf(
+				`  <ellipse cx="%d" cy="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				x, y, w/2, h/2, fill, stroke,
+			))
+		},
+		boundary: ellipseBoundary,
+	},
+	"circle": {
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			sb.WriteString(fmt.Sprintf(
+				`  <circle cx="%d" cy="%d" r="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				x, y, circleRadius(w, h), fill, stroke,
+			))
+		},
+		boundary: func(w, h int, dx, dy float64) (float64, float64) {
+			r := 2 * circleRadius(w, h)
+			return ellipseBoundary(r, r, dx, dy)
+		},
+	},
+	"diamond": polygonShape(func(w, h float64) [][2]float64 {
+		return [][2]float64{{0, -h / 2}, {w / 2, 0}, {0, h / 2}, {-w / 2, 0}}
+	}),
+	"parallelogram": polygonShape(func(w, h float64) [][2]float64 {
+		skew := h / 3
+		return [][2]float64{{-w/2 + skew, -h / 2}, {w / 2, -h / 2}, {w/2 - skew, h / 2}, {-w / 2, h / 2}}
+	}),
+	"hexagon": polygonShape(func(w, h float64) [][2]float64 {
+		inset := h / 3
+		return [][2]float64{
+			{-w / 2, 0}, {-w/2 + inset, -h / 2}, {w/2 - inset, -h / 2},
+			{w / 2, 0}, {w/2 - inset, h / 2}, {-w/2 + inset, h / 2},
+		}
+	}),
+	"cylinder": {
+		// The body with the bottom curve, then the whole top ellipse over it
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			left, right, top, bottom := x-w/2, x+w/2, y-h/2, y+h/2
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d L %d %d A %d %d 0 0 0 %d %d L %d %d Z" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				left, top+shapeCap, left, bottom-shapeCap, w/2, shapeCap, right, bottom-shapeCap, right, top+shapeCap, fill, stroke,
+			))
+			sb.WriteString(fmt.Sprintf(
+				`  <ellipse cx="%d" cy="%d" rx="%d" ry="%d" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				x, top+shapeCap, w/2, shapeCap, fill, stroke,
+			))
+		},
+		boundary: boxBoundary,
+	},
+	"note": {
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			left, right, top, bottom := x-w/2, x+w/2, y-h/2, y+h/2
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d L %d %d L %d %d L %d %d L %d %d Z" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				left, top, right-shapeFold, top, right, top+shapeFold, right, bottom, left, bottom, fill, stroke,
+			))
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d L %d %d L %d %d" fill="none" stroke="%s" stroke-width="2"/>`+"\n",
+				right-shapeFold, top, right-shapeFold, top+shapeFold, right, top+shapeFold, stroke,
+			))
+		},
+		boundary: boxBoundary,
+	},
+}
+
+// lookupShape returns the shape with the given name, or rect
+func lookupShape(name string) shape {
+	if s, ok := shapes[name]; ok {
+		return s
+	}
+	return shapes["rect"]
+}
+
+// drawNode draws the shape of a positioned node and its label
+func drawNode(sb *strings.Builder, pn PositionedNode) {
+	n := pn.Node
+	w, h := nodeSize(n)
+	lookupShape(n.Shape).draw(sb, pn.X, pn.Y, w, h, string(n.Color), string(n.Border))
+	writeText(sb, pn.X, pn.Y+5, nodeFontSize, "middle", string(n.Text), strings.Join(nodeLines(n), "\n"))
+}
+
+// attach returns the ends of an edge between two positioned nodes,
+// where the line between their centres crosses their outlines
+func attach(from, to PositionedNode) (x1, y1, x2, y2 int) {
+	dx, dy := float64(to.X-from.X), float64(to.Y-from.Y)
+	if dx == 0 && dy == 0 {
+		return from.X, from.Y, to.X, to.Y
+	}
+	fw, fh := nodeSize(from.Node)
+	tw, th := nodeSize(to.Node)
+	fx, fy := lookupShape(from.Node.Shape).boundary(fw, fh, dx, dy)
+	tx, ty := lookupShape(to.Node.Shape).boundary(tw, th, -dx, -dy)
+	return from.X + round(fx), from.Y + round(fy), to.X + round(tx), to.Y + round(ty)
+}
+
+// round rounds to the nearest int
+func round(f float64) int {
+	return int(math.Round(f))
+}
+
+// circleRadius is the radius of a circle in a w x h box,
+// between the half height and the half width so labels fit
+func circleRadius(w, h int) int {
+	return (w + h) / 4
+}
+
+// boxBoundary is the boundary of a w x h rectangle
+func boxBoundary(w, h int, dx, dy float64) (float64, float64) {
+	t := math.Inf(1)
+	if dx != 0 {
+		t = float64(w) / 2 / math.Abs(dx)
+	}
+	if dy != 0 {
+		t = math.Min(t, float64(h)/2/math.Abs(dy))
+	}
+	return dx * t, dy * t
+}
+
+// ellipseBoundary is the boundary of the ellipse that fills a w x h box
+func ellipseBoundary(w, h int, dx, dy float64) (float64, float64) {
+	a, b := float64(w)/2, float64(h)/2
+	t := 1 / math.Sqrt(dx*dx/(a*a)+dy*dy/(b*b))
+	return dx * t, dy * t
+}
+
+// polygonShape makes a shape from the corners of a polygon, given
+// relative to the centre for a w x h box
+func polygonShape(corners func(w, h float64) [][2]float64) shape {
+	return shape{
+		draw: func(sb *strings.Builder, x, y, w, h int, fill, stroke string) {
+			var points []string
+			for _, c := range corners(float64(w), float64(h)) {
+				points = append(points, fmt.Sprintf("%d,%d", x+round(c[0]), y+round(c[1])))
+			}
+			sb.WriteString(fmt.Sprintf(
+				`  <polygon points="%s" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				strings.Join(points, " "), fill, stroke,
+			))
+		},
+		boundary: func(w, h int, dx, dy float64) (float64, float64) {
+			return polygonBoundary(corners(float64(w), float64(h)), dx, dy)
+		},
+	}
+}
+
+// polygonBoundary returns where the ray from the origin in the direction
+// dx, dy leaves a convex polygon around the origin
+func polygonBoundary(corners [][2]float64, dx, dy float64) (float64, float64) {
+	best := math.Inf(1)
+	for i := range corners {
+		a, b := corners[i], corners[(i+1)%len(corners)]
+		ex, ey := b[0]-a[0], b[1]-a[1]
+		// Solve origin + t*(dx, dy) = a + s*(ex, ey)
+		det := dx*ey - dy*ex
+		if det == 0 {
+			continue // parallel
+		}
+		t := (a[0]*ey - a[1]*ex) / det
+		s := (a[0]*dy - a[1]*dx) / det
+		if t > 0 && s >= 0 && s <= 1 {
+			best = math.Min(best, t)
+		}
+	}
+	if math.IsInf(best, 1) {
+		return 0, 0
+	}
+	return dx * best, dy * best
+}
@@ -0,0 +1,376 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout and SVG for state diagrams.
+// States are placed in layers from top to bottom, a transition goes from
+// one layer to a lower one. Transitions that close a cycle are left out
+// when the layers are computed and drawn as curves instead. A composite
+// state is laid out the same way on its own and then placed as one
+// big state in the layers around it.
+
+// State layout constants
+const (
+	stateGapX    = 60 // horizontal space between states in a layer
+	stateGapY    = 70 // vertical space between layers
+	stateHeight  = 50 // height of a simple state
+	statePseudo  = 26 // diameter of the initial and final states
+	stateMargin  = 40 // space around the whole diagram
+	stateLoop    = 40 // how far a self-transition sticks out
+	stateCurve   = 60 // how far a transition that goes up bends sideways
+	compositeTop = groupLabel + 8
+)
+
+// StateLayout is the computed layout of a state diagram
+type StateLayout struct {
+	States      []PositionedNode  // X, Y is the centre of the state
+	Composites  []PositionedGroup // outer composite states first
+	Transitions []PositionedTransition
+	Width       int
+	Height      int
+}
+
+// PositionedTransition is a transition between two states. Straight
+// transitions go from (FromX, FromY) to (ToX, ToY), curved ones bend
+// around the control point (CtrlX, CtrlY).
+type PositionedTransition struct {
+	Edge           interpreter.Edge
+	FromX, FromY   int
+	ToX, ToY       int
+	CtrlX, CtrlY   int
+	Curved         bool
+	Self           bool
+	LabelX, LabelY int
+}
+
+// stateItem is a state or composite state while the layout is computed.
+// X, Y is the top left corner relative to the scope it is in.
+type stateItem struct {
+	id       string
+	node     interpreter.Node
+	group    *interpreter.Group // nil for simple states
+	children []*stateItem
+	offset   int
+	w, h     int
+	x, y     int
+}
+
+// stateSize returns the width and height of a simple state
+func stateSize(n interpreter.Node) (int, int) {
+	if n.Shape == "initial" || n.Shape == "final" {
+		return statePseudo, statePseudo
+	}
+	lines := strings.Count(n.Label, "\n")
+	return participantWidth(n.Label), stateHeight + lines*17
+}
+
+// ComputeStateLayout places the states, composite states and transitions
+func ComputeStateLayout(d interpreter.Diagram) StateLayout {
+	var l StateLayout
+
+	nodes := map[string]interpreter.Node{}
+	for _, n := range d.Nodes {
+		if _, ok := nodes[n.ID]; !ok {
+			nodes[n.ID] = n
+		}
+	}
+
+	// parent maps every state and composite state to the composite
+	// state it is directly inside, "" for the top level
+	parent := map[string]string{}
+	inGroup := map[string]bool{}
+	var walk func(gs []interpreter.Group, owner string)
+	walk = func(gs []interpreter.Group, owner string) {
+		for _, g := range gs {
+			parent[g.ID] = owner
+			for _, id := range g.Nodes {
+				parent[id] = g.ID
+				inGroup[id] = true
+			}
+			walk(g.Groups, g.ID)
+		}
+	}
+	walk(d.Groups, "")
+
+	// scope returns the item in the scope that contains id, or "" if
+	// id is not inside the scope at all
+	scope := func(id, owner string) string {
+		for range len(parent) + 1 { // bounded, duplicate IDs could form a loop
+			p, ok := parent[id]
+			if !ok {
+				p = ""
+			}
+			if p == owner {
+				return id
+			}
+			if p == "" {
+				return ""
+			}
+			id = p
+		}
+		return ""
+	}
+
+	// build lays out the states directly in one scope and returns them
+	var build func(ids []string, groups []interpreter.Group) []*stateItem
+	build = func(ids []string, groups []interpreter.Group) []*stateItem {
+		var items []*stateItem
+		for _, id := range ids {
+			n, ok := nodes[id]
+			if !ok {
+				continue
+			}
+			it := &stateItem{id: id, node: n, offset: n.Pos.Offset}
+			it.w, it.h = stateSize(n)
+			items = append(items, it)
+		}
+		for i := range groups {
+			g := &groups[i]
+			it := &stateItem{id: g.ID, group: g, offset: g.Pos.Offset}
+			it.children = build(g.Nodes, g.Groups)
+			w, h := layoutStateScope(it.children, d.Edges, func(id string) string { return scope(id, g.ID) })
+			it.w = max(w+2*groupPadding, participantWidth(g.Label)+2*groupPadding)
+			it.h = h + 2*groupPadding + compositeTop
+			items = append(items, it)
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].offset < items[j].offset })
+		return items
+	}
+
+	var topIDs []string
+	for _, n := range d.Nodes {
+		if !inGroup[n.ID] {
+			topIDs = append(topIDs, n.ID)
+		}
+	}
+	top := build(topIDs, d.Groups)
+	w, h := layoutStateScope(top, d.Edges, func(id string) string { return scope(id, "") })
+
+	// Turn the relative positions into absolute ones
+	type box struct {
+		cx, cy, hw, hh int
+		round          bool
+	}
+	boxes := map[string]box{}
+	var place func(items []*stateItem, ox, oy, depth int)
+	place = func(items []*stateItem, ox, oy, depth int) {
+		for _, it := range items {
+			x, y := ox+it.x, oy+it.y
+			round := it.group == nil && (it.node.Shape == "initial" || it.node.Shape == "final")
+			boxes[it.id] = box{x + it.w/2, y + it.h/2, it.w / 2, it.h / 2, round}
+			if it.group == nil {
+				l.States = append(l.States, PositionedNode{Node: it.node, X: x + it.w/2, Y: y + it.h/2})
+				continue
+			}
+			l.Composites = append(l.Composites, PositionedGroup{Group: *it.group, X: x, Y: y, W: it.w, H: it.h, Depth: depth})
+			place(it.children, x+groupPadding, y+groupPadding+compositeTop, depth+1)
+		}
+	}
+	place(top, stateMargin, stateMargin, 0)
+	sort.SliceStable(l.Composites, func(i, j int) bool {
+		return l.Composites[i].Depth < l.Composites[j].Depth
+	})
+	l.Width = w + 2*stateMargin
+	l.Height = h + 2*stateMargin
+
+	// clip returns the point where the line from the centre of b
+	// towards (tx, ty) leaves the box or circle
+	clip := func(b box, tx, ty int) (int, int) {
+		dx, dy := float64(tx-b.cx), float64(ty-b.cy)
+		if dx == 0 && dy == 0 {
+			return b.cx, b.cy
+		}
+		var t float64
+		if b.round {
+			t = float64(b.hw) / math.Hypot(dx, dy)
+		} else {
+			t = math.Inf(1)
+			if dx != 0 {
+				t = float64(b.hw) / math.Abs(dx)
+			}
+			if dy != 0 {
+				t = math.Min(t, float64(b.hh)/math.Abs(dy))
+			}
+		}
+		return b.cx + int(math.Round(dx*t)), b.cy + int(math.Round(dy*t))
+	}
+
+	for _, e := range d.Edges {
+		from, okFrom := boxes[e.From]
+		to, okTo := boxes[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		t := PositionedTransition{Edge: e}
+
+		switch {
+		case e.From == e.To:
+			// A loop on the right side of the state
+			t.Self = true
+			t.FromX, t.FromY = from.cx+from.hw, from.cy-10
+			t.ToX, t.ToY = from.cx+from.hw, from.cy+10
+			t.LabelX, t.LabelY = from.cx+from.hw+stateLoop+4, from.cy+4
+
+		case to.cy > from.cy+from.hh:
+			// Downwards, a straight line
+			t.FromX, t.FromY = clip(from, to.cx, to.cy)
+			t.ToX, t.ToY = clip(to, from.cx, from.cy)
+			t.LabelX, t.LabelY = (t.FromX+t.ToX)/2+8, (t.FromY+t.ToY)/2-4
+
+		default:
+			// Upwards or sideways, bend to the right of the direction
+			dx, dy := float64(to.cx-from.cx), float64(to.cy-from.cy)
+			length := math.Max(math.Hypot(dx, dy), 1)
+			t.Curved = true
+			t.CtrlX = (from.cx+to.cx)/2 + int(-dy/length*stateCurve*2)
+			t.CtrlY = (from.cy+to.cy)/2 + int(dx/length*stateCurve*2)
+			t.FromX, t.FromY = clip(from, t.CtrlX, t.CtrlY)
+			t.ToX, t.ToY = clip(to, t.CtrlX, t.CtrlY)
+			// The middle of the curve
+			t.LabelX = (t.FromX+2*t.CtrlX+t.ToX)/4 + 6
+			t.LabelY = (t.FromY+2*t.CtrlY+t.ToY)/4 + 4
+		}
+		// Make room for the curve and the label
+		l.Width = max(l.Width, t.CtrlX+stateMargin, t.LabelX+transitionLabelWidth(e)+stateMargin/2)
+		l.Height = max(l.Height, t.CtrlY+stateMargin, t.LabelY+stateMargin)
+		l.Transitions = append(l.Transitions, t)
+	}
+	return l
+}
+
+// transitionLabel returns the label shown for a transition,
+// the canonical form when it could be parsed
+func transitionLabel(e interpreter.Edge) string {
+	if label := e.Transition.String(); label != "" {
+		return label
+	}
+	return e.Label
+}
+
+// transitionLabelWidth estimates the width of a transition label
+func transitionLabelWidth(e interpreter.Edge) int {
+	longest := 0
+	for _, line := range strings.Split(transitionLabel(e), "\n") {
+		longest = max(longest, utf8.RuneCountInString(line))
+	}
+	return longest * 7
+}
+
+// layoutStateScope places the items of one scope in layers and returns
+// the size of the scope. itemOf maps a state ID to the item in this scope
+// that contains it, or "" when it is outside the scope.
+func layoutStateScope(items []*stateItem, edges []interpreter.Edge, itemOf func(string) string) (int, int) {
+	index := map[string]int{}
+	boxes := make([]*layerBox, len(items))
+	for i, it := range items {
+		index[it.id] = i
+		boxes[i] = &layerBox{W: it.w, H: it.h}
+	}
+
+	// Transitions between different items of this scope
+	var links [][2]int
+	for _, e := range edges {
+		from, okFrom := index[itemOf(e.From)]
+		to, okTo := index[itemOf(e.To)]
+		if okFrom && okTo && from != to {
+			links = append(links, [2]int{from, to})
+		}
+	}
+
+	width, height := layerLayout(boxes, links, stateGapX, stateGapY)
+	for i, b := range boxes {
+		items[i].x, items[i].y = b.X, b.Y
+	}
+	return width, height
+}
+
+// RenderStateSVG draws a state diagram
+func RenderStateSVG(d interpreter.Diagram) string {
+	var sb strings.Builder
+	th := themeOf(d)
+	l := ComputeStateLayout(d)
+
+	writeSVGStart(&sb, l.Width, l.Height, th)
+
+	// Composite states, outer ones first
+	for _, c := range l.Composites {
+		g := c.Group
+		sb.WriteString(fmt.Sprintf(
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="14" ry="14" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+			c.X, c.Y, c.W, c.H, g.Color, g.Border,
+		))
+		sb.WriteString(fmt.Sprintf(
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="1"/>`+"\n",
+			c.X, c.Y+compositeTop, c.X+c.W, c.Y+compositeTop, g.Border,
+		))
+		writeText(&sb, c.X+c.W/2, c.Y+compositeTop/2+5, 13, "middle", string(g.Text), g.Label)
+	}
+
+	// States
+	for _, s := range l.States {
+		n := s.Node
+		switch n.Shape {
+		case "initial":
+			sb.WriteString(fmt.Sprintf(
+				`  <circle cx="%d" cy="%d" r="%d" fill="%s"/>`+"\n",
+				s.X, s.Y, statePseudo/2, n.Border,
+			))
+		case "final":
+			sb.WriteString(fmt.Sprintf(
+				`  <circle cx="%d" cy="%d" r="%d" fill="none" stroke="%s" stroke-width="2"/>`+"\n"+
+					`  <circle cx="%d" cy="%d" r="%d" fill="%s"/>`+"\n",
+				s.X, s.Y, statePseudo/2, n.Border, s.X, s.Y, statePseudo/2-5, n.Border,
+			))
+		default:
+			w, h := stateSize(n)
+			sb.WriteString(fmt.Sprintf(
+				`  <rect x="%d" y="%d" width="%d" height="%d" rx="14" ry="14" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
+				s.X-w/2, s.Y-h/2, w, h, n.Color, n.Border,
+			))
+			writeText(&sb, s.X, s.Y+5, 14, "middle", string(n.Text), n.Label)
+		}
+	}
+
+	// Transitions
+	for _, t := range l.Transitions {
+		e := t.Edge
+		switch {
+		case t.Self:
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d C %d %d, %d %d, %d %d" fill="none" stroke="%s" stroke-width="%s"%s/>`+"\n",
+				t.FromX, t.FromY, t.FromX+stateLoop, t.FromY-25, t.ToX+stateLoop, t.ToY+25, t.ToX, t.ToY, e.Color, e.Width, edgeStroke(e.Kind, "state-arrow"),
+			))
+		case t.Curved:
+			sb.WriteString(fmt.Sprintf(
+				`  <path d="M %d %d Q %d %d %d %d" fill="none" stroke="%s" stroke-width="%s"%s/>`+"\n",
+				t.FromX, t.FromY, t.CtrlX, t.CtrlY, t.ToX, t.ToY, e.Color, e.Width, edgeStroke(e.Kind, "state-arrow"),
+			))
+		default:
+			sb.WriteString(fmt.Sprintf(
+				`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s"%s/>`+"\n",
+				t.FromX, t.FromY, t.ToX, t.ToY, e.Color, e.Width, edgeStroke(e.Kind, "state-arrow"),
+			))
+		}
+
+		writeText(&sb, t.LabelX, t.LabelY, 12, "start", string(th.EdgeColor), transitionLabel(e))
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+  <defs>
+    <marker id="state-arrow" viewBox="0 0 10 10" refX="9" refY="5"
+            markerWidth="6" markerHeight="6" orient="auto-start-reverse">
+      <path d="M 0 0 L 10 5 L 0 10 z" fill="%[1]s"/>
+    </marker>
+  </defs>
+`, th.EdgeColor))
+
+	sb.WriteString(`</svg>`)
+	return sb.String()
+}
@@ -20,7 +20,24 @@ const (
 // It calculates the positions of nodes and edges based on the layout type
 // and renders them as SVG elements.
 func RenderSVG(d interpreter.Diagram) string {
+	// Diagram types with their own layout and drawing
+	switch d.Name {
+	case "sequence":
+		return RenderSequenceSVG(d)
+	case "state":
+		return RenderStateSVG(d)
+	case "er":
+		return RenderERSVG(d)
+	case "class":
+		return RenderClassSVG(d)
+	case "gantt":
+		return RenderGanttSVG(d)
+	case "mindmap":
+		return RenderMindmapSVG(d)
+	}
+
 	var sb strings.Builder
+	th := themeOf(d)
 
 	defaultHeight := 600
 	defaultWidth := 800
@@ -40,11 +57,6 @@ func RenderSVG(d interpreter.Diagram) string {
 		width = len(d.Nodes)*nodeSpacingX + margin
 	}
 
-	sb.WriteString(fmt.Sprintf(
-		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
-		width, height, width, height,
-	))
-
 	var pNodes []PositionedNode
 	var pEdges []PositionedEdge
 
@@ -65,33 +77,40 @@ func RenderSVG(d interpreter.Diagram) string {
 	case "tree":
 		pNodes, pEdges = ComputeTreeLayout(d)
 	}
+	pGroups := ComputeGroupBoxes(d, pNodes)
 
-	// Nodes
+	// Make room for group boxes and the extra space between groups
+	for _, g := range pGroups {
+		width = max(width, g.X+g.W+margin/2)
+		height = max(height, g.Y+g.H+margin/2)
+	}
 	for _, n := range pNodes {
-		x, y := n.X, n.Y
-		if n.Node.Shape == "ellipse" {
-			sb.WriteString(fmt.Sprintf(
-				`  <ellipse cx="%d" cy="%d" rx="50" ry="25" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
-				x, y, n.Node.Color, n.Node.Border,
-			))
-		} else {
-			sb.WriteString(fmt.Sprintf(
-				`  <rect x="%d" y="%d" width="100" height="50" rx="10" ry="10" fill="%s" stroke="%s" stroke-width="2"/>`+"\n",
-				x-50, y-25, n.Node.Color, n.Node.Border,
-			))
-		}
+		w, h := nodeSize(n.Node)
+		width = max(width, n.X+w/2+margin/2)
+		height = max(height, n.Y+h/2+margin/2)
+	}
 
+	writeSVGStart(&sb, width, height, th)
+
+	// Groups, drawn first so they end up behind the nodes
+	for _, g := range pGroups {
 		sb.WriteString(fmt.Sprintf(
-			`  <text x="%d" y="%d" font-size="14" text-anchor="middle" fill="%s">%s</text>`+"\n",
-			x, y+5, n.Node.Text, n.Node.Label,
+			`  <rect x="%d" y="%d" width="%d" height="%d" rx="8" ry="8" fill="%s" stroke="%s" stroke-width="1.5"/>`+"\n",
+			g.X, g.Y, g.W, g.H, g.Group.Color, g.Group.Border,
 		))
+		writeText(&sb, g.X+10, g.Y+16, 13, "start", string(g.Group.Text), g.Group.Label)
+	}
+
+	// Nodes
+	for _, n := range pNodes {
+		drawNode(&sb, n)
 	}
 
 	// Edges
 	for _, e := range pEdges {
 		sb.WriteString(fmt.Sprintf(
-			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s" marker-end="url(#arrow)"/>`+"\n",
-			e.FromX, e.FromY, e.ToX, e.ToY, e.Edge.Color, e.Edge.Width,
+			`  <line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="%s"%s/>`+"\n",
+			e.FromX, e.FromY, e.ToX, e.ToY, e.Edge.Color, e.Edge.Width, edgeStroke(e.Edge.Kind, "arrow"),
 		))
 		midX := (e.FromX + e.ToX) / 2
 		midY := (e.FromY + e.ToY) / 2
@@ -107,24 +126,104 @@ func RenderSVG(d interpreter.Diagram) string {
 			labelX -= 30
 		}
 
-		sb.WriteString(fmt.Sprintf(
-			`  <text x="%d" y="%d" font-size="12" text-anchor="start" fill="#37474f">%s</text>`+"\n",
-			labelX, labelY, e.Edge.Label,
-		))
+		writeText(&sb, labelX, labelY, 12, "start", string(th.EdgeColor), e.Edge.Label)
 
 	}
 
 	// Arrow marker
-	sb.WriteString(`
+	sb.WriteString(fmt.Sprintf(`
   <defs>
     <marker id="arrow" viewBox="0 0 10 10" refX="9" refY="5"
             markerWidth="6" markerHeight="6"
             orient="auto-start-reverse">
-      <path d="M 0 0 L 10 5 L 0 10 z" fill="#37474f"/>
+      <path d="M 0 0 L 10 5 L 0 10 z" fill="%[1]s"/>
     </marker>
   </defs>
-`)
+`, th.EdgeColor))
 
 	sb.WriteString(`</svg>`)
 	return sb.String()
 }
+
+// themeOf returns the theme of a diagram, the default theme for a
+// diagram that was not made by the parser
+func themeOf(d interpreter.Diagram) interpreter.Theme {
+	if d.Theme.Name == "" {
+		t, _ := interpreter.LookupTheme(interpreter.DefaultTheme)
+		return t
+	}
+	return d.Theme
+}
+
+// edgeStroke returns the dash pattern and the arrowheads of an edge kind
+// as SVG attributes, marker is the id of the arrowhead marker
+func edgeStroke(kind interpreter.EdgeKind, marker string) string {
+	var attrs string
+	switch kind {
+	case interpreter.EdgeDashed:
+		attrs = ` stroke-dasharray="8,4"`
+	case interpreter.EdgeDotted:
+		attrs = ` stroke-dasharray="2,4" stroke-linecap="round"`
+	}
+	switch kind {
+	case interpreter.EdgeUndirected:
+		// no arrowheads
+	case interpreter.EdgeBidirectional:
+		attrs += fmt.Sprintf(` marker-start="url(#%[1]s)" marker-end="url(#%[1]s)"`, marker)
+	default:
+		attrs += fmt.Sprintf(` marker-end="url(#%s)"`, marker)
+	}
+	return attrs
+}
+
+// writeSVGStart writes the svg element and the background of the theme
+func writeSVGStart(sb *strings.Builder, width, height int, th interpreter.Theme) {
+	sb.WriteString(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height,
+	))
+	if th.Background != "" {
+		sb.WriteString(fmt.Sprintf(`  <rect width="100%%" height="100%%" fill="%s"/>`+"\n", th.Background))
+	}
+}
+
+// lineHeight is the distance between lines of a multi-line label,
+// relative to the font size
+const lineHeight = 1.2
+
+// writeText writes a text element centred vertically around y.
+// A label with line breaks gets one tspan per line.
+func writeText(sb *strings.Builder, x, y, size int, anchor, fill, label string) {
+	lines := strings.Split(label, "\n")
+	if len(lines) == 1 {
+		sb.WriteString(fmt.Sprintf(
+			`  <text x="%d" y="%d" font-size="%d" text-anchor="%s" fill="%s">%s</text>`+"\n",
+			x, y, size, anchor, fill, escapeXML(label),
+		))
+		return
+	}
+
+	// Move the first line up so the block of lines is centred on y
+	step := float64(size) * lineHeight
+	firstY := float64(y) - step*float64(len(lines)-1)/2
+
+	sb.WriteString(fmt.Sprintf(
+		`  <text x="%d" y="%.1f" font-size="%d" text-anchor="%s" fill="%s">`,
+		x, firstY, size, anchor, fill,
+	))
+	for i, line := range lines {
+		dy := "0"
+		if i > 0 {
+			dy = fmt.Sprintf("%.1f", step)
+		}
+		sb.WriteString(fmt.Sprintf(`<tspan x="%d" dy="%s">%s</tspan>`, x, dy, escapeXML(line)))
+	}
+	sb.WriteString("</text>\n")
+}
+
+// xmlEscaper escapes the characters that are not allowed as text in SVG
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func escapeXML(s string) string {
+	return xmlEscaper.Replace(s)
+}
@@ -0,0 +1,140 @@
+package renderer
+
+import (
+	"diagra/interpreter"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Text measurement and the size of nodes.
+// There is no font to measure with when the SVG is written, so the width
+// of a text is estimated from the widths of the glyphs in a normal sans
+// serif font. It is close enough to size boxes and wrap labels, the
+// viewer may still draw a text a few pixels wider or narrower.
+
+// Node size constants
+const (
+	nodeFontSize = 14  // font size of node labels
+	nodeMinW     = 100 // a node is never narrower, not even when width is set
+	nodeMaxW     = 200 // longer labels are wrapped to fit
+	nodeMinH     = 50  // a node is never lower, not even when height is set
+	nodePadX     = 12  // space between the label and the left and right side
+	nodePadY     = 8   // space between the label and the top and bottom
+)
+
+// glyphWidth returns the estimated width of a glyph in em
+func glyphWidth(r rune) float64 {
+	switch {
+	case r == ' ':
+		return 0.28
+	case strings.ContainsRune("iljI.,:;'|!", r):
+		return 0.28
+	case strings.ContainsRune("frt()[]{}-/\"", r):
+		return 0.36
+	case strings.ContainsRune("mwMW", r):
+		return 0.85
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return 1
+	case unicode.IsUpper(r):
+		return 0.68
+	case unicode.IsDigit(r):
+		return 0.56
+	case unicode.IsLower(r):
+		return 0.52
+	}
+	return 0.6
+}
+
+// textWidth returns the estimated width in pixels of one line of text
+func textWidth(s string, size int) int {
+	em := 0.0
+	for _, r := range s {
+		em += glyphWidth(r)
+	}
+	return int(math.Ceil(em * float64(size)))
+}
+
+// wrapText splits a label into lines no wider than maxWidth. Line breaks
+// in the label are kept, a word that is wider than maxWidth on its own is
+// split between its letters, a letter that is wider gets a line of its own.
+func wrapText(label string, size, maxWidth int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(label, "\n") {
+		first := len(lines)
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for textWidth(word, size) > maxWidth {
+				// Fill the rest of the line, or a line of its own
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				n := 1
+				for n < len(runes) && textWidth(string(runes[:n+1]), size) <= maxWidth {
+					n++
+				}
+				lines = append(lines, string(runes[:n]))
+				word = string(runes[n:])
+			}
+			switch {
+			case word == "":
+			case line == "":
+				line = word
+			case textWidth(line+" "+word, size) <= maxWidth:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		// An empty paragraph is an empty line, a split word ends without one
+		if line != "" || len(lines) == first {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// nodeLines returns the label of a node wrapped to fit the node
+func nodeLines(n interpreter.Node) []string {
+	maxW := nodeMaxW
+	if w, ok := n.Width.Pixels(); ok {
+		maxW = max(int(w), nodeMinW)
+	}
+	return wrapText(n.Label, nodeFontSize, max(maxW-2*nodePadX, 1))
+}
+
+// nodeSize returns the width and height of a node: the width and height
+// attributes when they are set, or a size that fits the label. Diamonds,
+// ellipses and circles are made larger so the label fits inside them.
+// A node is never smaller than nodeMinW by nodeMinH.
+func nodeSize(n interpreter.Node) (int, int) {
+	lines := nodeLines(n)
+	textW := 0
+	for _, line := range lines {
+		textW = max(textW, textWidth(line, nodeFontSize))
+	}
+	textH := int(math.Ceil(float64(len(lines)) * nodeFontSize * lineHeight))
+
+	w := min(max(textW+2*nodePadX, nodeMinW), nodeMaxW)
+	h := max(textH+2*nodePadY, nodeMinH)
+	switch n.Shape {
+	case "diamond":
+		w, h = w*3/2, h*3/2
+	case "ellipse":
+		w = w * 6 / 5
+	case "circle":
+		w = max(w, h)
+		h = w
+	}
+
+	if width, ok := n.Width.Pixels(); ok {
+		w = max(int(width), nodeMinW)
+	}
+	if height, ok := n.Height.Pixels(); ok {
+		h = max(int(height), nodeMinH)
+	}
+	return w, h
+}
@@ -0,0 +1,132 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+const classInput = `diagram class {
+	interface Pet {
+		+ play(): void
+	}
+	class Animal "Djur" {
+		+ name: string
+		protected age: int
+		+ static count: int
+		+ abstract speak(loud: bool, times: int): string
+	}
+	class Dog
+	class Zoo
+	Dog -> Animal (relation=inheritance)
+	Dog -> Pet (relation=implementation)
+	Zoo -> Animal "huserar" (relation=aggregation, from="1", to="*")
+	Zoo -> Dog
+}`
+
+func TestParser_ClassDiagram(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(classInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	if len(diagram.Nodes) != 4 || diagram.Nodes[0].Shape != "interface" || diagram.Nodes[1].Shape != "class" {
+		t.Fatalf("Fel klasser: %+v", diagram.Nodes)
+	}
+	members := diagram.Nodes[1].Members
+	if len(members) != 4 {
+		t.Fatalf("Förväntade 4 medlemmar, fick %+v", members)
+	}
+	want := []string{
+		"+ name: string",
+		"# age: int",
+		"+ count: int",
+		"+ speak(loud: bool, times: int): string",
+	}
+	for i, w := range want {
+		if got := members[i].String(); got != w {
+			t.Errorf("Medlem %d: förväntade %q, fick %q", i, w, got)
+		}
+	}
+	if !members[2].Static || members[2].Method || !members[3].Abstract || !members[3].Method {
+		t.Errorf("Fel modifierare: %+v", members)
+	}
+
+	relations := []interpreter.Relation{
+		interpreter.RelationInheritance, interpreter.RelationImplementation,
+		interpreter.RelationAggregation, interpreter.RelationAssociation,
+	}
+	for i, r := range relations {
+		if diagram.Edges[i].Relation != r {
+			t.Errorf("Kant %d: förväntade %s, fick %s", i, r, diagram.Edges[i].Relation)
+		}
+	}
+	if e := diagram.Edges[2]; e.FromMultiplicity != "1" || e.ToMultiplicity != "*" {
+		t.Errorf("Fel multiplicitet: %+v", e)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestValidate_ClassProblems(t *testing.T) {
+	input := `diagram class {
+	class A {
+		x: int
+		x: string
+	}
+	class B
+	interface I
+	A -> B (relation=inheritance)
+	B -> A (relation=inheritance)
+	A -> B (relation=implementation)
+	A -> I (relation=inheritance)
+	A -> B (relation=friendship)
+}`
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	want := []string{
+		"unknown relation 'friendship'",
+		"duplicate field 'x'",
+		"inheritance B -> A creates a cycle",
+		"'A' implements 'B' which is not an interface",
+		"class 'A' inherits from interface 'I'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestRenderSVG_Class(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(classInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeClassLayout(diagram)
+	if len(l.Classes) != 4 || len(l.Relationships) != 4 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	// Parents are placed above their children
+	animal, dog := l.Classes[1], l.Classes[2]
+	if animal.Y+animal.H >= dog.Y {
+		t.Errorf("Djur ska ligga ovanför Dog: %d >= %d", animal.Y+animal.H, dog.Y)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{
+		`marker-end="url(#class-triangle)"`, `marker-start="url(#class-diamond)"`, `marker-end="url(#class-open)"`,
+		"«interface»", `text-decoration="underline"`, `font-style="italic"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// dot parses src and renders it as DOT
+func dot(t *testing.T, src string) string {
+	t.Helper()
+	diagram, diags := interpreter.ParseWithTheme(interpreter.Lex(src), "")
+	if diags.HasErrors() {
+		t.Fatalf("Fel vid tolkning:\n%s", diags.Error())
+	}
+	return renderer.RenderDOT(diagram)
+}
+
+func TestRenderDOT_Flowchart(t *testing.T) {
+	out := dot(t, `diagram flowchart {
+	node A "Start" (shape=diamond, color=lightgreen, border=#f00, width=144)
+	group G "Grupp" {
+		node B "B" (color=rgb(255, 128, 0))
+	}
+	A -> B "vidare" (width=3)
+	B -- A
+	A ..> B
+}`)
+	for _, want := range []string{
+		"digraph flowchart {",
+		"rankdir=LR",
+		`A [shape=diamond, label="Start", fillcolor="/svg/lightgreen", fontcolor="#004d40", color="#ff0000", width=2.00]`,
+		`subgraph cluster_G {`,
+		`label="Grupp"`,
+		`B [shape=box, label="B", fillcolor="#ff8000"`,
+		`A -> B [label="vidare", color="#37474f", penwidth=3]`,
+		`B -> A [dir=none,`,
+		`A -> B [style=dotted,`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT saknar %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "{") != strings.Count(out, "}") {
+		t.Errorf("Klamrarna går inte jämnt upp:\n%s", out)
+	}
+	// Graphviz läser bara ett attribut per rad i ett kluster
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "fillcolor=") && strings.Contains(line, ",") {
+			t.Errorf("Klustrets attribut ska stå på egna rader: %q", line)
+		}
+	}
+}
+
+func TestRenderDOT_Quoting(t *testing.T) {
+	out := dot(t, `diagram flowchart (layout=vertical) {
+	node Graph "Säger \"hej\"\noch hejdå"
+	node B "C:\\temp"
+	Graph -> B
+}`)
+	for _, want := range []string{
+		"rankdir=TB",
+		`"Graph" [shape=box, label="Säger \"hej\"\noch hejdå"`,
+		`label="C:\\temp"`,
+		`"Graph" -> B`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT saknar %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderDOT_ERAndState(t *testing.T) {
+	out := dot(t, erInput)
+	for _, want := range []string{
+		`Order [shape=plain, style="", label=<<TABLE`,
+		`<TD PORT="customer_id" ALIGN="LEFT">FK customer_id: int</TD>`,
+		`Order:customer_id -> Customer:id [dir=both, arrowtail=crowodot, arrowhead=teetee`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT saknar %q:\n%s", want, out)
+		}
+	}
+
+	out = dot(t, `diagram state {
+	initial Start
+	state Busy "Arbetar" {
+		state Work
+	}
+	Start -> Busy "go [ok] / run()"
+}`)
+	for _, want := range []string{
+		"compound=true",
+		`Start [shape=point`,
+		`Start -> Work [lhead=cluster_Busy, label="go [ok] / run()"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT saknar %q:\n%s", want, out)
+		}
+	}
+}
+
+// parseDOT parses DOT source and fails on errors
+func parseDOT(t *testing.T, src string) (interpreter.Diagram, interpreter.Diagnostics) {
+	t.Helper()
+	diagram, diags := interpreter.ParseDOT(src)
+	if diags.HasErrors() {
+		t.Fatalf("Fel vid tolkning av DOT:\n%s", diags.Error())
+	}
+	return diagram, diags
+}
+
+func TestParseDOT(t *testing.T) {
+	diagram, diags := parseDOT(t, `digraph G {
+	rankdir=LR
+	node [shape=box, style=filled, fillcolor="#EEE"]
+	a [label="Start\nhär", shape=ellipse, color=red]
+	subgraph cluster_db {
+		label="Data" + "bas"
+		"db 1" [shape=cylinder]
+	}
+	a -> "db 1" -> c [label="sparar", style=dashed, penwidth=3]
+	c -> {a "db 1"} [dir=none]
+	c -> a [dir=back]
+}`)
+	if len(diags) != 0 {
+		t.Errorf("Förväntade inga varningar, fick:\n%s", diags.Error())
+	}
+	if diagram.Name != "flowchart" || diagram.Layout != "" {
+		t.Errorf("Förväntade ett vågrätt flödesschema, fick %q %q", diagram.Name, diagram.Layout)
+	}
+	if len(diagram.Nodes) != 3 || len(diagram.Edges) != 5 {
+		t.Fatalf("Förväntade 3 noder och 5 kanter, fick %d och %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+	a, db := diagram.Nodes[0], diagram.Nodes[1]
+	if a.Label != "Start\nhär" || a.Shape != "ellipse" || a.Color != "#eee" || a.Border != "red" {
+		t.Errorf("Fel nod a: %+v", a)
+	}
+	if db.ID != "db_1" || db.Label != "db 1" || db.Shape != "cylinder" {
+		t.Errorf("Fel nod db 1: %+v", db)
+	}
+	g := diagram.Groups[0]
+	if g.ID != "db" || g.Label != "Databas" || len(g.Nodes) != 1 || g.Nodes[0] != "db_1" {
+		t.Errorf("Fel grupp: %+v", g)
+	}
+	e := diagram.Edges[0]
+	if e.Kind != interpreter.EdgeDashed || e.Label != "sparar" || e.Width.Value != 3 {
+		t.Errorf("Fel kant: %+v", e)
+	}
+	if diagram.Edges[2].Kind != interpreter.EdgeUndirected || diagram.Edges[3].To != "db_1" {
+		t.Errorf("Kanten till {a \"db 1\"} ska bli två oriktade kanter: %+v", diagram.Edges[2:4])
+	}
+	if last := diagram.Edges[4]; last.From != "a" || last.To != "c" {
+		t.Errorf("dir=back ska vända kanten, fick %s -> %s", last.From, last.To)
+	}
+
+	// The formatted source is a diagram with the same content
+	src := interpreter.Format(diagram, nil)
+	again, parseDiags := interpreter.ParseWithTheme(interpreter.Lex(src), "")
+	if parseDiags.HasErrors() {
+		t.Fatalf("Konverterad källkod går inte att tolka:\n%s\n%s", src, parseDiags.Error())
+	}
+	if !reflect.DeepEqual(nodeIDs(again), nodeIDs(diagram)) || len(again.Edges) != len(diagram.Edges) || again.Nodes[0].Color != "#eee" {
+		t.Errorf("Konverteringen ändrade diagrammet:\n%s", src)
+	}
+}
+
+// nodeIDs returns the ids of the nodes of a diagram, sorted
+func nodeIDs(d interpreter.Diagram) []string {
+	var ids []string
+	for _, n := range d.Nodes {
+		ids = append(ids, n.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestParseDOT_Problems(t *testing.T) {
+	_, diags := interpreter.ParseDOT(`graph {
+	a -> b
+	c [label=<<B>C</B>>, fontname=Arial, shape=star, color=gray40]
+	d [fontname=Arial, color="red:blue"]
+	node -> e
+	f [label="F"
+}`)
+	for _, want := range []string{
+		"2:4: '->' can not be used in this graph, use '--'",
+		"3:5: warning: HTML-like labels are not supported",
+		"3:23: warning: the DOT attribute 'fontname' has no equivalent",
+		"3:39: warning: the DOT shape 'star' has no equivalent",
+		"3:51: warning: color: the colour 'gray40' is not a CSS colour",
+		"4:21: warning: colour lists are not supported",
+		"5:7: expected attributes after 'node'",
+		"7:1: expected an attribute or ']'",
+	} {
+		if !strings.Contains(diags.Error(), want) {
+			t.Errorf("Förväntade %q, fick:\n%s", want, diags.Error())
+		}
+	}
+	if strings.Count(diags.Error(), "fontname") != 1 {
+		t.Errorf("Samma attribut ska bara varnas för en gång:\n%s", diags.Error())
+	}
+
+	// The '}' after a broken statement still ends the graph
+	_, diags = interpreter.ParseDOT("digraph { a -> }")
+	if len(diags) != 1 || !strings.Contains(diags.Error(), "expected a node after '->'") {
+		t.Errorf("Förväntade ett fel om kanten, fick:\n%s", diags.Error())
+	}
+
+	_, diags = interpreter.ParseDOT("graph { a -- b [style=dotted] }")
+	if !strings.Contains(diags.Error(), "warning: undirected edges can not be dotted, they are drawn solid") {
+		t.Errorf("Förväntade en varning om stilen, fick:\n%s", diags.Error())
+	}
+}
+
+func TestParseDOT_RenderDOT(t *testing.T) {
+	// Flowcharts written by RenderDOT are read back the same
+	for _, file := range []string{"../example/example2.diag", "../example/shapes1.diag", "../example/edges1.diag"} {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, diags := interpreter.ParseFile(interpreter.Lex(string(src)), file, "")
+		if diags.HasErrors() {
+			t.Fatalf("%s: %s", file, diags.Error())
+		}
+		after, _ := parseDOT(t, renderer.RenderDOT(before))
+		if !reflect.DeepEqual(nodeIDs(after), nodeIDs(before)) || len(after.Edges) != len(before.Edges) {
+			t.Errorf("%s: fick %v och %d kanter, förväntade %v och %d", file, nodeIDs(after), len(after.Edges), nodeIDs(before), len(before.Edges))
+			continue
+		}
+		for i, e := range after.Edges {
+			if b := before.Edges[i]; e.From != b.From || e.To != b.To || e.Label != b.Label || e.Color != b.Color {
+				t.Errorf("%s: kanten %+v blev %+v", file, b, e)
+			}
+		}
+	}
+}
+
+func TestParseDOT_Examples(t *testing.T) {
+	// Everything RenderDOT writes is valid DOT, also clusters and records
+	files, err := filepath.Glob("../example/*.diag")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Hittade inga exempel: %v", err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diagram, diags := interpreter.ParseFile(interpreter.Lex(string(src)), file, "")
+		if diags.HasErrors() {
+			t.Fatalf("%s: %s", file, diags.Error())
+		}
+		out := renderer.RenderDOT(diagram)
+		if _, diags := interpreter.ParseDOT(out); diags.HasErrors() {
+			t.Errorf("%s: DOT går inte att läsa tillbaka:\n%s\n%s", file, diags.Error(), out)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+func TestParser_EdgeChain(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Start"
+	A -> B -> C -> D "vidare" (color=red)
+	D -> A
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+
+	var edges []string
+	for _, e := range diagram.Edges {
+		edges = append(edges, e.From+"->"+e.To)
+	}
+	if got := strings.Join(edges, " "); got != "A->B B->C C->D D->A" {
+		t.Fatalf("Förväntade kedjan A->B B->C C->D D->A, fick %s", got)
+	}
+	for _, e := range diagram.Edges[:3] {
+		if e.Label != "vidare" || e.Color != "red" {
+			t.Errorf("Hela kedjan ska få etiketten och färgen, fick %q %s", e.Label, e.Color)
+		}
+	}
+	if pos := diagram.Edges[1].Pos; pos.Line != 3 || pos.Col != 7 {
+		t.Errorf("Andra kanten ska börja vid B (3:7), fick %s", pos)
+	}
+
+	// B, C and D are created from the edges with the id as label
+	if len(diagram.Nodes) != 4 {
+		t.Fatalf("Förväntade 4 noder, fick %d", len(diagram.Nodes))
+	}
+	for i, id := range []string{"A", "B", "C", "D"} {
+		n := diagram.Nodes[i]
+		if n.ID != id || (i > 0 && n.Label != id) || n.Shape != "rect" || n.Color == "" {
+			t.Errorf("Nod %d: förväntade %s, fick %+v", i, id, n)
+		}
+	}
+}
+
+func TestParser_ImplicitNodesOnlyInGraphs(t *testing.T) {
+	// Sequence diagrams still want their participants declared
+	input := `diagram sequence {
+	participant A
+	A -> B -> C "fråga"
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if len(diagram.Steps) != 2 || len(diagram.Nodes) != 1 {
+		t.Fatalf("Förväntade 2 meddelanden och 1 deltagare, fick %d och %d", len(diagram.Steps), len(diagram.Nodes))
+	}
+	diags := interpreter.Validate(diagram)
+	if len(diags) != 3 || !strings.Contains(diags[0].Message, "undefined participant 'B'") {
+		t.Errorf("Förväntade odefinierade deltagare, fick:\n%s", diags.Error())
+	}
+
+	// A chain that is not finished is an error
+	_, diags = interpreter.ParseDiagnostics(interpreter.Lex(`diagram flowchart { A -> B -> }`))
+	if len(diags) == 0 || diags[0].Expected != "node id" {
+		t.Errorf("Förväntade fel för kedja utan mål, fick %v", diags)
+	}
+}
+
+func TestRenderSVG_UndefinedEdgeIsLeftOut(t *testing.T) {
+	diagram := interpreter.Diagram{
+		Name:  "flowchart",
+		Nodes: []interpreter.Node{{ID: "A", Label: "A", Shape: "rect"}},
+		Edges: []interpreter.Edge{{From: "A", To: "X", Label: "ingenstans"}},
+	}
+	svg := renderer.RenderSVG(diagram)
+	if strings.Contains(svg, "<line") || strings.Contains(svg, "ingenstans") {
+		t.Errorf("En kant till en nod som saknas ska inte ritas:\n%s", svg)
+	}
+}
+
+func TestLexer_Arrows(t *testing.T) {
+	var arrows []string
+	for _, tok := range interpreter.Lex(`A -> B -- C <-> D --> E ..> F ==> G ->> H`) {
+		if tok.Type == interpreter.TOKEN_ARROW {
+			arrows = append(arrows, tok.Value)
+		}
+	}
+	if got := strings.Join(arrows, " "); got != "-> -- <-> --> ..> ==> ->>" {
+		t.Errorf("Förväntade alla pilar, fick %s", got)
+	}
+}
+
+func TestParser_EdgeKinds(t *testing.T) {
+	input := `diagram flowchart {
+	A -> B
+	B -- C
+	C <-> D --> E
+	E ..> F
+	F ==> G
+	G ==> A (width=1)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	want := []struct {
+		kind  interpreter.EdgeKind
+		width string
+	}{
+		{interpreter.EdgeDirected, "2"},
+		{interpreter.EdgeUndirected, "2"},
+		{interpreter.EdgeBidirectional, "2"},
+		{interpreter.EdgeDashed, "2"},
+		{interpreter.EdgeDotted, "2"},
+		{interpreter.EdgeThick, "4"},
+		{interpreter.EdgeThick, "1"}, // the width attribute wins
+	}
+	for i, w := range want {
+		e := diagram.Edges[i]
+		if e.Kind != w.kind || e.Width.String() != w.width {
+			t.Errorf("Kant %d: förväntade %s/%s, fick %s/%s", i, w.kind, w.width, e.Kind, e.Width)
+		}
+	}
+
+	// Sequence diagrams keep their own arrows, ER and class diagrams only ->
+	tests := []struct {
+		input, want string
+	}{
+		{`diagram sequence { participant A participant B A <-> B }`, "arrow '<->' is not allowed in sequence diagrams"},
+		{`diagram flowchart { A ->> B }`, "arrow '->>' is only allowed in sequence diagrams"},
+		{`diagram class { class A class B A ..> B }`, "arrow '..>' is only allowed in flowcharts, trees and state diagrams"},
+	}
+	for _, tt := range tests {
+		_, diags := interpreter.ParseDiagnostics(interpreter.Lex(tt.input))
+		if len(diags) != 1 || diags[0].Message != tt.want {
+			t.Errorf("%s: förväntade %q, fick %v", tt.input, tt.want, diags)
+		}
+	}
+}
+
+func TestRenderSVG_EdgeKinds(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(`diagram flowchart {
+	A -- B
+	B <-> C
+	C --> D
+	D ..> E
+}`))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	svg := renderer.RenderSVG(diagram)
+	lines := strings.Split(svg, "\n")
+	var edges []string
+	for _, line := range lines {
+		if strings.Contains(line, "<line") {
+			edges = append(edges, line)
+		}
+	}
+	if len(edges) != 4 {
+		t.Fatalf("Förväntade 4 kanter, fick %d", len(edges))
+	}
+	if strings.Contains(edges[0], "marker") || strings.Contains(edges[0], "dasharray") {
+		t.Errorf("En oriktad kant ska inte ha pilar: %s", edges[0])
+	}
+	if !strings.Contains(edges[1], `marker-start="url(#arrow)" marker-end="url(#arrow)"`) {
+		t.Errorf("En dubbelriktad kant ska ha pilar i båda ändar: %s", edges[1])
+	}
+	if !strings.Contains(edges[2], `stroke-dasharray="8,4"`) || !strings.Contains(edges[2], "marker-end") {
+		t.Errorf("Förväntade en streckad kant med pil: %s", edges[2])
+	}
+	if !strings.Contains(edges[3], `stroke-dasharray="2,4"`) {
+		t.Errorf("Förväntade en prickad kant: %s", edges[3])
+	}
+}
@@ -0,0 +1,174 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+const erInput = `diagram er {
+	entity Customer "Kund" {
+		id: int PK
+		email: varchar(255) UK
+	}
+	entity Order {
+		id: int PK
+		customer_id: int FK
+		amount: decimal(10, 2)
+	}
+	Order.customer_id -> Customer.id "lägger" (from=zero_or_many, to=one)
+	Order -> Customer
+}`
+
+func TestParser_ERDiagram(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(erInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	if len(diagram.Nodes) != 2 || diagram.Nodes[0].Shape != "entity" || diagram.Nodes[0].Label != "Kund" {
+		t.Fatalf("Fel entiteter: %+v", diagram.Nodes)
+	}
+	order := diagram.Nodes[1]
+	if len(order.Columns) != 3 {
+		t.Fatalf("Förväntade 3 kolumner, fick %+v", order.Columns)
+	}
+	if c := order.Columns[1]; c.Name != "customer_id" || c.Type != "int" || !c.FK || c.PK {
+		t.Errorf("Fel kolumn: %+v", c)
+	}
+	if c := order.Columns[2]; c.Type != "decimal(10,2)" {
+		t.Errorf("Fel typ med parametrar: %q", c.Type)
+	}
+	if keys := diagram.Nodes[0].Columns[1].Keys(); keys != "UK" {
+		t.Errorf("Fel nyckelmarkering: %q", keys)
+	}
+
+	rel := diagram.Edges[0]
+	if rel.FromPort != "customer_id" || rel.ToPort != "id" ||
+		rel.FromCard != interpreter.CardinalityZeroOrMany || rel.ToCard != interpreter.CardinalityOne {
+		t.Errorf("Fel relation: %+v", rel)
+	}
+	// Without attributes a relationship is many to one
+	if rel := diagram.Edges[1]; rel.FromCard != interpreter.CardinalityMany || rel.ToCard != interpreter.CardinalityOne || rel.FromPort != "" {
+		t.Errorf("Fel standardkardinalitet: %+v", rel)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestParser_ERKeywordColumns(t *testing.T) {
+	input := `diagram er {
+	entity Ticket {
+		state: varchar(20)
+		entity: text
+		node: int
+	}
+	entity Queue {
+		id: int PK
+	}
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if len(diagram.Nodes) != 2 {
+		t.Fatalf("Förväntade 2 entiteter, fick %+v", diagram.Nodes)
+	}
+	var names []string
+	for _, c := range diagram.Nodes[0].Columns {
+		names = append(names, c.Name+": "+c.Type)
+	}
+	if got := strings.Join(names, ", "); got != "state: varchar(20), entity: text, node: int" {
+		t.Errorf("Nyckelord följt av ':' ska vara kolumnnamn, fick %q", got)
+	}
+}
+
+func TestParser_ERProblems(t *testing.T) {
+	input := `diagram er {
+	entity A {
+		id int PK
+		name: text NULLABLE
+	}
+	A.id -> A.id (from=lots)
+}`
+	_, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	want := []string{
+		"expected ':' after column id",
+		"unknown column marker 'NULLABLE'",
+		"unknown cardinality 'lots'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestValidate_ERProblems(t *testing.T) {
+	input := `diagram er {
+	entity A {
+		id: int PK
+		id: int
+	}
+	entity B {
+		name: text
+	}
+	B.a_id -> A.id
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	diags := interpreter.Validate(diagram)
+	want := []string{
+		"duplicate column 'id'",
+		"entity 'B' has no primary key",
+		"entity 'B' has no column 'a_id'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestRenderSVG_ER(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(erInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeERLayout(diagram)
+	if len(l.Entities) != 2 || len(l.Relationships) != 2 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	// The relationship is attached to the rows of its columns
+	customer, order := l.Entities[0], l.Entities[1]
+	rel := l.Relationships[0]
+	if rel.FromY != order.RowY("customer_id") || rel.ToY != customer.RowY("id") {
+		t.Errorf("Relationen sitter inte vid kolumnraderna: %+v", rel)
+	}
+	if order.RowY("customer_id") <= order.RowY("id") {
+		t.Errorf("Raderna ska komma i ordning: %d <= %d", order.RowY("customer_id"), order.RowY("id"))
+	}
+	if rel.FromX != order.X || rel.ToX != customer.X+customer.W {
+		t.Errorf("Relationen ska gå mellan sidorna som vetter mot varandra: %+v", rel)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{"url(#er-zero_or_many)", "url(#er-one)", ">customer_id<", ">varchar(255)<"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// format lexes, parses and formats src
+func format(t *testing.T, src string) string {
+	t.Helper()
+	tokens := interpreter.Lex(src)
+	diagram, diags := interpreter.ParseWithTheme(tokens, "")
+	if diags.HasErrors() {
+		t.Fatalf("Fel vid tolkning:\n%s", diags.Error())
+	}
+	return interpreter.Format(diagram, tokens)
+}
+
+func TestFormat_Canonical(t *testing.T) {
+	input := `diagram flowchart   (theme=dark,layout=vertical){
+  node A "Start"(border=blue,color=red, shape="ellipse")
+	node B "Två\nrader" (width=120, foo="a b")
+    group G "G"{
+        node C "C"
+    }
+  A->B->C "x"(width=3,color=#ff0000)
+  B -- D
+}`
+	want := `diagram flowchart (layout=vertical, theme=dark) {
+	node A "Start" (shape=ellipse, color=red, border=blue)
+	node B "Två\nrader" (width=120, foo="a b")
+	group G {
+		node C "C"
+	}
+	A -> B -> C "x" (color=#ff0000, width=3)
+	B -- D
+}
+`
+	got := format(t, input)
+	if got != want {
+		t.Errorf("Fel formatering, förväntade:\n%s\nfick:\n%s", want, got)
+	}
+	// D only exists because of the edge and is not written
+	if again := format(t, got); again != got {
+		t.Errorf("Formatering två gånger ska ge samma resultat, fick:\n%s", again)
+	}
+}
+
+func TestFormat_KeepsComments(t *testing.T) {
+	input := `# Rubrik
+
+diagram sequence { # efter klammern
+	participant A "A"
+	participant B "Bea" // slutet av raden
+
+
+	alt "ja" {
+		A -> B "x"
+		# sist i ja
+	} else "nej" {
+		A ->> B "y"
+	}
+	/* före loopen */ loop { A --> B }
+}
+# efter allt
+`
+	want := `# Rubrik
+
+diagram sequence { # efter klammern
+	participant A
+	participant B "Bea" // slutet av raden
+
+	alt "ja" {
+		A -> B "x"
+		# sist i ja
+	} else "nej" {
+		A ->> B "y"
+	}
+	/* före loopen */
+	loop {
+		A --> B
+	}
+}
+# efter allt
+`
+	if got := format(t, input); got != want {
+		t.Errorf("Fel formatering, förväntade:\n%s\nfick:\n%s", want, got)
+	}
+}
+
+func TestFormat_Examples(t *testing.T) {
+	files, err := filepath.Glob("../example/*.diag")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Hittade inga exempel: %v", err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens := interpreter.Lex(string(src))
+		before, diags := interpreter.ParseFile(tokens, file, "")
+		if diags.HasErrors() {
+			continue
+		}
+		formatted := interpreter.Format(before, tokens)
+
+		tokens = interpreter.Lex(formatted)
+		after, diags := interpreter.ParseFile(tokens, file, "")
+		if diags.HasErrors() {
+			t.Errorf("%s: formaterad källkod går inte att tolka:\n%s", file, diags.Error())
+			continue
+		}
+		if len(after.Nodes) != len(before.Nodes) || len(after.Edges) != len(before.Edges) ||
+			len(after.Groups) != len(before.Groups) || len(after.Steps) != len(before.Steps) {
+			t.Errorf("%s: formateringen ändrade diagrammet", file)
+		}
+		if again := interpreter.Format(after, tokens); again != formatted {
+			t.Errorf("%s: formatering två gånger ska ge samma resultat:\n%s", file, again)
+		}
+	}
+}
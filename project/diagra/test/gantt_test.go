@@ -0,0 +1,177 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ganttInput = `diagram gantt (today=2024-03-08) {
+	section Design "Design" {
+		task Spec "Kravspec" (start=2024-03-01, duration=5d)
+		task Mock "Skisser" after Spec (duration=3d)
+	}
+	section Build {
+		task API after Mock (duration=1w)
+		task UI (duration=2d)
+		task Test after API, UI (duration=4)
+		milestone Release after Test
+	}
+}`
+
+func date(s string) time.Time {
+	t, err := interpreter.ParseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestLexer_Date(t *testing.T) {
+	tokens := interpreter.Lex(`(start=2024-03-01, duration=5d)`)
+	if tokens[3].Type != interpreter.TOKEN_DATE || tokens[3].Value != "2024-03-01" {
+		t.Errorf("Förväntade ett datum, fick %+v", tokens[3])
+	}
+	if tokens[7].Type != interpreter.TOKEN_NUMBER || tokens[7].Value != "5d" {
+		t.Errorf("Förväntade en varaktighet, fick %+v", tokens[7])
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := map[string]int{"5d": 5, "2w": 14, "3": 3, "0d": 0}
+	for input, want := range tests {
+		got, err := interpreter.ParseDays(input)
+		if err != nil || got != want {
+			t.Errorf("ParseDays(%q) = %d, %v, förväntade %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"5m", "d", ""} {
+		if _, err := interpreter.ParseDays(input); err == nil {
+			t.Errorf("ParseDays(%q) borde ge fel", input)
+		}
+	}
+}
+
+func TestSchedule_Gantt(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(ganttInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if len(diagram.Nodes) != 6 || len(diagram.Groups) != 2 || diagram.Nodes[5].Shape != "milestone" {
+		t.Fatalf("Fel uppgifter: %+v", diagram.Nodes)
+	}
+
+	spans, diags := interpreter.Schedule(diagram)
+	if len(diags) != 0 {
+		t.Fatalf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+	want := map[string][2]string{
+		"Spec":    {"2024-03-01", "2024-03-06"},
+		"Mock":    {"2024-03-06", "2024-03-09"},
+		"API":     {"2024-03-09", "2024-03-16"},
+		"UI":      {"2024-03-16", "2024-03-18"}, // after the task before it
+		"Test":    {"2024-03-18", "2024-03-22"},
+		"Release": {"2024-03-22", "2024-03-22"},
+	}
+	for id, w := range want {
+		span := spans[id]
+		if !span.Start.Equal(date(w[0])) || !span.End.Equal(date(w[1])) {
+			t.Errorf("%s: förväntade %s..%s, fick %s..%s", id, w[0], w[1],
+				span.Start.Format(interpreter.DateLayout), span.End.Format(interpreter.DateLayout))
+		}
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestValidate_GanttProblems(t *testing.T) {
+	input := `diagram gantt {
+	task A (duration=2d)
+	task B after C (duration=1d)
+	task C after B (duration=1d)
+	task D after Missing (duration=1d)
+	task E (start=2024-01-01, duration=x)
+	task F
+}`
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	want := []string{
+		// from the parser
+		"duration: invalid duration 'x'",
+		"task F has no duration",
+		// from Validate
+		"task 'A' has no start date",
+		"task 'C' comes after 'B' which depends on 'C'",
+		"task 'D' comes after undefined task 'Missing'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestSchedule_FollowsUnscheduledTask(t *testing.T) {
+	input := `diagram gantt {
+	task A (duration=2d)
+	task B (duration=1d)
+	task C after A (duration=1d)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	spans, diags := interpreter.Schedule(diagram)
+	want := []string{
+		"2:2: task 'A' has no start date and nothing to come after",
+		"3:2: task 'B' has no start date and can not be scheduled because 'A' can not be scheduled",
+		"4:2: task 'C' can not be scheduled because 'A' can not be scheduled",
+	}
+	if len(spans) != 0 || len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser och inga uppgifter, fick %v:\n%s", len(want), spans, diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Error(), w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestRenderSVG_Gantt(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(ganttInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeGanttLayout(diagram, date("2024-03-08"))
+	if len(l.Rows) != 6 || len(l.Sections) != 2 || len(l.Dependencies) != 5 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	spec, mock := l.Rows[0], l.Rows[1]
+	if spec.X2-spec.X1 != 5*l.DayWidth || mock.X1 != spec.X2 {
+		t.Errorf("Fel staplar: %+v, %+v", spec, mock)
+	}
+	if l.TodayX != l.ChartX+8*l.DayWidth {
+		t.Errorf("Fel position för idag: %d", l.TodayX)
+	}
+	if l.Rows[2].Y <= l.Sections[1].Y {
+		t.Errorf("API ska ligga i sektionen Build")
+	}
+	if outside := renderer.ComputeGanttLayout(diagram, date("2025-01-01")); outside.TodayX != -1 {
+		t.Errorf("Idag utanför schemat ska inte visas, fick %d", outside.TodayX)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{"url(#gantt-arrow)", ">today<", ">Kravspec<", ">02-29<"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
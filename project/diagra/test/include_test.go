@@ -0,0 +1,221 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files to a temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// parseFile lexes and parses the file at path
+func parseFile(t *testing.T, path string) (interpreter.Diagram, interpreter.Diagnostics) {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return interpreter.ParseFile(interpreter.Lex(string(src)), path, "")
+}
+
+func TestParser_Include(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.diag": `diagram flowchart {
+	include "shared/services.diag"
+	include "shared/db.diag" as store
+	style service (color=green)
+
+	node API "Egen API"
+	API -> services.API
+	services.API -> store.API
+}`,
+		"shared/services.diag": `diagram flowchart {
+	style service (color=blue, border=navy)
+	group backend "Backend" {
+		node API "API" (class=service)
+	}
+}`,
+		// Relative to the included file, not to main.diag
+		"shared/db.diag": `diagram flowchart {
+	include "tables.diag"
+	node API "DB API"
+	API -> tables.Users
+}`,
+		"shared/tables.diag": `diagram flowchart {
+	node Users "Användare"
+}`,
+	})
+
+	diagram, diags := parseFile(t, filepath.Join(dir, "main.diag"))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	if len(diags) != 0 {
+		t.Fatalf("Förväntade inga problem, fick %v", diags)
+	}
+
+	var ids []string
+	for _, n := range diagram.Nodes {
+		ids = append(ids, n.ID)
+	}
+	want := "services.API store.tables.Users store.API API"
+	if strings.Join(ids, " ") != want {
+		t.Errorf("Förväntade noderna %q, fick %q", want, strings.Join(ids, " "))
+	}
+
+	var edges []string
+	for _, e := range diagram.Edges {
+		edges = append(edges, e.From+"->"+e.To)
+	}
+	want = "store.API->store.tables.Users API->services.API services.API->store.API"
+	if strings.Join(edges, " ") != want {
+		t.Errorf("Förväntade kanterna %q, fick %q", want, strings.Join(edges, " "))
+	}
+
+	if g := diagram.Groups[0]; g.ID != "services.backend" || g.Nodes[0] != "services.API" {
+		t.Errorf("Gruppen ska få namnrymden: %+v", g)
+	}
+
+	// The diagram's own style wins over the included one
+	if n := diagram.Nodes[0]; n.Color != "green" || n.Border != "navy" {
+		t.Errorf("Förväntade den egna stilen först, fick %s/%s", n.Color, n.Border)
+	}
+	if n := diagram.Nodes[0]; !strings.HasSuffix(n.Pos.File, "services.diag") || n.Pos.Line != 4 {
+		t.Errorf("Positionen ska peka på den inkluderade filen, fick %s", n.Pos)
+	}
+}
+
+func TestParser_IncludeProblems(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.diag": `diagram flowchart {
+	include "b.diag"
+	include "b.diag"
+	include "missing.diag"
+	include "gantt.diag"
+	include "2024.diag"
+}`,
+		"b.diag": `diagram flowchart {
+	include "a.diag"
+	node X "X" (color=nope)
+}`,
+		"gantt.diag": `diagram gantt {
+	task T "T" (start=2024-01-01, duration=1d)
+}`,
+		"2024.diag": `diagram flowchart { }`,
+	})
+
+	_, diags := parseFile(t, filepath.Join(dir, "a.diag"))
+	interpreter.AttachSource(diags, "")
+	want := []string{
+		"3:2: namespace 'b' is already used by the include at 2:2",
+		"4:2: could not read include",
+		"5:2: can not include a gantt diagram in a flowchart diagram",
+		"6:2: '2024.diag' is not a valid namespace, use 'as' to name it",
+		// The cycle is reported in b.diag, at the include that closes it
+		"b.diag:2:2: include cycle: a.diag -> b.diag -> a.diag",
+		"b.diag:3:14: color: ",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d problem, fick %d: %v", len(want), len(diags), diags)
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Error(), w) {
+			t.Errorf("Problem %d: förväntade %q, fick %q", i, w, diags[i].Error())
+		}
+	}
+
+	// The excerpt comes from the included file
+	if !strings.Contains(diags[5].Excerpt, `node X "X" (color=nope)`) {
+		t.Errorf("Förväntade utdrag ur b.diag, fick %q", diags[5].Excerpt)
+	}
+}
+
+func TestParser_IncludeUndefinedStyle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.diag": `diagram flowchart {
+	include "b.diag"
+}`,
+		"b.diag": `diagram flowchart {
+	include "c.diag"
+}`,
+		"c.diag": `diagram flowchart {
+	node X "X" (class="saknas annan")
+}`,
+	})
+
+	// The included nodes are styled in every file, the class only once
+	_, diags := parseFile(t, filepath.Join(dir, "a.diag"))
+	want := []string{"c.diag:2:14: undefined style 'saknas'", "c.diag:2:14: undefined style 'annan'"}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d problem, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Error(), w) {
+			t.Errorf("Problem %d: förväntade %q, fick %q", i, w, diags[i].Error())
+		}
+	}
+}
+
+func TestParser_FailedIncludeNamespace(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.diag": `diagram flowchart {
+	include "services.diag"
+	node Web "Web"
+	Web -> services.API
+}`,
+	})
+
+	diagram, diags := parseFile(t, filepath.Join(dir, "a.diag"))
+	if len(diags) != 1 || !strings.Contains(diags[0].Error(), "2:2: could not read include") {
+		t.Errorf("Förväntade bara felet om includen, fick:\n%s", diags.Error())
+	}
+	if len(diagram.Edges) != 1 || diagram.Edges[0].To != "services.API" {
+		t.Errorf("Kanten ska gå till services.API, fick %+v", diagram.Edges)
+	}
+}
+
+func TestParser_IncludeSequence(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.diag": `diagram sequence {
+	include "login.diag" as login
+	participant User
+	User -> login.Server "Start"
+	activate login.Server
+	deactivate login.Server
+}`,
+		"login.diag": `diagram sequence {
+	participant Server
+	participant DB
+	Server -> DB "Fråga"
+}`,
+	})
+
+	diagram, diags := parseFile(t, filepath.Join(dir, "main.diag"))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	if len(diags) != 0 {
+		t.Fatalf("Förväntade inga problem, fick %v", diags)
+	}
+	if len(diagram.Steps) != 4 {
+		t.Fatalf("Förväntade 4 steg, fick %d", len(diagram.Steps))
+	}
+	if e := diagram.Steps[0].Edge; e.From != "login.Server" || e.To != "login.DB" {
+		t.Errorf("Meddelandet ska få namnrymden, fick %s -> %s", e.From, e.To)
+	}
+	if p := diagram.Steps[2].Participants[0]; p != "login.Server" {
+		t.Errorf("Förväntade login.Server, fick %s", p)
+	}
+}
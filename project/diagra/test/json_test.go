@@ -0,0 +1,117 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSON_RoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../example/*.diag")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Hittade inga exempel: %v", err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, diags := interpreter.ParseFile(interpreter.Lex(string(src)), file, "")
+		if diags.HasErrors() {
+			continue
+		}
+		data, err := interpreter.ExportJSON(before)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		after, err := interpreter.ImportJSON(data, "")
+		if err != nil {
+			t.Errorf("%s: kunde inte läsa exporterad JSON: %v", file, err)
+			continue
+		}
+		if !reflect.DeepEqual(after.Nodes, before.Nodes) || !reflect.DeepEqual(after.Edges, before.Edges) ||
+			!reflect.DeepEqual(after.Groups, before.Groups) {
+			t.Errorf("%s: diagrammet ändrades av JSON", file)
+		}
+		if renderer.RenderSVG(after) != renderer.RenderSVG(before) {
+			t.Errorf("%s: SVG ska bli samma efter JSON", file)
+		}
+	}
+}
+
+func TestImportJSON_Defaults(t *testing.T) {
+	input := `{
+		"version": 1,
+		"type": "flowchart",
+		"theme": "dark",
+		"nodes": [
+			{"id": "A", "label": "Start", "shape": "ellipse"},
+			{"id": "B", "label": "Slut", "color": "red", "width": "3cm"}
+		],
+		"edges": [{"from": "A", "to": "B", "kind": "thick", "label": "vidare"}],
+		"framtida": true
+	}`
+	diagram, err := interpreter.ImportJSON([]byte(input), "")
+	if err != nil {
+		t.Fatalf("Fel vid import: %v", err)
+	}
+	dark, _ := interpreter.LookupTheme("dark")
+	a, b, e := diagram.Nodes[0], diagram.Nodes[1], diagram.Edges[0]
+	if a.Shape != "ellipse" || a.Color != dark.NodeColor || a.Border != dark.NodeBorder {
+		t.Errorf("Noden ska få temats färger: %+v", a)
+	}
+	if b.Shape != "rect" || b.Color != "red" || b.Width != (interpreter.Length{Value: 3, Unit: "cm"}) {
+		t.Errorf("Fel värden på B: %+v", b)
+	}
+	if e.Kind != interpreter.EdgeThick || e.Width.Value != 4 || e.Color != dark.EdgeColor {
+		t.Errorf("Fel kant: %+v", e)
+	}
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga problem, fick:\n%s", diags.Error())
+	}
+	svg := renderer.RenderSVG(diagram)
+	if !strings.Contains(svg, "Start") || !strings.Contains(svg, "vidare") {
+		t.Errorf("SVG saknar texterna:\n%s", svg)
+	}
+
+	// A theme from outside wins over the theme in the JSON
+	diagram, err = interpreter.ImportJSON([]byte(input), "light")
+	light, _ := interpreter.LookupTheme("light")
+	if err != nil || diagram.Theme.Name != "light" || diagram.Nodes[0].Color != light.NodeColor {
+		t.Errorf("Förväntade temat light, fick %q %v", diagram.Theme.Name, err)
+	}
+}
+
+func TestImportJSON_Problems(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`{"type": "flowchart"}`, []string{"missing version"}},
+		{`{"version": 99, "type": "flowchart"}`, []string{"version 99"}},
+		{`{"version": 1, "type": "pie"}`, []string{"unknown diagram type 'pie'"}},
+		{`{"version": 1, "type": "flowchart", "nodes": [}`, []string{"invalid diagram JSON"}},
+		{`{"version": 1, "type": "flowchart", "theme": "neon",
+			"nodes": [{"id": "A", "color": "inte-en-färg", "width": "10%"}],
+			"edges": [{"from": "A", "to": "A", "kind": "wavy"}]}`,
+			[]string{"unknown theme 'neon'", "node A: color", "node A: width", "unknown kind 'wavy'"}},
+		{`{"version": 1, "type": "er", "edges": [{"from": "A", "to": "B", "toCardinality": "few"}]}`,
+			[]string{"unknown cardinality 'few'"}},
+	}
+	for _, tt := range tests {
+		_, err := interpreter.ImportJSON([]byte(tt.input), "")
+		if err == nil {
+			t.Errorf("Förväntade fel för %s", tt.input)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Förväntade %q i felet, fick: %v", want, err)
+			}
+		}
+	}
+}
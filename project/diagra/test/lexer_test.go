@@ -2,6 +2,7 @@ package interpreter_test
 
 import (
 	"diagra/interpreter"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +52,103 @@ func TestLexer_TokensFromSimpleDiagram(t *testing.T) {
 		}
 	}
 }
+
+func TestLexer_TokenPositions(t *testing.T) {
+	input := "diagram tree {\n  node Å \"Rot\"\n}"
+
+	expected := []interpreter.Position{
+		{Line: 1, Col: 1, Offset: 0},
+		{Line: 1, Col: 9, Offset: 8},
+		{Line: 1, Col: 14, Offset: 13},
+		{Line: 2, Col: 3, Offset: 17},
+		{Line: 2, Col: 8, Offset: 22},
+		{Line: 2, Col: 10, Offset: 25},
+		{Line: 3, Col: 1, Offset: 31},
+		{Line: 3, Col: 2, Offset: 32},
+	}
+
+	tokens := interpreter.Lex(input)
+	if len(tokens) != len(expected) {
+		t.Fatalf("Förväntade %d tokens, fick %d", len(expected), len(tokens))
+	}
+	for i, token := range tokens {
+		if token.Pos != expected[i] {
+			t.Errorf("Token %d (%q): förväntade position %+v, fick %+v", i, token.Value, expected[i], token.Pos)
+		}
+	}
+}
+
+func TestLexer_Comments(t *testing.T) {
+	input := `# rubrik
+diagram flowchart { // slut på raden
+	/* block
+	   kommentar */ node A "Start"
+	A -> B # efter kanten
+	// sista
+}
+`
+
+	tokens := interpreter.Lex(input)
+
+	var values []string
+	for _, tok := range tokens {
+		values = append(values, tok.Value)
+	}
+	want := []string{"diagram", "flowchart", "{", "node", "A", "Start", "A", "->", "B", "}", ""}
+	if len(values) != len(want) {
+		t.Fatalf("Förväntade tokens %q, fick %q", want, values)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Fatalf("Förväntade tokens %q, fick %q", want, values)
+		}
+	}
+
+	cases := []struct {
+		token    int
+		text     string
+		block    bool
+		trailing bool
+	}{
+		{0, "# rubrik", false, false},
+		{3, "// slut på raden", false, true},
+		{9, "# efter kanten", false, true},
+	}
+	for _, c := range cases {
+		comments := tokens[c.token].Comments
+		if len(comments) == 0 {
+			t.Errorf("Token %d (%q) saknar kommentarer", c.token, tokens[c.token].Value)
+			continue
+		}
+		got := comments[0]
+		if got.Text != c.text || got.Block != c.block || got.Trailing != c.trailing {
+			t.Errorf("Token %d: förväntade kommentar %q (block=%v, trailing=%v), fick %+v",
+				c.token, c.text, c.block, c.trailing, got)
+		}
+	}
+
+	// "node" gets both the trailing line comment and the block comment
+	if n := len(tokens[3].Comments); n != 2 || !tokens[3].Comments[1].Block {
+		t.Errorf("Förväntade rad- och blockkommentar före 'node', fick %+v", tokens[3].Comments)
+	}
+	if n := len(tokens[9].Comments); n != 2 || tokens[9].Comments[1].Text != "// sista" {
+		t.Errorf("Förväntade två kommentarer före '}', fick %+v", tokens[9].Comments)
+	}
+}
+
+func TestLexer_UnterminatedBlockComment(t *testing.T) {
+	tokens := interpreter.Lex("node A /* aldrig stängd")
+	last := tokens[len(tokens)-1]
+	if last.Type != interpreter.TOKEN_EOF {
+		t.Fatalf("Sista token borde vara EOF, fick %s", last.Type)
+	}
+	if len(last.Comments) != 1 || last.Comments[0].Text != "/* aldrig stängd" {
+		t.Errorf("Fel kommentar på EOF: %+v", last.Comments)
+	}
+
+	// The rest of the file is not swallowed without a word
+	_, diags := interpreter.ParseDiagnostics(interpreter.Lex("diagram flowchart {\n\tnode A\n\t/* aldrig stängd\n\tnode B\n}"))
+	if len(diags) == 0 || diags[0].Pos.Line != 3 || diags[0].Pos.Col != 2 || !strings.Contains(diags[0].Message, "unterminated block comment") {
+		t.Errorf("Förväntade fel om oavslutad kommentar på 3:2, fick:\n%s", diags.Error())
+	}
+}
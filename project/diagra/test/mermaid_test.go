@@ -0,0 +1,221 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMermaid(t *testing.T) {
+	diagram, diags := interpreter.ParseMermaid(`%% En kommentar
+flowchart TD
+	A[Start] --> B{Giltig?} & C((Cirkel))
+	B -->|ja| D[(Databas)]
+	B -. "nej #quot;nu#quot;" .-> A; D === C
+	subgraph lager [Lager]
+		E{{Sex}} <--> F[/Para/]
+	end
+	E ==> A
+	style A fill:#e3f2fd,stroke:#1565c0
+	classDef klar fill:#c8e6c9
+	class D,E klar
+	linkStyle 0 stroke:red,stroke-width:3px`)
+	if len(diags) != 0 {
+		t.Errorf("Förväntade inga varningar, fick:\n%s", diags.Error())
+	}
+	if diagram.Name != "flowchart" || diagram.Layout != "vertical" {
+		t.Errorf("Förväntade ett lodrätt flödesschema, fick %q %q", diagram.Name, diagram.Layout)
+	}
+	if len(diagram.Nodes) != 6 || len(diagram.Edges) != 7 {
+		t.Fatalf("Förväntade 6 noder och 7 kanter, fick %d och %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+
+	shapes := map[string]string{}
+	for _, n := range diagram.Nodes {
+		shapes[n.ID] = n.Shape
+	}
+	want := map[string]string{"A": "rect", "B": "diamond", "C": "circle", "D": "cylinder", "E": "hexagon", "F": "parallelogram"}
+	if !reflect.DeepEqual(shapes, want) {
+		t.Errorf("Fel former: %v", shapes)
+	}
+	a, d := diagram.Nodes[0], diagram.Nodes[3]
+	if a.Label != "Start" || a.Color != "#e3f2fd" || a.Border != "#1565c0" {
+		t.Errorf("Fel nod A: %+v", a)
+	}
+	if d.Color != "#c8e6c9" || diagram.Styles[0].Name != "klar" {
+		t.Errorf("classDef ska bli en stil: %+v %+v", d, diagram.Styles)
+	}
+
+	kinds := []interpreter.EdgeKind{
+		interpreter.EdgeDirected, interpreter.EdgeDirected, interpreter.EdgeDirected, interpreter.EdgeDotted,
+		interpreter.EdgeUndirected, interpreter.EdgeBidirectional, interpreter.EdgeThick,
+	}
+	for i, e := range diagram.Edges {
+		if e.Kind != kinds[i] {
+			t.Errorf("Kant %d %s -> %s: förväntade %s, fick %s", i, e.From, e.To, kinds[i], e.Kind)
+		}
+	}
+	if e := diagram.Edges[0]; e.Color != "red" || e.Width.Value != 3 {
+		t.Errorf("linkStyle 0 ska gälla den första kanten: %+v", e)
+	}
+	if e := diagram.Edges[3]; e.Label != `nej "nu"` {
+		t.Errorf("Fel text på kanten: %q", e.Label)
+	}
+	if e := diagram.Edges[4]; e.Width.Value != 4 {
+		t.Errorf("=== ska bli en tjock oriktad kant: %+v", e)
+	}
+	g := diagram.Groups[0]
+	if g.ID != "lager" || g.Label != "Lager" || !reflect.DeepEqual(g.Nodes, []string{"E", "F"}) {
+		t.Errorf("Fel grupp: %+v", g)
+	}
+
+	// The formatted source is a diagram with the same content
+	src := interpreter.Format(diagram, nil)
+	again, parseDiags := interpreter.ParseWithTheme(interpreter.Lex(src), "")
+	if parseDiags.HasErrors() {
+		t.Fatalf("Konverterad källkod går inte att tolka:\n%s\n%s", src, parseDiags.Error())
+	}
+	if !reflect.DeepEqual(nodeIDs(again), nodeIDs(diagram)) || len(again.Edges) != len(diagram.Edges) || again.Nodes[3].Color != "#c8e6c9" {
+		t.Errorf("Konverteringen ändrade diagrammet:\n%s", src)
+	}
+
+	// A title of several words is also the id of the subgraph
+	diagram, _ = interpreter.ParseMermaid("graph LR\n\tsubgraph Två ord\n\t\tX\n\tend")
+	if g := diagram.Groups[0]; diagram.Layout != "" || g.ID != "Två_ord" || g.Label != "Två ord" || g.Nodes[0] != "X" {
+		t.Errorf("Fel grupp: %q %+v", diagram.Layout, g)
+	}
+}
+
+func TestParseMermaid_Problems(t *testing.T) {
+	_, diags := interpreter.ParseMermaid(`graph RL
+	A[Start --> B
+	C --> D>Asym] --o E
+	D -> E
+	subgraph one
+		F ~~~ G
+	end
+	end
+	style C fill:inte-en-färg,stroke-width:4px
+	class C nope
+	click C callback`)
+	for _, want := range []string{
+		"1:7: warning: the direction RL has no equivalent",
+		"2:3: the text of node A has no closing ']'",
+		"3:9: warning: the Mermaid shape asymmetric has no equivalent",
+		"3:16: warning: circle and cross arrow heads are drawn as arrows",
+		"4:4: unknown link '->'",
+		"6:3: warning: invisible links are left out",
+		"8:2: 'end' without 'subgraph'",
+		"9:10: warning: fill: the colour 'inte-en-färg' is not supported",
+		"9:28: warning: the Mermaid style 'stroke-width' has no equivalent",
+		"10:2: warning: the class 'nope' is not defined with classDef",
+		"11:2: warning: click events and links are not supported",
+	} {
+		if !strings.Contains(diags.Error(), want) {
+			t.Errorf("Förväntade %q, fick:\n%s", want, diags.Error())
+		}
+	}
+
+	_, diags = interpreter.ParseMermaid("sequenceDiagram\n\tA->>B: hej")
+	if !strings.Contains(diags.Error(), "only Mermaid flowcharts can be converted, this is a sequenceDiagram") || len(diags) != 1 {
+		t.Errorf("Förväntade ett fel om diagramtypen, fick:\n%s", diags.Error())
+	}
+}
+
+func TestExportMermaid(t *testing.T) {
+	src := `diagram flowchart (layout=vertical) {
+	node A "Start" (shape=ellipse, color=lightgreen)
+	node end "Säger \"hej\"\noch hejdå" (shape=note)
+	group G "Grupp" {
+		node B "B" (shape=diamond, border=rgb(255, 0, 0))
+	}
+	A -> B "ja"
+	B --> end
+	end -- A (width=4)
+	A ..> B (color=#f00, width=3)
+}`
+	diagram, diags := interpreter.ParseWithTheme(interpreter.Lex(src), "")
+	if diags.HasErrors() {
+		t.Fatalf("Fel vid tolkning:\n%s", diags.Error())
+	}
+	out, warnings, err := interpreter.ExportMermaid(diagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"flowchart TD\n",
+		"    A(Start)\n",
+		`    end2["Säger #quot;hej#quot;<br>och hejdå"]`,
+		"    subgraph G [Grupp]\n        B{B}\n    end\n",
+		"    A -->|ja| B\n",
+		"    B -.-> end2\n",
+		"    end2 === A\n",
+		"    style A fill:lightgreen\n",
+		"    style B stroke:#ff0000\n",
+		"    linkStyle 3 stroke:#ff0000,stroke-width:3px\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Mermaid saknar %q:\n%s", want, out)
+		}
+	}
+	for _, want := range []string{"3:2: warning: Mermaid has no note shape", "8:2: warning: Mermaid has no dashed links", "10:2: warning: Mermaid draws dotted links"} {
+		if !strings.Contains(warnings.Error(), want) {
+			t.Errorf("Förväntade %q, fick:\n%s", want, warnings.Error())
+		}
+	}
+
+	// Flowcharts written by ExportMermaid are read back the same
+	for _, file := range []string{"../example/example2.diag", "../example/shapes1.diag", "../example/style1.diag"} {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, diags := interpreter.ParseFile(interpreter.Lex(string(src)), file, "")
+		if diags.HasErrors() {
+			t.Fatalf("%s: %s", file, diags.Error())
+		}
+		out, _, err := interpreter.ExportMermaid(before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, diags := interpreter.ParseMermaid(out)
+		if diags.HasErrors() {
+			t.Fatalf("%s: %s\n%s", file, diags.Error(), out)
+		}
+		if !reflect.DeepEqual(nodeIDs(after), nodeIDs(before)) || len(after.Edges) != len(before.Edges) {
+			t.Errorf("%s: fick %v och %d kanter, förväntade %v och %d", file, nodeIDs(after), len(after.Edges), nodeIDs(before), len(before.Edges))
+			continue
+		}
+		for i, e := range after.Edges {
+			if b := before.Edges[i]; e.From != b.From || e.To != b.To || e.Label != b.Label || e.Color.Hex() != b.Color.Hex() {
+				t.Errorf("%s: kanten %+v blev %+v", file, b, e)
+			}
+		}
+	}
+
+	// The ids of included nodes have dots, Mermaid gets other ids for them
+	before, diags := parseFile(t, "../example/include1.diag")
+	if diags.HasErrors() {
+		t.Fatal(diags.Error())
+	}
+	out, _, err = interpreter.ExportMermaid(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "    Web -->|REST| services_API\n") {
+		t.Errorf("Fel id för services.API:\n%s", out)
+	}
+	after, diags := interpreter.ParseMermaid(out)
+	if diags.HasErrors() {
+		t.Fatalf("%s\n%s", diags.Error(), out)
+	}
+	if len(after.Nodes) != len(before.Nodes) || len(after.Edges) != len(before.Edges) {
+		t.Errorf("Fick %d noder och %d kanter, förväntade %d och %d", len(after.Nodes), len(after.Edges), len(before.Nodes), len(before.Edges))
+	}
+
+	if _, _, err := interpreter.ExportMermaid(interpreter.Diagram{Name: "sequence"}); err == nil {
+		t.Error("Förväntade fel för ett sekvensdiagram")
+	}
+}
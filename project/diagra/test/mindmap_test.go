@@ -0,0 +1,174 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"math"
+	"strings"
+	"testing"
+)
+
+const mindmapInput = `diagram mindmap {
+	topic Root "Projekt" {
+		topic A "Mål" {
+			topic A1
+			topic A2
+		}
+		topic B "Risker" (color=#ffffff) {
+			topic B1
+		}
+		topic C
+	}
+}`
+
+func TestParser_Mindmap(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(mindmapInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if len(diagram.Nodes) != 7 || len(diagram.Edges) != 6 {
+		t.Fatalf("Förväntade 7 ämnen och 6 grenar, fick %d och %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+	if e := diagram.Edges[0]; e.From != "Root" || e.To != "A" {
+		t.Errorf("Fel första gren: %+v", e)
+	}
+
+	// The colours of a branch are inherited, the own attributes win
+	nodes := map[string]interpreter.Node{}
+	for _, n := range diagram.Nodes {
+		nodes[n.ID] = n
+	}
+	if nodes["A"].Color == nodes["C"].Color {
+		t.Errorf("Grenarna A och C ska ha olika färger")
+	}
+	if nodes["A1"].Color != nodes["A"].Color || nodes["A2"].Border != nodes["A"].Border {
+		t.Errorf("A1 och A2 ska ärva färgerna från A: %+v", nodes["A1"])
+	}
+	if nodes["B"].Color != "#ffffff" || nodes["B1"].Color != "#ffffff" {
+		t.Errorf("B1 ska ärva den egna färgen från B: %+v", nodes["B1"])
+	}
+	if diagram.Edges[1].Color != nodes["A1"].Border {
+		t.Errorf("Grenen ska ha ämnets kantfärg, fick %s", diagram.Edges[1].Color)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestParser_MindmapStyledBranch(t *testing.T) {
+	input := `diagram mindmap {
+	topic Root {
+		topic A (class=varning) {
+			topic A1 {
+				topic A11
+			}
+			topic A2 (border=black)
+		}
+	}
+	style varning (color=red, border=#ff0000)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	nodes := map[string]interpreter.Node{}
+	for _, n := range diagram.Nodes {
+		nodes[n.ID] = n
+	}
+	for _, id := range []string{"A", "A1", "A11"} {
+		if nodes[id].Color != "red" || nodes[id].Border != "#ff0000" {
+			t.Errorf("%s ska ha färgerna från stilen på A: %+v", id, nodes[id])
+		}
+	}
+	if nodes["A2"].Color != "red" || nodes["A2"].Border != "black" {
+		t.Errorf("A2 ska ärva fyllningen men ha sin egen kant: %+v", nodes["A2"])
+	}
+	for _, e := range diagram.Edges {
+		if e.Color != nodes[e.To].Border {
+			t.Errorf("Grenen till %s ska ha ämnets kantfärg, fick %s", e.To, e.Color)
+		}
+	}
+}
+
+func TestValidate_MindmapProblems(t *testing.T) {
+	input := `diagram mindmap {
+	topic Root {
+		node X "X"
+		topic A
+	}
+	topic Other
+	A -> Root
+	state S
+}`
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	want := []string{
+		// from the parser
+		"expected topic inside topic Root",
+		"use nested topics instead of edges in mind maps",
+		"'state' is not allowed in mind maps",
+		// from Validate
+		"mindmap has more than one root: 'Root' and 'Other'",
+		"node 'Other' can not be reached from root 'Root'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestRenderSVG_Mindmap(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(mindmapInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeMindmapLayout(diagram)
+	if len(l.Topics) != 7 || len(l.Branches) != 6 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	root := l.Topics[0]
+	if root.Node.ID != "Root" || root.Depth != 0 {
+		t.Fatalf("Mittenämnet ska komma först: %+v", root)
+	}
+	distance := func(p renderer.PositionedTopic) float64 {
+		return math.Hypot(float64(p.X-root.X), float64(p.Y-root.Y))
+	}
+	var ring1, ring2 []renderer.PositionedTopic
+	for _, p := range l.Topics {
+		switch p.Depth {
+		case 1:
+			ring1 = append(ring1, p)
+		case 2:
+			ring2 = append(ring2, p)
+		}
+	}
+	if len(ring1) != 3 || len(ring2) != 3 {
+		t.Fatalf("Fel antal ämnen per nivå: %d och %d", len(ring1), len(ring2))
+	}
+	for _, p := range ring2 {
+		if distance(p) <= distance(ring1[0])+1 {
+			t.Errorf("%s ska ligga längre ut än första ringen", p.Node.ID)
+		}
+	}
+
+	// Radial: the branches go in different directions, the first one up
+	if a := ring1[0]; a.Y >= root.Y || math.Abs(float64(a.X-root.X)) > 60 {
+		t.Errorf("Första grenen ska peka uppåt: %+v", a)
+	}
+	if ring1[1].X <= root.X || ring1[2].X >= root.X {
+		t.Errorf("Grenarna ska ligga runt mittenämnet: %+v", ring1)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{"<ellipse", ">Projekt<", ">Risker<", `stroke="` + string(l.Branches[0].Edge.Color) + `"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
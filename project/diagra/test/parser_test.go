@@ -2,6 +2,9 @@ package interpreter_test
 
 import (
 	"diagra/interpreter"
+	"diagra/renderer"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -32,3 +35,196 @@ func TestParser_SimpleDiagram(t *testing.T) {
 		t.Errorf("Kantens label borde vara 'Går vidare', fick %s", diagram.Edges[0].Label)
 	}
 }
+
+func TestParser_ErrorIsDiagnostic(t *testing.T) {
+	input := "diagram flowchart {\n\tnode A \"Start\" (color red)\n}"
+
+	_, err := interpreter.Parse(interpreter.Lex(input))
+	if err == nil {
+		t.Fatal("Förväntade ett fel, fick inget")
+	}
+
+	var d *interpreter.Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("Förväntade *interpreter.Diagnostic, fick %T", err)
+	}
+	if d.Pos.Line != 2 || d.Pos.Col != 24 {
+		t.Errorf("Fel position: %s", d.Pos)
+	}
+	if d.Expected != "'='" || d.Found != "identifier 'red'" {
+		t.Errorf("Fel expected/found: %q / %q", d.Expected, d.Found)
+	}
+
+	interpreter.AttachSource(err, input)
+	want := "    \tnode A \"Start\" (color red)\n    \t                      ^"
+	if d.Excerpt != want {
+		t.Errorf("Fel utdrag:\n%s\nförväntade:\n%s", d.Excerpt, want)
+	}
+}
+
+func TestParser_RecoversAndReportsAllErrors(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Start" (color red, shape=ellipse)
+	node "saknar id"
+	node B "Mitten"
+	A B "saknar pil"
+	A -> B "ok" (width=3, color=)
+	node C "Slut"
+	B -> C
+}
+node D "efter slutet"`
+
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+
+	errs, warnings := diags.Count()
+	if errs != 4 || warnings != 1 {
+		t.Fatalf("Förväntade 4 fel och 1 varning, fick %d och %d:\n%s", errs, warnings, diags.Error())
+	}
+
+	wantLines := []int{2, 3, 5, 6, 10}
+	for i, d := range diags {
+		if d.Pos.Line != wantLines[i] {
+			t.Errorf("Diagnos %d på rad %d, förväntade rad %d: %s", i, d.Pos.Line, wantLines[i], d)
+		}
+	}
+
+	// The partial diagram keeps everything that could be parsed
+	if len(diagram.Nodes) != 3 {
+		t.Errorf("Förväntade 3 noder, fick %d", len(diagram.Nodes))
+	}
+	if len(diagram.Edges) != 2 {
+		t.Errorf("Förväntade 2 kanter, fick %d", len(diagram.Edges))
+	}
+	if diagram.Nodes[0].Shape != "ellipse" {
+		t.Errorf("Attribut efter felet borde tolkas, shape=%s", diagram.Nodes[0].Shape)
+	}
+	if diagram.Edges[0].Width.String() != "3" {
+		t.Errorf("Förväntade width=3, fick %s", diagram.Edges[0].Width)
+	}
+
+	_, err := interpreter.Parse(interpreter.Lex(input))
+	var all interpreter.Diagnostics
+	if !errors.As(err, &all) || len(all) != 5 {
+		t.Errorf("Parse borde returnera alla diagnoser som fel, fick %v", err)
+	}
+}
+
+func TestParser_NestedGroups(t *testing.T) {
+	input := `diagram flowchart {
+	node Start "Start"
+	group backend "Backend" (color=#fffde7) {
+		group api "API" {
+			node A "Tjänst A"
+		}
+		node DB "Databas"
+		A -> DB
+	}
+	Start -> A
+}`
+
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	if len(diagram.Nodes) != 3 || len(diagram.Edges) != 2 {
+		t.Fatalf("Förväntade 3 noder och 2 kanter, fick %d och %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+	if len(diagram.Groups) != 1 {
+		t.Fatalf("Förväntade 1 grupp på toppnivå, fick %d", len(diagram.Groups))
+	}
+
+	backend := diagram.Groups[0]
+	if backend.ID != "backend" || backend.Label != "Backend" || backend.Color != "#fffde7" {
+		t.Errorf("Fel grupp: %+v", backend)
+	}
+	if len(backend.Nodes) != 1 || backend.Nodes[0] != "DB" {
+		t.Errorf("Backend borde innehålla DB, fick %v", backend.Nodes)
+	}
+	if len(backend.Groups) != 1 || backend.Groups[0].ID != "api" || backend.Groups[0].Nodes[0] != "A" {
+		t.Errorf("Fel nästlad grupp: %+v", backend.Groups)
+	}
+}
+
+func TestParser_KeywordsOfOtherTypes(t *testing.T) {
+	input := `diagram flowchart {
+	node task "Task"
+	node note "Note"
+	A -> task
+	note -> include
+	participant X
+}`
+
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	if len(diagram.Nodes) != 4 || len(diagram.Edges) != 2 {
+		t.Fatalf("Förväntade 4 noder och 2 kanter, fick %d och %d", len(diagram.Nodes), len(diagram.Edges))
+	}
+	if diagram.Nodes[0].ID != "task" || diagram.Edges[1].To != "include" {
+		t.Errorf("Ord från andra diagramtyper ska vara id:n i flödesscheman: %+v %+v", diagram.Nodes, diagram.Edges)
+	}
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "'participant' is only allowed in sequence diagrams") {
+		t.Errorf("Förväntade ett fel om 'participant', fick:\n%s", diags.Error())
+	}
+}
+
+func TestParser_ImplicitNodesInGroup(t *testing.T) {
+	input := `diagram flowchart {
+	group g "G" {
+		A -> B
+	}
+	B -> C
+}`
+
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	if len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+	if len(diagram.Nodes) != 3 {
+		t.Fatalf("Förväntade 3 noder, fick %+v", diagram.Nodes)
+	}
+	// A and B are first used in the group, C at the top level
+	if g := diagram.Groups[0]; len(g.Nodes) != 2 || g.Nodes[0] != "A" || g.Nodes[1] != "B" {
+		t.Errorf("Gruppen borde innehålla A och B, fick %v", g.Nodes)
+	}
+}
+
+func TestComputeGroupBoxes_ImplicitNodes(t *testing.T) {
+	input := `diagram flowchart {
+	group G "G" {
+		node A "A"
+		A -> X
+	}
+	node B "B"
+	node C "C"
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	// X comes right after A, the layouts place the nodes in this order
+	var ids []string
+	for _, n := range diagram.Nodes {
+		ids = append(ids, n.ID)
+	}
+	if strings.Join(ids, " ") != "A X B C" {
+		t.Errorf("Förväntade ordningen A X B C, fick %v", ids)
+	}
+
+	for _, layout := range []func(interpreter.Diagram) ([]renderer.PositionedNode, []renderer.PositionedEdge){
+		renderer.ComputeLayout, renderer.ComputeVerticalLayout,
+	} {
+		pNodes, _ := layout(diagram)
+		boxes := renderer.ComputeGroupBoxes(diagram, pNodes)
+		if len(boxes) != 1 {
+			t.Fatalf("Förväntade en grupp, fick %+v", boxes)
+		}
+		box := boxes[0]
+		for _, pn := range pNodes {
+			inside := pn.X > box.X && pn.X < box.X+box.W && pn.Y > box.Y && pn.Y < box.Y+box.H
+			if member := pn.Node.ID == "A" || pn.Node.ID == "X"; inside != member {
+				t.Errorf("Nod %s vid (%d, %d), gruppen %+v", pn.Node.ID, pn.X, pn.Y, box)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+const sequenceInput = `diagram sequence {
+	actor U "Användare"
+	participant API "API"
+	participant DB
+
+	U -> API "hämta"
+	activate API
+	API ->> DB "fråga"
+	note right of DB "långsam"
+	alt "träff" {
+		DB --> API "rad"
+	} else "miss" {
+		loop "försök igen" {
+			API -> API "vänta"
+		}
+	}
+	deactivate API
+	note over U, API "klart"
+}`
+
+func TestParser_SequenceDiagram(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(sequenceInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	if len(diagram.Nodes) != 3 {
+		t.Fatalf("Förväntade 3 deltagare, fick %d", len(diagram.Nodes))
+	}
+	if diagram.Nodes[0].Shape != "actor" || diagram.Nodes[2].Label != "DB" {
+		t.Errorf("Fel deltagare: %+v", diagram.Nodes)
+	}
+	if len(diagram.Edges) != 0 {
+		t.Errorf("Meddelanden ska inte bli kanter, fick %d", len(diagram.Edges))
+	}
+
+	kinds := []interpreter.StepKind{
+		interpreter.StepMessage, interpreter.StepActivate, interpreter.StepMessage,
+		interpreter.StepNote, interpreter.StepFragment, interpreter.StepDeactivate,
+		interpreter.StepNote,
+	}
+	if len(diagram.Steps) != len(kinds) {
+		t.Fatalf("Förväntade %d steg, fick %d", len(kinds), len(diagram.Steps))
+	}
+	for i, k := range kinds {
+		if diagram.Steps[i].Kind != k {
+			t.Errorf("Steg %d: förväntade %s, fick %s", i, k, diagram.Steps[i].Kind)
+		}
+	}
+
+	if diagram.Steps[0].Message != interpreter.MessageSync || diagram.Steps[2].Message != interpreter.MessageAsync {
+		t.Errorf("Fel meddelandetyper: %s, %s", diagram.Steps[0].Message, diagram.Steps[2].Message)
+	}
+	if note := diagram.Steps[6]; note.Placement != "over" || len(note.Participants) != 2 || note.Text != "klart" {
+		t.Errorf("Fel anteckning: %+v", note)
+	}
+
+	alt := diagram.Steps[4].Fragment
+	if alt.Kind != "alt" || len(alt.Sections) != 2 || alt.Sections[1].Label != "miss" {
+		t.Fatalf("Fel alt-fragment: %+v", alt)
+	}
+	if ret := alt.Sections[0].Steps[0]; ret.Message != interpreter.MessageReturn || ret.Edge.Label != "rad" {
+		t.Errorf("Fel returmeddelande: %+v", ret)
+	}
+	if loop := alt.Sections[1].Steps[0].Fragment; loop == nil || loop.Kind != "loop" || len(loop.Sections[0].Steps) != 1 {
+		t.Errorf("Fel nästlad loop: %+v", loop)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestValidate_SequenceProblems(t *testing.T) {
+	input := `diagram sequence {
+	participant A
+	A -> B "till ingen"
+	deactivate A
+	activate A
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	diags := interpreter.Validate(diagram)
+	want := []string{
+		"undefined participant 'B'",
+		"deactivate 'A' without activate",
+		"'A' is activated but never deactivated",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestParser_SequenceOnlySyntax(t *testing.T) {
+	input := `diagram flowchart {
+	node A "A"
+	node B "B"
+	A ->> B
+	activate A
+}`
+	_, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	if errs, _ := diags.Count(); errs != 2 {
+		t.Errorf("Förväntade 2 fel, fick:\n%s", diags.Error())
+	}
+}
+
+func TestRenderSVG_Sequence(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(sequenceInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeSequenceLayout(diagram)
+	if len(l.Participants) != 3 || len(l.Messages) != 4 || len(l.Activations) != 1 || len(l.Notes) != 2 || len(l.Fragments) != 2 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	for i := 1; i < len(l.Messages); i++ {
+		if l.Messages[i].Y <= l.Messages[i-1].Y {
+			t.Errorf("Meddelanden ska komma i ordning uppifrån och ner: %d <= %d", l.Messages[i].Y, l.Messages[i-1].Y)
+		}
+	}
+	// The message from the activated API leaves from the edge of the bar
+	api := l.Participants[1].X
+	if l.Messages[1].FromX != api+5 {
+		t.Errorf("Meddelandet borde börja vid aktiveringsstapeln (%d), fick %d", api+5, l.Messages[1].FromX)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{"stroke-dasharray=\"6,4\"", "url(#seq-open)", "url(#seq-filled)", ">[försök igen]<"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
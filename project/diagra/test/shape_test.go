@@ -0,0 +1,82 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+// Every node has the same size so the coordinates are easy to follow
+const shapeInput = `diagram flowchart {
+	node A "Start" (shape=circle, width=100, height=50)
+	node B "Val?" (shape=diamond, width=100, height=50)
+	node C "Läs" (shape=parallelogram, width=100, height=50)
+	node D "Steg" (shape=hexagon, width=100, height=50)
+	node E "Databas" (shape=cylinder, width=100, height=50)
+	node F "Obs" (shape=note, width=100, height=50)
+	A -> B -> C -> D -> E -> F
+}`
+
+func TestValidate_Shapes(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(shapeInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Alla former ska vara kända, fick:\n%s", diags.Error())
+	}
+	// note is a keyword in sequence diagrams but still a value here
+	if diagram.Nodes[5].Shape != "note" {
+		t.Errorf("Förväntade formen note, fick %s", diagram.Nodes[5].Shape)
+	}
+}
+
+func TestRenderSVG_Shapes(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(shapeInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{
+		`<circle cx="150" cy="150" r="37"`,
+		`<polygon points="350,125 400,150 350,175 300,150"`,    // diamond
+		`<polygon points="517,125 600,125 583,175 500,175"`,    // parallelogram
+		`<polygon points="700,150 717,125 783,125 800,150`,     // hexagon
+		`<ellipse cx="950" cy="133" rx="50" ry="8"`,            // top of the cylinder
+		`<path d="M 1100 125 L 1188 125 L 1200 137 L 1200 175`, // note with a folded corner
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("Saknar %q i SVG:\n%s", want, svg)
+		}
+	}
+
+	// Edges end on the outlines: the right of the circle (r=37) to the
+	// left tip of the diamond
+	if !strings.Contains(svg, `<line x1="187" y1="150" x2="300" y2="150"`) {
+		t.Errorf("Kanten ska gå mellan formernas kanter:\n%s", svg)
+	}
+}
+
+func TestRenderSVG_EdgeOnDiamondOutline(t *testing.T) {
+	diagram := interpreter.Diagram{
+		Name: "tree",
+		Nodes: []interpreter.Node{
+			{ID: "A", Label: "A", Shape: "diamond", Width: px(100), Height: px(50)},
+			{ID: "B", Label: "B", Shape: "rect"},
+			{ID: "C", Label: "C", Shape: "rect"},
+		},
+		Edges: []interpreter.Edge{{From: "A", To: "B"}, {From: "A", To: "C"}},
+	}
+	svg := renderer.RenderSVG(diagram)
+	// A at 100,100, C at 260,220: the edge leaves the diamond on its lower
+	// right side and enters C on its top side
+	if !strings.Contains(svg, `<line x1="120" y1="115" x2="227" y2="195"`) {
+		t.Errorf("Förväntade en kant från romben till C:s ovansida:\n%s", svg)
+	}
+}
+
+// px is a length in pixels
+func px(v float64) interpreter.Length {
+	return interpreter.Length{Value: v, Unit: "px"}
+}
@@ -0,0 +1,199 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+const stateInput = `diagram state {
+	initial Start
+	state Idle "Väntar"
+	state Busy "Arbetar" {
+		initial BusyStart
+		state Loading
+		BusyStart -> Loading
+	}
+	final End
+
+	Start -> Idle
+	Idle -> Busy "start [redo] / lås()"
+	Busy -> Idle "avbryt"
+	Idle -> Idle "tick"
+	Busy -> End
+}`
+
+func TestParser_StateDiagram(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(stateInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	if len(diagram.Nodes) != 5 {
+		t.Fatalf("Förväntade 5 tillstånd, fick %d", len(diagram.Nodes))
+	}
+	if diagram.Nodes[0].Shape != "initial" || diagram.Nodes[1].Label != "Väntar" || diagram.Nodes[4].Shape != "final" {
+		t.Errorf("Fel tillstånd: %+v", diagram.Nodes)
+	}
+	if len(diagram.Groups) != 1 || diagram.Groups[0].ID != "Busy" || len(diagram.Groups[0].Nodes) != 2 {
+		t.Fatalf("Förväntade det sammansatta tillståndet Busy, fick %+v", diagram.Groups)
+	}
+
+	want := interpreter.Transition{Event: "start", Guard: "redo", Action: "lås()"}
+	if got := diagram.Edges[2].Transition; got != want {
+		t.Errorf("Fel övergång: förväntade %+v, fick %+v", want, got)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestParseTransition(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interpreter.Transition
+		ok    bool
+	}{
+		{"klick", interpreter.Transition{Event: "klick"}, true},
+		{"klick [x > 0]", interpreter.Transition{Event: "klick", Guard: "x > 0"}, true},
+		{"/ starta()", interpreter.Transition{Action: "starta()"}, true},
+		{"tid [a/b] / spara", interpreter.Transition{Event: "tid", Guard: "a/b", Action: "spara"}, true},
+		{"", interpreter.Transition{}, true},
+		{"klick [x > 0", interpreter.Transition{}, false},
+		{"klick x]", interpreter.Transition{}, false},
+		{"klick /", interpreter.Transition{}, false},
+	}
+	for _, tt := range tests {
+		got, err := interpreter.ParseTransition(tt.input)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseTransition(%q): fel = %v, förväntade ok = %v", tt.input, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("ParseTransition(%q) = %+v, förväntade %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestValidate_StateProblems(t *testing.T) {
+	input := `diagram state {
+	initial Start
+	state A
+	state B
+	final End
+	Start -> A "[x"
+	A -> Start
+	A -> End
+	End -> A
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	diags := interpreter.Validate(diagram)
+	want := []string{
+		"state 'B' can not be reached",
+		"missing ']'",
+		"transition into initial state 'Start'",
+		"transition out of final state 'End'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestParser_StateOnlySyntax(t *testing.T) {
+	input := `diagram flowchart {
+	state A
+	node B "B"
+}`
+	_, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	if errs, _ := diags.Count(); errs != 1 || !strings.Contains(diags[0].Message, "only allowed in state diagrams") {
+		t.Errorf("Förväntade ett fel om 'state', fick:\n%s", diags.Error())
+	}
+}
+
+func TestParser_StateDefaultStyle(t *testing.T) {
+	input := `diagram state {
+	style node (shape=rect, color=#e3f2fd)
+	initial Start
+	state Idle (shape=ellipse)
+	final End
+	Start -> Idle
+	Idle -> End
+}`
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	want := []string{
+		"2:14: 'shape' is not allowed in state diagrams",
+		"4:14: 'shape' is not allowed in state diagrams",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Error(), w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+
+	// The style keeps the kind of every state, the colour is still used
+	for i, shape := range []string{"initial", "rect", "final"} {
+		if n := diagram.Nodes[i]; n.Shape != shape || n.Color != "#e3f2fd" {
+			t.Errorf("Förväntade formen %s och stilens färg, fick %+v", shape, n)
+		}
+	}
+	if svg := renderer.RenderSVG(diagram); !strings.Contains(svg, "<circle") {
+		t.Errorf("Start- och sluttillståndet ska ritas som cirklar")
+	}
+}
+
+func TestRenderSVG_State(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(stateInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	l := renderer.ComputeStateLayout(diagram)
+	if len(l.States) != 5 || len(l.Composites) != 1 || len(l.Transitions) != 6 {
+		t.Fatalf("Fel antal element i layouten: %+v", l)
+	}
+	y := map[string]int{}
+	for _, s := range l.States {
+		y[s.Node.ID] = s.Y
+	}
+	if !(y["Start"] < y["Idle"] && y["Idle"] < y["End"]) {
+		t.Errorf("Tillstånden ska ligga i lager uppifrån och ner: %v", y)
+	}
+	// The composite state contains its inner states
+	busy := l.Composites[0]
+	for _, s := range l.States {
+		if s.Node.ID == "Loading" && (s.Y < busy.Y || s.Y > busy.Y+busy.H) {
+			t.Errorf("Loading ligger utanför Busy: %d inte i %d..%d", s.Y, busy.Y, busy.Y+busy.H)
+		}
+	}
+
+	var back, self bool
+	for _, tr := range l.Transitions {
+		back = back || (tr.Edge.From == "Busy" && tr.Edge.To == "Idle" && tr.Curved)
+		self = self || tr.Self
+	}
+	if !back || !self {
+		t.Errorf("Förväntade en böjd övergång tillbaka och en självövergång: %+v", l.Transitions)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{"url(#state-arrow)", ">start [redo] / lås()<", "<circle"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+func TestLexer_StringEscapes(t *testing.T) {
+	cases := map[string]string{
+		`"enkel"`:               "enkel",
+		`"citat \"här\""`:       `citat "här"`,
+		`"snedstreck \\ kvar"`:  `snedstreck \ kvar`,
+		`"rad 1\nrad 2\tflik"`:  "rad 1\nrad 2\tflik",
+		`"G\u00e5r vidare"`:     "Går vidare",
+		`"emoji \u{1F600}"`:     "emoji 😀",
+		`"""tre citattecken"""`: "tre citattecken",
+	}
+	for input, want := range cases {
+		tokens := interpreter.Lex(input)
+		if len(tokens) != 2 || tokens[0].Type != interpreter.TOKEN_STRING {
+			t.Errorf("%s: förväntade en sträng, fick %v", input, tokens)
+			continue
+		}
+		if tokens[0].Value != want {
+			t.Errorf("%s: förväntade %q, fick %q", input, want, tokens[0].Value)
+		}
+	}
+}
+
+func TestLexer_MultilineString(t *testing.T) {
+	input := "node A \"\"\"\n\t\tFörsta raden\n\t\t  indragen\n\t\tSista \"raden\"\n\t\"\"\" (shape=ellipse)"
+
+	tokens := interpreter.Lex(input)
+	if tokens[2].Type != interpreter.TOKEN_STRING {
+		t.Fatalf("Förväntade sträng, fick %s", tokens[2].Type)
+	}
+	want := "Första raden\n  indragen\nSista \"raden\""
+	if tokens[2].Value != want {
+		t.Errorf("Förväntade %q, fick %q", want, tokens[2].Value)
+	}
+	if tokens[3].Value != "(" || tokens[3].Pos.Line != 5 {
+		t.Errorf("Fel token efter strängen: %+v", tokens[3])
+	}
+}
+
+func TestParser_StringErrors(t *testing.T) {
+	input := `diagram flowchart {
+	node A "aldrig stängd
+	node B "okänd \q escape"
+	node C "ok" ; 
+}`
+
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+
+	want := []struct {
+		line, col int
+		text      string
+	}{
+		{2, 9, "unterminated string"},
+		{3, 16, `unknown escape sequence \q`},
+		{4, 14, "unexpected character ';'"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d fel, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		d := diags[i]
+		if d.Pos.Line != w.line || d.Pos.Col != w.col || !strings.Contains(d.Message, w.text) {
+			t.Errorf("Fel %d: förväntade %d:%d %q, fick %s", i, w.line, w.col, w.text, d)
+		}
+	}
+
+	// The parser goes on after the broken strings
+	if len(diagram.Nodes) != 3 {
+		t.Fatalf("Förväntade 3 noder, fick %d", len(diagram.Nodes))
+	}
+	if diagram.Nodes[0].Label != "aldrig stängd" || diagram.Nodes[1].Label != "okänd q escape" {
+		t.Errorf("Fel etiketter: %q, %q", diagram.Nodes[0].Label, diagram.Nodes[1].Label)
+	}
+}
+
+func TestRenderSVG_MultilineLabel(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Rad ett\nRad <två>"
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{
+		`<tspan x="150" dy="0">Rad ett</tspan>`,
+		`<tspan x="150" dy="16.8">Rad &lt;två&gt;</tspan>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s:\n%s", want, svg)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"strings"
+	"testing"
+)
+
+func TestParser_StyleCascade(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Standard" (class=warning)
+	node B "Klass" (class="warning round", border=black)
+	node C "Egen" (color=blue)
+	group G (class=warning) {
+		node D "D"
+	}
+	A -> B (class=thick)
+	B -> C
+
+	style node (color=#fff3e0, border=#ef6c00)
+	style warning (color=red, text=white)
+	style round (shape=ellipse, border=green)
+	style edge (color=gray)
+	style thick (width=5)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if len(diagram.Styles) != 5 {
+		t.Fatalf("Förväntade 5 stilar, fick %d", len(diagram.Styles))
+	}
+
+	tests := []struct {
+		node                int
+		color, text, border interpreter.Color
+		shape               string
+	}{
+		{0, "red", "white", "#ef6c00", "rect"},    // default style, then the class
+		{1, "red", "white", "black", "ellipse"},   // two classes, then its own border
+		{2, "blue", "#004d40", "#ef6c00", "rect"}, // own colour over the default style
+		{3, "#fff3e0", "#004d40", "#ef6c00", "rect"},
+	}
+	for _, tt := range tests {
+		n := diagram.Nodes[tt.node]
+		if n.Color != tt.color || n.Text != tt.text || n.Border != tt.border || n.Shape != tt.shape {
+			t.Errorf("Nod %s: förväntade %s/%s/%s/%s, fick %s/%s/%s/%s", n.ID,
+				tt.color, tt.text, tt.border, tt.shape, n.Color, n.Text, n.Border, n.Shape)
+		}
+	}
+
+	if g := diagram.Groups[0]; g.Color != "red" || g.Text != "white" || g.Border != "#90a4ae" {
+		t.Errorf("Gruppen ska få klassens färger: %+v", g)
+	}
+	if e := diagram.Edges[0]; e.Color != "gray" || e.Width.Value != 5 {
+		t.Errorf("Kanten ska få standardstilen och klassen: %+v", e)
+	}
+	if e := diagram.Edges[1]; e.Color != "gray" || e.Width.Value != 2 {
+		t.Errorf("Kanten ska få standardstilen: %+v", e)
+	}
+
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestValidate_StyleProblems(t *testing.T) {
+	input := `diagram flowchart {
+	style warning (color=nope, size=3)
+	style warning (color=red)
+	style (color=red)
+	node A "A" (class=missing)
+}`
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	diags = append(diags, interpreter.Validate(diagram)...)
+	want := []string{
+		// from the parser
+		"color: unknown colour 'nope'",
+		"expected style name after 'style'",
+		"undefined style 'missing'",
+		// from Validate
+		"unknown style attribute 'size'",
+		"duplicate style 'warning'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestParser_StyleInClassDiagram(t *testing.T) {
+	input := `diagram class {
+	style abstract (color=#eeeeee)
+	class Shape (class=abstract)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if diagram.Nodes[0].Color != "#eeeeee" {
+		t.Errorf("class= ska fungera även där class är ett nyckelord, fick %s", diagram.Nodes[0].Color)
+	}
+}
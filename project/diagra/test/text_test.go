@@ -0,0 +1,106 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParser_NodeSize(t *testing.T) {
+	input := `diagram flowchart {
+	style big (width=3cm)
+	node A "A" (width=160, height=80)
+	node B "B" (class=big)
+	node C "C" (width=50%)
+}`
+	diagram, diags := interpreter.ParseWithTheme(interpreter.Lex(input), "")
+	if w, ok := diagram.Nodes[0].Width.Pixels(); !ok || w != 160 {
+		t.Errorf("Förväntade bredden 160, fick %v", diagram.Nodes[0].Width)
+	}
+	if h, ok := diagram.Nodes[0].Height.Pixels(); !ok || h != 80 {
+		t.Errorf("Förväntade höjden 80, fick %v", diagram.Nodes[0].Height)
+	}
+	if diagram.Nodes[1].Width.String() != "3cm" {
+		t.Errorf("Bredden ska komma från stilen, fick %v", diagram.Nodes[1].Width)
+	}
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "percentage") {
+		t.Fatalf("Förväntade ett fel om procent, fick:\n%s", diags.Error())
+	}
+	if diags[0].Pos.Line != 5 {
+		t.Errorf("Felet ska vara på rad 5, fick %s", diags[0].Pos)
+	}
+}
+
+func TestRenderSVG_WrappedLabel(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Går vidare till nästa steg i processen när allt är klart"
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	svg := renderer.RenderSVG(diagram)
+	if n := strings.Count(svg, "<tspan"); n != 2 {
+		t.Errorf("Förväntade etiketten på två rader, fick %d:\n%s", n, svg)
+	}
+	m := regexp.MustCompile(`<rect x="\d+" y="\d+" width="(\d+)"`).FindStringSubmatch(svg)
+	if m == nil {
+		t.Fatalf("Hittade ingen nod:\n%s", svg)
+	}
+	if w, _ := strconv.Atoi(m[1]); w <= 100 || w > 200 {
+		t.Errorf("Noden ska vara bredare än 100 men högst 200, fick %d", w)
+	}
+	// An empty line in the label is kept, it is not a wrapped word
+	diagram, err = interpreter.Parse(interpreter.Lex(`diagram flowchart { node A "Ett\n\nTre" }`))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if n := strings.Count(renderer.RenderSVG(diagram), "<tspan"); n != 3 {
+		t.Errorf("Förväntade tre rader, fick %d", n)
+	}
+}
+
+func TestRenderSVG_SmallNode(t *testing.T) {
+	input := `diagram flowchart {
+	node A "Mmm" (width=2.5, height=1)
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	svg := renderer.RenderSVG(diagram)
+	// Too small sizes are the smallest size a node has, 100 by 50
+	if !strings.Contains(svg, `width="100" height="50"`) {
+		t.Errorf("Noden ska vara 100 gånger 50:\n%s", svg)
+	}
+	if strings.Contains(svg, "<tspan") || !strings.Contains(svg, ">Mmm</text>") {
+		t.Errorf("Förväntade etiketten på en rad:\n%s", svg)
+	}
+}
+
+func TestComputeLayout_WideNodes(t *testing.T) {
+	input := `diagram flowchart {
+	node A "A"
+	node B "En ganska lång etikett som bryts"
+	node C "C" (width=300)
+	node D "D"
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	pNodes, _ := renderer.ComputeLayout(diagram)
+	if pNodes[0].X != 150 {
+		t.Errorf("Första noden ska ha mitten vid 150, fick %d", pNodes[0].X)
+	}
+	// The gap between two nodes is the same however wide they are
+	if gap := (pNodes[3].X - 50) - (pNodes[2].X + 150); gap != 100 {
+		t.Errorf("Förväntade 100 mellan C och D, fick %d", gap)
+	}
+	if pNodes[2].X-150 <= pNodes[1].X {
+		t.Errorf("C ska inte överlappa B: B vid %d, C vid %d", pNodes[1].X, pNodes[2].X)
+	}
+}
@@ -0,0 +1,117 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"diagra/renderer"
+	"strings"
+	"testing"
+)
+
+const themeInput = `diagram flowchart (theme=dark) {
+	node A "A"
+	node B "B" (color=red)
+	A -> B
+}`
+
+func TestParser_Theme(t *testing.T) {
+	dark, _ := interpreter.LookupTheme("dark")
+	diagram, err := interpreter.Parse(interpreter.Lex(themeInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if diagram.Theme.Name != "dark" {
+		t.Fatalf("Förväntade temat dark, fick %q", diagram.Theme.Name)
+	}
+	a, b := diagram.Nodes[0], diagram.Nodes[1]
+	if a.Color != dark.NodeColor || a.Text != dark.NodeText || a.Border != dark.NodeBorder {
+		t.Errorf("Noden ska få temats färger: %+v", a)
+	}
+	if b.Color != "red" || b.Border != dark.NodeBorder {
+		t.Errorf("Egna attribut ska vinna över temat: %+v", b)
+	}
+	if diagram.Edges[0].Color != dark.EdgeColor {
+		t.Errorf("Kanten ska få temats färg, fick %s", diagram.Edges[0].Color)
+	}
+
+	// A theme from outside wins, high-contrast is the same as high_contrast
+	diagram, diags := interpreter.ParseWithTheme(interpreter.Lex(themeInput), "high-contrast")
+	if len(diags) != 0 || diagram.Theme.Name != "high_contrast" || diagram.Nodes[0].Color != "#000000" {
+		t.Errorf("Förväntade temat high_contrast, fick %q %v", diagram.Theme.Name, diags)
+	}
+
+	// Without a theme the colours are the light theme
+	diagram, _ = interpreter.Parse(interpreter.Lex(`diagram flowchart { node A "A" }`))
+	if diagram.Theme.Name != interpreter.DefaultTheme || diagram.Nodes[0].Color != "#e0f7fa" {
+		t.Errorf("Förväntade standardtemat, fick %q", diagram.Theme.Name)
+	}
+
+	_, diags = interpreter.ParseDiagnostics(interpreter.Lex(`diagram flowchart (theme=neon) { }`))
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "unknown theme 'neon', expected one of: colorblind, dark") {
+		t.Errorf("Förväntade fel för okänt tema, fick %v", diags)
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	src := `# ett eget tema
+name = ocean
+base = dark
+background = #002b36
+node_color = #073642
+branch = "#073642 #2aa198"
+branch = "#073642 #b58900"
+`
+	theme, diags := interpreter.ParseTheme(src, "file")
+	if len(diags) != 0 {
+		t.Fatalf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+	dark, _ := interpreter.LookupTheme("dark")
+	if theme.Name != "ocean" || theme.Background != "#002b36" || theme.NodeColor != "#073642" {
+		t.Errorf("Fel tema: %+v", theme)
+	}
+	if theme.EdgeColor != dark.EdgeColor {
+		t.Errorf("Det som inte anges ska komma från base, fick %s", theme.EdgeColor)
+	}
+	if len(theme.Palette) != 2 || theme.Palette[1][1] != "#b58900" {
+		t.Errorf("Fel grenfärger: %v", theme.Palette)
+	}
+
+	_, diags = interpreter.ParseTheme(`base = neon
+node_color = nope
+shadow = #000000
+branch = "#000000"
+text`, "broken")
+	want := []string{
+		"unknown base theme 'neon'",
+		"node_color: unknown colour 'nope'",
+		"unknown theme key 'shadow'",
+		"branch needs two colours",
+		"expected key = value",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick:\n%s", len(want), diags.Error())
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("Diagnos %d: förväntade %q, fick %s", i, w, diags[i])
+		}
+	}
+}
+
+func TestRenderSVG_Theme(t *testing.T) {
+	diagram, err := interpreter.Parse(interpreter.Lex(themeInput))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	svg := renderer.RenderSVG(diagram)
+	for _, want := range []string{`<rect width="100%" height="100%" fill="#263238"/>`, `fill="#b0bec5"/>`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG saknar %s", want)
+		}
+	}
+
+	// The light theme has no background, as before themes
+	diagram, _ = interpreter.Parse(interpreter.Lex(`diagram flowchart { node A "A" }`))
+	if svg := renderer.RenderSVG(diagram); strings.Contains(svg, `height="100%"`) {
+		t.Errorf("Standardtemat ska inte ha någon bakgrund")
+	}
+}
@@ -0,0 +1,115 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"strings"
+	"testing"
+)
+
+// at is a short way to give test nodes and edges distinct positions
+func at(line int) interpreter.Position {
+	return interpreter.Position{Line: line, Col: 1, Offset: line * 100}
+}
+
+func TestValidate_ValidDiagram(t *testing.T) {
+	input := `
+		diagram tree {
+			node A "Root"
+			node B "Left" (shape=ellipse)
+			node C "Right"
+			A -> B
+			A -> C
+		}
+	`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Fel vid tolkning: %v", err)
+	}
+	if diags := interpreter.Validate(diagram); len(diags) != 0 {
+		t.Errorf("Förväntade inga diagnoser, fick:\n%s", diags.Error())
+	}
+}
+
+func TestValidate_Problems(t *testing.T) {
+	diagram := interpreter.Diagram{
+		Name:  "flowchart",
+		Attrs: []interpreter.Attribute{{Key: "layuot", Value: "vertical", Pos: at(1)}},
+		Nodes: []interpreter.Node{
+			{ID: "A", Shape: "rect", Pos: at(2)},
+			{ID: "A", Shape: "rect", Pos: at(3)},
+			{ID: "B", Shape: "star", Pos: at(4)},
+			{ID: "C", Shape: "rect", Pos: at(5), Attrs: []interpreter.Attribute{{Key: "colour", Value: "red", Pos: at(5)}}},
+			{ID: "D", Shape: "rect", Pos: at(6)},
+		},
+		Edges: []interpreter.Edge{
+			{From: "A", To: "B", Pos: at(7)},
+			{From: "B", To: "X", Pos: at(8)},
+			{From: "C", To: "D", Pos: at(9)},
+			{From: "D", To: "C", Pos: at(10)},
+		},
+	}
+
+	diags := interpreter.Validate(diagram)
+
+	want := []struct {
+		line    int
+		warning bool
+		text    string
+	}{
+		{1, true, "unknown diagram attribute 'layuot'"},
+		{3, false, "duplicate node id 'A'"},
+		{4, true, "unknown shape 'star'"},
+		{5, true, "unknown node attribute 'colour'"},
+		{5, true, "node 'C' can not be reached"},
+		{6, true, "node 'D' can not be reached"},
+		{8, false, "edge to undefined node 'X'"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Förväntade %d diagnoser, fick %d:\n%s", len(want), len(diags), diags.Error())
+	}
+	for i, w := range want {
+		d := diags[i]
+		isWarning := d.Severity == interpreter.SeverityWarning
+		if d.Pos.Line != w.line || isWarning != w.warning || !strings.Contains(d.Message, w.text) {
+			t.Errorf("Diagnos %d: förväntade rad %d %q (varning=%v), fick %s", i, w.line, w.text, w.warning, d)
+		}
+	}
+}
+
+func TestValidate_TreeStructure(t *testing.T) {
+	diagram := interpreter.Diagram{
+		Name: "tree",
+		Nodes: []interpreter.Node{
+			{ID: "A", Shape: "rect", Pos: at(1)},
+			{ID: "B", Shape: "rect", Pos: at(2)},
+			{ID: "C", Shape: "rect", Pos: at(3)},
+			{ID: "D", Shape: "rect", Pos: at(4)},
+		},
+		Edges: []interpreter.Edge{
+			{From: "A", To: "B", Pos: at(5)},
+			{From: "B", To: "C", Pos: at(6)},
+			{From: "C", To: "B", Pos: at(7)},
+		},
+	}
+
+	diags := interpreter.Validate(diagram)
+
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	all := strings.Join(messages, "\n")
+
+	for _, want := range []string{
+		"tree has more than one root: 'A' and 'D'",
+		"node 'B' has more than one parent",
+		"edge C -> B creates a cycle",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Saknar %q bland:\n%s", want, all)
+		}
+	}
+	if !diags.HasErrors() {
+		t.Error("Trädfel borde vara fel, inte varningar")
+	}
+}
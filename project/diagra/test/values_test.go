@@ -0,0 +1,138 @@
+package interpreter_test
+
+import (
+	"diagra/interpreter"
+	"testing"
+)
+
+func TestLexer_ColorsAndNumbers(t *testing.T) {
+	input := `node A "A" (color=#FF0000, border=rgb(0, 128, 255)) # kommentar
+	A -> B (width=2.5px)`
+
+	expected := []interpreter.Token{
+		{Type: interpreter.TOKEN_KEYWORD, Value: "node"},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "A"},
+		{Type: interpreter.TOKEN_STRING, Value: "A"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: "("},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "color"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: "="},
+		{Type: interpreter.TOKEN_COLOR, Value: "#FF0000"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: ","},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "border"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: "="},
+		{Type: interpreter.TOKEN_COLOR, Value: "rgb(0, 128, 255)"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: ")"},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "A"},
+		{Type: interpreter.TOKEN_ARROW, Value: "->"},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "B"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: "("},
+		{Type: interpreter.TOKEN_IDENTIFIER, Value: "width"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: "="},
+		{Type: interpreter.TOKEN_NUMBER, Value: "2.5px"},
+		{Type: interpreter.TOKEN_SYMBOL, Value: ")"},
+		{Type: interpreter.TOKEN_EOF, Value: ""},
+	}
+
+	tokens := interpreter.Lex(input)
+	if len(tokens) != len(expected) {
+		t.Fatalf("Förväntade %d tokens, fick %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, token := range tokens {
+		if token.Type != expected[i].Type || token.Value != expected[i].Value {
+			t.Errorf("Token %d felaktig: förväntade (%s, %q), fick (%s, %q)",
+				i, expected[i].Type, expected[i].Value, token.Type, token.Value)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	valid := map[string]interpreter.Color{
+		"#FF0000":              "#ff0000",
+		"#abc":                 "#abc",
+		"#11223344":            "#11223344",
+		"LightGreen":           "lightgreen",
+		"rgb(0,128, 255)":      "rgb(0, 128, 255)",
+		"rgba(10%, 0, 0, 0.5)": "rgba(10%, 0, 0, 0.5)",
+	}
+	for in, want := range valid {
+		got, err := interpreter.ParseColor(in)
+		if err != nil || got != want {
+			t.Errorf("ParseColor(%q) = %q, %v; förväntade %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"#ff000", "#ggg", "ff0000", "reddish", "rgb(1, 2)", "rgb(300, 0, 0)", "rgba(0, 0, 0, 2)"} {
+		if _, err := interpreter.ParseColor(in); err == nil {
+			t.Errorf("ParseColor(%q) borde ge fel", in)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	valid := map[string]interpreter.Length{
+		"2":     {Value: 2},
+		"2.5":   {Value: 2.5},
+		"3px":   {Value: 3, Unit: "px"},
+		"50%":   {Value: 50, Unit: "%"},
+		"1.5EM": {Value: 1.5, Unit: "em"},
+	}
+	for in, want := range valid {
+		got, err := interpreter.ParseLength(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLength(%q) = %v, %v; förväntade %v", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "px", "2.5.1", "3furlongs"} {
+		if _, err := interpreter.ParseLength(in); err == nil {
+			t.Errorf("ParseLength(%q) borde ge fel", in)
+		}
+	}
+}
+
+func TestParser_InvalidAttributeValues(t *testing.T) {
+	input := `diagram flowchart {
+	node A "A" (color=#ff0000, text=notacolour)
+	node B "B" (border="#00ff00")
+	A -> B (color=blue, width=3furlongs)
+}`
+
+	diagram, diags := interpreter.ParseDiagnostics(interpreter.Lex(input))
+	if errs, _ := diags.Count(); errs != 2 {
+		t.Fatalf("Förväntade 2 fel, fick:\n%s", diags.Error())
+	}
+	if diags[0].Pos.Line != 2 || diags[1].Pos.Line != 4 {
+		t.Errorf("Fel rader: %s", diags.Error())
+	}
+
+	if diagram.Nodes[0].Color != "#ff0000" {
+		t.Errorf("Förväntade färg #ff0000, fick %s", diagram.Nodes[0].Color)
+	}
+	if diagram.Nodes[0].Text != "#004d40" {
+		t.Errorf("Ogiltig textfärg borde ge standardvärdet, fick %s", diagram.Nodes[0].Text)
+	}
+	if diagram.Nodes[1].Border != "#00ff00" {
+		t.Errorf("Färg i sträng borde godtas, fick %s", diagram.Nodes[1].Border)
+	}
+	if diagram.Edges[0].Color != "blue" || diagram.Edges[0].Width.String() != "2" {
+		t.Errorf("Fel kantattribut: %s %s", diagram.Edges[0].Color, diagram.Edges[0].Width)
+	}
+}
+
+func TestParser_NumericIDs(t *testing.T) {
+	input := `diagram flowchart {
+	node 1 "Ett"
+	node 2 "Två" (width=120)
+	1 -> 2 -> 3
+}`
+	diagram, err := interpreter.Parse(interpreter.Lex(input))
+	if err != nil {
+		t.Fatalf("Siffror ska gå att använda som id, fick: %v", err)
+	}
+	if len(diagram.Nodes) != 3 || diagram.Nodes[0].ID != "1" || diagram.Nodes[1].Label != "Två" {
+		t.Errorf("Fel noder: %+v", diagram.Nodes)
+	}
+	if len(diagram.Edges) != 2 || diagram.Edges[1].From != "2" || diagram.Edges[1].To != "3" {
+		t.Errorf("Fel kanter: %+v", diagram.Edges)
+	}
+}